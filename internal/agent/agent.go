@@ -96,6 +96,15 @@ type SessionAgent interface {
 	QueuedPrompts(sessionID string) int
 	QueuedPromptsList(sessionID string) []string
 	ClearQueue(sessionID string)
+	// IsLoopHalted reports whether the session was stopped because the agent
+	// got stuck repeating the same tool calls. Runs fail with ErrLoopHalted
+	// until the halt is overridden.
+	IsLoopHalted(sessionID string) bool
+	// OverrideLoopDetection clears a loop halt and resets the detection
+	// window, so the agent may continue past the detected loop.
+	OverrideLoopDetection(sessionID string)
+	// ForgetSession drops the state kept for a session once it is deleted.
+	ForgetSession(sessionID string)
 	Summarize(context.Context, string, fantasy.ProviderOptions) error
 	Model() Model
 }
@@ -117,11 +126,15 @@ type sessionAgent struct {
 	sessions             session.Service
 	messages             message.Service
 	disableAutoSummarize bool
+	loopMaxCycleLength   int
+	loopNudge            LoopNudgeOptions
+	loopServers          map[string]LoopServerOverride
 	isYolo               bool
 	notify               pubsub.Publisher[notify.Notification]
 
 	messageQueue   *csync.Map[string, []SessionAgentCall]
 	activeRequests *csync.Map[string, context.CancelFunc]
+	loopGuards     *csync.Map[string, *loopGuard]
 }
 
 type SessionAgentOptions struct {
@@ -132,6 +145,9 @@ type SessionAgentOptions struct {
 	IsSubAgent           bool
 	DisableAutoSummarize bool
 	IsYolo               bool
+	LoopMaxCycleLength   int // Zero uses the default; one disables cycle detection.
+	LoopNudge            LoopNudgeOptions
+	LoopServerOverrides  map[string]LoopServerOverride // Keyed by MCP server name.
 	Sessions             session.Service
 	Messages             message.Service
 	Tools                []fantasy.AgentTool
@@ -150,11 +166,15 @@ func NewSessionAgent(
 		sessions:             opts.Sessions,
 		messages:             opts.Messages,
 		disableAutoSummarize: opts.DisableAutoSummarize,
+		loopMaxCycleLength:   cmp.Or(opts.LoopMaxCycleLength, loopDetectionMaxCycleLength),
+		loopNudge:            opts.LoopNudge,
+		loopServers:          opts.LoopServerOverrides,
 		tools:                csync.NewSliceFrom(opts.Tools),
 		isYolo:               opts.IsYolo,
 		notify:               opts.Notify,
 		messageQueue:         csync.NewMap[string, []SessionAgentCall](),
 		activeRequests:       csync.NewMap[string, context.CancelFunc](),
+		loopGuards:           csync.NewMap[string, *loopGuard](),
 	}
 }
 
@@ -176,6 +196,9 @@ func (a *sessionAgent) Run(ctx context.Context, call SessionAgentCall) (*fantasy
 		a.messageQueue.Set(call.SessionID, existing)
 		return nil, nil
 	}
+	if a.IsLoopHalted(call.SessionID) {
+		return nil, ErrLoopHalted
+	}
 
 	// Copy mutable fields under lock to avoid races with SetTools/SetModels.
 	agentTools := a.tools.Copy()
@@ -243,6 +266,9 @@ func (a *sessionAgent) Run(ctx context.Context, call SessionAgentCall) (*fantasy
 	genCtx, cancel := context.WithCancel(ctx)
 	a.activeRequests.Set(call.SessionID, cancel)
 
+	loops := a.loopGuard(call.SessionID)
+	loops.begin()
+
 	defer cancel()
 	defer a.activeRequests.Del(call.SessionID)
 
@@ -290,6 +316,12 @@ func (a *sessionAgent) Run(ctx context.Context, call SessionAgentCall) (*fantasy
 
 			prepared.Messages = a.workaroundProviderMediaLimitations(prepared.Messages, largeModel)
 
+			// The nudge is only part of this step's input, so it never shows
+			// up as a step of its own in loop detection.
+			if nudge := loops.takeNudge(); nudge != "" {
+				prepared.Messages = appendNudge(prepared.Messages, nudge)
+			}
+
 			lastSystemRoleInx := 0
 			systemMessageUpdated := false
 			for i, msg := range prepared.Messages {
@@ -406,6 +438,7 @@ func (a *sessionAgent) Run(ctx context.Context, call SessionAgentCall) (*fantasy
 			return createMsgErr
 		},
 		OnStepFinish: func(stepResult fantasy.StepResult) error {
+			loops.observe(stepResult)
 			finishReason := message.FinishReasonUnknown
 			switch stepResult.FinishReason {
 			case fantasy.FinishReasonLength:
@@ -453,9 +486,7 @@ func (a *sessionAgent) Run(ctx context.Context, call SessionAgentCall) (*fantasy
 				}
 				return false
 			},
-			func(steps []fantasy.StepResult) bool {
-				return hasRepeatedToolCalls(steps, loopDetectionWindowSize, loopDetectionMaxRepeats)
-			},
+			loops.hook.StopCondition(),
 		},
 	})
 
@@ -582,6 +613,13 @@ func (a *sessionAgent) Run(ctx context.Context, call SessionAgentCall) (*fantasy
 			SessionTitle: currentSession.Title,
 			Type:         notify.TypeAgentFinished,
 		})
+		if loops.Halted() {
+			a.notify.Publish(pubsub.CreatedEvent, notify.Notification{
+				SessionID:    call.SessionID,
+				SessionTitle: currentSession.Title,
+				Type:         notify.TypeLoopHalted,
+			})
+		}
 	}
 
 	if shouldSummarize {
@@ -1156,6 +1194,25 @@ func (a *sessionAgent) QueuedPromptsList(sessionID string) []string {
 	return prompts
 }
 
+func (a *sessionAgent) loopGuard(sessionID string) *loopGuard {
+	return a.loopGuards.GetOrSet(sessionID, func() *loopGuard {
+		return newLoopGuard(a.loopMaxCycleLength, a.loopNudge, a.loopServers)
+	})
+}
+
+func (a *sessionAgent) IsLoopHalted(sessionID string) bool {
+	g, ok := a.loopGuards.Get(sessionID)
+	return ok && g.Halted()
+}
+
+func (a *sessionAgent) OverrideLoopDetection(sessionID string) {
+	a.loopGuard(sessionID).Override()
+}
+
+func (a *sessionAgent) ForgetSession(sessionID string) {
+	a.loopGuards.Del(sessionID)
+}
+
 func (a *sessionAgent) SetModels(large Model, small Model) {
 	a.largeModel.Set(large)
 	a.smallModel.Set(small)
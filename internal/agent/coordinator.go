@@ -22,12 +22,13 @@ import (
 	"github.com/charmbracelet/crush/internal/agent/notify"
 	"github.com/charmbracelet/crush/internal/agent/prompt"
 	"github.com/charmbracelet/crush/internal/agent/tools"
+	"github.com/charmbracelet/crush/internal/agent/tools/mcp"
 	"github.com/charmbracelet/crush/internal/config"
 	"github.com/charmbracelet/crush/internal/event"
 	"github.com/charmbracelet/crush/internal/filetracker"
 	"github.com/charmbracelet/crush/internal/history"
-	"github.com/charmbracelet/crush/internal/integrations/wakatime"
 	"github.com/charmbracelet/crush/internal/home"
+	"github.com/charmbracelet/crush/internal/integrations/wakatime"
 	"github.com/charmbracelet/crush/internal/log"
 	"github.com/charmbracelet/crush/internal/lsp"
 	"github.com/charmbracelet/crush/internal/message"
@@ -73,9 +74,15 @@ type Coordinator interface {
 	QueuedPrompts(sessionID string) int
 	QueuedPromptsList(sessionID string) []string
 	ClearQueue(sessionID string)
+	IsLoopHalted(sessionID string) bool
+	OverrideLoopDetection(sessionID string)
+	ForgetSession(sessionID string)
 	Summarize(context.Context, string) error
 	Model() Model
 	UpdateModels(ctx context.Context) error
+	// Close releases the coordinator's integrations. It is called once on
+	// shutdown, after CancelAll.
+	Close()
 }
 
 type coordinator struct {
@@ -137,8 +144,20 @@ func NewCoordinator(
 			Enabled:  cfg.Config().WakaTime.Enabled,
 			APIKey:   cfg.Config().WakaTime.APIKey,
 			Category: cfg.Config().WakaTime.Category,
+			CLIPath:  cfg.Config().WakaTime.CLIPath,
+			Hostname: cfg.Config().WakaTime.Hostname,
+			APIURL:   cfg.Config().WakaTime.APIURL,
+			Direct:   cfg.Config().WakaTime.Direct,
+			Tools:    cfg.Config().WakaTime.Tools,
+
+			CategoryByTool:  cfg.Config().WakaTime.CategoryByTool,
+			ProjectMarkers:  cfg.Config().WakaTime.ProjectMarkers,
+			ProjectStrategy: wakatime.ProjectStrategy(cfg.Config().WakaTime.ProjectStrategy),
+			ProjectDepth:    cfg.Config().WakaTime.ProjectDepth,
 		})
-		if err == nil && wakaService != nil {
+		if err != nil {
+			slog.Warn("WakaTime integration disabled", "error", err)
+		} else if wakaService != nil {
 			c.wakatimeHook = wakatime.NewHook(wakaService, cfg.WorkingDir())
 		}
 	}
@@ -160,6 +179,9 @@ func NewCoordinator(
 	}
 	c.currentAgent = agent
 	c.agents[config.AgentCoder] = agent
+
+	// MCP servers with sampling enabled use the large model.
+	mcp.SetSamplingModel(c.samplingModel)
 	return c, nil
 }
 
@@ -424,11 +446,18 @@ func (c *coordinator) buildAgent(ctx context.Context, prompt *prompt.Prompt, age
 		SystemPrompt:         "",
 		IsSubAgent:           isSubAgent,
 		DisableAutoSummarize: c.cfg.Config().Options.DisableAutoSummarize,
-		IsYolo:               c.permissions.SkipRequests(),
-		Sessions:             c.sessions,
-		Messages:             c.messages,
-		Tools:                nil,
-		Notify:               c.notify,
+		LoopMaxCycleLength:   c.cfg.Config().Options.LoopDetectionMaxCycle,
+		LoopServerOverrides:  loopServerOverrides(c.cfg.Config().MCP),
+		LoopNudge: LoopNudgeOptions{
+			Enabled:    c.cfg.Config().Options.LoopDetectionNudge,
+			Message:    c.cfg.Config().Options.LoopDetectionNudgeMessage,
+			GraceSteps: c.cfg.Config().Options.LoopDetectionGraceSteps,
+		},
+		IsYolo:   c.permissions.SkipRequests(),
+		Sessions: c.sessions,
+		Messages: c.messages,
+		Tools:    nil,
+		Notify:   c.notify,
 	})
 
 	c.readyWg.Go(func() error {
@@ -554,6 +583,21 @@ func (c *coordinator) buildTools(ctx context.Context, agent config.Agent) ([]fan
 	return filteredTools, nil
 }
 
+// loopServerOverrides collects the loop detection overrides of the
+// configured MCP servers.
+func loopServerOverrides(servers map[string]config.MCPConfig) map[string]LoopServerOverride {
+	overrides := make(map[string]LoopServerOverride)
+	for name, m := range servers {
+		if m.LoopDetectionExempt || m.LoopDetectionMaxRepeats > 0 {
+			overrides[name] = LoopServerOverride{
+				Exempt:     m.LoopDetectionExempt,
+				MaxRepeats: m.LoopDetectionMaxRepeats,
+			}
+		}
+	}
+	return overrides
+}
+
 // TODO: when we support multiple agents we need to change this so that we pass in the agent specific model config
 func (c *coordinator) buildAgentModels(ctx context.Context, isSubAgent bool) (Model, Model, error) {
 	largeModelCfg, ok := c.cfg.Config().Models[config.SelectedModelTypeLarge]
@@ -628,14 +672,14 @@ func (c *coordinator) buildAgentModels(ctx context.Context, isSubAgent bool) (Mo
 	}
 
 	return Model{
-			Model:      largeModel,
-			CatwalkCfg: *largeCatwalkModel,
-			ModelCfg:   largeModelCfg,
-		}, Model{
-			Model:      smallModel,
-			CatwalkCfg: *smallCatwalkModel,
-			ModelCfg:   smallModelCfg,
-		}, nil
+		Model:      largeModel,
+		CatwalkCfg: *largeCatwalkModel,
+		ModelCfg:   largeModelCfg,
+	}, Model{
+		Model:      smallModel,
+		CatwalkCfg: *smallCatwalkModel,
+		ModelCfg:   smallModelCfg,
+	}, nil
 }
 
 func (c *coordinator) buildAnthropicProvider(baseURL, apiKey string, headers map[string]string, providerID string) (fantasy.Provider, error) {
@@ -900,6 +944,11 @@ func (c *coordinator) CancelAll() {
 	c.currentAgent.CancelAll()
 }
 
+// Close stops the WakaTime service, abandoning any pending heartbeats.
+func (c *coordinator) Close() {
+	c.wakatimeHook.Close()
+}
+
 func (c *coordinator) ClearQueue(sessionID string) {
 	c.currentAgent.ClearQueue(sessionID)
 }
@@ -916,6 +965,12 @@ func (c *coordinator) Model() Model {
 	return c.currentAgent.Model()
 }
 
+// samplingModel returns the model answering MCP sampling requests.
+func (c *coordinator) samplingModel() (fantasy.LanguageModel, string) {
+	model := c.currentAgent.Model()
+	return model.Model, model.ModelCfg.Model
+}
+
 func (c *coordinator) UpdateModels(ctx context.Context) error {
 	// build the models again so we make sure we get the latest config
 	large, small, err := c.buildAgentModels(ctx, false)
@@ -945,6 +1000,18 @@ func (c *coordinator) QueuedPromptsList(sessionID string) []string {
 	return c.currentAgent.QueuedPromptsList(sessionID)
 }
 
+func (c *coordinator) IsLoopHalted(sessionID string) bool {
+	return c.currentAgent.IsLoopHalted(sessionID)
+}
+
+func (c *coordinator) OverrideLoopDetection(sessionID string) {
+	c.currentAgent.OverrideLoopDetection(sessionID)
+}
+
+func (c *coordinator) ForgetSession(sessionID string) {
+	c.currentAgent.ForgetSession(sessionID)
+}
+
 func (c *coordinator) Summarize(ctx context.Context, sessionID string) error {
 	providerCfg, ok := c.cfg.Config().Providers.Get(c.currentAgent.Model().ModelCfg.Provider)
 	if !ok {
@@ -36,6 +36,9 @@ func (m *mockSessionAgent) IsBusy() bool                                { return
 func (m *mockSessionAgent) QueuedPrompts(sessionID string) int          { return 0 }
 func (m *mockSessionAgent) QueuedPromptsList(sessionID string) []string { return nil }
 func (m *mockSessionAgent) ClearQueue(sessionID string)                 {}
+func (m *mockSessionAgent) IsLoopHalted(sessionID string) bool          { return false }
+func (m *mockSessionAgent) OverrideLoopDetection(sessionID string)      {}
+func (m *mockSessionAgent) ForgetSession(sessionID string)              {}
 func (m *mockSessionAgent) Summarize(context.Context, string, fantasy.ProviderOptions) error {
 	return nil
 }
@@ -7,4 +7,5 @@ var (
 	ErrSessionBusy      = errors.New("session is currently processing another request")
 	ErrEmptyPrompt      = errors.New("prompt is empty")
 	ErrSessionMissing   = errors.New("session id is missing")
+	ErrLoopHalted       = errors.New("agent was stopped for repeating the same tool calls; continue past the loop to resume")
 )
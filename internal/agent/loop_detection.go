@@ -1,9 +1,15 @@
 package agent
 
 import (
+	"cmp"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 
 	"charm.land/fantasy"
 )
@@ -11,31 +17,348 @@ import (
 const (
 	loopDetectionWindowSize = 10
 	loopDetectionMaxRepeats = 5
+
+	// loopDetectionMaxCycleLength is the default longest sequence of steps
+	// checked for oscillation (e.g. A,B,A,B).
+	loopDetectionMaxCycleLength = 3
+	// loopDetectionMaxCycleRepeats is how many consecutive times a cycle may
+	// repeat before it is considered a loop.
+	loopDetectionMaxCycleRepeats = 3
 )
 
+// LoopInfo describes a tool interaction the agent kept repeating.
+type LoopInfo struct {
+	// Signature is the tool interaction signature that repeated. For cycles
+	// it is the comma-separated signatures of the steps in the cycle.
+	Signature string
+	// Count is how many times the signature appeared in the window.
+	Count int
+	// CycleLength is the number of steps in the repeated sequence; 1 for a
+	// single repeated step.
+	CycleLength int
+	// Calls are the tool calls making up the repeated interaction.
+	Calls []fantasy.ToolCallContent
+}
+
+// ToolNames returns the names of the repeated tool calls.
+func (r LoopInfo) ToolNames() []string {
+	names := make([]string, len(r.Calls))
+	for i, c := range r.Calls {
+		names[i] = c.ToolName
+	}
+	return names
+}
+
+// String returns a short human-readable description of the repeated calls,
+// e.g. "view {"file_path":"a.go"}".
+func (r LoopInfo) String() string {
+	parts := make([]string, len(r.Calls))
+	for i, c := range r.Calls {
+		parts[i] = strings.TrimSpace(c.ToolName + " " + c.Input)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// LoopServerOverride relaxes loop detection for the tools of one MCP server.
+type LoopServerOverride struct {
+	// Exempt excludes steps calling only the server's tools from loop
+	// detection.
+	Exempt bool
+	// MaxRepeats is how often a step calling only the server's tools may
+	// repeat within the window. Zero uses the default.
+	MaxRepeats int
+}
+
+// loopServers applies per-server overrides to loop detection. The zero value
+// applies none.
+type loopServers map[string]LoopServerOverride
+
+// owner returns the MCP server with an override that owns every tool call in
+// content, or "" if there is none. MCP tools are named "mcp_<server>_<tool>";
+// the longest matching server name wins.
+func (s loopServers) owner(content fantasy.ResponseContent) string {
+	if len(s) == 0 {
+		return ""
+	}
+	owner := ""
+	for i, call := range content.ToolCalls() {
+		server := ""
+		for name := range s {
+			if strings.HasPrefix(call.ToolName, "mcp_"+name+"_") && len(name) > len(server) {
+				server = name
+			}
+		}
+		if server == "" || (i > 0 && server != owner) {
+			return ""
+		}
+		owner = server
+	}
+	return owner
+}
+
+// signature returns the step's tool interaction signature, tagged with the
+// owning server when it has an override.
+func (s loopServers) signature(content fantasy.ResponseContent) string {
+	sig := getToolInteractionSignature(content)
+	if sig == "" {
+		return ""
+	}
+	if owner := s.owner(content); owner != "" {
+		return owner + ":" + sig
+	}
+	return sig
+}
+
+// override returns the override for a tagged signature.
+func (s loopServers) override(sig string) LoopServerOverride {
+	owner, _, ok := strings.Cut(sig, ":")
+	if !ok {
+		return LoopServerOverride{}
+	}
+	return s[owner]
+}
+
 // hasRepeatedToolCalls checks whether the agent is stuck in a loop by looking
 // at recent steps. It examines the last windowSize steps and returns true if
 // any tool-call signature appears more than maxRepeats times.
 func hasRepeatedToolCalls(steps []fantasy.StepResult, windowSize, maxRepeats int) bool {
+	_, found := detectRepeatedToolCalls(steps, windowSize, maxRepeats)
+	return found
+}
+
+// detectRepeatedToolCalls is like hasRepeatedToolCalls, but also reports
+// which tool interaction repeated and how often.
+func detectRepeatedToolCalls(steps []fantasy.StepResult, windowSize, maxRepeats int) (LoopInfo, bool) {
+	return loopServers(nil).detectRepeatedToolCalls(steps, windowSize, maxRepeats)
+}
+
+// detectRepeatedToolCalls is like the package function, but skips steps of
+// exempt servers and uses each server's repeat limit.
+func (s loopServers) detectRepeatedToolCalls(steps []fantasy.StepResult, windowSize, maxRepeats int) (LoopInfo, bool) {
 	if len(steps) < windowSize {
-		return false
+		return LoopInfo{}, false
 	}
 
 	window := steps[len(steps)-windowSize:]
 	counts := make(map[string]int)
+	var worst LoopInfo
 
 	for _, step := range window {
-		sig := getToolInteractionSignature(step.Content)
+		sig := s.signature(step.Content)
 		if sig == "" {
 			continue
 		}
+		override := s.override(sig)
+		if override.Exempt {
+			continue
+		}
 		counts[sig]++
-		if counts[sig] > maxRepeats {
-			return true
+		if counts[sig] > cmp.Or(override.MaxRepeats, maxRepeats) && counts[sig] > worst.Count {
+			worst = LoopInfo{
+				Signature:   sig,
+				Count:       counts[sig],
+				CycleLength: 1,
+				Calls:       step.Content.ToolCalls(),
+			}
+		}
+	}
+
+	return worst, worst.Count > 0
+}
+
+// detectToolCallCycle checks whether the most recent steps are a short
+// sequence of tool interactions, two to maxCycleLength steps long, repeated
+// back to back more than maxRepeats times. This catches an agent oscillating
+// between a few actions, which single-step counting can miss.
+func detectToolCallCycle(steps []fantasy.StepResult, maxCycleLength, maxRepeats int) (LoopInfo, bool) {
+	return loopServers(nil).detectToolCallCycle(steps, maxCycleLength, maxRepeats)
+}
+
+// detectToolCallCycle is like the package function, but ignores cycles made
+// up only of steps of exempt servers.
+func (s loopServers) detectToolCallCycle(steps []fantasy.StepResult, maxCycleLength, maxRepeats int) (LoopInfo, bool) {
+	sigs := make([]string, len(steps))
+	for i, step := range steps {
+		sigs[i] = s.signature(step.Content)
+	}
+
+	for length := 2; length <= maxCycleLength; length++ {
+		if len(sigs) < length*(maxRepeats+1) {
+			break
+		}
+		cycle := sigs[len(sigs)-length:]
+		if !isToolCallCycle(cycle) {
+			continue
+		}
+		if !slices.ContainsFunc(cycle, func(sig string) bool { return !s.override(sig).Exempt }) {
+			continue
+		}
+
+		// Count how many whole copies of the cycle end the step list.
+		repeats := 1
+		for end := len(sigs) - length; end >= length; end -= length {
+			if !slices.Equal(sigs[end-length:end], cycle) {
+				break
+			}
+			repeats++
+		}
+		if repeats <= maxRepeats {
+			continue
+		}
+
+		var calls []fantasy.ToolCallContent
+		for _, step := range steps[len(steps)-length:] {
+			calls = append(calls, step.Content.ToolCalls()...)
+		}
+		return LoopInfo{
+			Signature:   strings.Join(cycle, ","),
+			Count:       repeats,
+			CycleLength: length,
+			Calls:       calls,
+		}, true
+	}
+
+	return LoopInfo{}, false
+}
+
+// isToolCallCycle reports whether sigs is a cycle worth tracking: every step
+// made tool calls and the steps are not all the same, which single-step
+// detection already covers.
+func isToolCallCycle(sigs []string) bool {
+	for _, sig := range sigs {
+		if sig == "" {
+			return false
+		}
+	}
+	return slices.ContainsFunc(sigs, func(sig string) bool { return sig != sigs[0] })
+}
+
+// LoopNudgeOptions configures nudging the model when it gets stuck in a loop,
+// instead of stopping it right away.
+type LoopNudgeOptions struct {
+	// Enabled turns nudging on. When off, the first detected loop stops the
+	// agent.
+	Enabled bool
+	// Message is the text sent to the model. If empty, a message describing
+	// the repeated tool calls is used.
+	Message string
+	// GraceSteps is how many steps after a nudge a second detected loop stops
+	// the agent. Later loops are nudged again. Since the steps before the
+	// nudge are forgotten, the grace steps last at least until the detection
+	// window is full again. Zero uses the default.
+	GraceSteps int
+}
+
+// loopGuard tracks loop detection for a single session. It remembers whether
+// the session was halted because of a detected loop until the user overrides
+// the detection so the agent can continue past it.
+type loopGuard struct {
+	hook  *LoopHook
+	nudge LoopNudgeOptions
+
+	mu     sync.Mutex
+	halted bool
+	// pendingNudge is the message to inject before the next step.
+	pendingNudge string
+	// nudged is set while a second loop within the grace steps stops the
+	// agent; sinceNudge counts the steps taken since.
+	nudged     bool
+	sinceNudge int
+}
+
+// newLoopGuard creates a guard for a session. A maxCycleLength below 2
+// disables cycle detection; servers relaxes detection for MCP servers' tools.
+func newLoopGuard(maxCycleLength int, nudge LoopNudgeOptions, servers map[string]LoopServerOverride) *loopGuard {
+	nudge.GraceSteps = cmp.Or(nudge.GraceSteps, loopDetectionWindowSize)
+	g := &loopGuard{nudge: nudge}
+	g.hook = NewLoopHook(LoopHookOptions{
+		MaxCycleLength:  maxCycleLength,
+		ServerOverrides: servers,
+		OnLoop:          g.onLoop,
+	})
+	return g
+}
+
+// onLoop decides whether a detected loop is nudged or stops the agent.
+func (g *loopGuard) onLoop(loop LoopInfo) LoopAction {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.nudge.Enabled || g.nudged {
+		slog.Warn("Agent is stuck repeating the same tool calls", "calls", loop.String(), "count", loop.Count, "cycle_length", loop.CycleLength)
+		g.halted = true
+		return LoopStop
+	}
+
+	slog.Info("Nudging agent out of a tool call loop", "calls", loop.String(), "count", loop.Count, "cycle_length", loop.CycleLength)
+	g.pendingNudge = cmp.Or(g.nudge.Message, fmt.Sprintf(
+		"You have repeated the same action (%s) %d times without making progress. Stop and try a different strategy.",
+		loop.String(), loop.Count,
+	))
+	g.nudged = true
+	g.sinceNudge = 0
+	// Forget the looping steps so only repeats after the nudge count.
+	g.hook.Reset()
+	return LoopContinue
+}
+
+// observe records a finished step.
+func (g *loopGuard) observe(step fantasy.StepResult) {
+	g.mu.Lock()
+	if g.nudged {
+		// A loop can only be detected again once the window has refilled,
+		// so shorter grace steps would never stop the agent.
+		g.sinceNudge++
+		if g.sinceNudge > max(g.nudge.GraceSteps, g.hook.keep) {
+			g.nudged = false
 		}
 	}
+	g.mu.Unlock()
+
+	_ = g.hook.OnStepFinish(step)
+}
+
+// takeNudge returns the pending nudge message, if any, and clears it.
+func (g *loopGuard) takeNudge() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	msg := g.pendingNudge
+	g.pendingNudge = ""
+	return msg
+}
+
+// appendNudge adds a nudge to the messages of a step. It is sent as a user
+// message, since some providers, such as Anthropic, drop system messages
+// that follow the start of the conversation.
+func appendNudge(messages []fantasy.Message, nudge string) []fantasy.Message {
+	return append(messages, fantasy.NewUserMessage(nudge))
+}
+
+// begin resets the guard at the start of a new run. A halt is kept until it
+// is overridden.
+func (g *loopGuard) begin() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pendingNudge = ""
+	g.nudged = false
+	g.hook.Reset()
+}
+
+// Halted reports whether the agent was stopped by loop detection.
+func (g *loopGuard) Halted() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.halted
+}
 
-	return false
+// Override clears the halted state and resets the detection window, so the
+// steps that triggered detection no longer count against the agent.
+func (g *loopGuard) Override() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.halted = false
+	g.nudged = false
+	g.hook.Reset()
 }
 
 // getToolInteractionSignature computes a hash signature for the tool
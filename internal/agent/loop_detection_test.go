@@ -1,10 +1,20 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 
 	"charm.land/fantasy"
+	"charm.land/fantasy/providers/anthropic"
+	"github.com/charmbracelet/crush/internal/csync"
 )
 
 // makeStep creates a StepResult with the given tool calls and results in its Content.
@@ -53,6 +63,9 @@ func TestHasRepeatedToolCalls(t *testing.T) {
 		if result {
 			t.Error("expected false for empty steps")
 		}
+		if loop, ok := detectRepeatedToolCalls(nil, 10, 5); ok || loop.Count != 0 || loop.Calls != nil {
+			t.Errorf("expected no details for empty steps, got %+v", loop)
+		}
 	})
 
 	t.Run("fewer steps than window", func(t *testing.T) {
@@ -105,6 +118,46 @@ func TestHasRepeatedToolCalls(t *testing.T) {
 		if !result {
 			t.Error("expected true when same signature appears more than maxRepeats times")
 		}
+
+		loop, ok := detectRepeatedToolCalls(steps, 10, 5)
+		if !ok {
+			t.Fatal("expected detectRepeatedToolCalls to report the loop")
+		}
+		if loop.Count != 6 {
+			t.Errorf("expected count 6, got %d", loop.Count)
+		}
+		if loop.Signature != getToolInteractionSignature(steps[0].Content) {
+			t.Error("expected signature of the repeated step")
+		}
+		if names := loop.ToolNames(); len(names) != 1 || names[0] != "read" {
+			t.Errorf("expected tool names [read], got %v", names)
+		}
+		if got, want := loop.String(), `read {"file":"a.go"}`; got != want {
+			t.Errorf("expected description %q, got %q", want, got)
+		}
+	})
+
+	t.Run("reports the most repeated signature", func(t *testing.T) {
+		steps := make([]fantasy.StepResult, 20)
+		for i := range 6 {
+			steps[i] = makeToolStep("read", `{"file":"a.go"}`, "content")
+		}
+		for i := 6; i < 14; i++ {
+			steps[i] = makeToolStep("write", `{"file":"b.go"}`, "ok")
+		}
+		for i := 14; i < 20; i++ {
+			steps[i] = makeToolStep("tool", fmt.Sprintf(`{"i":%d}`, i), fmt.Sprintf("result-%d", i))
+		}
+		loop, ok := detectRepeatedToolCalls(steps, 20, 5)
+		if !ok {
+			t.Fatal("expected loop to be detected")
+		}
+		if loop.Count != 8 {
+			t.Errorf("expected count 8, got %d", loop.Count)
+		}
+		if names := loop.ToolNames(); len(names) != 1 || names[0] != "write" {
+			t.Errorf("expected tool names [write], got %v", names)
+		}
 	})
 
 	t.Run("steps without tool calls are skipped", func(t *testing.T) {
@@ -203,3 +256,442 @@ func TestGetToolInteractionSignature(t *testing.T) {
 		}
 	})
 }
+
+func TestLoopGuard(t *testing.T) {
+	guard := newLoopGuard(loopDetectionMaxCycleLength, LoopNudgeOptions{}, nil)
+	guard.begin()
+	if guard.Halted() {
+		t.Fatal("expected new guard not to be halted")
+	}
+
+	step := makeToolStep("read", `{"file":"a.go"}`, "content")
+	for range 10 {
+		_ = guard.hook.OnStepFinish(step)
+	}
+	if !guard.hook.Stopped() || !guard.Halted() {
+		t.Fatal("expected loop to be detected and guard halted")
+	}
+
+	// Overriding clears the halt and discards the steps seen so far.
+	guard.Override()
+	if guard.Halted() || guard.hook.Stopped() {
+		t.Fatal("expected override to clear halted state")
+	}
+	_ = guard.hook.OnStepFinish(step)
+	if guard.hook.Stopped() {
+		t.Fatal("expected no loop right after override")
+	}
+
+	// The loop is detected again once a full window repeats after the override.
+	for range 9 {
+		_ = guard.hook.OnStepFinish(step)
+	}
+	if !guard.hook.Stopped() || !guard.Halted() {
+		t.Fatal("expected loop to be detected again after a full window")
+	}
+
+	// A new run keeps the halt until it is overridden.
+	guard.begin()
+	if !guard.Halted() {
+		t.Error("expected begin to keep the halted state")
+	}
+	guard.Override()
+	guard.begin()
+	if guard.Halted() || guard.hook.Stopped() {
+		t.Error("expected an overridden guard to start clean")
+	}
+}
+
+func TestSessionAgent_StaysHaltedUntilOverride(t *testing.T) {
+	a := &sessionAgent{
+		loopGuards:     csync.NewMap[string, *loopGuard](),
+		activeRequests: csync.NewMap[string, context.CancelFunc](),
+		messageQueue:   csync.NewMap[string, []SessionAgentCall](),
+	}
+	guard := a.loopGuard("session")
+	step := makeToolStep("read", `{"file":"a.go"}`, "content")
+	for range 10 {
+		_ = guard.hook.OnStepFinish(step)
+	}
+
+	call := SessionAgentCall{SessionID: "session", Prompt: "continue"}
+	for range 2 {
+		if _, err := a.Run(context.Background(), call); !errors.Is(err, ErrLoopHalted) {
+			t.Fatalf("expected a halted session to refuse runs, got %v", err)
+		}
+		if !a.IsLoopHalted("session") {
+			t.Fatal("expected the session to stay halted")
+		}
+	}
+
+	a.OverrideLoopDetection("session")
+	if a.IsLoopHalted("session") {
+		t.Error("expected the override to clear the halt")
+	}
+}
+
+func TestSessionAgent_ForgetSession(t *testing.T) {
+	a := &sessionAgent{loopGuards: csync.NewMap[string, *loopGuard]()}
+	guard := a.loopGuard("session")
+	step := makeToolStep("read", `{"file":"a.go"}`, "content")
+	for range 10 {
+		_ = guard.hook.OnStepFinish(step)
+	}
+	if !a.IsLoopHalted("session") {
+		t.Fatal("expected the session to be halted")
+	}
+
+	a.ForgetSession("session")
+	if a.IsLoopHalted("session") || a.loopGuards.Len() != 0 {
+		t.Error("expected the session's guard to be dropped")
+	}
+}
+
+func TestDetectToolCallCycle(t *testing.T) {
+	stepA := makeToolStep("read", `{"file":"a.go"}`, "content-a")
+	stepB := makeToolStep("write", `{"file":"b.go"}`, "content-b")
+	stepC := makeToolStep("bash", `{"cmd":"go test"}`, "FAIL")
+
+	repeat := func(n int, cycle ...fantasy.StepResult) []fantasy.StepResult {
+		var steps []fantasy.StepResult
+		for range n {
+			steps = append(steps, cycle...)
+		}
+		return steps
+	}
+
+	tests := []struct {
+		name       string
+		steps      []fantasy.StepResult
+		maxLength  int
+		wantLoop   bool
+		wantCount  int
+		wantLength int
+		wantTools  []string
+	}{
+		{
+			name:      "no steps",
+			maxLength: 3,
+		},
+		{
+			name:      "two-step oscillation at threshold not detected",
+			steps:     repeat(3, stepA, stepB),
+			maxLength: 3,
+		},
+		{
+			name:       "two-step oscillation detected",
+			steps:      repeat(4, stepA, stepB),
+			maxLength:  3,
+			wantLoop:   true,
+			wantCount:  4,
+			wantLength: 2,
+			wantTools:  []string{"read", "write"},
+		},
+		{
+			name:       "oscillation after unrelated steps",
+			steps:      append([]fantasy.StepResult{stepC, stepC}, repeat(5, stepB, stepA)...),
+			maxLength:  3,
+			wantLoop:   true,
+			wantCount:  5,
+			wantLength: 2,
+			wantTools:  []string{"write", "read"},
+		},
+		{
+			name:       "three-step cycle detected",
+			steps:      repeat(4, stepA, stepB, stepC),
+			maxLength:  3,
+			wantLoop:   true,
+			wantCount:  4,
+			wantLength: 3,
+			wantTools:  []string{"read", "write", "bash"},
+		},
+		{
+			name:      "three-step cycle ignored when max length is 2",
+			steps:     repeat(4, stepA, stepB, stepC),
+			maxLength: 2,
+		},
+		{
+			name:      "disabled with max length 1",
+			steps:     repeat(4, stepA, stepB),
+			maxLength: 1,
+		},
+		{
+			name:      "single repeated step is not a cycle",
+			steps:     repeat(8, stepA),
+			maxLength: 3,
+		},
+		{
+			name:      "steps without tool calls break the cycle",
+			steps:     repeat(4, stepA, makeEmptyStep()),
+			maxLength: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop, ok := detectToolCallCycle(tt.steps, tt.maxLength, 3)
+			if ok != tt.wantLoop {
+				t.Fatalf("expected loop=%v, got %v", tt.wantLoop, ok)
+			}
+			if !ok {
+				return
+			}
+			if loop.Count != tt.wantCount {
+				t.Errorf("expected count %d, got %d", tt.wantCount, loop.Count)
+			}
+			if loop.CycleLength != tt.wantLength {
+				t.Errorf("expected cycle length %d, got %d", tt.wantLength, loop.CycleLength)
+			}
+			if !slices.Equal(loop.ToolNames(), tt.wantTools) {
+				t.Errorf("expected tools %v, got %v", tt.wantTools, loop.ToolNames())
+			}
+		})
+	}
+}
+
+func TestLoopGuard_DetectsOscillation(t *testing.T) {
+	guard := newLoopGuard(loopDetectionMaxCycleLength, LoopNudgeOptions{}, nil)
+	guard.begin()
+
+	var steps []fantasy.StepResult
+	for i := range 10 {
+		if i%2 == 0 {
+			steps = append(steps, makeToolStep("read", `{"file":"a.go"}`, "content-a"))
+		} else {
+			steps = append(steps, makeToolStep("write", `{"file":"b.go"}`, "content-b"))
+		}
+	}
+	// Each signature appears only 5 times, which single-step detection allows.
+	if hasRepeatedToolCalls(steps, loopDetectionWindowSize, loopDetectionMaxRepeats) {
+		t.Fatal("expected single-step detection to miss the oscillation")
+	}
+	for _, step := range steps {
+		_ = guard.hook.OnStepFinish(step)
+	}
+	if !guard.Halted() {
+		t.Error("expected guard to detect the oscillation")
+	}
+}
+
+func TestLoopGuard_Nudge(t *testing.T) {
+	loopStep := makeToolStep("read", `{"file":"a.go"}`, "content")
+
+	t.Run("nudges first and stops on a second loop within grace", func(t *testing.T) {
+		guard := newLoopGuard(loopDetectionMaxCycleLength, LoopNudgeOptions{Enabled: true}, nil)
+		guard.begin()
+
+		for range 10 {
+			guard.observe(loopStep)
+		}
+		if guard.Halted() || guard.hook.Stopped() {
+			t.Fatal("expected the first loop to be nudged, not stopped")
+		}
+		nudge := guard.takeNudge()
+		if !strings.Contains(nudge, `read {"file":"a.go"}`) || !strings.Contains(nudge, "10 times") {
+			t.Errorf("expected nudge to describe the loop, got %q", nudge)
+		}
+		if guard.takeNudge() != "" {
+			t.Error("expected the nudge to be injected only once")
+		}
+
+		// The steps before the nudge no longer count, so a single repeat
+		// does not trip detection again.
+		guard.observe(loopStep)
+		if guard.hook.Stopped() {
+			t.Fatal("expected steps before the nudge not to count")
+		}
+
+		for range 9 {
+			guard.observe(loopStep)
+		}
+		if !guard.Halted() || !guard.hook.Stopped() {
+			t.Error("expected a second loop within the grace steps to stop the agent")
+		}
+	})
+
+	t.Run("stops on a second loop with short grace steps", func(t *testing.T) {
+		guard := newLoopGuard(1, LoopNudgeOptions{
+			Enabled:    true,
+			GraceSteps: 3,
+		}, nil)
+		guard.begin()
+
+		for range 10 {
+			guard.observe(loopStep)
+		}
+		if guard.takeNudge() == "" {
+			t.Fatal("expected the first loop to be nudged")
+		}
+
+		for range 10 {
+			guard.observe(loopStep)
+		}
+		if !guard.Halted() || !guard.hook.Stopped() {
+			t.Error("expected the second loop to stop the agent")
+		}
+		if guard.takeNudge() != "" {
+			t.Error("expected no second nudge")
+		}
+	})
+
+	t.Run("nudges again after the grace steps", func(t *testing.T) {
+		guard := newLoopGuard(1, LoopNudgeOptions{
+			Enabled:    true,
+			Message:    "try something else",
+			GraceSteps: 3,
+		}, nil)
+		guard.begin()
+
+		for range 10 {
+			guard.observe(loopStep)
+		}
+		if got := guard.takeNudge(); got != "try something else" {
+			t.Fatalf("expected configured nudge message, got %q", got)
+		}
+
+		// The agent makes progress for a while before looping again.
+		for i := range 10 {
+			guard.observe(makeToolStep("read", fmt.Sprintf(`{"file":"%d.go"}`, i), "content"))
+		}
+		for range 10 {
+			guard.observe(loopStep)
+		}
+		if guard.Halted() {
+			t.Fatal("expected a loop after the grace steps to be nudged again")
+		}
+		if guard.takeNudge() == "" {
+			t.Error("expected a second nudge")
+		}
+	})
+}
+
+func TestLoopServerOverrides(t *testing.T) {
+	servers := loopServers{
+		"jobs":   {Exempt: true},
+		"search": {MaxRepeats: 8},
+	}
+	repeat := func(name string, n int) []fantasy.StepResult {
+		steps := make([]fantasy.StepResult, n)
+		for i := range steps {
+			steps[i] = makeToolStep(name, `{"id":"42"}`, "pending")
+		}
+		return steps
+	}
+
+	t.Run("exempt server does not trip detection", func(t *testing.T) {
+		if loop, ok := servers.detectRepeatedToolCalls(repeat("mcp_jobs_status", 10), 10, 5); ok {
+			t.Errorf("expected exempt server to be ignored, got %+v", loop)
+		}
+	})
+
+	t.Run("other server trips detection", func(t *testing.T) {
+		loop, ok := servers.detectRepeatedToolCalls(repeat("mcp_other_status", 10), 10, 5)
+		if !ok {
+			t.Fatal("expected a loop for a server without an override")
+		}
+		if loop.Signature != getToolInteractionSignature(repeat("mcp_other_status", 1)[0].Content) {
+			t.Errorf("expected an untagged signature, got %q", loop.Signature)
+		}
+	})
+
+	t.Run("server with a higher limit", func(t *testing.T) {
+		if _, ok := servers.detectRepeatedToolCalls(repeat("mcp_search_query", 8), 8, 5); ok {
+			t.Error("expected 8 repeats to stay within the server's limit")
+		}
+		loop, ok := servers.detectRepeatedToolCalls(repeat("mcp_search_query", 10), 10, 5)
+		if !ok {
+			t.Fatal("expected a loop above the server's limit")
+		}
+		if !strings.HasPrefix(loop.Signature, "search:") {
+			t.Errorf("expected signature tagged with the server, got %q", loop.Signature)
+		}
+	})
+
+	t.Run("prefix of another server name", func(t *testing.T) {
+		servers := loopServers{"jobs": {Exempt: true}, "jobs_admin": {}}
+		if _, ok := servers.detectRepeatedToolCalls(repeat("mcp_jobs_admin_purge", 10), 10, 5); !ok {
+			t.Error("expected the longest matching server to own the tool")
+		}
+	})
+
+	t.Run("exempt cycle", func(t *testing.T) {
+		var steps []fantasy.StepResult
+		for range 5 {
+			steps = append(steps,
+				makeToolStep("mcp_jobs_status", `{"id":"42"}`, "pending"),
+				makeToolStep("mcp_jobs_logs", `{"id":"42"}`, "..."),
+			)
+		}
+		if loop, ok := servers.detectToolCallCycle(steps, 3, 3); ok {
+			t.Errorf("expected exempt cycle to be ignored, got %+v", loop)
+		}
+		if _, ok := detectToolCallCycle(steps, 3, 3); !ok {
+			t.Error("expected the cycle to be detected without overrides")
+		}
+	})
+
+	t.Run("hook", func(t *testing.T) {
+		hook := NewLoopHook(LoopHookOptions{ServerOverrides: servers})
+		for _, step := range repeat("mcp_jobs_status", 10) {
+			_ = hook.OnStepFinish(step)
+		}
+		if hook.Stopped() {
+			t.Error("expected exempt server's calls not to stop the agent")
+		}
+		for _, step := range repeat("mcp_other_status", 10) {
+			_ = hook.OnStepFinish(step)
+		}
+		if !hook.Stopped() {
+			t.Error("expected another server's calls to stop the agent")
+		}
+	})
+}
+
+func TestAppendNudge_ReachesAnthropic(t *testing.T) {
+	t.Parallel()
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	provider, err := anthropic.New(anthropic.WithBaseURL(server.URL), anthropic.WithAPIKey("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := provider.LanguageModel(t.Context(), "claude")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prompt := []fantasy.Message{
+		fantasy.NewSystemMessage("you are a coder"),
+		fantasy.NewUserMessage("fix the bug"),
+		{Role: fantasy.MessageRoleAssistant, Content: []fantasy.MessagePart{fantasy.TextPart{Text: "looking"}}},
+	}
+	if _, err := model.Generate(t.Context(), fantasy.Call{Prompt: appendNudge(prompt, "stop repeating yourself")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Messages) == 0 {
+		t.Fatal("expected messages in the request")
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != "user" || len(last.Content) == 0 || last.Content[len(last.Content)-1].Text != "stop repeating yourself" {
+		t.Errorf("expected the nudge as the last user message, got %s", body)
+	}
+}
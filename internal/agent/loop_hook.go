@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"cmp"
+	"sync"
+
+	"charm.land/fantasy"
+)
+
+// LoopAction tells a LoopHook how to react to a detected loop.
+type LoopAction int
+
+const (
+	// LoopContinue lets the agent keep going.
+	LoopContinue LoopAction = iota
+	// LoopStop stops the agent at the end of the current step.
+	LoopStop
+)
+
+// LoopHookOptions configures a LoopHook. Zero values use the defaults.
+type LoopHookOptions struct {
+	// WindowSize is the number of recent steps checked for single-step
+	// repeats.
+	WindowSize int
+	// MaxRepeats is how often a step may repeat within the window.
+	MaxRepeats int
+	// MaxCycleLength is the longest step sequence checked for oscillation.
+	// Values below 2 disable cycle detection.
+	MaxCycleLength int
+	// MaxCycleRepeats is how many consecutive times a cycle may repeat.
+	MaxCycleRepeats int
+	// ServerOverrides relaxes detection for steps calling only the tools of
+	// the named MCP servers.
+	ServerOverrides map[string]LoopServerOverride
+	// OnLoop is called each time a loop is detected and decides how to
+	// react. When nil, the agent is stopped.
+	OnLoop func(LoopInfo) LoopAction
+}
+
+// LoopHook accumulates the steps of an agent run and detects when the agent
+// is stuck repeating the same tool calls. Attach it to an agent by calling
+// OnStepFinish from the agent's step callback and adding StopCondition to its
+// stop conditions.
+type LoopHook struct {
+	opts LoopHookOptions
+	// keep is the number of recent steps the detectors look at; older steps
+	// are discarded so long runs don't grow the history.
+	keep int
+
+	mu      sync.Mutex
+	steps   []fantasy.StepResult
+	stopped bool
+}
+
+// NewLoopHook creates a new loop detection hook.
+func NewLoopHook(opts LoopHookOptions) *LoopHook {
+	opts.WindowSize = cmp.Or(opts.WindowSize, loopDetectionWindowSize)
+	opts.MaxRepeats = cmp.Or(opts.MaxRepeats, loopDetectionMaxRepeats)
+	opts.MaxCycleRepeats = cmp.Or(opts.MaxCycleRepeats, loopDetectionMaxCycleRepeats)
+	return &LoopHook{
+		opts: opts,
+		keep: max(opts.WindowSize, opts.MaxCycleLength*(opts.MaxCycleRepeats+1)),
+	}
+}
+
+// OnStepFinish records a finished step and checks the steps seen so far for
+// loops. It matches fantasy.OnStepFinishFunc and never returns an error.
+func (h *LoopHook) OnStepFinish(step fantasy.StepResult) error {
+	h.mu.Lock()
+	h.steps = append(h.steps, step)
+	if extra := len(h.steps) - h.keep; extra > 0 {
+		h.steps = append(h.steps[:0], h.steps[extra:]...)
+	}
+	servers := loopServers(h.opts.ServerOverrides)
+	loop, ok := servers.detectRepeatedToolCalls(h.steps, h.opts.WindowSize, h.opts.MaxRepeats)
+	if !ok {
+		loop, ok = servers.detectToolCallCycle(h.steps, h.opts.MaxCycleLength, h.opts.MaxCycleRepeats)
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	action := LoopStop
+	if h.opts.OnLoop != nil {
+		action = h.opts.OnLoop(loop)
+	}
+	if action == LoopStop {
+		h.mu.Lock()
+		h.stopped = true
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+// StopCondition returns a fantasy.StopCondition that stops the agent once a
+// detected loop was answered with LoopStop.
+func (h *LoopHook) StopCondition() fantasy.StopCondition {
+	return func([]fantasy.StepResult) bool {
+		return h.Stopped()
+	}
+}
+
+// Stopped reports whether a detected loop asked the agent to stop.
+func (h *LoopHook) Stopped() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stopped
+}
+
+// Reset discards the accumulated steps and clears the stop request.
+func (h *LoopHook) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.steps = nil
+	h.stopped = false
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"testing"
+
+	"charm.land/fantasy"
+)
+
+func TestLoopHook(t *testing.T) {
+	step := makeToolStep("read", `{"file":"a.go"}`, "content")
+
+	t.Run("stops by default", func(t *testing.T) {
+		hook := NewLoopHook(LoopHookOptions{})
+		stop := hook.StopCondition()
+		for i := range 10 {
+			if err := hook.OnStepFinish(step); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got, want := stop(nil), i == 9; got != want {
+				t.Fatalf("step %d: expected stop=%v, got %v", i, want, got)
+			}
+		}
+	})
+
+	t.Run("callback receives loop details and can continue", func(t *testing.T) {
+		var loops []LoopInfo
+		hook := NewLoopHook(LoopHookOptions{
+			WindowSize: 4,
+			MaxRepeats: 2,
+			OnLoop: func(loop LoopInfo) LoopAction {
+				loops = append(loops, loop)
+				return LoopContinue
+			},
+		})
+		for range 5 {
+			_ = hook.OnStepFinish(step)
+		}
+		if hook.Stopped() {
+			t.Error("expected hook not to stop when the callback continues")
+		}
+		if len(loops) != 2 {
+			t.Fatalf("expected 2 detections, got %d", len(loops))
+		}
+		if loops[0].Count != 4 || loops[0].String() != `read {"file":"a.go"}` {
+			t.Errorf("unexpected loop details: %+v", loops[0])
+		}
+	})
+
+	t.Run("detects cycles when enabled", func(t *testing.T) {
+		var got LoopInfo
+		hook := NewLoopHook(LoopHookOptions{
+			MaxCycleLength: 2,
+			OnLoop: func(loop LoopInfo) LoopAction {
+				got = loop
+				return LoopStop
+			},
+		})
+		other := makeToolStep("write", `{"file":"b.go"}`, "ok")
+		for range 4 {
+			_ = hook.OnStepFinish(step)
+			_ = hook.OnStepFinish(other)
+		}
+		if !hook.Stopped() || got.CycleLength != 2 {
+			t.Errorf("expected a two-step cycle to stop the hook, got %+v", got)
+		}
+	})
+
+	t.Run("keeps only the steps the detectors need", func(t *testing.T) {
+		hook := NewLoopHook(LoopHookOptions{
+			WindowSize:      4,
+			MaxCycleLength:  3,
+			MaxCycleRepeats: 2,
+			OnLoop:          func(LoopInfo) LoopAction { return LoopContinue },
+		})
+		for range 50 {
+			_ = hook.OnStepFinish(step)
+		}
+		if got := len(hook.steps); got != 9 {
+			t.Errorf("expected 9 steps to be kept, got %d", got)
+		}
+
+		hook = NewLoopHook(LoopHookOptions{WindowSize: 6})
+		for range 50 {
+			_ = hook.OnStepFinish(makeToolStep("read", `{"file":"b.go"}`, "content"))
+		}
+		if got := len(hook.steps); got != 6 {
+			t.Errorf("expected the window of 6 steps to be kept, got %d", got)
+		}
+	})
+
+	t.Run("reset clears steps and stop request", func(t *testing.T) {
+		hook := NewLoopHook(LoopHookOptions{})
+		for range 10 {
+			_ = hook.OnStepFinish(step)
+		}
+		hook.Reset()
+		if hook.Stopped() {
+			t.Fatal("expected reset to clear the stop request")
+		}
+		for range 9 {
+			_ = hook.OnStepFinish(step)
+		}
+		if hook.StopCondition()([]fantasy.StepResult{}) {
+			t.Error("expected steps before reset not to count")
+		}
+	})
+}
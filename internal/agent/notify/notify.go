@@ -12,6 +12,9 @@ const (
 	// TypeReAuthenticate indicates the agent encountered an
 	// authentication error and the user needs to re-authenticate.
 	TypeReAuthenticate Type = "re_authenticate"
+	// TypeLoopHalted indicates the agent was stopped for repeating the
+	// same tool calls, and stays stopped until the user overrides it.
+	TypeLoopHalted Type = "loop_halted"
 )
 
 // Notification represents a domain event published by the agent.
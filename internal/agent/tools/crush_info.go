@@ -4,11 +4,13 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"maps"
 	"slices"
 	"strings"
 
 	"charm.land/fantasy"
 	"github.com/charmbracelet/crush/internal/agent/tools/mcp"
+	"github.com/charmbracelet/crush/internal/commands"
 	"github.com/charmbracelet/crush/internal/config"
 	"github.com/charmbracelet/crush/internal/lsp"
 	"github.com/charmbracelet/crush/internal/skills"
@@ -45,6 +47,8 @@ func buildCrushInfo(cfg *config.ConfigStore, lspManager *lsp.Manager, allSkills
 	writeProviders(&b, cfg)
 	writeLSP(&b, lspManager, cfg)
 	writeMCP(&b, mcp.GetStates(), cfg)
+	writeMCPPrompts(&b, cfg)
+	writeMCPCache(&b)
 	writeSkills(&b, allSkills, activeSkills, skillTracker, cfg)
 	writePermissions(&b, cfg)
 	writeDisabledTools(&b, cfg)
@@ -290,6 +294,41 @@ func writeMCP(b *strings.Builder, states map[string]mcp.ClientInfo, cfg *config.
 	}
 }
 
+func writeMCPCache(b *strings.Builder) {
+	servers, total, limit := mcp.CacheUsage()
+	evicted := mcp.EvictedCaches()
+	if total == 0 && len(evicted) == 0 {
+		return
+	}
+	b.WriteString("[mcp_cache]\n")
+	for _, name := range slices.Sorted(maps.Keys(servers)) {
+		fmt.Fprintf(b, "%s = %d bytes\n", name, servers[name])
+	}
+	for _, name := range slices.Sorted(maps.Keys(evicted)) {
+		kinds := evicted[name]
+		slices.Sort(kinds)
+		fmt.Fprintf(b, "%s evicted = %s\n", name, strings.Join(kinds, ", "))
+	}
+	if limit > 0 {
+		fmt.Fprintf(b, "total = %d of %d bytes\n", total, limit)
+	} else {
+		fmt.Fprintf(b, "total = %d bytes\n", total)
+	}
+	b.WriteString("\n")
+}
+
+func writeMCPPrompts(b *strings.Builder, cfg *config.ConfigStore) {
+	prompts, err := commands.LoadMCPPrompts(cfg.Config())
+	if err != nil || len(prompts) == 0 {
+		return
+	}
+	b.WriteString("[mcp_prompts]\n")
+	for _, p := range prompts {
+		fmt.Fprintf(b, "%s = %s\n", p.Name, p.ID)
+	}
+	b.WriteString("\n")
+}
+
 func writeSkills(b *strings.Builder, allSkills []*skills.Skill, activeSkills []*skills.Skill, tracker *skills.Tracker, cfg *config.ConfigStore) {
 	var disabled []string
 	if cfg.Config().Options != nil {
@@ -102,8 +102,9 @@ func (m *Tool) Run(ctx context.Context, params fantasy.ToolCall) (fantasy.ToolRe
 		return fantasy.ToolResponse{}, fmt.Errorf("session ID is required for creating a new file")
 	}
 
-	// Skip permission for whitelisted Docker MCP tools.
-	if !slices.Contains(whitelistDockerTools, params.Name) {
+	// Skip permission for whitelisted Docker MCP tools and for tools the
+	// server's permission mode doesn't gate.
+	if !slices.Contains(whitelistDockerTools, params.Name) && mcp.RequiresPermission(m.cfg.Config().MCP[m.mcpName], m.tool) {
 		permissionDescription := fmt.Sprintf("execute %s with the following parameters:", m.Info().Name)
 		p, err := m.permissions.Request(ctx,
 			permission.CreatePermissionRequest{
@@ -124,7 +125,7 @@ func (m *Tool) Run(ctx context.Context, params fantasy.ToolCall) (fantasy.ToolRe
 		}
 	}
 
-	result, err := mcp.RunTool(ctx, m.cfg, m.mcpName, m.tool.Name, params.Input)
+	result, err := mcp.RunTool(mcp.WithSessionID(ctx, sessionID), m.cfg, m.mcpName, m.tool.Name, params.Input)
 	if err != nil {
 		return fantasy.NewTextErrorResponse(err.Error()), nil
 	}
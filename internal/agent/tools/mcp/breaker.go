@@ -0,0 +1,89 @@
+package mcp
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/csync"
+)
+
+// Circuit breaker defaults.
+const (
+	defaultBreakerMaxFailures = 5
+	defaultBreakerWindow      = 60 * time.Second
+	defaultBreakerCooldown    = 30 * time.Second
+)
+
+// breakers holds the connect circuit breaker of each MCP server.
+var breakers = csync.NewMap[string, *circuitBreaker]()
+
+// circuitBreaker stops connect attempts to a server for a cool-down period
+// once too many of them failed within a window, so a crash-looping stdio
+// server is not spawned in a tight loop.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  []time.Time
+	openUntil time.Time
+	now       func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{now: time.Now}
+}
+
+// breakerFor returns the circuit breaker of a server.
+func breakerFor(name string) *circuitBreaker {
+	return breakers.GetOrSet(name, newCircuitBreaker)
+}
+
+// breakerSettings returns the configured breaker settings, with defaults
+// for unset values.
+func breakerSettings(m config.MCPConfig) (maxFailures int, window, cooldown time.Duration) {
+	var c config.MCPCircuitBreakerConfig
+	if m.CircuitBreaker != nil {
+		c = *m.CircuitBreaker
+	}
+	return cmp.Or(c.MaxFailures, defaultBreakerMaxFailures),
+		cmp.Or(time.Duration(c.Window)*time.Second, defaultBreakerWindow),
+		cmp.Or(time.Duration(c.Cooldown)*time.Second, defaultBreakerCooldown)
+}
+
+// allow returns an error if the breaker is open.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait := b.openUntil.Sub(b.now()); wait > 0 {
+		return fmt.Errorf("circuit open, retrying in %s", wait.Round(time.Second))
+	}
+	return nil
+}
+
+// failure records a failed connect and opens the breaker once maxFailures
+// happened within window.
+func (b *circuitBreaker) failure(maxFailures int, window, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	recent := b.failures[:0]
+	for _, t := range b.failures {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	b.failures = append(recent, now)
+	if len(b.failures) >= maxFailures {
+		b.openUntil = now.Add(cooldown)
+		b.failures = nil
+	}
+}
+
+// success closes the breaker and forgets earlier failures.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = nil
+	b.openUntil = time.Time{}
+}
@@ -0,0 +1,92 @@
+package mcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	b := newCircuitBreaker()
+	b.now = func() time.Time { return now }
+
+	b.failure(3, time.Minute, 30*time.Second)
+	b.failure(3, time.Minute, 30*time.Second)
+	require.NoError(t, b.allow())
+
+	// Failures outside the window do not count.
+	now = now.Add(2 * time.Minute)
+	b.failure(3, time.Minute, 30*time.Second)
+	require.NoError(t, b.allow())
+	b.failure(3, time.Minute, 30*time.Second)
+	b.failure(3, time.Minute, 30*time.Second)
+	require.EqualError(t, b.allow(), "circuit open, retrying in 30s")
+
+	now = now.Add(20 * time.Second)
+	require.EqualError(t, b.allow(), "circuit open, retrying in 10s")
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, b.allow())
+
+	b.failure(3, time.Minute, 30*time.Second)
+	b.failure(3, time.Minute, 30*time.Second)
+	b.success()
+	b.failure(3, time.Minute, 30*time.Second)
+	require.NoError(t, b.allow(), "success resets the failure count")
+}
+
+func TestBreakerSettings(t *testing.T) {
+	t.Parallel()
+
+	maxFailures, window, cooldown := breakerSettings(config.MCPConfig{})
+	require.Equal(t, defaultBreakerMaxFailures, maxFailures)
+	require.Equal(t, defaultBreakerWindow, window)
+	require.Equal(t, defaultBreakerCooldown, cooldown)
+
+	maxFailures, window, cooldown = breakerSettings(config.MCPConfig{
+		CircuitBreaker: &config.MCPCircuitBreakerConfig{MaxFailures: 2, Cooldown: 300},
+	})
+	require.Equal(t, 2, maxFailures)
+	require.Equal(t, defaultBreakerWindow, window)
+	require.Equal(t, 5*time.Minute, cooldown)
+}
+
+func TestCreateSession_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	// A closed port refuses connections right away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	name := "breaker-" + t.Name()
+	t.Cleanup(func() {
+		states.Del(name)
+		breakers.Del(name)
+	})
+	m := config.MCPConfig{
+		Type:           config.MCPHttp,
+		URL:            "http://" + addr + "/mcp",
+		OAuth:          &config.MCPOAuthConfig{Enabled: new(false)},
+		CircuitBreaker: &config.MCPCircuitBreakerConfig{MaxFailures: 2, Cooldown: 60},
+	}
+	resolver := config.NewShellVariableResolver(env.New())
+
+	for range 2 {
+		_, err := createSession(t.Context(), name, m, resolver)
+		require.Error(t, err)
+		require.NotContains(t, err.Error(), "circuit open")
+	}
+
+	_, err = createSession(t.Context(), name, m, resolver)
+	require.ErrorContains(t, err, "circuit open, retrying in 1m0s")
+	require.ErrorContains(t, mustState(t, name).Error, "circuit open")
+}
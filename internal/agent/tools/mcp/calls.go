@@ -0,0 +1,71 @@
+package mcp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// outstandingCalls tracks the tool calls in flight on a session.
+type outstandingCalls struct {
+	mu    sync.Mutex
+	next  uint64
+	calls map[uint64]OutstandingCall
+}
+
+// OutstandingCall is a tool call waiting for its result.
+type OutstandingCall struct {
+	Tool    string
+	Started time.Time
+}
+
+func (o *outstandingCalls) add(tool string) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.calls == nil {
+		o.calls = make(map[uint64]OutstandingCall)
+	}
+	o.next++
+	o.calls[o.next] = OutstandingCall{Tool: tool, Started: time.Now()}
+	return o.next
+}
+
+func (o *outstandingCalls) remove(id uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.calls, id)
+}
+
+func (o *outstandingCalls) list() []OutstandingCall {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	calls := make([]OutstandingCall, 0, len(o.calls))
+	for _, c := range o.calls {
+		calls = append(calls, c)
+	}
+	return calls
+}
+
+// CallTool calls a tool on the server. When ctx is cancelled, for instance
+// because the user cancelled the agent turn, the SDK sends
+// notifications/cancelled for the request so the server can stop working on
+// it, and the call returns right away instead of waiting for the result.
+func (s *ClientSession) CallTool(ctx context.Context, params *mcp.CallToolParams) (*mcp.CallToolResult, error) {
+	id := s.outstanding.add(params.Name)
+	defer s.outstanding.remove(id)
+
+	result, err := s.ClientSession.CallTool(ctx, params)
+	if err != nil && ctx.Err() != nil {
+		slog.Debug("Cancelled MCP tool call", "tool", params.Name, "reason", context.Cause(ctx))
+	}
+	return result, err
+}
+
+// OutstandingCalls returns the tool calls currently waiting for a result on
+// the session.
+func (s *ClientSession) OutstandingCalls() []OutstandingCall {
+	return s.outstanding.list()
+}
@@ -0,0 +1,65 @@
+package mcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSession_CallToolCancellation(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	serverCancelled := make(chan struct{})
+	server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+	server.AddTool(&mcp.Tool{Name: "slow", InputSchema: map[string]any{"type": "object"}}, func(ctx context.Context, _ *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		close(started)
+		<-ctx.Done()
+		close(serverCancelled)
+		return nil, ctx.Err()
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	sessCtx, sessCancel := context.WithCancel(t.Context())
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, nil)
+	clientSession, err := client.Connect(sessCtx, clientTransport, nil)
+	require.NoError(t, err)
+	sess := &ClientSession{ClientSession: clientSession, cancel: sessCancel}
+	t.Cleanup(func() { _ = sess.Close() })
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() {
+		_, err := sess.CallTool(ctx, &mcp.CallToolParams{Name: "slow"})
+		done <- err
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("tool call did not reach the server")
+	}
+	calls := sess.OutstandingCalls()
+	require.Len(t, calls, 1)
+	require.Equal(t, "slow", calls[0].Tool)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("CallTool did not return after cancellation")
+	}
+	select {
+	case <-serverCancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server was not notified of the cancellation")
+	}
+	require.Empty(t, sess.OutstandingCalls())
+}
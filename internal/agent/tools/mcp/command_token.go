@@ -0,0 +1,129 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/home"
+	"github.com/charmbracelet/crush/internal/oauth"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
+)
+
+// tokenCommandTimeout bounds a single run of a token command.
+const tokenCommandTimeout = 30 * time.Second
+
+// CommandTokenProvider implements TokenProvider by running an external
+// program, like a git or docker credential helper, that prints a bearer
+// token on stdout.
+type CommandTokenProvider struct {
+	name    string
+	command []string
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	token *oauth.Token
+}
+
+// NewCommandTokenProvider creates a token provider for an MCP server that
+// runs command to get a token. Tokens are reused for ttl, or until the server
+// rejects them when ttl is zero.
+func NewCommandTokenProvider(name string, command []string, ttl time.Duration) (*CommandTokenProvider, error) {
+	if len(command) == 0 || strings.TrimSpace(command[0]) == "" {
+		return nil, fmt.Errorf("empty token command for MCP %q", name)
+	}
+	return &CommandTokenProvider{
+		name:    name,
+		command: command,
+		timeout: tokenCommandTimeout,
+		ttl:     ttl,
+	}, nil
+}
+
+// EnsureToken returns the current token, running the command if there is
+// none or it expired.
+func (p *CommandTokenProvider) EnsureToken(ctx context.Context) (*oauth.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != nil && !mcpoauth.IsExpiredAt(p.token, time.Now(), 0) {
+		return p.token, nil
+	}
+	return p.fetch(ctx)
+}
+
+// RefreshToken runs the command to get a new token.
+func (p *CommandTokenProvider) RefreshToken(ctx context.Context) (*oauth.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fetch(ctx)
+}
+
+// InvalidateToken drops the current token so the next EnsureToken runs the
+// command again.
+func (p *CommandTokenProvider) InvalidateToken() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.token = nil
+	return nil
+}
+
+// fetch runs the command and stores its output as the current token. The
+// caller must hold p.mu.
+func (p *CommandTokenProvider) fetch(ctx context.Context) (*oauth.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, home.Long(p.command[0]), p.command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	slog.Debug("Running token command", "mcp", p.name, "command", p.command[0])
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("token command for MCP %q timed out after %s", p.name, p.timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("token command for MCP %q exited with code %d: %s", p.name, exitErr.ExitCode(), commandOutput(stderr.String()))
+		}
+		return nil, fmt.Errorf("failed to run token command for MCP %q: %w", p.name, err)
+	}
+
+	accessToken := strings.TrimSpace(stdout.String())
+	if accessToken == "" {
+		return nil, fmt.Errorf("token command for MCP %q printed no token", p.name)
+	}
+
+	token := &oauth.Token{
+		AccessToken: accessToken,
+		ExpiresIn:   int(p.ttl.Seconds()),
+	}
+	// Without a TTL the token never expires and is reused until rejected.
+	mcpoauth.SetExpiresAt(token, time.Now())
+	p.token = token
+	return token, nil
+}
+
+// commandOutput shortens a command's error output for an error message.
+func commandOutput(s string) string {
+	const maxLen = 200
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "no output"
+	}
+	if len(s) > maxLen {
+		s = s[:maxLen] + "..."
+	}
+	return s
+}
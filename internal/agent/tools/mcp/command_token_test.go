@@ -0,0 +1,134 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTokenScript writes a shell script that prints tokens from a counter
+// file, so each run returns a new token.
+func writeTokenScript(t *testing.T) (script, counter string) {
+	t.Helper()
+	dir := t.TempDir()
+	counter = filepath.Join(dir, "count")
+	script = filepath.Join(dir, "token.sh")
+	body := "#!/bin/sh\nn=$(cat " + counter + " 2>/dev/null || echo 0)\nn=$((n+1))\necho $n > " + counter + "\necho \"  token-$n  \"\n"
+	require.NoError(t, os.WriteFile(script, []byte(body), 0o700))
+	return script, counter
+}
+
+func TestCommandTokenProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("runs the command and trims the token", func(t *testing.T) {
+		t.Parallel()
+
+		script, _ := writeTokenScript(t)
+		p, err := NewCommandTokenProvider("test", []string{"sh", script}, 0)
+		require.NoError(t, err)
+
+		token, err := p.EnsureToken(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "token-1", token.AccessToken)
+
+		token, err = p.EnsureToken(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "token-1", token.AccessToken, "token is reused without a ttl")
+
+		token, err = p.RefreshToken(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "token-2", token.AccessToken)
+	})
+
+	t.Run("runs again once the token expires", func(t *testing.T) {
+		t.Parallel()
+
+		script, _ := writeTokenScript(t)
+		p, err := NewCommandTokenProvider("test", []string{"sh", script}, time.Hour)
+		require.NoError(t, err)
+
+		token, err := p.EnsureToken(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "token-1", token.AccessToken)
+
+		p.token.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		token, err = p.EnsureToken(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, "token-2", token.AccessToken)
+	})
+
+	t.Run("nonzero exit", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := NewCommandTokenProvider("test", []string{"sh", "-c", "echo 'not logged in' >&2; exit 3"}, 0)
+		require.NoError(t, err)
+
+		_, err = p.EnsureToken(t.Context())
+		require.ErrorContains(t, err, "exited with code 3: not logged in")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := NewCommandTokenProvider("test", []string{"true"}, 0)
+		require.NoError(t, err)
+
+		_, err = p.EnsureToken(t.Context())
+		require.ErrorContains(t, err, "printed no token")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := NewCommandTokenProvider("test", []string{"sleep", "5"}, 0)
+		require.NoError(t, err)
+		p.timeout = 50 * time.Millisecond
+
+		_, err = p.EnsureToken(t.Context())
+		require.ErrorContains(t, err, "timed out")
+	})
+
+	t.Run("empty command", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewCommandTokenProvider("test", nil, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestCommandTokenProvider_RefreshOn401(t *testing.T) {
+	t.Parallel()
+
+	script, _ := writeTokenScript(t)
+	p, err := NewCommandTokenProvider("test", []string{"sh", script}, 0)
+	require.NoError(t, err)
+
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: NewOAuthRoundTripper(p, nil)}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, seen)
+}
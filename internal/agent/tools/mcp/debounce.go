@@ -0,0 +1,45 @@
+package mcp
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer coalesces rapid calls per key into a single trailing call that
+// runs once no new call has arrived within the window.
+type debouncer struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// Trigger schedules fn to run after window, cancelling any call still pending
+// for the same key. A non-positive window runs fn immediately.
+func (d *debouncer) Trigger(key string, window time.Duration, fn func()) {
+	if window <= 0 {
+		fn()
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t, ok := d.timers[key]; ok {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(window, func() {
+		d.mu.Lock()
+		if d.timers[key] == t {
+			delete(d.timers, key)
+		}
+		d.mu.Unlock()
+		fn()
+	})
+	d.timers[key] = t
+}
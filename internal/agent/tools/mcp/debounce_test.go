@@ -0,0 +1,45 @@
+package mcp
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebouncer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("coalesces calls within window", func(t *testing.T) {
+		t.Parallel()
+		d := newDebouncer()
+		var calls atomic.Int32
+		for range 5 {
+			d.Trigger("server", 50*time.Millisecond, func() { calls.Add(1) })
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		t.Parallel()
+		d := newDebouncer()
+		var calls atomic.Int32
+		d.Trigger("a", 20*time.Millisecond, func() { calls.Add(1) })
+		d.Trigger("b", 20*time.Millisecond, func() { calls.Add(1) })
+
+		require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("runs immediately without window", func(t *testing.T) {
+		t.Parallel()
+		d := newDebouncer()
+		var calls atomic.Int32
+		d.Trigger("server", 0, func() { calls.Add(1) })
+		require.Equal(t, int32(1), calls.Load())
+	})
+}
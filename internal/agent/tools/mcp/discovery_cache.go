@@ -0,0 +1,92 @@
+package mcp
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/csync"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
+)
+
+// discoveryCacheTTL is how long a successful OAuth discovery result is
+// reused before the well-known endpoint is probed again.
+const discoveryCacheTTL = time.Hour
+
+type discoveryCacheEntry struct {
+	serverURL string
+	// policy and allowedHosts are the endpoint host settings the result
+	// was validated against.
+	policy       mcpoauth.EndpointHostPolicy
+	allowedHosts []string
+	cfg          mcpoauth.Config
+	expiresAt    time.Time
+}
+
+// matches reports whether the entry holds a fresh result for serverURL
+// validated with the same endpoint host settings as opts.
+func (e discoveryCacheEntry) matches(serverURL string, opts mcpoauth.DiscoveryOptions) bool {
+	return e.serverURL == serverURL &&
+		e.policy == cmp.Or(opts.Policy, mcpoauth.EndpointHostStrict) &&
+		slices.Equal(e.allowedHosts, opts.AllowedHosts) &&
+		time.Now().Before(e.expiresAt)
+}
+
+var discoveryCache = csync.NewMap[string, discoveryCacheEntry]()
+
+// discoverOAuth returns the OAuth configuration of an MCP server, reusing a
+// cached discovery result when one is still fresh. Results are keyed by the
+// server's name, and discovery runs again when its URL changed, e.g. for a
+// tunnel whose host rotates, or its endpoint host policy or allowed hosts
+// changed. Only successful discoveries are cached, so
+// after an error the next connect attempt discovers again. Discovery that
+// goes to the network publishes its stage and a final stage for its outcome.
+func discoverOAuth(ctx context.Context, name, serverURL string, opts mcpoauth.DiscoveryOptions) (*mcpoauth.Config, error) {
+	if entry, ok := discoveryCache.Get(name); ok && entry.matches(serverURL, opts) {
+		slog.Debug("Using cached OAuth discovery result", "mcp", name, "url", serverURL)
+		return cloneOAuthConfig(entry.cfg), nil
+	}
+
+	publishAuthStage(name, mcpoauth.StageEvent{Stage: mcpoauth.AuthStageDiscovering})
+	cfg, err := mcpoauth.DiscoverOAuthWithOptions(ctx, serverURL, opts)
+	switch {
+	case err != nil:
+		publishAuthStage(name, mcpoauth.StageEvent{
+			Stage:       mcpoauth.AuthStageFailed,
+			FailedStage: mcpoauth.AuthStageDiscovering,
+			Error:       err,
+		})
+		return nil, err
+	case cfg == nil:
+		publishAuthStage(name, mcpoauth.StageEvent{Stage: mcpoauth.AuthStageNone})
+		return nil, nil
+	}
+	publishAuthStage(name, mcpoauth.StageEvent{Stage: mcpoauth.AuthStageDiscovered})
+
+	discoveryCache.Set(name, discoveryCacheEntry{
+		serverURL:    serverURL,
+		policy:       cmp.Or(opts.Policy, mcpoauth.EndpointHostStrict),
+		allowedHosts: slices.Clone(opts.AllowedHosts),
+		cfg:          *cloneOAuthConfig(*cfg),
+		expiresAt:    time.Now().Add(discoveryCacheTTL),
+	})
+	return cfg, nil
+}
+
+// invalidateDiscovery drops the cached discovery result of an MCP server.
+func invalidateDiscovery(name string) {
+	discoveryCache.Del(name)
+}
+
+// clearDiscoveryCache drops all cached discovery results.
+func clearDiscoveryCache() {
+	discoveryCache.Reset(make(map[string]discoveryCacheEntry))
+}
+
+func cloneOAuthConfig(cfg mcpoauth.Config) *mcpoauth.Config {
+	cfg.Scopes = slices.Clone(cfg.Scopes)
+	cfg.Registration.Contacts = slices.Clone(cfg.Registration.Contacts)
+	return &cfg
+}
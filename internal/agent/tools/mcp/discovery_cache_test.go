@@ -0,0 +1,217 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/oauth"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+// newDiscoveryServer starts a server exposing OAuth metadata and counts how
+// often the metadata is fetched.
+func newDiscoveryServer(t *testing.T, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/.well-known/") {
+			hits.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                   server.URL,
+			"authorization_endpoint":   server.URL + "/authorize",
+			"token_endpoint":           server.URL + "/token",
+			"response_types_supported": []string{"code"},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDiscoverOAuthCache(t *testing.T) {
+	t.Cleanup(clearDiscoveryCache)
+
+	var hits atomic.Int32
+	server := newDiscoveryServer(t, &hits)
+
+	cfg, err := discoverOAuth(context.Background(), "test", server.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, server.URL+"/token", cfg.TokenURL)
+
+	cfg, err = discoverOAuth(context.Background(), "test", server.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, int32(1), hits.Load(), "second lookup should be served from cache")
+
+	invalidateDiscovery("test")
+	cfg, err = discoverOAuth(context.Background(), "test", server.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, int32(2), hits.Load())
+
+	clearDiscoveryCache()
+	cfg, err = discoverOAuth(context.Background(), "test", server.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, int32(3), hits.Load())
+
+	// A new URL for the same server discovers again.
+	var movedHits atomic.Int32
+	moved := newDiscoveryServer(t, &movedHits)
+	cfg, err = discoverOAuth(context.Background(), "test", moved.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, moved.URL+"/token", cfg.TokenURL)
+	require.Equal(t, int32(1), movedHits.Load())
+
+	// Changed endpoint host settings discover again, as the cached result
+	// may not pass them.
+	_, err = discoverOAuth(context.Background(), "test", moved.URL, mcpoauth.DiscoveryOptions{Policy: mcpoauth.EndpointHostStrict})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), movedHits.Load(), "the default policy is strict")
+	_, err = discoverOAuth(context.Background(), "test", moved.URL, mcpoauth.DiscoveryOptions{Policy: mcpoauth.EndpointHostWarn})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), movedHits.Load())
+	_, err = discoverOAuth(context.Background(), "test", moved.URL, mcpoauth.DiscoveryOptions{Policy: mcpoauth.EndpointHostWarn, AllowedHosts: []string{"auth.example.com"}})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), movedHits.Load())
+}
+
+// countingTransport counts the requests sent through http.DefaultTransport.
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDiscoverOAuth_UsesTransport(t *testing.T) {
+	t.Cleanup(clearDiscoveryCache)
+
+	var hits atomic.Int32
+	server := newDiscoveryServer(t, &hits)
+	transport := &countingTransport{}
+
+	cfg, err := discoverOAuth(context.Background(), "transport", server.URL, mcpoauth.DiscoveryOptions{Transport: transport})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Positive(t, transport.requests.Load())
+	require.Same(t, transport, cfg.Transport)
+}
+
+func TestCheckServerURL(t *testing.T) {
+	t.Parallel()
+
+	const oldURL, newURL = "https://old.example.com/mcp", "https://new.example.com/mcp"
+
+	tests := []struct {
+		name     string
+		stored   string
+		wantKept bool
+	}{
+		{"same url", serverURLHash(newURL), true},
+		{"changed url", serverURLHash(oldURL), false},
+		{"no hash stored", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			store := NewMemoryTokenStore()
+			require.NoError(t, store.Save("tunnel", "", &MCPOAuthData{AccessToken: "a", ServerURLHash: tt.stored}))
+
+			checkServerURL("tunnel", config.MCPConfig{URL: newURL}, store)
+
+			data, err := store.Load("tunnel", "")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantKept, data != nil)
+		})
+	}
+}
+
+func TestMCPTokenProvider_SavesServerURLHash(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryTokenStore()
+	provider, err := NewOAuthTokenProvider("tunnel", "", validConfig(), store)
+	require.NoError(t, err)
+	provider.serverURLHash = serverURLHash("https://new.example.com/mcp")
+	provider.SetAuthFunc(func(context.Context, mcpoauth.Config) (*oauth.Token, error) {
+		return validToken(), nil
+	})
+
+	_, err = provider.EnsureToken(t.Context())
+	require.NoError(t, err)
+
+	data, err := store.Load("tunnel", "")
+	require.NoError(t, err)
+	require.Equal(t, serverURLHash("https://new.example.com/mcp"), data.ServerURLHash)
+}
+
+func TestMCPTokenProvider_EndpointNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cfg := validConfig()
+	cfg.TokenURL = server.URL
+	provider, err := NewOAuthTokenProvider("test", "", cfg, newTestStore(t))
+	require.NoError(t, err)
+	provider.token = validToken()
+
+	var notified bool
+	provider.onEndpointNotFound = func() { notified = true }
+
+	_, err = provider.RefreshToken(context.Background())
+	require.ErrorIs(t, err, mcpoauth.ErrEndpointNotFound)
+	require.True(t, notified)
+}
+
+func TestDiscoverOAuth_PublishesStages(t *testing.T) {
+	t.Cleanup(clearDiscoveryCache)
+	clearDiscoveryCache()
+
+	events := SubscribeEvents(t.Context())
+	stages := func() []mcpoauth.AuthStage {
+		var got []mcpoauth.AuthStage
+		for {
+			select {
+			case ev := <-events:
+				if ev.Payload.Type == EventOAuthStage && ev.Payload.Name == "stages" {
+					got = append(got, ev.Payload.AuthStage.Stage)
+				}
+			case <-time.After(50 * time.Millisecond):
+				return got
+			}
+		}
+	}
+
+	var hits atomic.Int32
+	server := newDiscoveryServer(t, &hits)
+	_, err := discoverOAuth(context.Background(), "stages", server.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []mcpoauth.AuthStage{mcpoauth.AuthStageDiscovering, mcpoauth.AuthStageDiscovered}, stages())
+
+	_, err = discoverOAuth(context.Background(), "stages", server.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.Empty(t, stages(), "cache hits make no request")
+
+	noOAuth := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(noOAuth.Close)
+	cfg, err := discoverOAuth(context.Background(), "stages", noOAuth.URL, mcpoauth.DiscoveryOptions{})
+	require.NoError(t, err)
+	require.Nil(t, cfg)
+	require.Equal(t, []mcpoauth.AuthStage{mcpoauth.AuthStageDiscovering, mcpoauth.AuthStageNone}, stages())
+}
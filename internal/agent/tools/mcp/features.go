@@ -0,0 +1,109 @@
+package mcp
+
+import (
+	"cmp"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// protocolVersionElicitation is the first protocol version with
+// elicitation.
+const protocolVersionElicitation = "2025-06-18"
+
+// clientCapabilities are the capabilities Crush advertises to MCP servers.
+// They match the SDK defaults, but are set explicitly so the negotiated
+// features can be computed from them.
+var clientCapabilities = &mcp.ClientCapabilities{
+	RootsV2: &mcp.RootCapabilities{ListChanged: true},
+}
+
+// capabilitiesFor returns the capabilities Crush advertises to a server,
+// adding sampling when the server's config enables it.
+func capabilitiesFor(m config.MCPConfig) *mcp.ClientCapabilities {
+	if !m.Sampling {
+		return clientCapabilities
+	}
+	caps := *clientCapabilities
+	caps.Sampling = &mcp.SamplingCapabilities{}
+	return &caps
+}
+
+// Features is the set of optional MCP features both Crush and a server
+// support. Handlers for a feature are only active when it is negotiated.
+type Features struct {
+	// ProtocolVersion is the protocol version agreed with the server.
+	ProtocolVersion string
+
+	// Server features, advertised by the server and handled by Crush.
+	Tools                bool
+	ToolsListChanged     bool
+	Prompts              bool
+	PromptsListChanged   bool
+	Resources            bool
+	ResourcesListChanged bool
+	ResourcesSubscribe   bool
+	Logging              bool
+	Completions          bool
+
+	// Client features, advertised by Crush and usable by the server.
+	Roots            bool
+	RootsListChanged bool
+	Sampling         bool
+	Elicitation      bool
+}
+
+// Features returns the features negotiated for the session.
+func (s *ClientSession) Features() Features {
+	if s.ClientSession == nil {
+		return Features{}
+	}
+	return negotiateFeatures(cmp.Or(s.capabilities, clientCapabilities), s.InitializeResult())
+}
+
+// sessionFeatures returns the features negotiated for an SDK session.
+func sessionFeatures(s *mcp.ClientSession) Features {
+	if s == nil {
+		return Features{}
+	}
+	return negotiateFeatures(clientCapabilities, s.InitializeResult())
+}
+
+// negotiateFeatures computes the features supported by both sides from the
+// client capabilities and the server's initialize result.
+func negotiateFeatures(client *mcp.ClientCapabilities, res *mcp.InitializeResult) Features {
+	if res == nil {
+		return Features{}
+	}
+	f := Features{ProtocolVersion: res.ProtocolVersion}
+
+	if caps := res.Capabilities; caps != nil {
+		if caps.Tools != nil {
+			f.Tools = true
+			f.ToolsListChanged = caps.Tools.ListChanged
+		}
+		if caps.Prompts != nil {
+			f.Prompts = true
+			f.PromptsListChanged = caps.Prompts.ListChanged
+		}
+		if caps.Resources != nil {
+			f.Resources = true
+			f.ResourcesListChanged = caps.Resources.ListChanged
+			f.ResourcesSubscribe = caps.Resources.Subscribe
+		}
+		f.Logging = caps.Logging != nil
+		f.Completions = caps.Completions != nil
+	}
+
+	if client != nil {
+		if client.RootsV2 != nil {
+			f.Roots = true
+			f.RootsListChanged = client.RootsV2.ListChanged
+		}
+		f.Sampling = client.Sampling != nil
+		// Protocol versions are dates, so they compare lexically.
+		f.Elicitation = client.Elicitation != nil && res.ProtocolVersion >= protocolVersionElicitation
+	}
+
+	return f
+}
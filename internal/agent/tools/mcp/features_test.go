@@ -0,0 +1,319 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/jsonrpc"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFeatures(t *testing.T) {
+	t.Parallel()
+
+	t.Run("server advertises a subset", func(t *testing.T) {
+		t.Parallel()
+
+		f := negotiateFeatures(clientCapabilities, &mcp.InitializeResult{
+			ProtocolVersion: "2025-06-18",
+			Capabilities: &mcp.ServerCapabilities{
+				Tools: &mcp.ToolCapabilities{ListChanged: true},
+			},
+		})
+		require.Equal(t, Features{
+			ProtocolVersion:  "2025-06-18",
+			Tools:            true,
+			ToolsListChanged: true,
+			Roots:            true,
+			RootsListChanged: true,
+		}, f)
+	})
+
+	t.Run("resources without list changes", func(t *testing.T) {
+		t.Parallel()
+
+		f := negotiateFeatures(clientCapabilities, &mcp.InitializeResult{
+			Capabilities: &mcp.ServerCapabilities{
+				Resources: &mcp.ResourceCapabilities{Subscribe: true},
+			},
+		})
+		require.True(t, f.Resources)
+		require.True(t, f.ResourcesSubscribe)
+		require.False(t, f.ResourcesListChanged)
+	})
+
+	t.Run("elicitation requires a recent protocol", func(t *testing.T) {
+		t.Parallel()
+
+		client := &mcp.ClientCapabilities{
+			Sampling:    &mcp.SamplingCapabilities{},
+			Elicitation: &mcp.ElicitationCapabilities{},
+		}
+		old := negotiateFeatures(client, &mcp.InitializeResult{ProtocolVersion: "2025-03-26"})
+		require.True(t, old.Sampling)
+		require.False(t, old.Elicitation)
+		require.False(t, old.Roots)
+
+		current := negotiateFeatures(client, &mcp.InitializeResult{ProtocolVersion: "2025-06-18"})
+		require.True(t, current.Elicitation)
+	})
+
+	t.Run("no initialize result", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, Features{}, negotiateFeatures(clientCapabilities, nil))
+	})
+}
+
+func TestClientOptions_GatesNotifications(t *testing.T) {
+	t.Parallel()
+
+	connect := func(t *testing.T, withPrompts bool) *mcp.ClientSession {
+		t.Helper()
+
+		server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+		if withPrompts {
+			server.AddPrompt(&mcp.Prompt{Name: "greet"}, func(context.Context, *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+				return &mcp.GetPromptResult{}, nil
+			})
+		}
+		serverTransport, clientTransport := mcp.NewInMemoryTransports()
+		serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = serverSession.Close() })
+
+		client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+		session, err := client.Connect(t.Context(), clientTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = session.Close() })
+		return session
+	}
+
+	tests := []struct {
+		name        string
+		withPrompts bool
+		wantEvent   bool
+	}{
+		{"server without prompts", false, false},
+		{"server with prompts", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			name := "features-" + t.Name()
+			session := connect(t, tt.withPrompts)
+			require.Equal(t, tt.withPrompts, sessionFeatures(session).PromptsListChanged)
+
+			events := SubscribeEvents(t.Context())
+			opts := clientOptions(name, config.MCPConfig{})
+			opts.PromptListChangedHandler(t.Context(), &mcp.PromptListChangedRequest{Session: session})
+
+			got := false
+			timeout := time.After(100 * time.Millisecond)
+		loop:
+			for {
+				select {
+				case ev := <-events:
+					if ev.Payload.Name == name && ev.Payload.Type == EventPromptsListChanged {
+						got = true
+						break loop
+					}
+				case <-timeout:
+					break loop
+				}
+			}
+			require.Equal(t, tt.wantEvent, got)
+		})
+	}
+}
+
+func TestClientOptions_DebouncesToolsListChanged(t *testing.T) {
+	t.Parallel()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+	server.AddTool(&mcp.Tool{Name: "echo", InputSchema: map[string]any{"type": "object"}}, func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return &mcp.CallToolResult{}, nil
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+	session, err := client.Connect(t.Context(), clientTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+
+	name := "debounce-" + t.Name()
+	events := SubscribeEvents(t.Context())
+	// The default window applies without configuration.
+	opts := clientOptions(name, config.MCPConfig{})
+	for range 5 {
+		opts.ToolListChangedHandler(t.Context(), &mcp.ToolListChangedRequest{Session: session})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	refetches := 0
+	timeout := time.After(defaultToolsChangedDebounce + 500*time.Millisecond)
+loop:
+	for {
+		select {
+		case ev := <-events:
+			if ev.Payload.Name == name && ev.Payload.Type == EventToolsListChanged {
+				refetches++
+			}
+		case <-timeout:
+			break loop
+		}
+	}
+	require.Equal(t, 1, refetches)
+}
+
+func TestUpdateState_ServerInfo(t *testing.T) {
+	t.Parallel()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "foo-server", Version: "1.2.3"}, nil)
+	server.AddPrompt(&mcp.Prompt{Name: "greet"}, func(context.Context, *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{}, nil
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+	session, err := client.Connect(t.Context(), clientTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+
+	name := "server-info-" + t.Name()
+	t.Cleanup(func() { states.Del(name) })
+	updateState(name, StateConnected, nil, &ClientSession{ClientSession: session}, Counts{})
+
+	info := mustState(t, name)
+	require.NotNil(t, info.ServerInfo)
+	require.Equal(t, "foo-server", info.ServerInfo.Name)
+	require.Equal(t, "1.2.3", info.ServerInfo.Version)
+	require.NotNil(t, info.Capabilities)
+	require.NotNil(t, info.Capabilities.Prompts)
+	require.NotEmpty(t, info.Features.ProtocolVersion)
+}
+
+func TestListingSkipsUnsupportedFeatures(t *testing.T) {
+	t.Parallel()
+
+	connect := func(t *testing.T, server *mcp.Server) *ClientSession {
+		t.Helper()
+
+		serverTransport, clientTransport := mcp.NewInMemoryTransports()
+		serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = serverSession.Close() })
+
+		client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+		session, err := client.Connect(t.Context(), clientTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = session.Close() })
+		return &ClientSession{ClientSession: session}
+	}
+
+	t.Run("tool-only server", func(t *testing.T) {
+		t.Parallel()
+
+		// Methods are recorded on the server's goroutine.
+		var (
+			mu     sync.Mutex
+			listed []string
+		)
+		server := mcp.NewServer(&mcp.Implementation{Name: "tools-only"}, nil)
+		server.AddReceivingMiddleware(func(next mcp.MethodHandler) mcp.MethodHandler {
+			return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+				mu.Lock()
+				listed = append(listed, method)
+				mu.Unlock()
+				return next(ctx, method, req)
+			}
+		})
+		sess := connect(t, server)
+
+		prompts, err := getPrompts(t.Context(), sess)
+		require.NoError(t, err)
+		require.Empty(t, prompts)
+		resources, err := getResources(t.Context(), sess)
+		require.NoError(t, err)
+		require.Empty(t, resources)
+		templates, err := getResourceTemplates(t.Context(), sess)
+		require.NoError(t, err)
+		require.Empty(t, templates)
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotContains(t, listed, "prompts/list")
+		require.NotContains(t, listed, "resources/list")
+		require.NotContains(t, listed, "resources/templates/list")
+	})
+
+	t.Run("advertised but not implemented", func(t *testing.T) {
+		t.Parallel()
+
+		server := mcp.NewServer(&mcp.Implementation{Name: "half-baked"}, &mcp.ServerOptions{
+			Capabilities: &mcp.ServerCapabilities{
+				Prompts:   &mcp.PromptCapabilities{},
+				Resources: &mcp.ResourceCapabilities{},
+			},
+		})
+		server.AddReceivingMiddleware(func(next mcp.MethodHandler) mcp.MethodHandler {
+			return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+				if method == "prompts/list" || method == "resources/list" || method == "resources/templates/list" {
+					return nil, &jsonrpc.Error{Code: jsonrpc.CodeMethodNotFound, Message: "method not found"}
+				}
+				return next(ctx, method, req)
+			}
+		})
+		sess := connect(t, server)
+		require.True(t, sess.Features().Prompts)
+
+		prompts, err := getPrompts(t.Context(), sess)
+		require.NoError(t, err)
+		require.Empty(t, prompts)
+		resources, err := getResources(t.Context(), sess)
+		require.NoError(t, err)
+		require.Empty(t, resources)
+		templates, err := getResourceTemplates(t.Context(), sess)
+		require.NoError(t, err)
+		require.Empty(t, templates)
+	})
+
+	t.Run("resource templates", func(t *testing.T) {
+		t.Parallel()
+
+		server := mcp.NewServer(&mcp.Implementation{Name: "templated"}, nil)
+		server.AddResourceTemplate(&mcp.ResourceTemplate{
+			Name:        "issue",
+			URITemplate: "tracker://issues/{id}",
+			MIMEType:    "text/markdown",
+		}, func(_ context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			return &mcp.ReadResourceResult{Contents: []*mcp.ResourceContents{{
+				URI:      req.Params.URI,
+				MIMEType: "text/markdown",
+				Text:     "# Issue",
+			}}}, nil
+		})
+		sess := connect(t, server)
+
+		templates, err := getResourceTemplates(t.Context(), sess)
+		require.NoError(t, err)
+		require.Len(t, templates, 1)
+		require.Equal(t, "tracker://issues/{id}", templates[0].URITemplate)
+
+		result, err := sess.ReadResource(t.Context(), &mcp.ReadResourceParams{URI: "tracker://issues/42"})
+		require.NoError(t, err)
+		require.Len(t, result.Contents, 1)
+		require.Equal(t, "text/markdown", result.Contents[0].MIMEType)
+		require.Equal(t, "# Issue", result.Contents[0].Text)
+	})
+}
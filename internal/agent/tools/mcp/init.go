@@ -5,20 +5,28 @@ package mcp
 import (
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/crush/internal/config"
 	"github.com/charmbracelet/crush/internal/csync"
+	"github.com/charmbracelet/crush/internal/env"
 	"github.com/charmbracelet/crush/internal/home"
+	"github.com/charmbracelet/crush/internal/log"
 	"github.com/charmbracelet/crush/internal/oauth"
 	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
 	"github.com/charmbracelet/crush/internal/permission"
@@ -45,7 +53,11 @@ func parseLevel(level mcp.LoggingLevel) slog.Level {
 // on close.
 type ClientSession struct {
 	*mcp.ClientSession
-	cancel context.CancelFunc
+	cancel      context.CancelFunc
+	outstanding outstandingCalls
+	// capabilities are the capabilities advertised to the server; nil
+	// means clientCapabilities.
+	capabilities *mcp.ClientCapabilities
 }
 
 // Close cancels the session context and then closes the underlying session.
@@ -59,11 +71,27 @@ var (
 	states         = csync.NewMap[string, ClientInfo]()
 	broker         = pubsub.NewBroker[Event]()
 	tokenProviders = csync.NewMap[string, *OAuthTokenProvider]()
-	tokenStore     *TokenStore
+	tokenStore     = csync.NewValue[TokenStore](nil)
 	initOnce       sync.Once
 	initDone       = make(chan struct{})
+
+	toolsChangedDebouncer = newDebouncer()
+	lastHealthChecks      = csync.NewMap[string, time.Time]()
+	connStats             = csync.NewMap[string, connectionStats]()
+	// disabledAtRuntime holds the servers disabled with DisableClient until
+	// they are enabled again with EnableClient.
+	disabledAtRuntime = csync.NewMap[string, bool]()
 )
 
+// connectionStats accumulates connection metrics for a server across
+// sessions.
+type connectionStats struct {
+	connects        int
+	connectDuration time.Duration
+	// uptime is the time spent connected in sessions that have ended.
+	uptime time.Duration
+}
+
 // State represents the current state of an MCP client
 type State int
 
@@ -98,6 +126,11 @@ const (
 	EventPromptsListChanged
 	EventResourcesListChanged
 	EventOAuthRequired
+	EventOAuthStage
+	// EventClientsSettled is published when no enabled server is starting
+	// anymore after any of them was, on startup as well as after later
+	// reconnects or additions.
+	EventClientsSettled
 )
 
 // Event represents an event in the MCP system
@@ -109,6 +142,8 @@ type Event struct {
 	Counts        Counts
 	AuthURL       string
 	BrowserFailed bool
+	// AuthStage is the OAuth stage transition, for EventOAuthStage.
+	AuthStage mcpoauth.StageEvent
 }
 
 // Counts number of available tools, prompts, etc.
@@ -126,6 +161,26 @@ type ClientInfo struct {
 	Client      *ClientSession
 	Counts      Counts
 	ConnectedAt time.Time
+	// ServerInfo is the implementation the server reported during the
+	// handshake: its name, version and title.
+	ServerInfo *mcp.Implementation
+	// Capabilities are the capabilities the server advertised.
+	Capabilities *mcp.ServerCapabilities
+	// Features are the optional features negotiated with the server.
+	Features Features
+	// InFlight is the number of tool calls currently running on the server.
+	InFlight int
+	// ConnectDuration is how long the last successful connect took.
+	ConnectDuration time.Duration
+	// Reconnects is how many times the server connected after the first
+	// time.
+	Reconnects int
+	// Uptime is the total time the server has been connected, including
+	// the current session.
+	Uptime time.Duration
+	// OAuth describes the server's OAuth token, e.g. its granted scopes. It
+	// is nil for servers not using OAuth and before authorization.
+	OAuth *OAuthInfo
 }
 
 // SubscribeEvents returns a channel for MCP events
@@ -135,56 +190,119 @@ func SubscribeEvents(ctx context.Context) <-chan pubsub.Event[Event] {
 
 // GetStates returns the current state of all MCP clients
 func GetStates() map[string]ClientInfo {
-	return states.Copy()
+	all := states.Copy()
+	for name, info := range all {
+		all[name] = withLiveStats(info)
+	}
+	return all
 }
 
 // GetState returns the state of a specific MCP client
 func GetState(name string) (ClientInfo, bool) {
-	return states.Get(name)
+	info, ok := states.Get(name)
+	if ok {
+		info = withLiveStats(info)
+	}
+	return info, ok
 }
 
-// Close closes all MCP clients. This should be called during application shutdown.
+// withLiveStats fills in the ClientInfo fields that change without a state
+// update.
+func withLiveStats(info ClientInfo) ClientInfo {
+	info.InFlight = inFlightCalls(info.Name)
+	if provider, ok := tokenProviders.Get(info.Name); ok {
+		info.OAuth = provider.OAuthInfo()
+	}
+	if info.State == StateConnected && !info.ConnectedAt.IsZero() {
+		info.Uptime += time.Since(info.ConnectedAt)
+	}
+	return info
+}
+
+// Close closes all MCP clients. This should be called during application
+// shutdown. It returns the joined errors of the servers that did not shut
+// down cleanly.
 func Close(ctx context.Context) error {
-	var wg sync.WaitGroup
+	results := CloseWithResults(ctx)
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(results)) {
+		if err := results[name]; err != nil {
+			errs = append(errs, fmt.Errorf("mcp %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CloseWithResults closes all MCP clients in parallel like Close, returning
+// the shutdown error of each server, nil for those that shut down cleanly.
+func CloseWithResults(ctx context.Context) map[string]error {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error)
+	)
 	for name, session := range sessions.Seq2() {
 		wg.Go(func() {
-			done := make(chan error, 1)
-			go func() {
-				done <- session.Close()
-			}()
-			select {
-			case err := <-done:
-				if err != nil &&
-					!errors.Is(err, io.EOF) &&
-					!errors.Is(err, context.Canceled) &&
-					err.Error() != "signal: killed" {
-					slog.Warn("Failed to shutdown MCP client", "name", name, "error", err)
-				}
-			case <-ctx.Done():
+			err := closeSession(ctx, session.Close)
+			if err != nil {
+				slog.Warn("Failed to shutdown MCP client", "name", name, "error", err)
 			}
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
 		})
 	}
 	wg.Wait()
+	closeStderrLogs()
+	clearDiscoveryCache()
 	broker.Shutdown()
-	return nil
+	return results
+}
+
+// closeSession runs closeFn until it returns or ctx is done. Errors expected
+// from a server going away, like EOF or a killed process, are ignored.
+func closeSession(ctx context.Context, closeFn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- closeFn()
+	}()
+	select {
+	case err := <-done:
+		if err == nil ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, context.Canceled) ||
+			err.Error() == "signal: killed" {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown did not finish: %w", ctx.Err())
+	}
 }
 
 // Initialize initializes MCP clients based on the provided configuration.
 func Initialize(ctx context.Context, permissions permission.Service, cfg *config.ConfigStore) {
 	slog.Info("Initializing MCP clients")
-	// Initialize the token store for OAuth token persistence (uses global data directory)
-	tokenStore = NewTokenStore()
+	// Initialize the token store for OAuth token persistence (uses global
+	// data directory unless another store was set)
+	if tokenStore.Get() == nil {
+		tokenStore.Set(defaultTokenStore())
+	}
+	caches.SetLimit(int64(cfg.Config().Options.MCPCacheLimit) << 20)
+	samplingPermissions.Set(permissions)
 
 	var wg sync.WaitGroup
+	var started []string
 	// Initialize states for all configured MCPs
 	for name, m := range cfg.Config().MCP {
-		if m.Disabled {
+		if m.Disabled || isDisabledAtRuntime(name) {
 			updateState(name, StateDisabled, nil, nil, Counts{})
 			slog.Debug("Skipping disabled MCP", "name", name)
 			continue
 		}
 
 		// Set initial starting state
+		started = append(started, name)
 		wg.Add(1)
 		go func(name string, m config.MCPConfig) {
 			defer func() {
@@ -209,10 +327,91 @@ func Initialize(ctx context.Context, permissions permission.Service, cfg *config
 			}
 		}(name, m)
 	}
-	wg.Wait()
+	if !waitInit(ctx, &wg) {
+		slog.Warn("MCP initialization cancelled", "error", ctx.Err())
+		markInitCancelled(started)
+		initOnce.Do(func() { close(initDone) })
+		return
+	}
+	if cfg.Config().Options.MCPPruneTokens {
+		pruneTokens(cfg.Config().MCP)
+	}
 	initOnce.Do(func() { close(initDone) })
 }
 
+// waitInit waits for wg and reports whether it finished before ctx was done.
+// The clients still starting see the same ctx and wind down on their own.
+func waitInit(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// markInitCancelled marks the named servers that are still starting as
+// errored after Initialize was cancelled.
+func markInitCancelled(names []string) {
+	for _, name := range names {
+		if info, ok := states.Get(name); ok && info.State == StateStarting {
+			updateState(name, StateError, errors.New("init cancelled"), nil, Counts{})
+		}
+	}
+}
+
+// SetTokenStore sets the store for OAuth data of MCP servers, replacing the
+// file-based default. Call it before Initialize; nil restores the default.
+func SetTokenStore(store TokenStore) {
+	tokenStore.Set(store)
+}
+
+// defaultTokenStore returns the file-based token store. If its directory is
+// not writable, e.g. because CRUSH_GLOBAL_DATA points to a read-only path, it
+// falls back to a memory store holding a copy of the file's data, so OAuth
+// still works for the current session instead of failing after the user
+// authorized in the browser.
+func defaultTokenStore() TokenStore {
+	store := NewTokenStore()
+	return fallbackTokenStore(store, store.CheckWritable())
+}
+
+// fallbackTokenStore returns store if writeErr is nil, and otherwise a
+// memory store seeded with a copy of its data.
+func fallbackTokenStore(store *FileTokenStore, writeErr error) TokenStore {
+	if writeErr == nil {
+		return store
+	}
+	slog.Warn("MCP OAuth tokens will not be saved beyond this session", "error", writeErr)
+	mem := NewMemoryTokenStore()
+	if err := copyTokenStore(mem, store); err != nil {
+		slog.Warn("Failed to read stored MCP OAuth data", "error", err)
+	}
+	return mem
+}
+
+// pruneTokens removes stored OAuth data of MCP servers that are no longer
+// configured.
+func pruneTokens(servers map[string]config.MCPConfig) {
+	keep := make(map[string]bool, len(servers))
+	for name := range servers {
+		keep[name] = true
+	}
+	removed, err := pruneStore(tokenStore.Get(), keep)
+	if err != nil {
+		slog.Warn("Failed to prune MCP OAuth data", "error", err)
+		return
+	}
+	if len(removed) > 0 {
+		slog.Info("Pruned OAuth data of removed MCP servers", "entries", removed)
+	}
+}
+
 // WaitForInit blocks until MCP initialization is complete.
 // If Initialize was never called, this returns immediately.
 func WaitForInit(ctx context.Context) error {
@@ -224,6 +423,93 @@ func WaitForInit(ctx context.Context) error {
 	}
 }
 
+// WaitForReady blocks until ready reports true for the current server
+// states or MCP initialization is complete, whichever comes first. ready is
+// called again on every state change, so callers can start using fast servers
+// while slow ones are still connecting. A nil ready waits for initialization
+// only, like WaitForInit.
+func WaitForReady(ctx context.Context, ready func(states map[string]ClientInfo) bool) error {
+	if ready == nil {
+		return WaitForInit(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribe before checking the current states so no transition is
+	// missed.
+	events := SubscribeEvents(ctx)
+	for {
+		select {
+		case <-initDone:
+			return nil
+		default:
+		}
+		if ready(GetStates()) {
+			return nil
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					return WaitForInit(ctx)
+				}
+				waiting = ev.Payload.Type != EventStateChanged
+			case <-initDone:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// WaitForConnected blocks until the named MCP server is connected. It returns
+// an error if the server fails or is disabled, or when ctx is done.
+func WaitForConnected(ctx context.Context, name string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribe before checking the current state so no transition is missed.
+	events := SubscribeEvents(ctx)
+	for {
+		if info, ok := GetState(name); ok {
+			switch info.State {
+			case StateConnected:
+				return nil
+			case StateError:
+				if info.Error != nil {
+					return fmt.Errorf("mcp '%s' failed to connect: %w", name, info.Error)
+				}
+				return fmt.Errorf("mcp '%s' failed to connect", name)
+			case StateDisabled:
+				return fmt.Errorf("mcp '%s' is disabled", name)
+			}
+		}
+
+		// Wait for the next state change of this server. The state is read
+		// again rather than taken from the event, since events can be dropped.
+		for waiting := true; waiting; {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					return fmt.Errorf("mcp '%s': event stream closed", name)
+				}
+				waiting = ev.Payload.Type != EventStateChanged || ev.Payload.Name != name
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
 // InitializeSingle initializes a single MCP client by name.
 func InitializeSingle(ctx context.Context, name string, cfg *config.ConfigStore) error {
 	m, exists := cfg.Config().MCP[name]
@@ -231,7 +517,7 @@ func InitializeSingle(ctx context.Context, name string, cfg *config.ConfigStore)
 		return fmt.Errorf("mcp '%s' not found in configuration", name)
 	}
 
-	if m.Disabled {
+	if m.Disabled || isDisabledAtRuntime(name) {
 		updateState(name, StateDisabled, nil, nil, Counts{})
 		slog.Debug("Skipping disabled MCP", "name", name)
 		return nil
@@ -240,6 +526,40 @@ func InitializeSingle(ctx context.Context, name string, cfg *config.ConfigStore)
 	return initClient(ctx, cfg, name, m, cfg.Resolver())
 }
 
+// EnableClient connects an MCP server that is currently disabled, whether
+// by DisableClient or in its configuration, or that failed to connect; the
+// configuration is left unchanged. It does nothing for a server that is
+// connected or connecting.
+func EnableClient(ctx context.Context, cfg *config.ConfigStore, name string) error {
+	m, exists := cfg.Config().MCP[name]
+	if !exists {
+		return fmt.Errorf("mcp '%s' not found in configuration", name)
+	}
+	if info, ok := states.Get(name); ok && (info.State == StateConnected || info.State == StateStarting) {
+		return nil
+	}
+
+	disabledAtRuntime.Del(name)
+	slog.Info("Enabling mcp client", "name", name)
+	return initClient(ctx, cfg, name, m, cfg.Resolver())
+}
+
+// DisableClient closes the session of an MCP server and keeps it disabled,
+// also across InitializeSingle, until EnableClient is called. The
+// configuration is left unchanged.
+func DisableClient(cfg *config.ConfigStore, name string) error {
+	if _, exists := cfg.Config().MCP[name]; !exists {
+		return fmt.Errorf("mcp '%s' not found in configuration", name)
+	}
+	disabledAtRuntime.Set(name, true)
+	return DisableSingle(cfg, name)
+}
+
+func isDisabledAtRuntime(name string) bool {
+	disabled, _ := disabledAtRuntime.Get(name)
+	return disabled
+}
+
 // initClient initializes a single MCP client with the given configuration.
 func initClient(ctx context.Context, cfg *config.ConfigStore, name string, m config.MCPConfig, resolver config.VariableResolver) error {
 	// Set initial starting state.
@@ -267,6 +587,20 @@ func initClient(ctx context.Context, cfg *config.ConfigStore, name string, m con
 		return err
 	}
 
+	// Don't register a session that finished connecting after the caller
+	// gave up, and leave the server in a state it can be enabled from.
+	if err := ctx.Err(); err != nil {
+		updateState(name, StateError, errors.New("init cancelled"), nil, Counts{})
+		session.Close()
+		return err
+	}
+	// Nor one disabled while it was connecting.
+	if isDisabledAtRuntime(name) {
+		updateState(name, StateDisabled, nil, nil, Counts{})
+		session.Close()
+		return nil
+	}
+
 	toolCount := updateTools(cfg, name, tools)
 	updatePrompts(name, prompts)
 	sessions.Set(name, session)
@@ -291,6 +625,7 @@ func DisableSingle(cfg *config.ConfigStore, name string) error {
 		}
 		sessions.Del(name)
 	}
+	lastHealthChecks.Del(name)
 
 	// Clear tools and prompts for this MCP.
 	updateTools(cfg, name, nil)
@@ -303,6 +638,70 @@ func DisableSingle(cfg *config.ConfigStore, name string) error {
 	return nil
 }
 
+// RemoveConfigField removes key from the config file for the given scope.
+// A key naming a whole MCP server, "mcp.<name>", removes that server: once
+// it is no longer configured in any scope, its client is disabled and its
+// dynamically registered OAuth client, if any, is deleted.
+func RemoveConfigField(ctx context.Context, cfg *config.ConfigStore, scope config.Scope, key string) error {
+	name, ok := strings.CutPrefix(key, "mcp.")
+	if !ok || name == "" || strings.Contains(name, ".") {
+		return cfg.RemoveConfigField(scope, key)
+	}
+	m, configured := cfg.Config().MCP[name]
+	if err := cfg.RemoveConfigField(scope, key); err != nil {
+		return err
+	}
+	if _, still := cfg.Config().MCP[name]; !configured || still {
+		return nil
+	}
+	if err := DisableSingle(cfg, name); err != nil {
+		return err
+	}
+	return UnregisterClient(ctx, name, m)
+}
+
+// ForceReauthenticate runs the OAuth authorization flow of an MCP server
+// even if it holds a valid token, keeping its client credentials.
+func ForceReauthenticate(ctx context.Context, name string) (*oauth.Token, error) {
+	provider, ok := tokenProviders.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("MCP %q does not use OAuth", name)
+	}
+	return provider.ForceReauthenticate(ctx)
+}
+
+// UnregisterClient deletes the dynamic client registration for an MCP server
+// and the profile in its configuration from the authorization server (RFC
+// 7592) and removes its stored OAuth data, along with the token provider
+// holding them in memory. The request uses the server's OAuth HTTP settings.
+// The stored data is removed even if the server cannot be reached, so a
+// removed server leaves no credentials behind.
+func UnregisterClient(ctx context.Context, name string, m config.MCPConfig) error {
+	tokenProviders.Del(name)
+	profile := m.Profile
+
+	store := tokenStore.Get()
+	if store == nil {
+		store = NewTokenStore()
+	}
+
+	data, err := store.Load(name, profile)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var deleteErr error
+	if data.RegistrationClientURI != "" && data.RegistrationAccessToken != "" {
+		var oauthCfg mcpoauth.Config
+		applyOAuthSettings(&oauthCfg, m)
+		deleteErr = mcpoauth.DeleteClientRegistration(ctx, oauthCfg, data.RegistrationAccessToken, data.RegistrationClientURI)
+		if deleteErr != nil {
+			slog.Warn("Failed to delete OAuth client registration", "name", name, "error", deleteErr)
+		}
+	}
+	return errors.Join(deleteErr, store.Delete(name, profile))
+}
+
 func getOrRenewClient(ctx context.Context, cfg *config.ConfigStore, name string) (*ClientSession, error) {
 	sess, ok := sessions.Get(name)
 	if !ok {
@@ -316,11 +715,23 @@ func getOrRenewClient(ctx context.Context, cfg *config.ConfigStore, name string)
 	pingCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	err := sess.Ping(pingCtx, nil)
+	if err == nil {
+		err = checkHealth(pingCtx, name, sess, m)
+	}
 	if err == nil {
 		return sess, nil
 	}
-	updateState(name, StateError, maybeTimeoutErr(err, timeout), nil, state.Counts)
+	if ctx.Err() != nil {
+		// The caller gave up; that says nothing about the server's health.
+		return nil, ctx.Err()
+	}
+	timedOut := errors.Is(pingCtx.Err(), context.DeadlineExceeded)
+	updateState(name, StateError, maybeTimeoutErr(err, timeout, timedOut), nil, state.Counts)
+	// The new session gets checked on its first use.
+	lastHealthChecks.Del(name)
 
+	// The new session resolves env and headers again, so rotated secrets
+	// take effect without a restart.
 	sess, err = createSession(ctx, name, m, cfg.Resolver())
 	if err != nil {
 		return nil, err
@@ -331,6 +742,41 @@ func getOrRenewClient(ctx context.Context, cfg *config.ConfigStore, name string)
 	return sess, nil
 }
 
+// checkHealth runs the configured health check if one is due. A server can
+// answer pings while failing real requests, so this lists tools or calls a
+// canary tool on a longer interval than pings.
+func checkHealth(ctx context.Context, name string, sess *ClientSession, m config.MCPConfig) error {
+	hc := m.HealthCheck
+	if hc == nil {
+		return nil
+	}
+	interval := time.Duration(cmp.Or(hc.Interval, 300)) * time.Second
+	if last, ok := lastHealthChecks.Get(name); ok && time.Since(last) < interval {
+		return nil
+	}
+
+	if hc.Tool == "" {
+		if _, err := sess.ListTools(ctx, nil); err != nil {
+			return fmt.Errorf("health check failed: %w", err)
+		}
+		lastHealthChecks.Set(name, time.Now())
+		return nil
+	}
+
+	result, err := sess.CallTool(ctx, &mcp.CallToolParams{
+		Name:      hc.Tool,
+		Arguments: hc.Arguments,
+	})
+	if err != nil {
+		return fmt.Errorf("health check tool %q failed: %w", hc.Tool, err)
+	}
+	if result.IsError {
+		return fmt.Errorf("health check tool %q returned an error", hc.Tool)
+	}
+	lastHealthChecks.Set(name, time.Now())
+	return nil
+}
+
 // updateState updates the state of an MCP client and publishes an event
 func updateState(name string, state State, err error, client *ClientSession, counts Counts) {
 	info := ClientInfo{
@@ -340,9 +786,37 @@ func updateState(name string, state State, err error, client *ClientSession, cou
 		Client: client,
 		Counts: counts,
 	}
+	if client != nil && client.ClientSession != nil {
+		if res := client.InitializeResult(); res != nil {
+			info.ServerInfo = res.ServerInfo
+			info.Capabilities = res.Capabilities
+		}
+	}
+	if client != nil {
+		info.Features = client.Features()
+	}
+
+	prev, _ := states.Get(name)
+	wasConnected := prev.State == StateConnected && !prev.ConnectedAt.IsZero()
+	sameSession := wasConnected && state == StateConnected && prev.Client == client
+	stats, _ := connStats.Get(name)
+	if wasConnected && !sameSession {
+		stats.uptime += time.Since(prev.ConnectedAt)
+		connStats.Set(name, stats)
+	}
+	info.ConnectDuration = stats.connectDuration
+	info.Reconnects = max(stats.connects-1, 0)
+	info.Uptime = stats.uptime
+
 	switch state {
 	case StateConnected:
-		info.ConnectedAt = time.Now()
+		// Refreshing lists reports the same session again; keep the time
+		// it connected.
+		if sameSession {
+			info.ConnectedAt = prev.ConnectedAt
+		} else {
+			info.ConnectedAt = time.Now()
+		}
 	case StateError:
 		sessions.Del(name)
 	}
@@ -356,15 +830,60 @@ func updateState(name string, state State, err error, client *ClientSession, cou
 		Error:  err,
 		Counts: counts,
 	})
+	publishSettled(state)
+}
+
+var (
+	settleMu sync.Mutex
+	// unsettled is set while a server is starting, so EventClientsSettled
+	// is published once per round of state churn.
+	unsettled bool
+)
+
+// publishSettled publishes EventClientsSettled once no server is starting
+// anymore after a state change to state.
+func publishSettled(state State) {
+	settleMu.Lock()
+	defer settleMu.Unlock()
+
+	if state == StateStarting {
+		unsettled = true
+		return
+	}
+	if !unsettled {
+		return
+	}
+	for info := range states.Seq() {
+		if info.State == StateStarting {
+			return
+		}
+	}
+	unsettled = false
+	broker.Publish(pubsub.UpdatedEvent, Event{Type: EventClientsSettled})
 }
 
 func createSession(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver) (*ClientSession, error) {
+	breaker := breakerFor(name)
+	if err := breaker.allow(); err != nil {
+		updateState(name, StateError, err, nil, Counts{})
+		slog.Debug("Skipping MCP connect", "name", name, "error", err)
+		return nil, err
+	}
+	maxFailures, window, cooldown := breakerSettings(m)
+
 	timeout := mcpTimeout(m)
 	mcpCtx, cancel := context.WithCancel(ctx)
-	cancelTimer := time.AfterFunc(timeout, cancel)
+	// timedOut tells the timer's cancellation apart from the parent's.
+	var timedOut atomic.Bool
+	cancelTimer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	start := time.Now()
 
-	transport, err := createTransport(mcpCtx, name, m, resolver, tokenStore)
+	transport, err := createTransport(mcpCtx, name, m, resolver, tokenStore.Get())
 	if err != nil {
+		breaker.failure(maxFailures, window, cooldown)
 		updateState(name, StateError, err, nil, Counts{})
 		slog.Error("Error creating MCP client", "error", err, "name", name)
 		cancel()
@@ -378,36 +897,17 @@ func createSession(ctx context.Context, name string, m config.MCPConfig, resolve
 			Version: version.Version,
 			Title:   "Crush",
 		},
-		&mcp.ClientOptions{
-			ToolListChangedHandler: func(context.Context, *mcp.ToolListChangedRequest) {
-				broker.Publish(pubsub.UpdatedEvent, Event{
-					Type: EventToolsListChanged,
-					Name: name,
-				})
-			},
-			PromptListChangedHandler: func(context.Context, *mcp.PromptListChangedRequest) {
-				broker.Publish(pubsub.UpdatedEvent, Event{
-					Type: EventPromptsListChanged,
-					Name: name,
-				})
-			},
-			ResourceListChangedHandler: func(context.Context, *mcp.ResourceListChangedRequest) {
-				broker.Publish(pubsub.UpdatedEvent, Event{
-					Type: EventResourcesListChanged,
-					Name: name,
-				})
-			},
-			LoggingMessageHandler: func(ctx context.Context, req *mcp.LoggingMessageRequest) {
-				level := parseLevel(req.Params.Level)
-				slog.Log(ctx, level, "MCP log", "name", name, "logger", req.Params.Logger, "data", req.Params.Data)
-			},
-		},
+		clientOptions(name, m),
 	)
 
 	session, err := client.Connect(mcpCtx, transport, nil)
 	if err != nil {
 		err = maybeStdioErr(err, transport)
-		updateState(name, StateError, maybeTimeoutErr(err, timeout), nil, Counts{})
+		if ctx.Err() == nil {
+			// Cancellation by the caller is not the server's fault.
+			breaker.failure(maxFailures, window, cooldown)
+		}
+		updateState(name, StateError, maybeTimeoutErr(err, timeout, timedOut.Load()), nil, Counts{})
 		slog.Error("MCP client failed to initialize", "error", err, "name", name)
 		cancel()
 		cancelTimer.Stop()
@@ -415,8 +915,73 @@ func createSession(ctx context.Context, name string, m config.MCPConfig, resolve
 	}
 
 	cancelTimer.Stop()
-	slog.Debug("MCP client initialized", "name", name)
-	return &ClientSession{session, cancel}, nil
+	breaker.success()
+	recordConnect(name, time.Since(start))
+	c := &ClientSession{ClientSession: session, cancel: cancel, capabilities: capabilitiesFor(m)}
+	slog.Debug("MCP client initialized", "name", name, "features", c.Features())
+	return c, nil
+}
+
+// recordConnect records a successful connect to a server that took d.
+func recordConnect(name string, d time.Duration) {
+	stats, _ := connStats.Get(name)
+	stats.connects++
+	stats.connectDuration = d
+	connStats.Set(name, stats)
+}
+
+// clientOptions returns the client options for an MCP server. Notification
+// handlers ignore notifications for features the server did not negotiate.
+// Sampling requests are only handled for servers that enable it.
+func clientOptions(name string, m config.MCPConfig) *mcp.ClientOptions {
+	opts := &mcp.ClientOptions{
+		Capabilities: capabilitiesFor(m),
+		ToolListChangedHandler: func(_ context.Context, req *mcp.ToolListChangedRequest) {
+			if !sessionFeatures(req.Session).ToolsListChanged {
+				slog.Debug("Ignoring unnegotiated MCP notification", "name", name, "notification", "tools/list_changed")
+				return
+			}
+			// Coalesce bursts of notifications so consumers refetch once
+			// the server has settled.
+			toolsChangedDebouncer.Trigger(name, toolsChangedDebounce(m), func() {
+				broker.Publish(pubsub.UpdatedEvent, Event{
+					Type: EventToolsListChanged,
+					Name: name,
+				})
+			})
+		},
+		PromptListChangedHandler: func(_ context.Context, req *mcp.PromptListChangedRequest) {
+			if !sessionFeatures(req.Session).PromptsListChanged {
+				slog.Debug("Ignoring unnegotiated MCP notification", "name", name, "notification", "prompts/list_changed")
+				return
+			}
+			broker.Publish(pubsub.UpdatedEvent, Event{
+				Type: EventPromptsListChanged,
+				Name: name,
+			})
+		},
+		ResourceListChangedHandler: func(_ context.Context, req *mcp.ResourceListChangedRequest) {
+			if !sessionFeatures(req.Session).ResourcesListChanged {
+				slog.Debug("Ignoring unnegotiated MCP notification", "name", name, "notification", "resources/list_changed")
+				return
+			}
+			broker.Publish(pubsub.UpdatedEvent, Event{
+				Type: EventResourcesListChanged,
+				Name: name,
+			})
+		},
+		LoggingMessageHandler: func(ctx context.Context, req *mcp.LoggingMessageRequest) {
+			if !sessionFeatures(req.Session).Logging {
+				return
+			}
+			level := parseLevel(req.Params.Level)
+			slog.Log(ctx, level, "MCP log", "name", name, "logger", req.Params.Logger, "data", req.Params.Data)
+		},
+	}
+	if m.Sampling {
+		opts.CreateMessageHandler = createMessageHandler(name)
+	}
+	return opts
 }
 
 // maybeStdioErr if a stdio mcp prints an error in non-json format, it'll fail
@@ -430,53 +995,71 @@ func maybeStdioErr(err error, transport mcp.Transport) error {
 	if !errors.Is(err, io.EOF) {
 		return err
 	}
-	ct, ok := transport.(*mcp.CommandTransport)
-	if !ok {
+	var cmd *exec.Cmd
+	switch ct := transport.(type) {
+	case *mcp.CommandTransport:
+		cmd = ct.Command
+	case *tolerantCommandTransport:
+		cmd = ct.Command
+	default:
 		return err
 	}
-	if err2 := stdioCheck(ct.Command); err2 != nil {
+	if err2 := stdioCheck(cmd); err2 != nil {
 		err = errors.Join(err, err2)
 	}
 	return err
 }
 
-func maybeTimeoutErr(err error, timeout time.Duration) error {
-	if errors.Is(err, context.Canceled) {
+// maybeTimeoutErr describes a context error as a timeout when the connect
+// timer fired, and as a cancellation otherwise, e.g. during shutdown.
+func maybeTimeoutErr(err error, timeout time.Duration, timedOut bool) error {
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if timedOut {
 		return fmt.Errorf("timed out after %s", timeout)
 	}
-	return err
+	return fmt.Errorf("cancelled: %w", err)
 }
 
-func createTransport(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver, tokenStore *TokenStore) (mcp.Transport, error) {
+func createTransport(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver, tokenStore TokenStore) (mcp.Transport, error) {
 	switch m.Type {
 	case config.MCPStdio:
-		command, err := resolver.ResolveValue(m.Command)
+		cmd, err := stdioCommand(ctx, m, resolver)
 		if err != nil {
-			return nil, fmt.Errorf("invalid mcp command: %w", err)
+			return nil, err
 		}
-		if strings.TrimSpace(command) == "" {
-			return nil, fmt.Errorf("mcp stdio config requires a non-empty 'command' field")
+		stderr, err := stderrLog(name, m, resolver, cmd.Args[0])
+		if err != nil {
+			return nil, err
 		}
-		cmd := exec.CommandContext(ctx, home.Long(command), m.Args...)
-		cmd.Env = append(os.Environ(), m.ResolvedEnv()...)
-		return &mcp.CommandTransport{
-			Command: cmd,
-		}, nil
+		cmd.Stderr = stderr
+		return stdioTransport(name, m, cmd), nil
 	case config.MCPHttp:
-		if strings.TrimSpace(m.URL) == "" {
-			return nil, fmt.Errorf("mcp http config requires a non-empty 'url' field")
+		url, err := resolveURL(m, resolver)
+		if err != nil {
+			return nil, err
+		}
+		m.URL = url
+		transport, err := buildHTTPTransport(ctx, name, m, resolver, tokenStore)
+		if err != nil {
+			return nil, err
 		}
-		transport := buildHTTPTransport(ctx, name, m, tokenStore)
 		client := &http.Client{Transport: transport}
 		return &mcp.StreamableClientTransport{
 			Endpoint:   m.URL,
 			HTTPClient: client,
 		}, nil
 	case config.MCPSSE:
-		if strings.TrimSpace(m.URL) == "" {
-			return nil, fmt.Errorf("mcp sse config requires a non-empty 'url' field")
+		url, err := resolveURL(m, resolver)
+		if err != nil {
+			return nil, err
+		}
+		m.URL = url
+		transport, err := buildHTTPTransport(ctx, name, m, resolver, tokenStore)
+		if err != nil {
+			return nil, err
 		}
-		transport := buildHTTPTransport(ctx, name, m, tokenStore)
 		client := &http.Client{Transport: transport}
 		return &mcp.SSEClientTransport{
 			Endpoint:   m.URL,
@@ -487,34 +1070,215 @@ func createTransport(ctx context.Context, name string, m config.MCPConfig, resol
 	}
 }
 
-// buildHTTPTransport creates an http.RoundTripper with appropriate middleware.
-// It stacks OAuth (if configured or discovered) on top of static headers.
-func buildHTTPTransport(ctx context.Context, name string, m config.MCPConfig, tokenStore *TokenStore) http.RoundTripper {
-	transport := http.DefaultTransport
+// stdioCommand returns the command that runs a stdio MCP server.
+func stdioCommand(ctx context.Context, m config.MCPConfig, resolver config.VariableResolver) (*exec.Cmd, error) {
+	command, err := resolveCommand(m, resolver)
+	if err != nil {
+		return nil, err
+	}
+	args, err := resolveArgs(m.Args, resolver)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mcp args: %w", err)
+	}
+	dir, err := resolveCwd(m, resolver)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, home.Long(command), args...)
+	cmd.Dir = dir
+	cmd.Env = stdioEnv(m, resolver)
+	return cmd, nil
+}
 
-	// Add static headers layer
-	if len(m.Headers) > 0 {
-		transport = &headerRoundTripper{
-			headers: m.ResolvedHeaders(),
-			base:    transport,
+// cleanEnvVars are the variables passed to stdio servers that don't inherit
+// the environment. SystemRoot is needed by most processes on Windows.
+var cleanEnvVars = []string{"PATH", "HOME", "SystemRoot"}
+
+// stdioEnv returns the environment of a stdio MCP server: Crush's own
+// environment, or only cleanEnvVars if the server doesn't inherit it, plus
+// the configured variables.
+func stdioEnv(m config.MCPConfig, resolver config.VariableResolver) []string {
+	if m.InheritsEnv() {
+		return append(os.Environ(), m.ResolveEnv(resolver)...)
+	}
+	// Non-nil so that an empty environment isn't taken as inheriting.
+	env := make([]string, 0, len(cleanEnvVars)+len(m.Env))
+	for _, k := range cleanEnvVars {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return append(env, m.ResolveEnv(resolver)...)
+}
+
+// resolveCwd resolves the working directory of a stdio MCP server and checks
+// that it is an existing directory. It returns "" when none is configured,
+// so the server runs in the current directory.
+func resolveCwd(m config.MCPConfig, resolver config.VariableResolver) (string, error) {
+	if m.Cwd == "" {
+		return "", nil
+	}
+	dir, err := resolver.ResolveValue(m.Cwd)
+	if err != nil {
+		return "", fmt.Errorf("invalid mcp cwd: %w", err)
+	}
+	dir = home.Long(dir)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("mcp cwd %q is not accessible: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("mcp cwd %q is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// stdioTransport returns the transport for a stdio MCP server running cmd.
+func stdioTransport(name string, m config.MCPConfig, cmd *exec.Cmd) mcp.Transport {
+	if m.TolerantStdout {
+		return &tolerantCommandTransport{
+			name:    name,
+			Command: cmd,
+		}
+	}
+	return &mcp.CommandTransport{
+		Command: cmd,
+	}
+}
+
+// resolveCommand resolves the command of a stdio MCP server.
+func resolveCommand(m config.MCPConfig, resolver config.VariableResolver) (string, error) {
+	command, err := resolver.ResolveValue(m.Command)
+	if err != nil {
+		return "", fmt.Errorf("invalid mcp command: %w", err)
+	}
+	if strings.TrimSpace(command) == "" {
+		return "", fmt.Errorf("mcp stdio config requires a non-empty 'command' field")
+	}
+	return command, nil
+}
+
+// resolveURL resolves ${VAR} and ${VAR:-default} references in the URL of
+// an HTTP or SSE MCP server and checks that it is not empty. Other dollar
+// signs, such as in an OData $filter query, are kept.
+func resolveURL(m config.MCPConfig, resolver config.VariableResolver) (string, error) {
+	url, err := resolveBracedVars(m.URL, resolver)
+	if err != nil {
+		return "", fmt.Errorf("invalid mcp url: %w", err)
+	}
+	if strings.TrimSpace(url) == "" {
+		return "", fmt.Errorf("mcp %s config requires a non-empty 'url' field", m.Type)
+	}
+	return url, nil
+}
+
+// resolveArgs resolves ${VAR} and ${VAR:-default} references in the
+// arguments of a stdio server. Anything else, such as $1 in an awk program
+// or a trailing $ in a regular expression, is passed through verbatim, as
+// arguments were before variables were supported.
+func resolveArgs(args []string, resolver config.VariableResolver) ([]string, error) {
+	resolved := make([]string, len(args))
+	for i, arg := range args {
+		v, err := resolveBracedVars(arg, resolver)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = v
+	}
+	return resolved, nil
+}
+
+// resolveBracedVars replaces each ${VAR} and ${VAR:-default} reference in s
+// with its value. An unterminated ${, or a reference that isn't of that form
+// or whose default contains a $, is left as is, so command substitution never
+// runs.
+func resolveBracedVars(s string, resolver config.VariableResolver) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end == -1 {
+			break
+		}
+		end += start + 1
+		ref := s[start:end]
+		if !isBracedVar(ref) {
+			b.WriteString(s[:start+2])
+			s = s[start+2:]
+			continue
+		}
+		v, err := resolver.ResolveValue(ref)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:start])
+		b.WriteString(v)
+		s = s[end:]
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+// isBracedVar reports whether ref is ${VAR} or ${VAR:-default} with a valid
+// variable name and a default without a $.
+func isBracedVar(ref string) bool {
+	name, fallback, _ := strings.Cut(ref[2:len(ref)-1], ":-")
+	if name == "" || strings.Contains(fallback, "$") {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
 		}
 	}
+	return true
+}
+
+// buildHTTPTransport creates an http.RoundTripper with appropriate middleware.
+// It stacks OAuth (if configured or discovered) on top of static headers, and
+// fails if OAuth discovery could not complete.
+func buildHTTPTransport(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver, tokenStore TokenStore) (http.RoundTripper, error) {
+	m = m.WithEnvSecrets(name, env.New())
+	transport := baseHTTPTransport(name, m, resolver)
+
+	// A token command replaces OAuth.
+	if len(m.TokenCommand) > 0 {
+		provider, err := newCommandTokenProvider(name, m, resolver)
+		if err != nil {
+			slog.Error("Failed to create token command provider", "mcp", name, "error", err)
+			return transport, nil
+		}
+		return NewOAuthRoundTripper(provider, transport), nil
+	}
 
 	// Skip OAuth if explicitly disabled
 	if !m.OAuth.IsEnabled() {
 		slog.Debug("OAuth disabled for MCP", "name", name)
-		return transport
+		return transport, nil
 	}
 
 	// Resolve OAuth configuration (explicit or auto-discovered)
-	oauthCfg := resolveOAuthConfig(ctx, m)
+	checkServerURL(name, m, tokenStore)
+	oauthCfg, err := resolveOAuthConfig(ctx, name, m)
+	if errors.Is(err, mcpoauth.ErrEndpointHostRejected) {
+		return nil, fmt.Errorf("%w (add the host to oauth.allowed_endpoint_hosts to trust it)", err)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Add OAuth layer if we have configuration
 	if oauthCfg != nil && oauthCfg.AuthURL != "" && oauthCfg.TokenURL != "" {
-		provider, err := NewOAuthTokenProvider(name, *oauthCfg, tokenStore)
+		provider, err := NewOAuthTokenProvider(name, m.Profile, *oauthCfg, tokenStore)
 		if err != nil {
 			slog.Error("Failed to create OAuth provider", "mcp", name, "error", err)
-			return transport // Fall back to non-OAuth transport
+			return transport, nil // Fall back to non-OAuth transport
 		}
 
 		// Set up the auth function immediately so it's available when needed
@@ -523,6 +1287,9 @@ func buildHTTPTransport(ctx context.Context, name string, m config.MCPConfig, to
 			slog.Info("Starting OAuth authorization flow", "mcp", mcpName)
 
 			opts := mcpoauth.DefaultAuthFlowOptions()
+			opts.OnStage = func(event mcpoauth.StageEvent) {
+				publishAuthStage(mcpName, event)
+			}
 			opts.OnAuthURL = func(url string) {
 				slog.Info("Please authorize in your browser", "mcp", mcpName, "url", url)
 			}
@@ -540,39 +1307,359 @@ func buildHTTPTransport(ctx context.Context, name string, m config.MCPConfig, to
 		})
 		slog.Debug("OAuth auth function configured for MCP", "name", name)
 
-		registerTokenProvider(name, provider)
+		if m.OAuth != nil {
+			provider.SetStrictIntrospection(m.OAuth.StrictIntrospection)
+		}
+		provider.onEndpointNotFound = func() {
+			slog.Debug("OAuth endpoint not found, invalidating discovery cache", "mcp", mcpName)
+			invalidateDiscovery(mcpName)
+		}
+
+		provider.serverURLHash = serverURLHash(m.URL)
+		provider = registerTokenProvider(name, provider)
 
 		transport = NewOAuthRoundTripper(provider, transport)
 	}
 
+	return transport, nil
+}
+
+// baseHTTPTransport returns the transport of an HTTP or SSE MCP server
+// without any authorization layer: HTTP/2 settings, rate limit retries, call
+// timeouts, static headers and trace propagation.
+func baseHTTPTransport(name string, m config.MCPConfig, resolver config.VariableResolver) http.RoundTripper {
+	transport := http.DefaultTransport
+
+	if m.DisableHTTP2 {
+		slog.Debug("HTTP/2 disabled for MCP", "name", name)
+		transport = newHTTP1Transport()
+	}
+
+	// SSE streams stay open for the whole session, so only bound the wait
+	// for the response headers rather than the whole request.
+	if m.Type == config.MCPSSE {
+		transport = withResponseHeaderTimeout(transport, mcpTimeout(m))
+	}
+
+	// Retry requests rejected with 429 after their Retry-After delay.
+	transport = newRateLimitRoundTripper(name, m, transport)
+
+	// Bound each request to an HTTP server, but not the standalone SSE
+	// stream, which stays open for the whole session.
+	if timeout := callTimeout(m); timeout > 0 {
+		transport = callTimeoutRoundTripper{timeout: timeout, base: transport}
+	}
+
+	// Add static headers layer
+	if len(m.Headers) > 0 {
+		headers := m.ResolveHeaders(resolver)
+		slog.Debug("Setting static headers for MCP", "name", name, "headers", log.RedactHeaderMap(headers))
+		transport = &headerRoundTripper{
+			headers: headers,
+			base:    transport,
+		}
+	}
+
+	// Add trace propagation layer
+	if m.TraceHeader != "" {
+		transport = traceRoundTripper{
+			header: m.TraceHeader,
+			base:   transport,
+		}
+	}
+
 	return transport
 }
 
+// publishAuthStage publishes an OAuth stage transition of a server.
+func publishAuthStage(name string, event mcpoauth.StageEvent) {
+	if event.Stage == mcpoauth.AuthStageFailed {
+		slog.Debug("OAuth stage failed", "mcp", name, "stage", event.FailedStage, "error", event.Error)
+	}
+	broker.Publish(pubsub.UpdatedEvent, Event{
+		Type:      EventOAuthStage,
+		Name:      name,
+		Error:     event.Error,
+		AuthStage: event,
+	})
+}
+
+// newHTTP1Transport returns a copy of the default transport that never
+// negotiates HTTP/2.
+func newHTTP1Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = false
+	// A non-nil, empty map disables HTTP/2 over TLS.
+	t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	return t
+}
+
+// withResponseHeaderTimeout returns a copy of base that waits at most
+// timeout for response headers.
+func withResponseHeaderTimeout(base http.RoundTripper, timeout time.Duration) http.RoundTripper {
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	t = t.Clone()
+	t.ResponseHeaderTimeout = timeout
+	return t
+}
+
+// newCommandTokenProvider creates the token provider for a server's token
+// command, resolving variables in its arguments.
+func newCommandTokenProvider(name string, m config.MCPConfig, resolver config.VariableResolver) (*CommandTokenProvider, error) {
+	command := make([]string, len(m.TokenCommand))
+	for i, arg := range m.TokenCommand {
+		resolved, err := resolver.ResolveValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token command: %w", err)
+		}
+		command[i] = resolved
+	}
+	return NewCommandTokenProvider(name, command, time.Duration(m.TokenCommandTTL)*time.Second)
+}
+
 // resolveOAuthConfig returns the OAuth configuration for an MCP server.
-// It first checks for explicit configuration, then attempts auto-discovery.
-// Returns nil if no OAuth configuration is available.
-func resolveOAuthConfig(ctx context.Context, m config.MCPConfig) *mcpoauth.Config {
-	// Check for explicit configuration
-	if m.OAuth != nil && m.OAuth.ClientID != "" {
-		return &mcpoauth.Config{
-			ClientID:     m.OAuth.ClientID,
-			ClientSecret: m.OAuth.ClientSecret,
-			AuthURL:      m.OAuth.AuthURL,
-			TokenURL:     m.OAuth.TokenURL,
-			Scopes:       m.OAuth.Scopes,
-			RedirectURI:  m.OAuth.RedirectURI,
+// Returns nil if no OAuth configuration is available, and an error if
+// discovery could not tell whether the server supports OAuth.
+//
+// Explicit configuration with a client ID is used as is, and discovery only
+// runs without one. SkipDiscovery uses the explicit configuration even
+// without a client ID, and ForceDiscovery runs discovery even with one,
+// filling in endpoints the config leaves unset. SkipDiscovery wins if both
+// are set. Explicit values always take precedence over discovered ones.
+func resolveOAuthConfig(ctx context.Context, name string, m config.MCPConfig) (*mcpoauth.Config, error) {
+	o := m.OAuth
+	switch {
+	case o != nil && o.SkipDiscovery:
+		if o.AuthURL == "" || o.TokenURL == "" {
+			slog.Warn("OAuth discovery skipped without authorization_url and token_url, disabling OAuth", "url", m.URL)
+			return nil, nil
+		}
+		return explicitOAuthConfig(m), nil
+	case o != nil && o.ForceDiscovery:
+		cfg, err := discoverOAuth(ctx, name, m.URL, discoveryOptions(m))
+		if cfg == nil {
+			if o.ClientID == "" {
+				return nil, err
+			}
+			if err != nil {
+				slog.Warn("OAuth discovery failed, using the configured client", "mcp", name, "error", err)
+			}
+			return explicitOAuthConfig(m), nil
 		}
+		return mergeOAuthConfig(cfg, m), nil
+	case o != nil && o.ClientID != "":
+		return explicitOAuthConfig(m), nil
 	}
 
 	// Try auto-discovery
-	cfg, err := mcpoauth.DiscoverOAuth(ctx, m.URL)
-	if err != nil || cfg == nil {
+	cfg, err := discoverOAuth(ctx, name, m.URL, discoveryOptions(m))
+	if cfg != nil {
+		applyOAuthSettings(cfg, m)
+	}
+	return cfg, err
+}
+
+// serverURLHash returns the hash of an MCP server URL kept with its OAuth
+// data to notice when the URL changes.
+func serverURLHash(serverURL string) string {
+	sum := sha256.Sum256([]byte(serverURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkServerURL discards the stored OAuth data and cached discovery result
+// of an MCP server whose URL changed since the data was saved, as tokens and
+// client registrations issued for the old server are not valid for the new
+// one.
+func checkServerURL(name string, m config.MCPConfig, store TokenStore) {
+	if store == nil {
+		return
+	}
+	data, err := store.Load(name, m.Profile)
+	if err != nil || data == nil || data.ServerURLHash == "" || data.ServerURLHash == serverURLHash(m.URL) {
+		return
+	}
+
+	slog.Info("MCP server URL changed, discarding stored OAuth data", "mcp", name)
+	invalidateDiscovery(name)
+	if err := store.Delete(name, m.Profile); err != nil {
+		slog.Warn("Failed to discard stored OAuth data", "mcp", name, "error", err)
+	}
+}
+
+// discoveryOptions returns the OAuth discovery options of an MCP server.
+// Failing discovery only fails servers with an oauth block, as others may
+// not use OAuth at all.
+func discoveryOptions(m config.MCPConfig) mcpoauth.DiscoveryOptions {
+	return mcpoauth.DiscoveryOptions{
+		Policy:       endpointHostPolicy(m),
+		AllowedHosts: allowedEndpointHosts(m),
+		Transport:    oauthTransport(m),
+		RequireOAuth: m.OAuth != nil,
+	}
+}
+
+// oauthTransport returns the transport for the OAuth requests of an MCP
+// server. It follows the server's HTTP/2 setting, and nil means
+// http.DefaultTransport.
+func oauthTransport(m config.MCPConfig) http.RoundTripper {
+	if m.DisableHTTP2 {
+		return oauthHTTP1Transport()
+	}
+	return nil
+}
+
+// oauthHTTP1Transport is shared by the OAuth requests of all servers with
+// HTTP/2 disabled, so they reuse connections.
+var oauthHTTP1Transport = sync.OnceValue(newHTTP1Transport)
+
+// endpointHostPolicy returns how discovery treats endpoints on a host other
+// than the issuer's.
+func endpointHostPolicy(m config.MCPConfig) mcpoauth.EndpointHostPolicy {
+	if m.OAuth == nil {
+		return mcpoauth.EndpointHostStrict
+	}
+	return mcpoauth.EndpointHostPolicy(m.OAuth.EndpointHostCheck)
+}
+
+// allowedEndpointHosts returns the hosts besides the issuer's that
+// discovered endpoints may use.
+func allowedEndpointHosts(m config.MCPConfig) []string {
+	if m.OAuth == nil {
 		return nil
 	}
+	return m.OAuth.AllowedEndpointHosts
+}
 
+// explicitOAuthConfig returns the OAuth configuration set in the config.
+func explicitOAuthConfig(m config.MCPConfig) *mcpoauth.Config {
+	cfg := &mcpoauth.Config{
+		ClientID:     m.OAuth.ClientID,
+		ClientSecret: m.OAuth.ClientSecret,
+		AuthURL:      m.OAuth.AuthURL,
+		TokenURL:     m.OAuth.TokenURL,
+		Scopes:       m.OAuth.Scopes,
+		RedirectURI:  m.OAuth.RedirectURI,
+
+		IntrospectionEndpoint: m.OAuth.IntrospectionURL,
+	}
+	applyOAuthSettings(cfg, m)
 	return cfg
 }
 
+// mergeOAuthConfig overrides a discovered OAuth configuration with the values
+// set in the config.
+func mergeOAuthConfig(cfg *mcpoauth.Config, m config.MCPConfig) *mcpoauth.Config {
+	o := m.OAuth
+	cfg.ClientID = cmp.Or(o.ClientID, cfg.ClientID)
+	cfg.ClientSecret = cmp.Or(o.ClientSecret, cfg.ClientSecret)
+	cfg.AuthURL = cmp.Or(o.AuthURL, cfg.AuthURL)
+	cfg.TokenURL = cmp.Or(o.TokenURL, cfg.TokenURL)
+	cfg.RedirectURI = cmp.Or(o.RedirectURI, cfg.RedirectURI)
+	cfg.IntrospectionEndpoint = cmp.Or(o.IntrospectionURL, cfg.IntrospectionEndpoint)
+	if len(o.Scopes) > 0 {
+		cfg.Scopes = o.Scopes
+	}
+	applyOAuthSettings(cfg, m)
+	return cfg
+}
+
+// applyOAuthSettings applies the configured client-side OAuth settings.
+func applyOAuthSettings(cfg *mcpoauth.Config, m config.MCPConfig) {
+	cfg.DefaultExpiresIn = defaultExpiresIn(m)
+	cfg.ExpirySkew = expirySkew(m)
+	cfg.HTTPTimeout = oauthTimeout(m)
+	cfg.Transport = oauthTransport(m)
+	cfg.Registration = registrationMetadata(m)
+	if m.OAuth != nil {
+		// Scopes set explicitly are needed; discovered ones are only what
+		// the server supports.
+		cfg.RequiredScopes = m.OAuth.Scopes
+	}
+}
+
+// registrationMetadata returns the configured dynamic registration metadata.
+func registrationMetadata(m config.MCPConfig) mcpoauth.RegistrationMetadata {
+	if m.OAuth == nil || m.OAuth.Registration == nil {
+		return mcpoauth.RegistrationMetadata{}
+	}
+	r := m.OAuth.Registration
+	return mcpoauth.RegistrationMetadata{
+		ClientName:              r.ClientName,
+		TokenEndpointAuthMethod: r.TokenEndpointAuthMethod,
+		SoftwareID:              r.SoftwareID,
+		SoftwareVersion:         r.SoftwareVersion,
+		Contacts:                r.Contacts,
+		LogoURI:                 r.LogoURI,
+		SoftwareStatement:       r.SoftwareStatement,
+	}
+}
+
+// oauthTimeout returns the configured timeout for authorization server
+// requests, or zero to use the default.
+func oauthTimeout(m config.MCPConfig) time.Duration {
+	if m.OAuth == nil {
+		return 0
+	}
+	return time.Duration(m.OAuth.Timeout) * time.Second
+}
+
+// defaultExpiresIn returns the token lifetime assumed when the server reports
+// no expiry.
+func defaultExpiresIn(m config.MCPConfig) time.Duration {
+	if m.OAuth == nil {
+		return 0
+	}
+	return time.Duration(m.OAuth.DefaultExpiresIn) * time.Second
+}
+
+// expirySkew returns how long before their expiry tokens are refreshed, or
+// zero to use the default.
+func expirySkew(m config.MCPConfig) time.Duration {
+	if m.OAuth == nil {
+		return 0
+	}
+	return time.Duration(m.OAuth.ExpirySkew) * time.Second
+}
+
+// callTimeoutRoundTripper bounds requests to an HTTP server, including the
+// response body, which may stream a tool result. GET requests open the
+// standalone SSE stream, which is idle most of the time and would otherwise
+// be cut and reconnected every timeout, so they are not bounded.
+type callTimeoutRoundTripper struct {
+	timeout time.Duration
+	base    http.RoundTripper
+}
+
+func (rt callTimeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		return rt.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), rt.timeout)
+	resp, err := rt.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the context of a request once its response body
+// is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 type headerRoundTripper struct {
 	headers map[string]string
 	base    http.RoundTripper
@@ -593,11 +1680,32 @@ func mcpTimeout(m config.MCPConfig) time.Duration {
 	return time.Duration(cmp.Or(m.Timeout, 15)) * time.Second
 }
 
+// callTimeout returns the timeout for requests to an HTTP server, 0 meaning
+// none. It does not apply to SSE servers, whose stream is long-lived.
+func callTimeout(m config.MCPConfig) time.Duration {
+	if m.Type != config.MCPHttp {
+		return 0
+	}
+	return time.Duration(m.CallTimeout) * time.Second
+}
+
+// defaultToolsChangedDebounce batches tools/list_changed notifications of
+// servers that do not configure a window.
+const defaultToolsChangedDebounce = 250 * time.Millisecond
+
+func toolsChangedDebounce(m config.MCPConfig) time.Duration {
+	if m.ToolsChangedDebounce == 0 {
+		return defaultToolsChangedDebounce
+	}
+	return time.Duration(m.ToolsChangedDebounce) * time.Millisecond
+}
+
 func stdioCheck(old *exec.Cmd) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, old.Path, old.Args...)
+	cmd := exec.CommandContext(ctx, old.Path, old.Args[1:]...)
 	cmd.Env = old.Env
+	cmd.Dir = old.Dir
 	out, err := cmd.CombinedOutput()
 	if err == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
 		return nil
@@ -605,7 +1713,14 @@ func stdioCheck(old *exec.Cmd) error {
 	return fmt.Errorf("%w: %s", err, string(out))
 }
 
-// registerTokenProvider registers a token provider for an MCP server.
-func registerTokenProvider(name string, provider *OAuthTokenProvider) {
+// registerTokenProvider registers a token provider for an MCP server and
+// returns the provider to use. When the client is reinitialized, the earlier
+// provider is reused with the new settings where possible, so its in-memory
+// token survives the reconnect.
+func registerTokenProvider(name string, provider *OAuthTokenProvider) *OAuthTokenProvider {
+	if prev, ok := tokenProviders.Get(name); ok && prev.reuse(provider) {
+		return prev
+	}
 	tokenProviders.Set(name, provider)
+	return provider
 }
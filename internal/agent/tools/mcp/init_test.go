@@ -1,9 +1,28 @@
 package mcp
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
@@ -25,7 +44,7 @@ func TestMCPSession_CancelOnClose(t *testing.T) {
 	clientSession, err := client.Connect(ctx, clientTransport, nil)
 	require.NoError(t, err)
 
-	sess := &ClientSession{clientSession, cancel}
+	sess := &ClientSession{ClientSession: clientSession, cancel: cancel}
 
 	// Verify the context is not cancelled before close.
 	require.NoError(t, ctx.Err())
@@ -36,3 +55,1069 @@ func TestMCPSession_CancelOnClose(t *testing.T) {
 	// After Close, the context must be cancelled.
 	require.ErrorIs(t, ctx.Err(), context.Canceled)
 }
+
+// testHTTPTransport builds the HTTP transport of an MCP server, failing the
+// test on error.
+func testHTTPTransport(t *testing.T, name string, m config.MCPConfig, resolver config.VariableResolver, store TokenStore) http.RoundTripper {
+	t.Helper()
+	transport, err := buildHTTPTransport(t.Context(), name, m, resolver, store)
+	require.NoError(t, err)
+	return transport
+}
+
+func TestBuildHTTPTransport_DisableHTTP2(t *testing.T) {
+	disabled := false
+	oauthOff := &config.MCPOAuthConfig{Enabled: &disabled}
+
+	t.Run("uses default transport by default", func(t *testing.T) {
+		transport := withoutRateLimit(testHTTPTransport(t, "test", config.MCPConfig{OAuth: oauthOff}, nil, nil))
+		require.Same(t, http.DefaultTransport, transport)
+	})
+
+	t.Run("clears TLSNextProto when disabled", func(t *testing.T) {
+		transport := withoutRateLimit(testHTTPTransport(t, "test", config.MCPConfig{
+			OAuth:        oauthOff,
+			DisableHTTP2: true,
+		}, nil, nil))
+		httpTransport, ok := transport.(*http.Transport)
+		require.True(t, ok)
+		require.NotNil(t, httpTransport.TLSNextProto)
+		require.Empty(t, httpTransport.TLSNextProto)
+		require.False(t, httpTransport.ForceAttemptHTTP2)
+
+		// The default transport must be left untouched.
+		require.True(t, http.DefaultTransport.(*http.Transport).ForceAttemptHTTP2)
+	})
+}
+
+func TestHTTPTimeouts(t *testing.T) {
+	t.Parallel()
+
+	disabled := false
+	oauthOff := &config.MCPOAuthConfig{Enabled: &disabled}
+
+	t.Run("sse waits only for response headers", func(t *testing.T) {
+		t.Parallel()
+		m := config.MCPConfig{Type: config.MCPSSE, OAuth: oauthOff, Timeout: 7, CallTimeout: 30}
+		transport, ok := withoutRateLimit(testHTTPTransport(t, "test", m, nil, nil)).(*http.Transport)
+		require.True(t, ok)
+		require.Equal(t, 7*time.Second, transport.ResponseHeaderTimeout)
+		require.Zero(t, callTimeout(m))
+		require.Zero(t, http.DefaultTransport.(*http.Transport).ResponseHeaderTimeout)
+	})
+
+	t.Run("http bounds whole requests", func(t *testing.T) {
+		t.Parallel()
+		m := config.MCPConfig{Type: config.MCPHttp, OAuth: oauthOff, CallTimeout: 30}
+		transport, ok := testHTTPTransport(t, "test", m, nil, nil).(callTimeoutRoundTripper)
+		require.True(t, ok)
+		require.Equal(t, 30*time.Second, transport.timeout)
+		require.Same(t, http.DefaultTransport, withoutRateLimit(transport.base))
+		require.Zero(t, callTimeout(config.MCPConfig{Type: config.MCPHttp}))
+	})
+
+	t.Run("bounds posts but not the standalone stream", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+			}
+		}))
+		t.Cleanup(srv.Close)
+		rt := callTimeoutRoundTripper{timeout: 50 * time.Millisecond, base: http.DefaultTransport}
+
+		do := func(method string) error {
+			req, err := http.NewRequestWithContext(t.Context(), method, srv.URL, nil)
+			require.NoError(t, err)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			return err
+		}
+		require.ErrorIs(t, do(http.MethodPost), context.DeadlineExceeded)
+		require.NoError(t, do(http.MethodGet))
+	})
+}
+
+func TestCallTimeout_KeepsIdleSession(t *testing.T) {
+	t.Parallel()
+
+	// Count standalone streams that were opened and that ended.
+	var opened, ended atomic.Int32
+	server := mcp.NewServer(&mcp.Implementation{Name: "idle"}, nil)
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		opened.Add(1)
+		handler.ServeHTTP(w, r)
+		ended.Add(1)
+	}))
+	t.Cleanup(ts.Close)
+
+	name := "idle-" + t.Name()
+	t.Cleanup(func() { states.Del(name) })
+	m := config.MCPConfig{
+		Type:        config.MCPHttp,
+		URL:         ts.URL,
+		CallTimeout: 1,
+		OAuth:       &config.MCPOAuthConfig{Enabled: new(false)},
+	}
+	sess, err := createSession(t.Context(), name, m, config.NewShellVariableResolver(env.New()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sess.Close() })
+
+	// Stay idle for longer than the call timeout: the standalone stream
+	// must not be cut.
+	time.Sleep(1500 * time.Millisecond)
+	require.Equal(t, int32(1), opened.Load())
+	require.Zero(t, ended.Load())
+	_, err = sess.ListTools(t.Context(), nil)
+	require.NoError(t, err)
+}
+
+func TestCheckHealth(t *testing.T) {
+	connect := func(t *testing.T, canaryFails bool) *ClientSession {
+		t.Helper()
+		serverTransport, clientTransport := mcp.NewInMemoryTransports()
+
+		server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+		server.AddTool(&mcp.Tool{
+			Name:        "canary",
+			InputSchema: map[string]any{"type": "object"},
+		}, func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return &mcp.CallToolResult{IsError: canaryFails}, nil
+		})
+		serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { serverSession.Close() })
+
+		ctx, cancel := context.WithCancel(t.Context())
+		client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, nil)
+		clientSession, err := client.Connect(ctx, clientTransport, nil)
+		require.NoError(t, err)
+		sess := &ClientSession{ClientSession: clientSession, cancel: cancel}
+		t.Cleanup(func() { sess.Close() })
+		return sess
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		sess := connect(t, true)
+		require.NoError(t, checkHealth(t.Context(), "health-disabled", sess, config.MCPConfig{}))
+	})
+
+	t.Run("lists tools without a canary", func(t *testing.T) {
+		t.Cleanup(func() { lastHealthChecks.Del("health-list") })
+		sess := connect(t, true)
+		m := config.MCPConfig{HealthCheck: &config.MCPHealthCheckConfig{}}
+		require.NoError(t, checkHealth(t.Context(), "health-list", sess, m))
+	})
+
+	t.Run("ping succeeds but canary fails", func(t *testing.T) {
+		sess := connect(t, true)
+		m := config.MCPConfig{HealthCheck: &config.MCPHealthCheckConfig{Tool: "canary"}}
+
+		require.NoError(t, sess.Ping(t.Context(), nil))
+		err := checkHealth(t.Context(), "health-canary-fails", sess, m)
+		require.ErrorContains(t, err, `health check tool "canary"`)
+
+		// A failed check is not recorded, so the next one runs again.
+		err = checkHealth(t.Context(), "health-canary-fails", sess, m)
+		require.ErrorContains(t, err, `health check tool "canary"`)
+	})
+
+	t.Run("canary succeeds", func(t *testing.T) {
+		t.Cleanup(func() { lastHealthChecks.Del("health-canary-ok") })
+		sess := connect(t, false)
+		m := config.MCPConfig{HealthCheck: &config.MCPHealthCheckConfig{Tool: "canary"}}
+		require.NoError(t, checkHealth(t.Context(), "health-canary-ok", sess, m))
+
+		// The next check waits for the interval to pass.
+		_, ok := lastHealthChecks.Get("health-canary-ok")
+		require.True(t, ok)
+		require.NoError(t, checkHealth(t.Context(), "health-canary-ok", sess, m))
+	})
+}
+
+func TestGetOrRenewClient_FailingCanaryReconnects(t *testing.T) {
+	t.Setenv("CRUSH_GLOBAL_CONFIG", t.TempDir())
+	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+	// The canary fails in the first session only.
+	var connects atomic.Int32
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server {
+		failing := connects.Add(1) == 1
+		server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+		server.AddTool(&mcp.Tool{
+			Name:        "canary",
+			InputSchema: map[string]any{"type": "object"},
+		}, func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return &mcp.CallToolResult{IsError: failing}, nil
+		})
+		return server
+	}, nil)
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	name := "health-reconnect"
+	m := config.MCPConfig{
+		Type:        config.MCPHttp,
+		URL:         ts.URL,
+		OAuth:       &config.MCPOAuthConfig{Enabled: new(false)},
+		HealthCheck: &config.MCPHealthCheckConfig{Tool: "canary"},
+	}
+	cfg, err := config.Init(t.TempDir(), "", false)
+	require.NoError(t, err)
+	cfg.Config().MCP = config.MCPs{name: m}
+
+	sess, err := createSession(t.Context(), name, m, cfg.Resolver())
+	require.NoError(t, err)
+	sessions.Set(name, sess)
+	t.Cleanup(func() {
+		if sess, ok := sessions.Take(name); ok {
+			_ = sess.Close()
+		}
+		states.Del(name)
+		connStats.Del(name)
+		lastHealthChecks.Del(name)
+	})
+
+	renewed, err := getOrRenewClient(t.Context(), cfg, name)
+	require.NoError(t, err)
+	require.NotSame(t, sess, renewed, "a failing canary must replace the session")
+	require.Equal(t, int32(2), connects.Load())
+	_ = sess.Close()
+
+	// The new session passes its check and is kept.
+	again, err := getOrRenewClient(t.Context(), cfg, name)
+	require.NoError(t, err)
+	require.Same(t, renewed, again)
+}
+
+func TestResolveOAuthConfig(t *testing.T) {
+	t.Cleanup(clearDiscoveryCache)
+
+	t.Run("explicit client ID skips discovery", func(t *testing.T) {
+		clearDiscoveryCache()
+		var hits atomic.Int32
+		server := newDiscoveryServer(t, &hits)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL: server.URL,
+			OAuth: &config.MCPOAuthConfig{
+				ClientID: "explicit-client",
+				AuthURL:  "https://auth.example.com/authorize",
+				TokenURL: "https://auth.example.com/token",
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		require.Equal(t, "https://auth.example.com/token", cfg.TokenURL)
+		require.Zero(t, hits.Load())
+	})
+
+	t.Run("skip discovery without client ID", func(t *testing.T) {
+		clearDiscoveryCache()
+		var hits atomic.Int32
+		server := newDiscoveryServer(t, &hits)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL: server.URL,
+			OAuth: &config.MCPOAuthConfig{
+				SkipDiscovery: true,
+				AuthURL:       "https://auth.example.com/authorize",
+				TokenURL:      "https://auth.example.com/token",
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		require.Empty(t, cfg.ClientID)
+		require.Equal(t, "https://auth.example.com/authorize", cfg.AuthURL)
+		require.Zero(t, hits.Load())
+	})
+
+	t.Run("skip discovery requires endpoints", func(t *testing.T) {
+		clearDiscoveryCache()
+		var hits atomic.Int32
+		server := newDiscoveryServer(t, &hits)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL:   server.URL,
+			OAuth: &config.MCPOAuthConfig{SkipDiscovery: true},
+		})
+		require.NoError(t, err)
+		require.Nil(t, cfg)
+		require.Zero(t, hits.Load())
+	})
+
+	t.Run("force discovery merges with explicit values", func(t *testing.T) {
+		clearDiscoveryCache()
+		var hits atomic.Int32
+		server := newDiscoveryServer(t, &hits)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL: server.URL,
+			OAuth: &config.MCPOAuthConfig{
+				ForceDiscovery: true,
+				ClientID:       "explicit-client",
+				TokenURL:       "https://auth.example.com/token",
+			},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		require.Equal(t, int32(1), hits.Load())
+		require.Equal(t, "explicit-client", cfg.ClientID)
+		require.Equal(t, "https://auth.example.com/token", cfg.TokenURL)
+		require.Equal(t, server.URL+"/authorize", cfg.AuthURL)
+	})
+
+	t.Run("follows the server's HTTP/2 setting", func(t *testing.T) {
+		clearDiscoveryCache()
+		var hits atomic.Int32
+		server := newDiscoveryServer(t, &hits)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{URL: server.URL})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		require.Nil(t, cfg.Transport)
+
+		// The cached discovery result must not keep the old transport.
+		cfg, err = resolveOAuthConfig(context.Background(), "test", config.MCPConfig{URL: server.URL, DisableHTTP2: true})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		httpTransport, ok := cfg.Transport.(*http.Transport)
+		require.True(t, ok)
+		require.False(t, httpTransport.ForceAttemptHTTP2)
+
+		cfg, err = resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL:          server.URL,
+			DisableHTTP2: true,
+			OAuth: &config.MCPOAuthConfig{
+				ClientID: "explicit-client",
+				AuthURL:  "https://auth.example.com/authorize",
+				TokenURL: "https://auth.example.com/token",
+			},
+		})
+		require.NoError(t, err)
+		require.Same(t, httpTransport, cfg.Transport)
+	})
+
+	t.Run("ignores discovery failures without a challenge", func(t *testing.T) {
+		clearDiscoveryCache()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/.well-known/oauth-authorization-server" {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}))
+		t.Cleanup(server.Close)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{URL: server.URL})
+		require.NoError(t, err)
+		require.Nil(t, cfg)
+
+		_, err = createTransport(t.Context(), "test", config.MCPConfig{Type: config.MCPHttp, URL: server.URL}, config.NewShellVariableResolver(env.New()), nil)
+		require.NoError(t, err)
+
+		// Servers configured for OAuth still fail.
+		cfg, err = resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL:   server.URL,
+			OAuth: &config.MCPOAuthConfig{Scopes: []string{"read"}},
+		})
+		require.ErrorIs(t, err, mcpoauth.ErrDiscoveryUnavailable)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("surfaces discovery failures", func(t *testing.T) {
+		clearDiscoveryCache()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(server.Close)
+
+		cfg, err := resolveOAuthConfig(context.Background(), "test", config.MCPConfig{URL: server.URL})
+		require.ErrorIs(t, err, mcpoauth.ErrDiscoveryUnavailable)
+		require.Nil(t, cfg)
+
+		_, err = createTransport(t.Context(), "test", config.MCPConfig{Type: config.MCPHttp, URL: server.URL}, config.NewShellVariableResolver(env.New()), nil)
+		require.ErrorIs(t, err, mcpoauth.ErrDiscoveryUnavailable, "the connect fails so it can be retried")
+
+		// A configured client is used when forced discovery fails.
+		cfg, err = resolveOAuthConfig(context.Background(), "test", config.MCPConfig{
+			URL: server.URL,
+			OAuth: &config.MCPOAuthConfig{
+				ForceDiscovery: true,
+				ClientID:       "explicit-client",
+				AuthURL:        "https://auth.example.com/authorize",
+				TokenURL:       "https://auth.example.com/token",
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "explicit-client", cfg.ClientID)
+	})
+
+	t.Run("reports rejected endpoint hosts", func(t *testing.T) {
+		clearDiscoveryCache()
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/.well-known/") {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"issuer":                   server.URL,
+				"authorization_endpoint":   server.URL + "/authorize",
+				"token_endpoint":           "https://tokens.example.net/token",
+				"response_types_supported": []string{"code"},
+			})
+		}))
+		t.Cleanup(server.Close)
+
+		_, err := createTransport(t.Context(), "test", config.MCPConfig{Type: config.MCPHttp, URL: server.URL}, config.NewShellVariableResolver(env.New()), nil)
+		require.ErrorIs(t, err, mcpoauth.ErrEndpointHostRejected)
+		require.ErrorContains(t, err, "allowed_endpoint_hosts")
+	})
+}
+
+func TestWaitForConnected(t *testing.T) {
+	t.Parallel()
+
+	t.Run("already connected", func(t *testing.T) {
+		t.Parallel()
+
+		name := "wait-" + t.Name()
+		t.Cleanup(func() { states.Del(name) })
+		updateState(name, StateConnected, nil, nil, Counts{})
+
+		require.NoError(t, WaitForConnected(t.Context(), name))
+	})
+
+	t.Run("connects later", func(t *testing.T) {
+		t.Parallel()
+
+		name := "wait-" + t.Name()
+		t.Cleanup(func() { states.Del(name) })
+		updateState(name, StateStarting, nil, nil, Counts{})
+
+		done := make(chan error, 1)
+		go func() { done <- WaitForConnected(t.Context(), name) }()
+
+		time.Sleep(20 * time.Millisecond)
+		updateState(name, StateConnected, nil, nil, Counts{})
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("WaitForConnected did not return after the server connected")
+		}
+	})
+
+	t.Run("server fails", func(t *testing.T) {
+		t.Parallel()
+
+		name := "wait-" + t.Name()
+		t.Cleanup(func() { states.Del(name) })
+		updateState(name, StateStarting, nil, nil, Counts{})
+
+		done := make(chan error, 1)
+		go func() { done <- WaitForConnected(t.Context(), name) }()
+
+		time.Sleep(20 * time.Millisecond)
+		updateState(name, StateError, errors.New("connection refused"), nil, Counts{})
+		select {
+		case err := <-done:
+			require.ErrorContains(t, err, "connection refused")
+		case <-time.After(2 * time.Second):
+			t.Fatal("WaitForConnected did not return after the server failed")
+		}
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		t.Parallel()
+
+		name := "wait-" + t.Name()
+		ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, WaitForConnected(ctx, name), context.DeadlineExceeded)
+	})
+}
+
+func TestWaitForReady(t *testing.T) {
+	t.Parallel()
+
+	fast, slow := "ready-fast-"+t.Name(), "ready-slow-"+t.Name()
+	t.Cleanup(func() {
+		states.Del(fast)
+		states.Del(slow)
+	})
+	updateState(fast, StateStarting, nil, nil, Counts{})
+	updateState(slow, StateStarting, nil, nil, Counts{})
+
+	anyConnected := func(states map[string]ClientInfo) bool {
+		return states[fast].State == StateConnected || states[slow].State == StateConnected
+	}
+	done := make(chan error, 1)
+	go func() { done <- WaitForReady(t.Context(), anyConnected) }()
+
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("WaitForReady returned before any server was ready")
+	default:
+	}
+
+	updateState(fast, StateConnected, nil, nil, Counts{})
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("WaitForReady did not return after a server connected")
+	}
+	require.Equal(t, StateStarting, mustState(t, slow).State)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	never := func(map[string]ClientInfo) bool { return false }
+	require.ErrorIs(t, WaitForReady(ctx, never), context.DeadlineExceeded)
+}
+
+func TestConnectionStats(t *testing.T) {
+	t.Parallel()
+
+	name := "stats-" + t.Name()
+	t.Cleanup(func() {
+		states.Del(name)
+		connStats.Del(name)
+	})
+
+	first := &ClientSession{}
+	recordConnect(name, 240*time.Millisecond)
+	updateState(name, StateConnected, nil, first, Counts{})
+	connectedAt := mustState(t, name).ConnectedAt
+
+	time.Sleep(10 * time.Millisecond)
+	updateState(name, StateConnected, nil, first, Counts{Tools: 3})
+
+	info := mustState(t, name)
+	require.Equal(t, connectedAt, info.ConnectedAt, "refreshing the same session keeps the connect time")
+	require.Equal(t, 240*time.Millisecond, info.ConnectDuration)
+	require.Zero(t, info.Reconnects)
+	require.GreaterOrEqual(t, info.Uptime, 10*time.Millisecond)
+
+	updateState(name, StateError, errors.New("connection reset"), nil, Counts{})
+	info = mustState(t, name)
+	uptime := info.Uptime
+	require.GreaterOrEqual(t, uptime, 10*time.Millisecond, "uptime survives a disconnect")
+	time.Sleep(5 * time.Millisecond)
+	require.Equal(t, uptime, mustState(t, name).Uptime, "uptime does not grow while disconnected")
+
+	recordConnect(name, 100*time.Millisecond)
+	updateState(name, StateConnected, nil, &ClientSession{}, Counts{})
+	info = mustState(t, name)
+	require.Equal(t, 1, info.Reconnects)
+	require.Equal(t, 100*time.Millisecond, info.ConnectDuration)
+	require.GreaterOrEqual(t, info.Uptime, uptime)
+	require.True(t, info.ConnectedAt.After(connectedAt))
+
+	require.Equal(t, info.Reconnects, GetStates()[name].Reconnects)
+}
+
+func mustState(t *testing.T, name string) ClientInfo {
+	t.Helper()
+	info, ok := GetState(name)
+	require.True(t, ok)
+	return info
+}
+
+func TestBuildHTTPTransport_RedactsHeadersInLogs(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	t.Cleanup(server.Close)
+
+	m := config.MCPConfig{
+		Type: config.MCPHttp,
+		URL:  server.URL,
+		Headers: map[string]string{
+			"Authorization": "Bearer s3cr3t-bearer",
+			"X-Api-Key":     "s3cr3t-key",
+			"Cookie":        "session=s3cr3t-cookie",
+			"X-Team":        "platform",
+		},
+		OAuth: &config.MCPOAuthConfig{Enabled: new(false)},
+	}
+	client := &http.Client{Transport: testHTTPTransport(t, "redact", m, config.NewShellVariableResolver(env.New()), nil)}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, "Bearer s3cr3t-bearer", got.Get("Authorization"), "headers are still sent")
+	require.Contains(t, logs.String(), "platform")
+	require.NotContains(t, logs.String(), "s3cr3t")
+}
+
+func TestCreateSession_ResolvesHeadersOnReconnect(t *testing.T) {
+	t.Setenv("CRUSH_TEST_ROTATING_TOKEN", "first")
+
+	var tokens []string
+	var mu sync.Mutex
+	server := mcp.NewServer(&mcp.Implementation{Name: "rotating"}, nil)
+	handler := mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	name := "rotating-" + t.Name()
+	t.Cleanup(func() { states.Del(name) })
+	m := config.MCPConfig{
+		Type:    config.MCPHttp,
+		URL:     ts.URL,
+		Headers: map[string]string{"Authorization": "Bearer $CRUSH_TEST_ROTATING_TOKEN"},
+		OAuth:   &config.MCPOAuthConfig{Enabled: new(false)},
+	}
+	resolver := config.NewShellVariableResolver(env.New())
+	lastToken := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return tokens[len(tokens)-1]
+	}
+
+	sess, err := createSession(t.Context(), name, m, resolver)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer first", lastToken())
+	require.NoError(t, sess.Close())
+
+	t.Setenv("CRUSH_TEST_ROTATING_TOKEN", "second")
+	sess, err = createSession(t.Context(), name, m, resolver)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sess.Close() })
+	require.Equal(t, "Bearer second", lastToken())
+	require.Equal(t, "Bearer $CRUSH_TEST_ROTATING_TOKEN", m.Headers["Authorization"], "config keeps the unresolved value")
+}
+
+func TestMaybeTimeoutErr(t *testing.T) {
+	t.Parallel()
+
+	other := errors.New("boom")
+	require.Equal(t, other, maybeTimeoutErr(other, time.Second, true))
+	require.EqualError(t, maybeTimeoutErr(context.Canceled, 15*time.Second, true), "timed out after 15s")
+	require.EqualError(t, maybeTimeoutErr(context.DeadlineExceeded, time.Second, true), "timed out after 1s")
+
+	err := maybeTimeoutErr(fmt.Errorf("connect: %w", context.Canceled), time.Second, false)
+	require.EqualError(t, err, "cancelled: connect: context canceled")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCreateSession_ReportsTimeoutOrCancellation(t *testing.T) {
+	t.Parallel()
+
+	// The server never answers initialize, so only the timer or the parent
+	// context can end the connect. The cancellation notification that
+	// follows is accepted right away.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte(`"initialize"`)) {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	m := config.MCPConfig{
+		Type:    config.MCPHttp,
+		URL:     ts.URL,
+		Timeout: 1,
+		OAuth:   &config.MCPOAuthConfig{Enabled: new(false)},
+	}
+	resolver := config.NewShellVariableResolver(env.New())
+
+	t.Run("timer", func(t *testing.T) {
+		t.Parallel()
+
+		name := "timeout-" + t.Name()
+		t.Cleanup(func() { states.Del(name) })
+		_, err := createSession(t.Context(), name, m, resolver)
+		require.Error(t, err)
+		require.EqualError(t, mustState(t, name).Error, "timed out after 1s")
+	})
+
+	t.Run("parent", func(t *testing.T) {
+		t.Parallel()
+
+		name := "timeout-" + t.Name()
+		t.Cleanup(func() { states.Del(name) })
+		ctx, cancel := context.WithCancel(t.Context())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		_, err := createSession(ctx, name, m, resolver)
+		require.Error(t, err)
+		state := mustState(t, name)
+		require.ErrorIs(t, state.Error, context.Canceled)
+		require.NotContains(t, state.Error.Error(), "timed out")
+	})
+}
+
+func TestCreateTransport_ResolvesDefaults(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantArgs []string
+		wantURL  string
+	}{
+		{
+			name:     "defaults",
+			wantArgs: []string{"--port", "3000"},
+			wantURL:  "http://localhost:3000/mcp",
+		},
+		{
+			name:     "overrides",
+			env:      map[string]string{"MCP_HOST": "example.com", "MCP_PORT": "8080"},
+			wantArgs: []string{"--port", "8080"},
+			wantURL:  "http://example.com:8080/mcp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resolver := config.NewShellVariableResolver(env.NewFromMap(tt.env))
+
+			stdio, err := createTransport(t.Context(), "defaults", config.MCPConfig{
+				Type:    config.MCPStdio,
+				Command: "${MCP_CMD:-echo}",
+				Args:    []string{"--port", "${MCP_PORT:-3000}"},
+			}, resolver, nil)
+			require.NoError(t, err)
+			cmd := stdio.(*mcp.CommandTransport).Command
+			require.Equal(t, tt.wantArgs, cmd.Args[1:])
+
+			remote, err := createTransport(t.Context(), "defaults", config.MCPConfig{
+				Type:  config.MCPHttp,
+				URL:   "http://${MCP_HOST:-localhost}:${MCP_PORT:-3000}/mcp",
+				OAuth: &config.MCPOAuthConfig{Enabled: new(false)},
+			}, resolver, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantURL, remote.(*mcp.StreamableClientTransport).Endpoint)
+		})
+	}
+
+	// Other dollar signs are kept.
+	remote, err := createTransport(t.Context(), "defaults", config.MCPConfig{
+		Type:  config.MCPHttp,
+		URL:   "http://localhost/odata?$filter=name eq 'a'&$top=$(not run)",
+		OAuth: &config.MCPOAuthConfig{Enabled: new(false)},
+	}, config.NewShellVariableResolver(env.NewFromMap(nil)), nil)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/odata?$filter=name eq 'a'&$top=$(not run)", remote.(*mcp.StreamableClientTransport).Endpoint)
+
+	_, err = createTransport(t.Context(), "defaults", config.MCPConfig{
+		Type: config.MCPHttp,
+		URL:  "http://${MCP_REQUIRED_HOST}/mcp",
+	}, config.NewShellVariableResolver(env.NewFromMap(nil)), nil)
+	require.ErrorContains(t, err, "MCP_REQUIRED_HOST")
+}
+
+func TestResolveArgs(t *testing.T) {
+	t.Parallel()
+
+	resolver := config.NewShellVariableResolver(env.NewFromMap(map[string]string{"MCP_DIR": "/srv"}))
+
+	// Only ${...} references are resolved; other dollar signs are literal.
+	literal := []string{"{print $1}", "^foo$", "$", "$UNSET_VAR", "$(not run)", "cost: $5", "${unterminated", "${X:-$(not run)}", "${$(not run)}", "${1BAD}"}
+	got, err := resolveArgs(literal, resolver)
+	require.NoError(t, err)
+	require.Equal(t, literal, got)
+
+	got, err = resolveArgs([]string{"--root=${MCP_DIR}/data", "${MCP_PORT:-3000}", "$1 ${MCP_DIR}"}, resolver)
+	require.NoError(t, err)
+	require.Equal(t, []string{"--root=/srv/data", "3000", "$1 /srv"}, got)
+
+	_, err = resolveArgs([]string{"${MCP_MISSING}"}, resolver)
+	require.ErrorContains(t, err, "MCP_MISSING")
+}
+
+func TestCreateTransport_Cwd(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	resolver := config.NewShellVariableResolver(env.NewFromMap(map[string]string{"SERVER_DIR": dir}))
+
+	create := func(cwd string) (*exec.Cmd, error) {
+		transport, err := createTransport(t.Context(), "cwd", config.MCPConfig{
+			Type:    config.MCPStdio,
+			Command: "echo",
+			Cwd:     cwd,
+		}, resolver, nil)
+		if err != nil {
+			return nil, err
+		}
+		return transport.(*mcp.CommandTransport).Command, nil
+	}
+
+	cmd, err := create("")
+	require.NoError(t, err)
+	require.Empty(t, cmd.Dir)
+
+	cmd, err = create("$SERVER_DIR")
+	require.NoError(t, err)
+	require.Equal(t, dir, cmd.Dir)
+
+	_, err = create(filepath.Join(dir, "missing"))
+	require.ErrorContains(t, err, "is not accessible")
+
+	_, err = create(file)
+	require.ErrorContains(t, err, "is not a directory")
+}
+
+func TestStdioCheck_UsesWorkingDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "marker"), nil, 0o644))
+
+	// The server only starts from its configured directory.
+	old := exec.Command("sh", "-c", `test -f marker || { echo "marker missing"; exit 1; }`)
+	old.Dir = dir
+	require.NoError(t, stdioCheck(old))
+
+	old = exec.Command("sh", "-c", `test -f marker || { echo "marker missing"; exit 1; }`)
+	old.Dir = t.TempDir()
+	require.ErrorContains(t, stdioCheck(old), "marker missing")
+}
+
+func TestStdioEnv(t *testing.T) {
+	t.Setenv("CRUSH_TEST_SECRET", "secret")
+	t.Setenv("PATH", "/usr/bin")
+	resolver := config.NewShellVariableResolver(env.NewFromMap(nil))
+
+	m := config.MCPConfig{Env: map[string]string{"SERVER_MODE": "test"}}
+	inherited := stdioEnv(m, resolver)
+	require.Contains(t, inherited, "CRUSH_TEST_SECRET=secret")
+	require.Contains(t, inherited, "SERVER_MODE=test")
+
+	m.InheritEnv = new(false)
+	clean := stdioEnv(m, resolver)
+	require.NotContains(t, clean, "CRUSH_TEST_SECRET=secret")
+	require.Contains(t, clean, "PATH=/usr/bin")
+	require.Contains(t, clean, "SERVER_MODE=test")
+}
+
+func TestWaitInit(t *testing.T) {
+	t.Parallel()
+
+	var wg sync.WaitGroup
+	require.True(t, waitInit(t.Context(), &wg))
+
+	release := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-release // a client that ignores its context
+	}()
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	require.False(t, waitInit(ctx, &wg))
+	close(release)
+	wg.Wait()
+}
+
+func TestMarkInitCancelled(t *testing.T) {
+	t.Parallel()
+
+	starting, connected := "cancel-starting-"+t.Name(), "cancel-connected-"+t.Name()
+	t.Cleanup(func() {
+		states.Del(starting)
+		states.Del(connected)
+	})
+	updateState(starting, StateStarting, nil, nil, Counts{})
+	updateState(connected, StateConnected, nil, nil, Counts{Tools: 2})
+
+	markInitCancelled([]string{starting, connected})
+
+	info := mustState(t, starting)
+	require.Equal(t, StateError, info.State)
+	require.EqualError(t, info.Error, "init cancelled")
+	require.Equal(t, StateConnected, mustState(t, connected).State)
+}
+
+func TestClientsSettledEvent(t *testing.T) {
+	// Settling looks at every server's state, so not parallel.
+	a, b := "settle-a", "settle-b"
+	t.Cleanup(func() {
+		states.Del(a)
+		states.Del(b)
+	})
+
+	events := SubscribeEvents(t.Context())
+	settled := func() int {
+		n := 0
+		for {
+			select {
+			case ev := <-events:
+				if ev.Payload.Type == EventClientsSettled {
+					n++
+				}
+			case <-time.After(50 * time.Millisecond):
+				return n
+			}
+		}
+	}
+
+	updateState(a, StateStarting, nil, nil, Counts{})
+	updateState(b, StateStarting, nil, nil, Counts{})
+	updateState(a, StateConnected, nil, nil, Counts{})
+	require.Zero(t, settled(), "b is still starting")
+
+	updateState(b, StateError, errors.New("boom"), nil, Counts{})
+	require.Equal(t, 1, settled())
+
+	// Refreshes of settled servers don't publish again.
+	updateState(a, StateConnected, nil, nil, Counts{Tools: 1})
+	require.Zero(t, settled())
+
+	// A later reconnect settles again.
+	updateState(b, StateStarting, nil, nil, Counts{})
+	updateState(b, StateConnected, nil, nil, Counts{})
+	require.Equal(t, 1, settled())
+}
+
+func TestCloseSession(t *testing.T) {
+	t.Parallel()
+
+	failed := errors.New("broken pipe")
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{"clean", nil, nil},
+		{"eof", fmt.Errorf("read: %w", io.EOF), nil},
+		{"canceled", context.Canceled, nil},
+		{"killed", errors.New("signal: killed"), nil},
+		{"failed", failed, failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := closeSession(t.Context(), func() error { return tt.err })
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+
+	t.Run("context done", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		block := make(chan struct{})
+		t.Cleanup(func() { close(block) })
+		err := closeSession(ctx, func() error {
+			<-block
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.ErrorContains(t, err, "shutdown did not finish")
+	})
+}
+
+func TestDisableEnableClient(t *testing.T) {
+	// Uses the package-wide state maps, so not parallel.
+	const name = "runtime-toggle"
+	t.Cleanup(func() {
+		disabledAtRuntime.Del(name)
+		states.Del(name)
+		connStats.Del(name)
+	})
+	cfg := config.NewTestStore(&config.Config{
+		Options: &config.Options{},
+		MCP: map[string]config.MCPConfig{
+			name: {Type: config.MCPStdio, Command: "crush-no-such-mcp-server", Timeout: 1},
+		},
+	})
+
+	require.Error(t, DisableClient(cfg, "unknown"))
+	require.Error(t, EnableClient(t.Context(), cfg, "unknown"))
+
+	require.NoError(t, DisableClient(cfg, name))
+	require.Equal(t, StateDisabled, mustState(t, name).State)
+
+	// Initializing again keeps the server disabled.
+	require.NoError(t, InitializeSingle(t.Context(), name, cfg))
+	require.Equal(t, StateDisabled, mustState(t, name).State)
+
+	// A connected server is left alone.
+	disabledAtRuntime.Del(name)
+	updateState(name, StateConnected, nil, nil, Counts{})
+	require.NoError(t, EnableClient(t.Context(), cfg, name))
+	require.Equal(t, StateConnected, mustState(t, name).State)
+}
+
+func TestInitializeSingle_CancelledContext(t *testing.T) {
+	// Uses the package-wide state maps, so not parallel.
+	t.Setenv("CRUSH_GLOBAL_CONFIG", t.TempDir())
+	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+	ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(ts.Close)
+
+	const name = "cancelled-init"
+	cfg, err := config.Init(t.TempDir(), "", false)
+	require.NoError(t, err)
+	cfg.Config().MCP = config.MCPs{name: {
+		Type:  config.MCPHttp,
+		URL:   ts.URL,
+		OAuth: &config.MCPOAuthConfig{Enabled: new(false)},
+	}}
+	t.Cleanup(func() {
+		if sess, ok := sessions.Take(name); ok {
+			_ = sess.Close()
+		}
+		states.Del(name)
+		connStats.Del(name)
+		breakers.Del(name)
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	require.ErrorIs(t, InitializeSingle(ctx, name, cfg), context.Canceled)
+	require.Equal(t, StateError, mustState(t, name).State, "a cancelled init must not stay starting")
+
+	ctx, cancel = context.WithCancel(t.Context())
+	cancel()
+	require.Error(t, EnableClient(ctx, cfg, name))
+	require.Equal(t, StateError, mustState(t, name).State)
+
+	// The server can still be enabled once the caller stops cancelling.
+	require.NoError(t, EnableClient(t.Context(), cfg, name))
+	require.Equal(t, StateConnected, mustState(t, name).State)
+}
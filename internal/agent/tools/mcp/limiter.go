@@ -0,0 +1,69 @@
+package mcp
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/charmbracelet/crush/internal/csync"
+)
+
+// callLimiters tracks in-flight tool calls per MCP server and, when a limit
+// is configured, bounds them.
+var callLimiters = csync.NewMap[string, *callLimiter]()
+
+// callLimiter gates tool calls to a single MCP server. Calls beyond the
+// limit wait for a slot; a zero limit never blocks.
+type callLimiter struct {
+	limit    int
+	slots    chan struct{}
+	inFlight atomic.Int64
+}
+
+func newCallLimiter(limit int) *callLimiter {
+	l := &callLimiter{limit: max(limit, 0)}
+	if l.limit > 0 {
+		l.slots = make(chan struct{}, l.limit)
+	}
+	return l
+}
+
+// acquire waits for a free slot. It returns a function releasing the slot,
+// or the context error if ctx is done first.
+func (l *callLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	l.inFlight.Add(1)
+	return func() {
+		l.inFlight.Add(-1)
+		if l.slots != nil {
+			<-l.slots
+		}
+	}, nil
+}
+
+// limiterFor returns the limiter for a server, replacing it if the
+// configured limit changed. Calls holding a slot on a replaced limiter
+// release it there.
+func limiterFor(name string, limit int) *callLimiter {
+	limit = max(limit, 0)
+	return callLimiters.Update(name, func(l *callLimiter, ok bool) *callLimiter {
+		if ok && l.limit == limit {
+			return l
+		}
+		return newCallLimiter(limit)
+	})
+}
+
+// inFlightCalls returns the number of tool calls currently running on a
+// server.
+func inFlightCalls(name string) int {
+	if l, ok := callLimiters.Get(name); ok {
+		return int(l.inFlight.Load())
+	}
+	return 0
+}
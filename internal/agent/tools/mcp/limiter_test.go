@@ -0,0 +1,113 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bounds concurrent calls", func(t *testing.T) {
+		t.Parallel()
+
+		l := newCallLimiter(2)
+		var running, peak atomic.Int64
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Go(func() {
+				release, _ := l.acquire(context.Background())
+				defer release()
+
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				running.Add(-1)
+			})
+		}
+		wg.Wait()
+		require.Equal(t, int64(2), peak.Load())
+		require.Zero(t, l.inFlight.Load())
+	})
+
+	t.Run("queued call honors context", func(t *testing.T) {
+		t.Parallel()
+
+		l := newCallLimiter(1)
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = l.acquire(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Equal(t, int64(1), l.inFlight.Load())
+	})
+
+	t.Run("zero limit is unlimited", func(t *testing.T) {
+		t.Parallel()
+
+		l := newCallLimiter(0)
+		var releases []func()
+		for range 100 {
+			release, err := l.acquire(context.Background())
+			require.NoError(t, err)
+			releases = append(releases, release)
+		}
+		require.Equal(t, int64(100), l.inFlight.Load())
+		for _, release := range releases {
+			release()
+		}
+		require.Zero(t, l.inFlight.Load())
+	})
+}
+
+func TestLimiterFor_ReportsInFlight(t *testing.T) {
+	t.Cleanup(func() {
+		callLimiters.Del("limited")
+		states.Del("limited")
+	})
+	states.Set("limited", ClientInfo{Name: "limited", State: StateConnected})
+
+	l := limiterFor("limited", 1)
+	require.Same(t, l, limiterFor("limited", 1))
+
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+	info, ok := GetState("limited")
+	require.True(t, ok)
+	require.Equal(t, 1, info.InFlight)
+	require.Equal(t, 1, GetStates()["limited"].InFlight)
+
+	release()
+	info, _ = GetState("limited")
+	require.Zero(t, info.InFlight)
+
+	require.NotSame(t, l, limiterFor("limited", 2), "changing the limit replaces the limiter")
+}
+
+func TestLimiterFor_Concurrent(t *testing.T) {
+	t.Cleanup(func() { callLimiters.Del("racing") })
+
+	var wg sync.WaitGroup
+	limiters := make([]*callLimiter, 50)
+	for i := range limiters {
+		wg.Go(func() { limiters[i] = limiterFor("racing", 1) })
+	}
+	wg.Wait()
+
+	for _, l := range limiters {
+		require.Same(t, limiters[0], l, "concurrent callers share one limiter")
+	}
+}
@@ -0,0 +1,240 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Kinds of per-server caches tracked by the cache budget.
+const (
+	cacheTools     = "tools"
+	cachePrompts   = "prompts"
+	cacheResources = "resources"
+)
+
+// refetchTimeout bounds fetching an evicted list again.
+const refetchTimeout = 15 * time.Second
+
+// caches is the global budget for per-server MCP caches.
+var caches = newCacheBudget()
+
+type cacheKey struct {
+	server string
+	kind   string
+}
+
+type cacheEntry struct {
+	size     int64
+	lastUsed uint64
+}
+
+// cacheBudget accounts for the approximate memory used by per-server MCP
+// caches and evicts the least recently used ones once a limit is exceeded.
+// Tool lists are counted but never evicted, since the agent's tool set
+// depends on them. Evicted prompt and resource lists are remembered and
+// fetched again in the background, once per eviction, when a caller asks for
+// it with RefetchEvictedPrompts or RefetchEvictedResources.
+type cacheBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	total   int64
+	clock   uint64
+	entries map[cacheKey]cacheEntry
+	// evicted holds the evicted caches, and whether they are being
+	// fetched again.
+	evicted map[cacheKey]bool
+	evict   map[string]func(server string)
+}
+
+func newCacheBudget() *cacheBudget {
+	return &cacheBudget{
+		entries: make(map[cacheKey]cacheEntry),
+		evicted: make(map[cacheKey]bool),
+		evict: map[string]func(string){
+			cachePrompts:   func(server string) { allPrompts.Del(server) },
+			cacheResources: func(server string) { allResources.Del(server) },
+		},
+	}
+}
+
+// SetLimit sets the budget in bytes, evicting caches if it is already
+// exceeded. Zero or less means unlimited.
+func (b *cacheBudget) SetLimit(limit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+	b.shrink(cacheKey{}, false)
+}
+
+// Track records the cache of the given kind for a server holding value,
+// whose size is estimated from its JSON encoding. A nil or empty value
+// removes the entry. store, if not nil, saves value in the cache; it runs
+// under the budget's lock so that evictions cannot interleave with it.
+// Storing a cache that is being fetched again after its eviction never
+// evicts other caches of the same kind, so refetches cannot keep evicting
+// each other.
+func (b *cacheBudget) Track(server, kind string, value any, store func()) {
+	size := approximateSize(value)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if store != nil {
+		store()
+	}
+	key := cacheKey{server, kind}
+	refetch := b.evicted[key]
+	b.total -= b.entries[key].size
+	delete(b.entries, key)
+	delete(b.evicted, key)
+	if size > 0 {
+		b.clock++
+		b.entries[key] = cacheEntry{size: size, lastUsed: b.clock}
+		b.total += size
+	}
+	b.shrink(key, refetch)
+}
+
+// Evicted returns the servers whose cache of the given kind was evicted and
+// not stored again since.
+func (b *cacheBudget) Evicted(kind string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var servers []string
+	for key := range b.evicted {
+		if key.kind == kind {
+			servers = append(servers, key.server)
+		}
+	}
+	return servers
+}
+
+// ClaimRefetch returns the servers whose cache of the given kind was evicted
+// and is not being fetched again yet, and marks them as being fetched again.
+func (b *cacheBudget) ClaimRefetch(kind string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var servers []string
+	for key, refetching := range b.evicted {
+		if key.kind == kind && !refetching {
+			b.evicted[key] = true
+			servers = append(servers, key.server)
+		}
+	}
+	return servers
+}
+
+// Touch marks a server's cache as recently used.
+func (b *cacheBudget) Touch(server, kind string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := cacheKey{server, kind}
+	if e, ok := b.entries[key]; ok {
+		b.clock++
+		e.lastUsed = b.clock
+		b.entries[key] = e
+	}
+}
+
+// shrink evicts least recently used evictable entries until the total is
+// within the limit, preferring to keep keep. If keep was fetched again after
+// an eviction, other entries of its kind are kept, and keep itself is not
+// fetched again if it is evicted. The caller must hold b.mu.
+func (b *cacheBudget) shrink(keep cacheKey, refetch bool) {
+	for b.limit > 0 && b.total > b.limit {
+		victim, ok := b.leastRecentlyUsed(keep, refetch)
+		if !ok {
+			// Only the kept entry is left to evict.
+			if _, exists := b.entries[keep]; !exists || b.evict[keep.kind] == nil {
+				break
+			}
+			victim = keep
+		}
+		slog.Debug("Evicting MCP cache over memory limit", "name", victim.server, "cache", victim.kind)
+		b.total -= b.entries[victim].size
+		delete(b.entries, victim)
+		b.evicted[victim] = victim == keep && refetch
+		b.evict[victim.kind](victim.server)
+	}
+}
+
+// leastRecentlyUsed returns the evictable entry used longest ago, other
+// than skip, and other than entries of skip's kind if skipKind is set. The
+// caller must hold b.mu.
+func (b *cacheBudget) leastRecentlyUsed(skip cacheKey, skipKind bool) (cacheKey, bool) {
+	var (
+		victim cacheKey
+		oldest uint64
+		found  bool
+	)
+	for key, e := range b.entries {
+		if key == skip || (skipKind && key.kind == skip.kind) || b.evict[key.kind] == nil {
+			continue
+		}
+		if !found || e.lastUsed < oldest {
+			victim, oldest, found = key, e.lastUsed, true
+		}
+	}
+	return victim, found
+}
+
+// refetchEvicted starts fetching again in the background, with refresh, the
+// caches of the given kind that were evicted for servers that are still
+// connected. Each eviction is fetched again at most once.
+func refetchEvicted(kind string, refresh func(ctx context.Context, name string)) {
+	for _, server := range caches.ClaimRefetch(kind) {
+		if _, ok := sessions.Get(server); !ok {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), refetchTimeout)
+			defer cancel()
+			refresh(ctx, server)
+		}()
+	}
+}
+
+// Usage returns the approximate bytes used per server, the total, and the
+// limit (zero when unlimited).
+func (b *cacheBudget) Usage() (servers map[string]int64, total, limit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	servers = make(map[string]int64)
+	for key, e := range b.entries {
+		servers[key.server] += e.size
+	}
+	return servers, b.total, b.limit
+}
+
+// CacheUsage returns the approximate memory, in bytes, used by each MCP
+// server's caches, the total, and the configured limit (zero when
+// unlimited).
+func CacheUsage() (servers map[string]int64, total, limit int64) {
+	return caches.Usage()
+}
+
+// EvictedCaches returns the kinds of cache, "prompts" or "resources",
+// evicted over the limit per server. Until they are fetched again, listing
+// them returns nothing for that server. Reading it does not start fetching
+// them again.
+func EvictedCaches() map[string][]string {
+	evicted := make(map[string][]string)
+	for _, kind := range []string{cachePrompts, cacheResources} {
+		for _, server := range caches.Evicted(kind) {
+			evicted[server] = append(evicted[server], kind)
+		}
+	}
+	return evicted
+}
+
+// approximateSize estimates the memory held by value from the size of its
+// JSON encoding.
+func approximateSize(value any) int64 {
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" || string(data) == "[]" {
+		return 0
+	}
+	return int64(len(data))
+}
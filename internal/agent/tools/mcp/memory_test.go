@@ -0,0 +1,212 @@
+package mcp
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheBudget(t *testing.T) {
+	t.Run("evicts least recently used caches over the limit", func(t *testing.T) {
+		b := newCacheBudget()
+		evicted := map[string][]string{}
+		for _, kind := range []string{cachePrompts, cacheResources} {
+			b.evict[kind] = func(server string) { evicted[kind] = append(evicted[kind], server) }
+		}
+
+		value := strings.Repeat("x", 98) // 100 bytes as JSON
+		b.SetLimit(250)
+		b.Track("a", cachePrompts, value, nil)
+		b.Track("b", cacheResources, value, nil)
+		b.Touch("a", cachePrompts)
+		b.Track("c", cachePrompts, value, nil)
+
+		servers, total, limit := b.Usage()
+		require.Equal(t, int64(250), limit)
+		require.LessOrEqual(t, total, limit)
+		require.Equal(t, map[string]int64{"a": 100, "c": 100}, servers)
+		require.Equal(t, []string{"b"}, evicted[cacheResources])
+		require.Empty(t, evicted[cachePrompts])
+		require.Equal(t, []string{"b"}, b.Evicted(cacheResources))
+
+		b.Track("b", cacheResources, value, nil)
+		require.Empty(t, b.Evicted(cacheResources), "storing a list again clears its eviction")
+	})
+
+	t.Run("tool lists are never evicted", func(t *testing.T) {
+		b := newCacheBudget()
+		var evicted []string
+		b.evict[cachePrompts] = func(server string) { evicted = append(evicted, server) }
+
+		b.SetLimit(150)
+		b.Track("a", cacheTools, strings.Repeat("x", 98), nil)
+		b.Track("b", cachePrompts, strings.Repeat("x", 98), nil)
+
+		servers, total, _ := b.Usage()
+		require.Equal(t, map[string]int64{"a": 100}, servers)
+		require.Equal(t, int64(100), total)
+		require.Equal(t, []string{"b"}, evicted)
+	})
+
+	t.Run("lowering the limit evicts", func(t *testing.T) {
+		b := newCacheBudget()
+		b.evict[cachePrompts] = func(string) {}
+
+		b.Track("a", cachePrompts, strings.Repeat("x", 98), nil)
+		b.Track("b", cachePrompts, strings.Repeat("x", 98), nil)
+		_, total, _ := b.Usage()
+		require.Equal(t, int64(200), total)
+
+		b.SetLimit(100)
+		servers, total, _ := b.Usage()
+		require.Equal(t, int64(100), total)
+		require.Equal(t, map[string]int64{"b": 100}, servers)
+	})
+
+	t.Run("evictions are fetched again once", func(t *testing.T) {
+		b := newCacheBudget()
+		b.evict[cachePrompts] = func(string) {}
+
+		value := strings.Repeat("x", 98) // 100 bytes as JSON
+		b.SetLimit(150)
+		b.Track("a", cachePrompts, value, nil)
+		b.Track("b", cachePrompts, value, nil)
+		require.Equal(t, []string{"a"}, b.ClaimRefetch(cachePrompts))
+		require.Empty(t, b.ClaimRefetch(cachePrompts), "an eviction is fetched again once")
+
+		// The refetched list does not evict the other prompts, so it is
+		// evicted itself and not fetched again.
+		b.Track("a", cachePrompts, value, nil)
+		servers, _, _ := b.Usage()
+		require.Equal(t, map[string]int64{"b": 100}, servers)
+		require.Equal(t, []string{"a"}, b.Evicted(cachePrompts))
+		require.Empty(t, b.ClaimRefetch(cachePrompts))
+	})
+
+	t.Run("empty values remove the entry", func(t *testing.T) {
+		b := newCacheBudget()
+		b.Track("a", cachePrompts, []*Prompt{{Name: "greet"}}, nil)
+		_, total, _ := b.Usage()
+		require.Positive(t, total)
+
+		b.Track("a", cachePrompts, []*Prompt(nil), nil)
+		servers, total, _ := b.Usage()
+		require.Empty(t, servers)
+		require.Zero(t, total)
+	})
+}
+
+func TestCacheBudget_EvictsGlobalCaches(t *testing.T) {
+	prev := caches
+	caches = newCacheBudget()
+	t.Cleanup(func() {
+		for _, name := range []string{"budget-a", "budget-b"} {
+			updatePrompts(name, nil)
+			sessions.Del(name)
+			states.Del(name)
+		}
+		caches = prev
+	})
+
+	prompt := func(name string) *Prompt {
+		return &Prompt{Name: name, Description: strings.Repeat("x", 200)}
+	}
+	server := mcp.NewServer(&mcp.Implementation{Name: "budget-a"}, nil)
+	server.AddPrompt(prompt("one"), func(context.Context, *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{}, nil
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+	session, err := client.Connect(t.Context(), clientTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+	sessions.Set("budget-a", &ClientSession{ClientSession: session})
+
+	caches.SetLimit(approximateSize([]*Prompt{prompt("one")}) + 10)
+	updatePrompts("budget-a", []*Prompt{prompt("one")})
+	updatePrompts("budget-b", []*Prompt{prompt("two")})
+
+	_, ok := allPrompts.Get("budget-a")
+	require.False(t, ok, "least recently used prompts should be evicted")
+	_, ok = allPrompts.Get("budget-b")
+	require.True(t, ok)
+
+	listed := maps.Collect(Prompts())
+	require.NotContains(t, listed, "budget-a", "evicted prompts are not fetched while listing")
+	require.Equal(t, map[string][]string{"budget-a": {cachePrompts}}, EvictedCaches())
+	RefetchEvictedPrompts()
+
+	// The refetch runs in the background and does not evict the other
+	// server's prompts, so it is evicted again and stays evicted.
+	require.Eventually(t, func() bool {
+		state, ok := states.Get("budget-a")
+		return ok && state.State == StateConnected
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"budget-a"}, caches.Evicted(cachePrompts))
+	require.Empty(t, caches.ClaimRefetch(cachePrompts))
+	_, ok = allPrompts.Get("budget-b")
+	require.True(t, ok)
+	servers, total, limit := CacheUsage()
+	require.LessOrEqual(t, total, limit)
+	require.Contains(t, servers, "budget-b")
+}
+
+func TestCacheBudget_RefetchesInBackground(t *testing.T) {
+	prev := caches
+	caches = newCacheBudget()
+	t.Cleanup(func() {
+		for _, name := range []string{"refetch-a", "refetch-b"} {
+			updatePrompts(name, nil)
+			updateResources(name, nil)
+			sessions.Del(name)
+			states.Del(name)
+		}
+		caches = prev
+	})
+
+	prompt := &Prompt{Name: "one", Description: strings.Repeat("x", 200)}
+	server := mcp.NewServer(&mcp.Implementation{Name: "refetch-a"}, nil)
+	server.AddPrompt(prompt, func(context.Context, *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{}, nil
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+	session, err := client.Connect(t.Context(), clientTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+	sessions.Set("refetch-a", &ClientSession{ClientSession: session})
+
+	// A resource list of the same size makes room for the prompts when
+	// they are fetched again.
+	size := approximateSize([]*Prompt{prompt})
+	caches.SetLimit(size + 10)
+	updatePrompts("refetch-a", []*Prompt{prompt})
+	updateResources("refetch-b", []*Resource{{URI: "file:///" + strings.Repeat("x", int(size)-30)}})
+	_, ok := allPrompts.Get("refetch-a")
+	require.False(t, ok)
+
+	require.NotContains(t, maps.Collect(Prompts()), "refetch-a")
+	require.Equal(t, []string{"refetch-a"}, caches.Evicted(cachePrompts))
+	require.False(t, caches.evicted[cacheKey{"refetch-a", cachePrompts}], "listing does not start a refetch")
+
+	RefetchEvictedPrompts()
+	require.Eventually(t, func() bool {
+		state, ok := states.Get("refetch-a")
+		return ok && state.State == StateConnected
+	}, 5*time.Second, 10*time.Millisecond, "evicted prompts are fetched again in the background")
+	_, ok = allPrompts.Get("refetch-a")
+	require.True(t, ok)
+	_, ok = allResources.Get("refetch-b")
+	require.False(t, ok, "the refetch evicts lists of other kinds")
+}
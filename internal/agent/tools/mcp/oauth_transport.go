@@ -2,16 +2,22 @@ package mcp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/charmbracelet/crush/internal/oauth"
 	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
 )
 
-// TokenProvider is the interface for getting and refreshing OAuth tokens.
+// TokenProvider is the interface for getting and refreshing bearer tokens,
+// whether from OAuth or another source.
 type TokenProvider interface {
 	// EnsureToken returns a valid token, loading from cache, refreshing, or
 	// triggering authorization as needed. The token is persisted to storage.
@@ -20,49 +26,87 @@ type TokenProvider interface {
 	RefreshToken(ctx context.Context) (*oauth.Token, error)
 }
 
-// oauthRoundTripper wraps an http.RoundTripper to add OAuth authentication.
+// ErrReauthRequired is returned when a server keeps rejecting requests after
+// their token was refreshed, e.g. because it revoked the grant.
+var ErrReauthRequired = errors.New("token refresh succeeded but server still rejected it, re-authentication required")
+
+// refreshRetryBackoff is how long oauthRoundTripper waits before retrying a
+// request with a refreshed token, giving the server time to accept it.
+const refreshRetryBackoff = 200 * time.Millisecond
+
+// introspectionCacheTTL is how long a token reported active by strict
+// introspection is used without asking again, so not every request waits
+// for the introspection endpoint.
+const introspectionCacheTTL = 30 * time.Second
+
+// tokenInvalidator is implemented by token providers that can drop their
+// current token so the next EnsureToken obtains a fresh one.
+type tokenInvalidator interface {
+	InvalidateToken() error
+}
+
+// expirySkewer is implemented by token providers with a configured expiry
+// skew.
+type expirySkewer interface {
+	ExpirySkew() time.Duration
+}
+
+// oauthRoundTripper wraps an http.RoundTripper to add tokens from a
+// TokenProvider.
 type oauthRoundTripper struct {
 	provider TokenProvider
 	base     http.RoundTripper
 	mu       sync.Mutex
+	now      func() time.Time
+	// retryDelay is waited before retrying a request after a 401.
+	retryDelay time.Duration
 }
 
-// NewOAuthRoundTripper creates a new OAuth-aware RoundTripper.
+// NewOAuthRoundTripper creates a RoundTripper that authenticates requests
+// with tokens from provider.
 func NewOAuthRoundTripper(provider TokenProvider, base http.RoundTripper) http.RoundTripper {
 	if base == nil {
 		base = http.DefaultTransport
 	}
 	return &oauthRoundTripper{
-		provider: provider,
-		base:     base,
+		provider:   provider,
+		base:       base,
+		now:        time.Now,
+		retryDelay: refreshRetryBackoff,
 	}
 }
 
-// RoundTrip implements http.RoundTripper to transparently add OAuth authentication
+// RoundTrip implements http.RoundTripper to transparently add authentication
 // to outgoing HTTP requests. It handles token lifecycle automatically: retrieving
 // tokens, refreshing expired tokens, and retrying requests on 401 responses.
+// If the retry is rejected too, the token is invalidated and
+// ErrReauthRequired is returned.
 func (rt *oauthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
 
 	token, err := rt.provider.EnsureToken(req.Context())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get OAuth token: %w", err)
+		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
 
 	// Check if token is expired and try to refresh
-	if token.IsExpired() {
-		slog.Debug("OAuth token expired, refreshing", "mcp", req.URL.Host)
+	var skew time.Duration
+	if s, ok := rt.provider.(expirySkewer); ok {
+		skew = s.ExpirySkew()
+	}
+	if mcpoauth.IsExpiredAt(token, rt.now(), skew) {
+		slog.Debug("Token expired, refreshing", "mcp", req.URL.Host)
 		newToken, rErr := rt.provider.RefreshToken(req.Context())
 		if rErr != nil {
-			return nil, fmt.Errorf("failed to refresh OAuth token: %w", rErr)
+			return nil, fmt.Errorf("failed to refresh token: %w", rErr)
 		}
 		token = newToken
 	}
 
 	// Clone the request to avoid modifying the original
 	req2 := req.Clone(req.Context())
-	req2.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req2.Header.Set("Authorization", authorizationHeader(token))
 
 	resp, err := rt.base.RoundTrip(req2)
 	if err != nil {
@@ -79,28 +123,95 @@ func (rt *oauthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 			return nil, fmt.Errorf("token refresh after 401 failed: %w", rErr)
 		}
 
-		req3 := req.Clone(req.Context())
-		req3.Header.Set("Authorization", fmt.Sprintf("Bearer %s", newToken.AccessToken))
-		return rt.base.RoundTrip(req3)
+		select {
+		case <-time.After(rt.retryDelay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		// The first attempt consumed the body; JSON-RPC POSTs need it again.
+		req3, ok := rewindRequest(req)
+		if !ok {
+			return nil, fmt.Errorf("cannot retry %s %s after token refresh: request body cannot be replayed", req.Method, req.URL.Redacted())
+		}
+		req3.Header.Set("Authorization", authorizationHeader(newToken))
+		resp, err = rt.base.RoundTrip(req3)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			slog.Warn("Refreshed token rejected, re-authentication required", "mcp", req.URL.Host)
+			if inv, ok := rt.provider.(tokenInvalidator); ok {
+				if iErr := inv.InvalidateToken(); iErr != nil {
+					slog.Warn("Failed to invalidate token", "mcp", req.URL.Host, "error", iErr)
+				}
+			}
+			return nil, ErrReauthRequired
+		}
 	}
 
 	return resp, nil
 }
 
+// authorizationHeader builds the Authorization header value for a token,
+// using its token type and defaulting to "Bearer" when none was returned.
+func authorizationHeader(token *oauth.Token) string {
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return fmt.Sprintf("%s %s", tokenType, token.AccessToken)
+}
+
 // OAuthTokenProvider implements TokenProvider for MCP OAuth.
 type OAuthTokenProvider struct {
 	name     string
+	profile  string
 	config   mcpoauth.Config
-	store    *TokenStore
+	store    TokenStore
 	token    *oauth.Token
 	mu       sync.RWMutex
 	authFunc func(ctx context.Context, cfg mcpoauth.Config) (*oauth.Token, error)
+	// now is the clock token expiry is measured against.
+	now func() time.Time
+
+	// strictIntrospection makes EnsureToken introspect tokens before
+	// returning them, discarding those the server reports as inactive.
+	strictIntrospection bool
+	// activeToken is the access token last reported active by strict
+	// introspection, trusted until activeUntil.
+	activeToken string
+	activeUntil time.Time
+	// onEndpointNotFound is called when a token request reports that the
+	// endpoint no longer exists, so stale discovery results can be dropped.
+	onEndpointNotFound func()
+	// serverURLHash is saved with the OAuth data to detect a changed
+	// server URL.
+	serverURLHash string
+	// info describes the current token for ClientInfo. It is read without
+	// p.mu, which is held during interactive authorization.
+	info atomic.Pointer[OAuthInfo]
+}
+
+// OAuthInfo describes the OAuth token an MCP server is authorized with.
+type OAuthInfo struct {
+	// GrantedScopes are the scopes the server reported granting. It is
+	// empty if the server didn't report them.
+	GrantedScopes []string
+	// RequestedScopes are the scopes requested during authorization.
+	RequestedScopes []string
+	// ScopesMatch reports whether all scopes the configuration requires
+	// were granted. It is true when the server didn't report the granted
+	// scopes or no scopes are required.
+	ScopesMatch bool
 }
 
 // NewOAuthTokenProvider creates a new token provider for an MCP server.
 // It validates the OAuth configuration and returns an error if invalid.
-// The store is required for token persistence.
-func NewOAuthTokenProvider(name string, cfg mcpoauth.Config, store *TokenStore) (*OAuthTokenProvider, error) {
+// The store is required for token persistence. The profile selects which of
+// the server's stored identities to use; empty selects the default one.
+func NewOAuthTokenProvider(name, profile string, cfg mcpoauth.Config, store TokenStore) (*OAuthTokenProvider, error) {
 	if store == nil {
 		return nil, fmt.Errorf("token store is required for MCP %q", name)
 	}
@@ -109,9 +220,11 @@ func NewOAuthTokenProvider(name string, cfg mcpoauth.Config, store *TokenStore)
 	}
 
 	return &OAuthTokenProvider{
-		name:   name,
-		config: cfg,
-		store:  store,
+		name:    name,
+		profile: profile,
+		config:  cfg,
+		store:   store,
+		now:     time.Now,
 	}, nil
 }
 
@@ -123,6 +236,75 @@ func (p *OAuthTokenProvider) SetAuthFunc(fn func(ctx context.Context, cfg mcpoau
 	p.authFunc = fn
 }
 
+// SetStrictIntrospection enables or disables introspecting tokens in
+// EnsureToken before they are used. It has no effect when no introspection
+// endpoint is known.
+func (p *OAuthTokenProvider) SetStrictIntrospection(strict bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strictIntrospection = strict
+}
+
+// Introspect checks with the authorization server whether the current token
+// is still active (RFC 7662). It reports true without contacting the server
+// when no introspection endpoint is known, and false when there is no token.
+func (p *OAuthTokenProvider) Introspect(ctx context.Context) (active bool, err error) {
+	p.mu.RLock()
+	cfg, token := p.config, p.token
+	p.mu.RUnlock()
+
+	if cfg.IntrospectionEndpoint == "" {
+		return true, nil
+	}
+	if token == nil {
+		return false, nil
+	}
+	return mcpoauth.IntrospectToken(ctx, cfg, token.AccessToken)
+}
+
+// isActive reports whether a token may be used. Outside strict mode, or
+// without an introspection endpoint, every token is considered active.
+// Introspection failures are logged and the token is kept, so an unreachable
+// endpoint doesn't force re-authorization. An active token is not
+// introspected again for introspectionCacheTTL, or until it expires if that
+// is sooner.
+//
+// The caller must hold p.mu, which is released while the introspection
+// request is in flight. If p.token was replaced in the meantime, changed is
+// true and the caller must start over with the current state.
+func (p *OAuthTokenProvider) isActive(ctx context.Context, token *oauth.Token) (active, changed bool) {
+	if !p.strictIntrospection || p.config.IntrospectionEndpoint == "" {
+		return true, false
+	}
+	if token.AccessToken == p.activeToken && p.now().Before(p.activeUntil) {
+		return true, false
+	}
+
+	cfg := p.config
+	p.mu.Unlock()
+	active, err := mcpoauth.IntrospectToken(ctx, cfg, token.AccessToken)
+	p.mu.Lock()
+
+	if p.token != token {
+		return false, true
+	}
+	if err != nil {
+		slog.Warn("Failed to introspect OAuth token", "mcp", p.name, "error", err)
+		return true, false
+	}
+	if !active {
+		slog.Debug("OAuth token reported inactive by introspection", "mcp", p.name)
+		p.activeToken = ""
+		return false, false
+	}
+	p.activeToken = token.AccessToken
+	p.activeUntil = p.now().Add(introspectionCacheTTL)
+	if expiry := time.Unix(token.ExpiresAt, 0); token.ExpiresAt > 0 && expiry.Before(p.activeUntil) {
+		p.activeUntil = expiry
+	}
+	return true, false
+}
+
 // ensureClientRegistration ensures we have a registered client_id.
 // If dynamic registration is supported and we don't have a client_id, it registers one.
 func (p *OAuthTokenProvider) ensureClientRegistration(ctx context.Context) error {
@@ -132,13 +314,14 @@ func (p *OAuthTokenProvider) ensureClientRegistration(ctx context.Context) error
 	}
 
 	// Try to load stored client credentials from MCPOAuthData
-	data, err := p.store.Load(p.name)
+	data, err := p.store.Load(p.name, p.profile)
 	if err != nil {
 		return fmt.Errorf("failed to load OAuth data for MCP %q: %w", p.name, err)
 	}
 	if data != nil && data.ClientID != "" {
 		p.config.ClientID = data.ClientID
 		p.config.ClientSecret = data.ClientSecret
+		p.config.TokenEndpointAuthMethod = data.TokenEndpointAuthMethod
 		slog.Debug("Loaded stored client credentials", "mcp", p.name, "client_id", data.ClientID)
 		return nil
 	}
@@ -150,16 +333,28 @@ func (p *OAuthTokenProvider) ensureClientRegistration(ctx context.Context) error
 
 	// Perform dynamic registration
 	slog.Info("Registering OAuth client dynamically", "mcp", p.name)
+	publishAuthStage(p.name, mcpoauth.StageEvent{Stage: mcpoauth.AuthStageRegistering})
 
 	creds, err := mcpoauth.RegisterClient(ctx, p.config)
 	if err != nil {
-		return fmt.Errorf("dynamic client registration failed: %w", err)
+		err = fmt.Errorf("dynamic client registration failed: %w", err)
+		publishAuthStage(p.name, mcpoauth.StageEvent{
+			Stage:       mcpoauth.AuthStageFailed,
+			FailedStage: mcpoauth.AuthStageRegistering,
+			Error:       err,
+		})
+		return err
 	}
 
 	// Save credentials (merge with existing data if any)
 	saveData := &MCPOAuthData{
 		ClientID:     creds.ClientID,
 		ClientSecret: creds.ClientSecret,
+
+		TokenEndpointAuthMethod: creds.TokenEndpointAuthMethod,
+		RegistrationAccessToken: creds.RegistrationAccessToken,
+		RegistrationClientURI:   creds.RegistrationClientURI,
+		ServerURLHash:           p.serverURLHash,
 	}
 	if data != nil {
 		// Preserve existing token data
@@ -167,14 +362,17 @@ func (p *OAuthTokenProvider) ensureClientRegistration(ctx context.Context) error
 		saveData.RefreshToken = data.RefreshToken
 		saveData.ExpiresIn = data.ExpiresIn
 		saveData.ExpiresAt = data.ExpiresAt
+		saveData.TokenType = data.TokenType
+		saveData.GrantedScopes = data.GrantedScopes
 	}
-	if err = p.store.Save(p.name, saveData); err != nil {
+	if err = p.store.Save(p.name, p.profile, saveData); err != nil {
 		slog.Warn("Failed to save client credentials", "mcp", p.name, "error", err)
 	}
 
 	// Update config
 	p.config.ClientID = creds.ClientID
 	p.config.ClientSecret = creds.ClientSecret
+	p.config.TokenEndpointAuthMethod = creds.TokenEndpointAuthMethod
 	slog.Info("OAuth client registered successfully", "mcp", p.name, "client_id", creds.ClientID)
 
 	return nil
@@ -185,22 +383,63 @@ func (p *OAuthTokenProvider) ensureClientRegistration(ctx context.Context) error
 func (p *OAuthTokenProvider) EnsureToken(ctx context.Context) (*oauth.Token, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.ensureToken(ctx)
+}
 
+// ensureToken implements EnsureToken. The caller must hold p.mu.
+func (p *OAuthTokenProvider) ensureToken(ctx context.Context) (*oauth.Token, error) {
 	// Return cached token if valid
-	if p.token != nil && !p.token.IsExpired() {
-		return p.token, nil
+	if p.token != nil && !mcpoauth.IsExpiredAt(p.token, p.now(), p.config.ExpirySkew) {
+		active, changed := p.isActive(ctx, p.token)
+		if changed {
+			return p.ensureToken(ctx)
+		}
+		if active {
+			return p.token, nil
+		}
+		p.setToken(nil)
 	}
 
 	// Try to load from store
 	if token, err := p.loadOrRefreshStoredToken(ctx); err == nil && token != nil {
-		return token, nil
+		active, changed := p.isActive(ctx, token)
+		if changed {
+			return p.ensureToken(ctx)
+		}
+		if active {
+			return token, nil
+		}
+		p.setToken(nil)
 	}
 
 	// No valid token available, need to authorize
 	if p.authFunc == nil {
 		return nil, fmt.Errorf("no valid token available and no auth function configured for MCP %q", p.name)
 	}
+	return p.authorize(ctx)
+}
+
+// ForceReauthenticate runs the authorization flow even if a valid token is
+// cached or stored, e.g. to switch accounts or after access was revoked on
+// the server. The current token is discarded first; client credentials are
+// kept.
+func (p *OAuthTokenProvider) ForceReauthenticate(ctx context.Context) (*oauth.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.authFunc == nil {
+		return nil, fmt.Errorf("no auth function configured for MCP %q", p.name)
+	}
+	p.setToken(nil)
+	if err := p.clearStoredToken(); err != nil {
+		return nil, fmt.Errorf("failed to discard stored token for MCP %q: %w", p.name, err)
+	}
+	return p.authorize(ctx)
+}
 
+// authorize runs the authorization flow and saves the new token. The caller
+// must hold p.mu and have checked that p.authFunc is set.
+func (p *OAuthTokenProvider) authorize(ctx context.Context) (*oauth.Token, error) {
 	// Ensure we have a client_id before starting auth flow
 	if err := p.ensureClientRegistration(ctx); err != nil {
 		return nil, err
@@ -208,10 +447,15 @@ func (p *OAuthTokenProvider) EnsureToken(ctx context.Context) (*oauth.Token, err
 
 	token, err := p.authFunc(ctx, p.config)
 	if err != nil {
+		p.checkEndpointErr(err)
 		return nil, fmt.Errorf("authorization failed: %w", err)
 	}
+	if err := mcpoauth.CheckScopes(p.config.RequiredScopes, token); err != nil {
+		return nil, err
+	}
+	p.stampExpiry(token)
 
-	p.token = token
+	p.setToken(token)
 	if err = p.saveToken(token); err != nil {
 		slog.Warn("Failed to save token", "mcp", p.name, "error", err)
 	}
@@ -222,16 +466,20 @@ func (p *OAuthTokenProvider) EnsureToken(ctx context.Context) (*oauth.Token, err
 // or refresh an expired token if a refresh token is available.
 // Returns (nil, nil) if no usable token is found.
 func (p *OAuthTokenProvider) loadOrRefreshStoredToken(ctx context.Context) (*oauth.Token, error) {
-	data, err := p.store.Load(p.name)
+	data, err := p.store.Load(p.name, p.profile)
 	if err != nil || data == nil || data.AccessToken == "" {
 		return nil, nil
 	}
 
 	stored := dataToToken(data)
+	if err := mcpoauth.CheckScopes(p.config.RequiredScopes, stored); err != nil {
+		slog.Debug("Stored token lacks required scopes", "mcp", p.name, "error", err)
+		return nil, nil
+	}
 
 	// Valid token in store
-	if !stored.IsExpired() {
-		p.token = stored
+	if !mcpoauth.IsExpiredAt(stored, p.now(), p.config.ExpirySkew) {
+		p.setToken(stored)
 		return p.token, nil
 	}
 
@@ -246,13 +494,13 @@ func (p *OAuthTokenProvider) loadOrRefreshStoredToken(ctx context.Context) (*oau
 		return nil, nil
 	}
 
-	newToken, err := mcpoauth.RefreshToken(ctx, p.config, stored.RefreshToken)
+	newToken, err := p.refresh(ctx, stored.RefreshToken)
 	if err != nil {
 		slog.Debug("Failed to refresh stored token", "mcp", p.name, "error", err)
 		return nil, nil
 	}
 
-	p.token = newToken
+	p.setToken(newToken)
 	if err = p.saveToken(newToken); err != nil {
 		slog.Warn("Failed to save refreshed token", "mcp", p.name, "error", err)
 	}
@@ -273,7 +521,7 @@ func (p *OAuthTokenProvider) RefreshToken(ctx context.Context) (*oauth.Token, er
 	if p.token != nil && p.token.RefreshToken != "" {
 		refreshToken = p.token.RefreshToken
 	} else {
-		data, err := p.store.Load(p.name)
+		data, err := p.store.Load(p.name, p.profile)
 		if err == nil && data != nil && data.RefreshToken != "" {
 			refreshToken = data.RefreshToken
 		}
@@ -283,21 +531,168 @@ func (p *OAuthTokenProvider) RefreshToken(ctx context.Context) (*oauth.Token, er
 		return nil, fmt.Errorf("no refresh token available for MCP %q", p.name)
 	}
 
-	newToken, err := mcpoauth.RefreshToken(ctx, p.config, refreshToken)
+	newToken, err := p.refresh(ctx, refreshToken)
 	if err != nil {
 		return nil, err
 	}
 
-	p.token = newToken
+	p.setToken(newToken)
 	_ = p.saveToken(newToken)
 
 	return newToken, nil
 }
 
+// refresh exchanges the refresh token for a new token, failing with a
+// *mcpoauth.ScopeError if it lacks required scopes. Servers that rotate
+// refresh tokens return a new one which replaces the old; servers that don't
+// may omit it, in which case the previous refresh token is kept so the next
+// refresh still works.
+func (p *OAuthTokenProvider) refresh(ctx context.Context, refreshToken string) (*oauth.Token, error) {
+	newToken, err := mcpoauth.RefreshToken(ctx, p.config, refreshToken)
+	if err != nil {
+		p.checkEndpointErr(err)
+		return nil, err
+	}
+	// Retrying with a token missing scopes would only be rejected again.
+	if err := mcpoauth.CheckScopes(p.config.RequiredScopes, newToken); err != nil {
+		return nil, err
+	}
+	if newToken.RefreshToken == "" {
+		newToken.RefreshToken = refreshToken
+	}
+	p.stampExpiry(newToken)
+	return newToken, nil
+}
+
+// setToken makes token the current token and updates the OAuth info it is
+// reported with. The caller must hold p.mu.
+func (p *OAuthTokenProvider) setToken(token *oauth.Token) {
+	p.token = token
+	if token == nil {
+		p.info.Store(nil)
+		return
+	}
+	info := &OAuthInfo{
+		GrantedScopes:   slices.Clone(token.GrantedScopes),
+		RequestedScopes: slices.Clone(p.config.Scopes),
+		// Discovered configurations request every supported scope, so only
+		// the required ones are compared.
+		ScopesMatch: mcpoauth.CheckScopes(p.config.RequiredScopes, token) == nil,
+	}
+	p.info.Store(info)
+}
+
+// OAuthInfo returns a description of the current token, or nil if there is
+// none yet.
+func (p *OAuthTokenProvider) OAuthInfo() *OAuthInfo {
+	info := p.info.Load()
+	if info == nil {
+		return nil
+	}
+	return &OAuthInfo{
+		GrantedScopes:   slices.Clone(info.GrantedScopes),
+		RequestedScopes: slices.Clone(info.RequestedScopes),
+		ScopesMatch:     info.ScopesMatch,
+	}
+}
+
+// ExpirySkew returns how long before their expiry tokens are refreshed, or
+// zero for the default.
+func (p *OAuthTokenProvider) ExpirySkew() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config.ExpirySkew
+}
+
+// stampExpiry recomputes the expiry of a newly issued token against the
+// provider's clock. Tokens without a reported lifetime get no expiry.
+func (p *OAuthTokenProvider) stampExpiry(token *oauth.Token) {
+	mcpoauth.SetExpiresAt(token, p.now())
+}
+
+// InvalidateToken drops the current token from memory and storage, keeping
+// the client credentials, so the next EnsureToken triggers authorization.
+func (p *OAuthTokenProvider) InvalidateToken() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setToken(nil)
+	return p.clearStoredToken()
+}
+
+// clearStoredToken removes the token from the stored OAuth data, keeping
+// the client credentials. The caller must hold p.mu.
+func (p *OAuthTokenProvider) clearStoredToken() error {
+	data, err := p.store.Load(p.name, p.profile)
+	if err != nil || data == nil {
+		return err
+	}
+	data.AccessToken = ""
+	data.RefreshToken = ""
+	data.ExpiresIn = 0
+	data.ExpiresAt = 0
+	data.TokenType = ""
+	data.GrantedScopes = nil
+	return p.store.Save(p.name, p.profile, data)
+}
+
+// reuse takes over the settings of next, a provider created for the same
+// server when its client is reinitialized, and reports whether p can be used
+// in its place. Keeping p keeps its in-memory token, so reconnecting neither
+// re-reads the store nor re-authorizes when the store did not keep the
+// token. p can't be reused if the profile, token endpoint or server URL
+// changed. A change of required scopes drops the cached token so it is
+// checked again.
+func (p *OAuthTokenProvider) reuse(next *OAuthTokenProvider) bool {
+	if next == nil || next == p {
+		return next == p
+	}
+
+	next.mu.RLock()
+	cfg, profile, store := next.config, next.profile, next.store
+	authFunc, strict, onEndpointNotFound := next.authFunc, next.strictIntrospection, next.onEndpointNotFound
+	urlHash := next.serverURLHash
+	next.mu.RUnlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if profile != p.profile || cfg.TokenURL != p.config.TokenURL || urlHash != p.serverURLHash {
+		return false
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = p.config.ClientID
+		cfg.ClientSecret = p.config.ClientSecret
+		cfg.TokenEndpointAuthMethod = p.config.TokenEndpointAuthMethod
+	}
+	if !slices.Equal(cfg.RequiredScopes, p.config.RequiredScopes) {
+		p.setToken(nil)
+	}
+	p.config = cfg
+	p.store = store
+	p.authFunc = authFunc
+	p.strictIntrospection = strict
+	p.onEndpointNotFound = onEndpointNotFound
+	p.serverURLHash = urlHash
+	if p.token != nil {
+		// Report the kept token against the new configuration.
+		p.setToken(p.token)
+	}
+	slog.Debug("Reusing OAuth token provider", "mcp", p.name)
+	return true
+}
+
+// checkEndpointErr notifies onEndpointNotFound if err reports a missing
+// OAuth endpoint.
+func (p *OAuthTokenProvider) checkEndpointErr(err error) {
+	if p.onEndpointNotFound != nil && errors.Is(err, mcpoauth.ErrEndpointNotFound) {
+		p.onEndpointNotFound()
+	}
+}
+
 // saveToken saves the token while preserving client credentials.
 func (p *OAuthTokenProvider) saveToken(token *oauth.Token) error {
 	// Load existing data to preserve client credentials
-	data, _ := p.store.Load(p.name)
+	data, _ := p.store.Load(p.name, p.profile)
 	if data == nil {
 		data = &MCPOAuthData{}
 	}
@@ -307,16 +702,23 @@ func (p *OAuthTokenProvider) saveToken(token *oauth.Token) error {
 	data.RefreshToken = token.RefreshToken
 	data.ExpiresIn = token.ExpiresIn
 	data.ExpiresAt = token.ExpiresAt
+	data.TokenType = token.TokenType
+	data.GrantedScopes = token.GrantedScopes
+	if p.serverURLHash != "" {
+		data.ServerURLHash = p.serverURLHash
+	}
 
-	return p.store.Save(p.name, data)
+	return p.store.Save(p.name, p.profile, data)
 }
 
 // dataToToken converts MCPOAuthData to oauth.Token.
 func dataToToken(data *MCPOAuthData) *oauth.Token {
 	return &oauth.Token{
-		AccessToken:  data.AccessToken,
-		RefreshToken: data.RefreshToken,
-		ExpiresIn:    data.ExpiresIn,
-		ExpiresAt:    data.ExpiresAt,
+		AccessToken:   data.AccessToken,
+		RefreshToken:  data.RefreshToken,
+		ExpiresIn:     data.ExpiresIn,
+		ExpiresAt:     data.ExpiresAt,
+		TokenType:     data.TokenType,
+		GrantedScopes: data.GrantedScopes,
 	}
 }
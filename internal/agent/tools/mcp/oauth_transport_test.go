@@ -2,6 +2,12 @@ package mcp
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,29 +42,30 @@ func expiredTokenNoRefresh() *oauth.Token {
 }
 
 // newTestStore creates a TokenStore for testing with a temp directory.
-func newTestStore(t *testing.T) *TokenStore {
+func newTestStore(t *testing.T) *FileTokenStore {
 	t.Helper()
 	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
 	return NewTokenStore()
 }
 
 // saveTestToken saves an oauth.Token to the store using the new MCPOAuthData format.
-func saveTestToken(t *testing.T, store *TokenStore, name string, token *oauth.Token) {
+func saveTestToken(t *testing.T, store TokenStore, name string, token *oauth.Token) {
 	t.Helper()
 	data := &MCPOAuthData{
-		AccessToken:  token.AccessToken,
-		RefreshToken: token.RefreshToken,
-		ExpiresIn:    token.ExpiresIn,
-		ExpiresAt:    token.ExpiresAt,
+		AccessToken:   token.AccessToken,
+		RefreshToken:  token.RefreshToken,
+		ExpiresIn:     token.ExpiresIn,
+		ExpiresAt:     token.ExpiresAt,
+		GrantedScopes: token.GrantedScopes,
 	}
-	err := store.Save(name, data)
+	err := store.Save(name, "", data)
 	require.NoError(t, err)
 }
 
 // loadTestToken loads a token from the store and converts to oauth.Token.
-func loadTestToken(t *testing.T, store *TokenStore, name string) *oauth.Token {
+func loadTestToken(t *testing.T, store TokenStore, name string) *oauth.Token {
 	t.Helper()
-	data, err := store.Load(name)
+	data, err := store.Load(name, "")
 	require.NoError(t, err)
 	if data == nil || data.AccessToken == "" {
 		return nil
@@ -73,19 +80,37 @@ func loadTestToken(t *testing.T, store *TokenStore, name string) *oauth.Token {
 
 func TestNewMCPTokenProvider(t *testing.T) {
 	t.Run("requires non-nil store", func(t *testing.T) {
-		_, err := NewOAuthTokenProvider("test", validConfig(), nil)
+		_, err := NewOAuthTokenProvider("test", "", validConfig(), nil)
 		require.Error(t, err)
 	})
 
 	t.Run("validates config", func(t *testing.T) {
 		store := newTestStore(t)
-		_, err := NewOAuthTokenProvider("test", mcpoauth.Config{}, store)
+		_, err := NewOAuthTokenProvider("test", "", mcpoauth.Config{}, store)
 		require.Error(t, err)
 	})
 
+	t.Run("uses any token store", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		cfg := validConfig()
+		cfg.ClientID = ""
+		cfg.RegistrationEndpoint = "https://example.com/register"
+		require.NoError(t, store.Save("test", "", &MCPOAuthData{
+			ClientID:                "stored-client",
+			ClientSecret:            "stored-secret",
+			TokenEndpointAuthMethod: "client_secret_basic",
+		}))
+
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+		require.NoError(t, provider.ensureClientRegistration(t.Context()))
+		require.Equal(t, "stored-client", provider.config.ClientID)
+		require.Equal(t, "client_secret_basic", provider.config.TokenEndpointAuthMethod)
+	})
+
 	t.Run("creates provider with valid inputs", func(t *testing.T) {
 		store := newTestStore(t)
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 		require.NotNil(t, provider)
 	})
@@ -94,7 +119,7 @@ func TestNewMCPTokenProvider(t *testing.T) {
 func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 	t.Run("returns cached valid token", func(t *testing.T) {
 		store := newTestStore(t)
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		cachedToken := validToken()
@@ -110,7 +135,7 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 		storedToken := validToken()
 		saveTestToken(t, store, "test", storedToken)
 
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		token, err := provider.EnsureToken(context.Background())
@@ -120,7 +145,7 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 
 	t.Run("uses authFunc when no valid token", func(t *testing.T) {
 		store := newTestStore(t)
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		expected := validToken()
@@ -136,7 +161,7 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 
 	t.Run("token saved and retrievable from store", func(t *testing.T) {
 		store := newTestStore(t)
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		expected := validToken()
@@ -156,7 +181,7 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 
 	t.Run("returns error when no token and no authFunc", func(t *testing.T) {
 		store := newTestStore(t)
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		token, err := provider.EnsureToken(context.Background())
@@ -169,7 +194,7 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 		store := newTestStore(t)
 		saveTestToken(t, store, "test", expiredTokenNoRefresh())
 
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		expected := validToken()
@@ -188,7 +213,7 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 		storedToken := validToken()
 		saveTestToken(t, store, "test", storedToken)
 
-		provider, err := NewOAuthTokenProvider("test", validConfig(), store)
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
 		require.NoError(t, err)
 
 		// First call loads from store
@@ -206,3 +231,824 @@ func TestMCPTokenProvider_EnsureToken(t *testing.T) {
 		require.Equal(t, storedToken.AccessToken, token.AccessToken)
 	})
 }
+
+func TestAuthorizationHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenType string
+		want      string
+	}{
+		{name: "defaults to Bearer", tokenType: "", want: "Bearer abc"},
+		{name: "normalizes lowercase bearer", tokenType: "bearer", want: "Bearer abc"},
+		{name: "uses custom token type", tokenType: "DPoP", want: "DPoP abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &oauth.Token{AccessToken: "abc", TokenType: tt.tokenType}
+			require.Equal(t, tt.want, authorizationHeader(token))
+		})
+	}
+}
+
+// newRefreshServer starts a token endpoint that answers refresh requests with
+// the given refresh token (omitted when empty) and records the refresh token
+// it received.
+func newRefreshServer(t *testing.T, newRefreshToken string, received *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		*received = r.FormValue("refresh_token")
+		resp := map[string]any{
+			"access_token": "refreshed-access-token",
+			"expires_in":   3600,
+		}
+		if newRefreshToken != "" {
+			resp["refresh_token"] = newRefreshToken
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMCPTokenProvider_RefreshTokenRotation(t *testing.T) {
+	t.Run("retains previous refresh token when omitted", func(t *testing.T) {
+		var received string
+		server := newRefreshServer(t, "", &received)
+
+		store := newTestStore(t)
+		cfg := validConfig()
+		cfg.TokenURL = server.URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+		provider.token = validToken()
+
+		token, err := provider.RefreshToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "valid-refresh-token", received)
+		require.Equal(t, "refreshed-access-token", token.AccessToken)
+		require.Equal(t, "valid-refresh-token", token.RefreshToken)
+
+		loaded := loadTestToken(t, store, "test")
+		require.Equal(t, "valid-refresh-token", loaded.RefreshToken)
+	})
+
+	t.Run("persists rotated refresh token", func(t *testing.T) {
+		var received string
+		server := newRefreshServer(t, "rotated-refresh-token", &received)
+
+		store := newTestStore(t)
+		cfg := validConfig()
+		cfg.TokenURL = server.URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+		provider.token = validToken()
+
+		token, err := provider.RefreshToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "rotated-refresh-token", token.RefreshToken)
+
+		loaded := loadTestToken(t, store, "test")
+		require.Equal(t, "rotated-refresh-token", loaded.RefreshToken)
+
+		// The next refresh must use the rotated token.
+		_, err = provider.RefreshToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "rotated-refresh-token", received)
+	})
+
+	t.Run("retains stored refresh token when refreshing on load", func(t *testing.T) {
+		var received string
+		server := newRefreshServer(t, "", &received)
+
+		store := newTestStore(t)
+		expired := expiredTokenNoRefresh()
+		expired.RefreshToken = "stored-refresh-token"
+		saveTestToken(t, store, "test", expired)
+
+		cfg := validConfig()
+		cfg.TokenURL = server.URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+
+		token, err := provider.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "stored-refresh-token", received)
+		require.Equal(t, "stored-refresh-token", token.RefreshToken)
+
+		loaded := loadTestToken(t, store, "test")
+		require.Equal(t, "stored-refresh-token", loaded.RefreshToken)
+	})
+}
+
+func TestMCPTokenProvider_Introspect(t *testing.T) {
+	newIntrospectionServer := func(t *testing.T, active bool) *httptest.Server {
+		t.Helper()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": active})
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	t.Run("no-op without introspection endpoint", func(t *testing.T) {
+		provider, err := NewOAuthTokenProvider("test", "", validConfig(), newTestStore(t))
+		require.NoError(t, err)
+
+		active, err := provider.Introspect(context.Background())
+		require.NoError(t, err)
+		require.True(t, active)
+	})
+
+	t.Run("reports server answer", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.IntrospectionEndpoint = newIntrospectionServer(t, false).URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, newTestStore(t))
+		require.NoError(t, err)
+		provider.token = validToken()
+
+		active, err := provider.Introspect(context.Background())
+		require.NoError(t, err)
+		require.False(t, active)
+	})
+
+	t.Run("strict mode re-authorizes inactive token", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.IntrospectionEndpoint = newIntrospectionServer(t, false).URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, newTestStore(t))
+		require.NoError(t, err)
+		provider.SetStrictIntrospection(true)
+		provider.token = validToken()
+
+		expected := validToken()
+		expected.AccessToken = "reauthorized-token"
+		provider.SetAuthFunc(func(ctx context.Context, cfg mcpoauth.Config) (*oauth.Token, error) {
+			return expected, nil
+		})
+
+		token, err := provider.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, expected.AccessToken, token.AccessToken)
+	})
+
+	t.Run("strict mode caches active tokens", func(t *testing.T) {
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": true})
+		}))
+		t.Cleanup(server.Close)
+
+		cfg := validConfig()
+		cfg.IntrospectionEndpoint = server.URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, newTestStore(t))
+		require.NoError(t, err)
+		provider.SetStrictIntrospection(true)
+		now := time.Now()
+		provider.now = func() time.Time { return now }
+		provider.token = validToken()
+
+		for range 3 {
+			_, err := provider.EnsureToken(context.Background())
+			require.NoError(t, err)
+		}
+		require.Equal(t, int32(1), hits.Load())
+
+		// The result is trusted only for a while.
+		now = now.Add(introspectionCacheTTL)
+		_, err = provider.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int32(2), hits.Load())
+
+		// A new token is introspected again.
+		provider.token = validToken()
+		provider.token.AccessToken = "other-token"
+		_, err = provider.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int32(3), hits.Load())
+	})
+
+	t.Run("strict mode does not hold the lock while introspecting", func(t *testing.T) {
+		requested := make(chan struct{})
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(requested)
+			<-release
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": true})
+		}))
+		t.Cleanup(server.Close)
+
+		cfg := validConfig()
+		cfg.IntrospectionEndpoint = server.URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, newTestStore(t))
+		require.NoError(t, err)
+		provider.SetStrictIntrospection(true)
+		provider.token = validToken()
+
+		expected := validToken()
+		expected.AccessToken = "reauthorized-token"
+		provider.SetAuthFunc(func(ctx context.Context, cfg mcpoauth.Config) (*oauth.Token, error) {
+			return expected, nil
+		})
+
+		type result struct {
+			token *oauth.Token
+			err   error
+		}
+		done := make(chan result, 1)
+		go func() {
+			token, err := provider.EnsureToken(context.Background())
+			done <- result{token, err}
+		}()
+
+		<-requested
+		// Invalidating needs the lock, so this would block if EnsureToken
+		// held it during the request.
+		require.NoError(t, provider.InvalidateToken())
+		close(release)
+
+		// The introspected token was dropped meanwhile, so it is not used.
+		res := <-done
+		require.NoError(t, res.err)
+		require.Equal(t, expected.AccessToken, res.token.AccessToken)
+	})
+
+	t.Run("non-strict mode keeps cached token", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.IntrospectionEndpoint = newIntrospectionServer(t, false).URL
+		provider, err := NewOAuthTokenProvider("test", "", cfg, newTestStore(t))
+		require.NoError(t, err)
+		cached := validToken()
+		provider.token = cached
+
+		token, err := provider.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, cached.AccessToken, token.AccessToken)
+	})
+}
+
+func TestMCPTokenProvider_Profile(t *testing.T) {
+	store := newTestStore(t)
+
+	work := validToken()
+	work.AccessToken = "work-token"
+	require.NoError(t, store.Save("test", "work", &MCPOAuthData{
+		AccessToken: work.AccessToken,
+		ExpiresIn:   work.ExpiresIn,
+		ExpiresAt:   work.ExpiresAt,
+	}))
+	saveTestToken(t, store, "test", validToken())
+
+	provider, err := NewOAuthTokenProvider("test", "work", validConfig(), store)
+	require.NoError(t, err)
+
+	token, err := provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "work-token", token.AccessToken)
+}
+
+func TestMCPTokenProvider_ReuseOnReinitialize(t *testing.T) {
+	newProvider := func(t *testing.T, store *FileTokenStore, profile string, cfg mcpoauth.Config, authCalls *int) *OAuthTokenProvider {
+		t.Helper()
+		provider, err := NewOAuthTokenProvider("reinit", profile, cfg, store)
+		require.NoError(t, err)
+		provider.SetAuthFunc(func(ctx context.Context, cfg mcpoauth.Config) (*oauth.Token, error) {
+			*authCalls++
+			return validToken(), nil
+		})
+		return provider
+	}
+
+	t.Run("ephemeral store does not re-authorize", func(t *testing.T) {
+		store := newTestStore(t)
+		t.Cleanup(func() { tokenProviders.Del("reinit") })
+
+		var authCalls int
+		first := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		_, err := first.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, authCalls)
+
+		// The store loses its contents, as an in-memory store would.
+		require.NoError(t, store.Delete("reinit", ""))
+
+		second := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		require.Same(t, first, second)
+		token, err := second.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "valid-access-token", token.AccessToken)
+		require.Equal(t, 1, authCalls)
+	})
+
+	t.Run("valid token does not re-read store", func(t *testing.T) {
+		store := newTestStore(t)
+		t.Cleanup(func() { tokenProviders.Del("reinit") })
+		saveTestToken(t, store, "reinit", validToken())
+
+		var loads int
+		store.SetEventHandler(func(e TokenStoreEvent) {
+			if e.Op == TokenStoreOpLoad {
+				loads++
+			}
+		})
+
+		var authCalls int
+		first := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		_, err := first.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, loads)
+
+		second := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		_, err = second.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, loads)
+		require.Zero(t, authCalls)
+	})
+
+	t.Run("changed token endpoint re-authorizes", func(t *testing.T) {
+		store := newTestStore(t)
+		t.Cleanup(func() { tokenProviders.Del("reinit") })
+
+		var authCalls int
+		first := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		_, err := first.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, store.Delete("reinit", ""))
+
+		cfg := validConfig()
+		cfg.TokenURL = "https://other.example.com/token"
+		second := registerTokenProvider("reinit", newProvider(t, store, "", cfg, &authCalls))
+		require.NotSame(t, first, second)
+		_, err = second.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, authCalls)
+	})
+
+	t.Run("changed profile re-authorizes", func(t *testing.T) {
+		store := newTestStore(t)
+		t.Cleanup(func() { tokenProviders.Del("reinit") })
+
+		var authCalls int
+		first := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		_, err := first.EnsureToken(context.Background())
+		require.NoError(t, err)
+
+		second := registerTokenProvider("reinit", newProvider(t, store, "work", validConfig(), &authCalls))
+		require.NotSame(t, first, second)
+		_, err = second.EnsureToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, authCalls)
+	})
+
+	t.Run("changed required scopes drop cached token", func(t *testing.T) {
+		store := newTestStore(t)
+		t.Cleanup(func() { tokenProviders.Del("reinit") })
+
+		var authCalls int
+		first := registerTokenProvider("reinit", newProvider(t, store, "", validConfig(), &authCalls))
+		_, err := first.EnsureToken(context.Background())
+		require.NoError(t, err)
+
+		cfg := validConfig()
+		cfg.RequiredScopes = []string{"write"}
+		second := registerTokenProvider("reinit", newProvider(t, store, "", cfg, &authCalls))
+		require.Same(t, first, second)
+		require.Nil(t, second.token)
+	})
+}
+
+func TestMCPTokenProvider_RequiredScopes(t *testing.T) {
+	narrow := func() *oauth.Token {
+		token := validToken()
+		token.GrantedScopes = []string{"read"}
+		return token
+	}
+
+	t.Run("rejects authorized token missing scopes", func(t *testing.T) {
+		store := newTestStore(t)
+		cfg := validConfig()
+		cfg.RequiredScopes = []string{"read", "write"}
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+		provider.SetAuthFunc(func(context.Context, mcpoauth.Config) (*oauth.Token, error) {
+			return narrow(), nil
+		})
+
+		_, err = provider.EnsureToken(t.Context())
+		var scopeErr *mcpoauth.ScopeError
+		require.ErrorAs(t, err, &scopeErr)
+		require.EqualError(t, err, `server granted scopes "read" but "read write" was required`)
+		require.Nil(t, loadTestToken(t, store, "test"), "the token must not be stored")
+	})
+
+	t.Run("rejects refreshed token missing scopes", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "narrow-access-token",
+				"expires_in":   3600,
+				"scope":        "read",
+			})
+		}))
+		t.Cleanup(server.Close)
+
+		store := newTestStore(t)
+		cfg := validConfig()
+		cfg.TokenURL = server.URL
+		cfg.RequiredScopes = []string{"write"}
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+		provider.token = validToken()
+
+		_, err = provider.RefreshToken(t.Context())
+		var scopeErr *mcpoauth.ScopeError
+		require.ErrorAs(t, err, &scopeErr)
+		require.Equal(t, []string{"read"}, scopeErr.Granted)
+	})
+
+	t.Run("re-authorizes instead of using stored token missing scopes", func(t *testing.T) {
+		store := newTestStore(t)
+		saveTestToken(t, store, "test", narrow())
+		cfg := validConfig()
+		cfg.RequiredScopes = []string{"write"}
+		provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+		require.NoError(t, err)
+		var authorized bool
+		provider.SetAuthFunc(func(context.Context, mcpoauth.Config) (*oauth.Token, error) {
+			authorized = true
+			token := validToken()
+			token.GrantedScopes = []string{"read", "write"}
+			return token, nil
+		})
+
+		token, err := provider.EnsureToken(t.Context())
+		require.NoError(t, err)
+		require.True(t, authorized)
+		require.Equal(t, []string{"read", "write"}, token.GrantedScopes)
+	})
+}
+
+// fakeTokenProvider hands out a fixed token and counts refreshes.
+type fakeTokenProvider struct {
+	token     *oauth.Token
+	refreshed *oauth.Token
+	refreshes int
+}
+
+func (f *fakeTokenProvider) EnsureToken(context.Context) (*oauth.Token, error) {
+	return f.token, nil
+}
+
+func (f *fakeTokenProvider) RefreshToken(context.Context) (*oauth.Token, error) {
+	f.refreshes++
+	f.token = f.refreshed
+	return f.refreshed, nil
+}
+
+func TestMCPTokenProvider_Clock(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+
+	var received string
+	server := newRefreshServer(t, "", &received)
+
+	store := newTestStore(t)
+	saveTestToken(t, store, "test", &oauth.Token{
+		AccessToken:  "stored-access-token",
+		RefreshToken: "stored-refresh-token",
+		ExpiresIn:    1000,
+		ExpiresAt:    now.Add(1000 * time.Second).Unix(),
+	})
+
+	cfg := validConfig()
+	cfg.TokenURL = server.URL
+	provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+	require.NoError(t, err)
+	provider.now = clock
+
+	token, err := provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "stored-access-token", token.AccessToken)
+
+	// Just before the last 10% of the lifetime the token is still used.
+	now = now.Add(899 * time.Second)
+	token, err = provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "stored-access-token", token.AccessToken)
+	require.Empty(t, received)
+
+	// From there on it is refreshed ahead of its expiry.
+	now = now.Add(time.Second)
+	token, err = provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "refreshed-access-token", token.AccessToken)
+	require.Equal(t, "stored-refresh-token", received)
+	require.Equal(t, now.Add(time.Hour).Unix(), token.ExpiresAt)
+}
+
+func TestMCPTokenProvider_ExpirySkew(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	var received string
+	server := newRefreshServer(t, "", &received)
+
+	store := newTestStore(t)
+	saveTestToken(t, store, "test", &oauth.Token{
+		AccessToken:  "stored-access-token",
+		RefreshToken: "stored-refresh-token",
+		ExpiresIn:    1000,
+		ExpiresAt:    now.Add(1000 * time.Second).Unix(),
+	})
+
+	cfg := validConfig()
+	cfg.TokenURL = server.URL
+	cfg.ExpirySkew = 5 * time.Minute
+	provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+	require.NoError(t, err)
+	now = now.Add(700 * time.Second)
+	provider.now = func() time.Time { return now }
+
+	// Outside the last 10% of the lifetime, but within the configured skew.
+	token, err := provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "refreshed-access-token", token.AccessToken)
+	require.Equal(t, "stored-refresh-token", received)
+	require.Equal(t, 5*time.Minute, provider.ExpirySkew())
+}
+
+func TestOAuthRoundTripper_Clock(t *testing.T) {
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth == "Bearer revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	now := time.Unix(1_700_000_000, 0)
+	newToken := func(access string) *oauth.Token {
+		return &oauth.Token{
+			AccessToken: access,
+			ExpiresIn:   100,
+			ExpiresAt:   now.Add(100 * time.Second).Unix(),
+		}
+	}
+	do := func(t *testing.T, provider TokenProvider) {
+		t.Helper()
+		rt := NewOAuthRoundTripper(provider, nil).(*oauthRoundTripper)
+		rt.now = func() time.Time { return now }
+		rt.retryDelay = 0
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("uses token before refresh window", func(t *testing.T) {
+		seen = nil
+		provider := &fakeTokenProvider{token: newToken("current"), refreshed: newToken("refreshed")}
+		now = now.Add(69 * time.Second)
+		t.Cleanup(func() { now = now.Add(-69 * time.Second) })
+
+		do(t, provider)
+		require.Zero(t, provider.refreshes)
+		require.Equal(t, []string{"Bearer current"}, seen)
+	})
+
+	t.Run("refreshes ahead of expiry", func(t *testing.T) {
+		seen = nil
+		provider := &fakeTokenProvider{token: newToken("current"), refreshed: newToken("refreshed")}
+		now = now.Add(70 * time.Second)
+		t.Cleanup(func() { now = now.Add(-70 * time.Second) })
+
+		do(t, provider)
+		require.Equal(t, 1, provider.refreshes)
+		require.Equal(t, []string{"Bearer refreshed"}, seen)
+	})
+
+	t.Run("retries once after 401", func(t *testing.T) {
+		seen = nil
+		provider := &fakeTokenProvider{token: newToken("revoked"), refreshed: newToken("refreshed")}
+
+		do(t, provider)
+		require.Equal(t, 1, provider.refreshes)
+		require.Equal(t, []string{"Bearer revoked", "Bearer refreshed"}, seen)
+	})
+}
+
+func TestOAuthRoundTripper_ReplaysBodyAfter401(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") == "Bearer revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	newRT := func() *oauthRoundTripper {
+		provider := &fakeTokenProvider{
+			token:     &oauth.Token{AccessToken: "revoked"},
+			refreshed: &oauth.Token{AccessToken: "refreshed"},
+		}
+		rt := NewOAuthRoundTripper(provider, nil).(*oauthRoundTripper)
+		rt.retryDelay = 0
+		return rt
+	}
+	const payload = `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`
+
+	t.Run("replays a POST body", func(t *testing.T) {
+		bodies = nil
+		rt := newRT()
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(payload))
+		require.NoError(t, err)
+
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, []string{payload, payload}, bodies)
+	})
+
+	t.Run("fails when the body cannot be replayed", func(t *testing.T) {
+		bodies = nil
+		rt := newRT()
+		req, err := http.NewRequest(http.MethodPost, server.URL, io.NopCloser(strings.NewReader(payload)))
+		require.NoError(t, err)
+
+		_, err = rt.RoundTrip(req)
+		require.ErrorContains(t, err, "request body cannot be replayed")
+		require.Equal(t, []string{payload}, bodies)
+	})
+}
+
+func TestOAuthRoundTripper_RejectedAfterRefresh(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+
+	var received string
+	tokenServer := newRefreshServer(t, "", &received)
+
+	store := newTestStore(t)
+	token := validToken()
+	err := store.Save("test", "", &MCPOAuthData{
+		ClientID:     "registered-client",
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresIn:    token.ExpiresIn,
+		ExpiresAt:    token.ExpiresAt,
+	})
+	require.NoError(t, err)
+
+	cfg := validConfig()
+	cfg.TokenURL = tokenServer.URL
+	provider, err := NewOAuthTokenProvider("test", "", cfg, store)
+	require.NoError(t, err)
+
+	rt := NewOAuthRoundTripper(provider, nil).(*oauthRoundTripper)
+	rt.retryDelay = 0
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := rt.RoundTrip(req)
+	require.ErrorIs(t, err, ErrReauthRequired)
+	require.Nil(t, resp)
+	require.Equal(t, 2, requests)
+	require.Equal(t, "valid-refresh-token", received)
+
+	// The token is gone, so the next request triggers authorization.
+	require.Nil(t, provider.token)
+	require.Nil(t, loadTestToken(t, store, "test"))
+	data, err := store.Load("test", "")
+	require.NoError(t, err)
+	require.Equal(t, "registered-client", data.ClientID)
+
+	_, err = provider.EnsureToken(context.Background())
+	require.ErrorContains(t, err, "no auth function configured")
+}
+
+func TestMCPTokenProvider_ForceReauthenticate(t *testing.T) {
+	store := newTestStore(t)
+	token := validToken()
+	err := store.Save("test", "", &MCPOAuthData{
+		ClientID:     "registered-client",
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresIn:    token.ExpiresIn,
+		ExpiresAt:    token.ExpiresAt,
+	})
+	require.NoError(t, err)
+
+	provider, err := NewOAuthTokenProvider("test", "", validConfig(), store)
+	require.NoError(t, err)
+
+	_, err = provider.ForceReauthenticate(context.Background())
+	require.ErrorContains(t, err, "no auth function configured")
+
+	var calls int
+	provider.SetAuthFunc(func(ctx context.Context, cfg mcpoauth.Config) (*oauth.Token, error) {
+		calls++
+		fresh := validToken()
+		fresh.AccessToken = "reauthenticated-token"
+		return fresh, nil
+	})
+
+	// The stored token is still valid, so EnsureToken does not authorize.
+	current, err := provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, token.AccessToken, current.AccessToken)
+	require.Zero(t, calls)
+
+	current, err = provider.ForceReauthenticate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, "reauthenticated-token", current.AccessToken)
+
+	data, err := store.Load("test", "")
+	require.NoError(t, err)
+	require.Equal(t, "registered-client", data.ClientID)
+	require.Equal(t, "reauthenticated-token", data.AccessToken)
+}
+
+func TestForceReauthenticate_UnknownMCP(t *testing.T) {
+	_, err := ForceReauthenticate(context.Background(), "not-configured")
+	require.ErrorContains(t, err, `MCP "not-configured" does not use OAuth`)
+}
+
+func TestMCPTokenProvider_OAuthInfo(t *testing.T) {
+	const name = "oauth-info"
+	t.Cleanup(func() {
+		tokenProviders.Del(name)
+		states.Del(name)
+		connStats.Del(name)
+	})
+
+	// Discovered configurations request every supported scope but only
+	// require the configured ones.
+	cfg := validConfig()
+	cfg.Scopes = []string{"read", "write"}
+	cfg.RequiredScopes = []string{"read"}
+	store := newTestStore(t)
+	provider, err := NewOAuthTokenProvider(name, "", cfg, store)
+	require.NoError(t, err)
+	granted := []string{"read"}
+	provider.SetAuthFunc(func(ctx context.Context, cfg mcpoauth.Config) (*oauth.Token, error) {
+		token := validToken()
+		token.GrantedScopes = granted
+		return token, nil
+	})
+	registerTokenProvider(name, provider)
+	updateState(name, StateConnected, nil, nil, Counts{})
+
+	// Nothing to report before authorization.
+	require.Nil(t, mustState(t, name).OAuth)
+
+	_, err = provider.EnsureToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &OAuthInfo{
+		GrantedScopes:   []string{"read"},
+		RequestedScopes: []string{"read", "write"},
+		ScopesMatch:     true,
+	}, mustState(t, name).OAuth)
+
+	granted = []string{"read", "write", "admin"}
+	_, err = provider.ForceReauthenticate(context.Background())
+	require.NoError(t, err)
+	require.True(t, mustState(t, name).OAuth.ScopesMatch)
+
+	// A reused provider reports its token against the new configuration.
+	cfg.Scopes = []string{"read"}
+	next, err := NewOAuthTokenProvider(name, "", cfg, store)
+	require.NoError(t, err)
+	require.True(t, provider.reuse(next))
+	require.Equal(t, []string{"read"}, mustState(t, name).OAuth.RequestedScopes)
+
+	require.NoError(t, provider.InvalidateToken())
+	require.Nil(t, mustState(t, name).OAuth)
+
+	// Servers without OAuth report nothing.
+	updateState("no-oauth", StateConnected, nil, nil, Counts{})
+	t.Cleanup(func() {
+		states.Del("no-oauth")
+		connStats.Del("no-oauth")
+	})
+	require.Nil(t, mustState(t, "no-oauth").OAuth)
+}
@@ -0,0 +1,99 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/charmbracelet/crush/internal/version"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// ProbeConfig connects to the MCP server described by m, counts its tools,
+// prompts and resources and disconnects again. Unlike a regular connect it
+// touches no package state: nothing is registered, no events are published
+// and no stderr log is written. OAuth is not attempted, so a server that
+// requires it fails with its authorization error; a token command is used
+// as configured.
+func ProbeConfig(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver) (Counts, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	transport, err := probeConfigTransport(ctx, name, m, resolver)
+	if err != nil {
+		return Counts{}, err
+	}
+
+	client := mcp.NewClient(&mcp.Implementation{
+		Name:    "crush",
+		Version: version.Version,
+		Title:   "Crush",
+	}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+
+	connectCtx, connectCancel := context.WithTimeout(ctx, mcpTimeout(m))
+	defer connectCancel()
+	session, err := client.Connect(connectCtx, transport, nil)
+	if err != nil {
+		return Counts{}, fmt.Errorf("connecting to mcp server: %w", err)
+	}
+	c := &ClientSession{ClientSession: session, cancel: cancel}
+	defer c.Close()
+
+	tools, err := getTools(ctx, c)
+	if err != nil {
+		return Counts{}, fmt.Errorf("listing tools: %w", err)
+	}
+	prompts, err := getPrompts(ctx, c)
+	if err != nil {
+		return Counts{}, fmt.Errorf("listing prompts: %w", err)
+	}
+	resources, err := getResources(ctx, c)
+	if err != nil {
+		return Counts{}, fmt.Errorf("listing resources: %w", err)
+	}
+
+	counts := Counts{Prompts: len(prompts), Resources: len(resources)}
+	for _, tool := range tools {
+		if !slices.Contains(m.DisabledTools, tool.Name) {
+			counts.Tools++
+		}
+	}
+	return counts, nil
+}
+
+// probeConfigTransport is createTransport without the stderr log and OAuth.
+func probeConfigTransport(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver) (mcp.Transport, error) {
+	switch m.Type {
+	case config.MCPStdio:
+		cmd, err := stdioCommand(ctx, m, resolver)
+		if err != nil {
+			return nil, err
+		}
+		return stdioTransport(name, m, cmd), nil
+	case config.MCPHttp, config.MCPSSE:
+		url, err := resolveURL(m, resolver)
+		if err != nil {
+			return nil, err
+		}
+		m.URL = url
+		m = m.WithEnvSecrets(name, env.New())
+		transport := baseHTTPTransport(name, m, resolver)
+		if len(m.TokenCommand) > 0 {
+			provider, err := newCommandTokenProvider(name, m, resolver)
+			if err != nil {
+				return nil, err
+			}
+			transport = NewOAuthRoundTripper(provider, transport)
+		}
+		client := &http.Client{Transport: transport}
+		if m.Type == config.MCPSSE {
+			return &mcp.SSEClientTransport{Endpoint: m.URL, HTTPClient: client}, nil
+		}
+		return &mcp.StreamableClientTransport{Endpoint: m.URL, HTTPClient: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mcp type: %s", m.Type)
+	}
+}
@@ -0,0 +1,68 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeConfig(t *testing.T) {
+	t.Parallel()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "probe-test"}, nil)
+	for _, name := range []string{"read", "write"} {
+		server.AddTool(&mcp.Tool{Name: name, InputSchema: map[string]any{"type": "object"}}, func(context.Context, *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return &mcp.CallToolResult{}, nil
+		})
+	}
+	server.AddPrompt(&mcp.Prompt{Name: "greet"}, func(context.Context, *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return &mcp.GetPromptResult{}, nil
+	})
+	ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+	t.Cleanup(ts.Close)
+
+	resolver := config.NewShellVariableResolver(env.New())
+
+	t.Run("counts without touching state", func(t *testing.T) {
+		t.Parallel()
+		const name = "probe-config"
+		m := config.MCPConfig{Type: config.MCPHttp, URL: ts.URL, DisabledTools: []string{"write"}}
+
+		counts, err := ProbeConfig(t.Context(), name, m, resolver)
+		require.NoError(t, err)
+		require.Equal(t, Counts{Tools: 1, Prompts: 1}, counts)
+
+		_, ok := sessions.Get(name)
+		require.False(t, ok)
+		_, ok = states.Get(name)
+		require.False(t, ok)
+		_, ok = breakers.Get(name)
+		require.False(t, ok)
+	})
+
+	t.Run("reports connect errors", func(t *testing.T) {
+		t.Parallel()
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		t.Cleanup(ts.Close)
+
+		m := config.MCPConfig{Type: config.MCPHttp, URL: ts.URL}
+		_, err := ProbeConfig(t.Context(), "probe-config-unauthorized", m, resolver)
+		require.Error(t, err)
+		_, ok := states.Get("probe-config-unauthorized")
+		require.False(t, ok)
+	})
+
+	t.Run("rejects unknown types", func(t *testing.T) {
+		t.Parallel()
+		_, err := ProbeConfig(t.Context(), "probe-config-bad", config.MCPConfig{Type: "carrier-pigeon"}, resolver)
+		require.ErrorContains(t, err, "unsupported mcp type")
+	})
+}
@@ -2,8 +2,11 @@ package mcp
 
 import (
 	"context"
+	"fmt"
 	"iter"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/charmbracelet/crush/internal/config"
 	"github.com/charmbracelet/crush/internal/csync"
@@ -14,21 +17,73 @@ type Prompt = mcp.Prompt
 
 var allPrompts = csync.NewMap[string, []*Prompt]()
 
-// Prompts returns all available MCP prompts.
+// Prompts returns all available MCP prompts. Lists evicted over the cache
+// limit are missing until RefetchEvictedPrompts fetches them again.
 func Prompts() iter.Seq2[string, []*Prompt] {
 	return allPrompts.Seq2()
 }
 
-// GetPromptMessages retrieves the content of an MCP prompt with the given arguments.
-func GetPromptMessages(ctx context.Context, cfg *config.ConfigStore, clientName, promptName string, args map[string]string) ([]string, error) {
+// RefetchEvictedPrompts starts fetching again in the background the prompt
+// lists evicted over the cache limit, for callers about to list prompts.
+func RefetchEvictedPrompts() {
+	refetchEvicted(cachePrompts, RefreshPrompts)
+}
+
+// PromptResult is an MCP prompt rendered with its arguments.
+type PromptResult struct {
+	Description string
+	Messages    []*mcp.PromptMessage
+}
+
+// GetPrompt renders an MCP prompt with the given arguments. Required
+// arguments of a listed prompt are checked before the server is called.
+func GetPrompt(ctx context.Context, cfg *config.ConfigStore, clientName, promptName string, args map[string]string) (PromptResult, error) {
+	// Invalid arguments must not cost a reconnect.
+	if err := checkPromptArgs(clientName, promptName, args); err != nil {
+		return PromptResult{}, err
+	}
 	c, err := getOrRenewClient(ctx, cfg, clientName)
 	if err != nil {
-		return nil, err
+		return PromptResult{}, err
 	}
+	caches.Touch(clientName, cachePrompts)
 	result, err := c.GetPrompt(ctx, &mcp.GetPromptParams{
 		Name:      promptName,
 		Arguments: args,
 	})
+	if err != nil {
+		return PromptResult{}, err
+	}
+	return PromptResult{
+		Description: result.Description,
+		Messages:    result.Messages,
+	}, nil
+}
+
+// checkPromptArgs returns an error naming the required arguments of a prompt
+// that args leaves unset or empty. Prompts the server did not list are left
+// for the server to validate.
+func checkPromptArgs(clientName, promptName string, args map[string]string) error {
+	prompts, _ := allPrompts.Get(clientName)
+	idx := slices.IndexFunc(prompts, func(p *Prompt) bool { return p.Name == promptName })
+	if idx < 0 {
+		return nil
+	}
+	var missing []string
+	for _, arg := range prompts[idx].Arguments {
+		if arg.Required && args[arg.Name] == "" {
+			missing = append(missing, arg.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("prompt %q of mcp %q is missing required arguments: %s", promptName, clientName, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// GetPromptMessages retrieves the content of an MCP prompt with the given arguments.
+func GetPromptMessages(ctx context.Context, cfg *config.ConfigStore, clientName, promptName string, args map[string]string) ([]string, error) {
+	result, err := GetPrompt(ctx, cfg, clientName, promptName, args)
 	if err != nil {
 		return nil, err
 	}
@@ -68,11 +123,17 @@ func RefreshPrompts(ctx context.Context, name string) {
 }
 
 func getPrompts(ctx context.Context, c *ClientSession) ([]*Prompt, error) {
-	if c.InitializeResult().Capabilities.Prompts == nil {
+	if !c.Features().Prompts {
 		return nil, nil
 	}
 	result, err := c.ListPrompts(ctx, &mcp.ListPromptsParams{})
 	if err != nil {
+		// Servers may advertise prompts without implementing prompts/list;
+		// that must not take down an otherwise working server.
+		if isMethodNotFoundError(err) {
+			slog.Warn("MCP server does not support prompts/list", "error", err)
+			return nil, nil
+		}
 		return nil, err
 	}
 	return result.Prompts, nil
@@ -81,8 +142,8 @@ func getPrompts(ctx context.Context, c *ClientSession) ([]*Prompt, error) {
 // updatePrompts updates the global mcpPrompts and mcpClient2Prompts maps
 func updatePrompts(mcpName string, prompts []*Prompt) {
 	if len(prompts) == 0 {
-		allPrompts.Del(mcpName)
+		caches.Track(mcpName, cachePrompts, nil, func() { allPrompts.Del(mcpName) })
 		return
 	}
-	allPrompts.Set(mcpName, prompts)
+	caches.Track(mcpName, cachePrompts, prompts, func() { allPrompts.Set(mcpName, prompts) })
 }
@@ -0,0 +1,36 @@
+package mcp
+
+import (
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPromptArgs(t *testing.T) {
+	t.Parallel()
+
+	const name = "prompt-args"
+	allPrompts.Set(name, []*Prompt{{
+		Name: "review",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "file", Required: true},
+			{Name: "focus", Required: true},
+			{Name: "tone"},
+		},
+	}})
+	t.Cleanup(func() { allPrompts.Del(name) })
+
+	require.NoError(t, checkPromptArgs(name, "review", map[string]string{"file": "a.go", "focus": "errors"}))
+	require.NoError(t, checkPromptArgs(name, "unlisted", nil), "unlisted prompts are left to the server")
+
+	err := checkPromptArgs(name, "review", map[string]string{"file": "a.go", "focus": ""})
+	require.EqualError(t, err, `prompt "review" of mcp "prompt-args" is missing required arguments: focus`)
+
+	err = checkPromptArgs(name, "review", map[string]string{"tone": "kind"})
+	require.ErrorContains(t, err, "missing required arguments: file, focus")
+
+	// Arguments are checked before the server is looked up or reconnected.
+	_, err = GetPrompt(t.Context(), nil, name, "review", map[string]string{"tone": "kind"})
+	require.ErrorContains(t, err, "missing required arguments: file, focus")
+}
@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"cmp"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
+)
+
+const (
+	// defaultRateLimitRetries is how often a rate limited request is retried
+	// when the server's config does not say.
+	defaultRateLimitRetries = 3
+	// defaultRateLimitMaxWait caps a single retry delay when the server's
+	// config does not say.
+	defaultRateLimitMaxWait = 30 * time.Second
+	// rateLimitBackoff is the first delay for 429 responses without a usable
+	// Retry-After header. It doubles with each retry.
+	rateLimitBackoff = time.Second
+)
+
+// rateLimitRoundTripper retries requests the server rejects with 429 Too
+// Many Requests, waiting as long as its Retry-After header asks. A delay
+// beyond maxWait, a request body that cannot be replayed or running out of
+// retries returns the 429 response as is.
+type rateLimitRoundTripper struct {
+	name    string
+	retries int
+	maxWait time.Duration
+	base    http.RoundTripper
+	now     func() time.Time
+	// backoff is the first delay used without a Retry-After header.
+	backoff time.Duration
+}
+
+// newRateLimitRoundTripper wraps base with the rate limit retries configured
+// for the server, returning base unchanged when they are disabled.
+func newRateLimitRoundTripper(name string, m config.MCPConfig, base http.RoundTripper) http.RoundTripper {
+	retries := defaultRateLimitRetries
+	if m.RateLimitRetries != nil {
+		retries = *m.RateLimitRetries
+	}
+	if retries <= 0 {
+		return base
+	}
+	return &rateLimitRoundTripper{
+		name:    name,
+		retries: retries,
+		maxWait: cmp.Or(time.Duration(m.RateLimitMaxWait)*time.Second, defaultRateLimitMaxWait),
+		base:    base,
+		now:     time.Now,
+		backoff: rateLimitBackoff,
+	}
+}
+
+func (rt *rateLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	backoff := rt.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := rt.base.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == rt.retries {
+			return resp, err
+		}
+
+		wait, ok := mcpoauth.RetryAfter(resp.Header.Get("Retry-After"), rt.now())
+		if !ok {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > rt.maxWait {
+			slog.Debug("Rate limited by MCP, Retry-After exceeds max wait", "name", rt.name, "retry_after", wait, "max_wait", rt.maxWait)
+			return resp, nil
+		}
+
+		next, ok := rewindRequest(req)
+		if !ok {
+			return resp, nil
+		}
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+
+		slog.Debug("Rate limited by MCP, retrying", "name", rt.name, "attempt", attempt+1, "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		req = next
+	}
+}
+
+// rewindRequest returns a copy of req with a fresh body for a retry, or false
+// if its body cannot be read again.
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	next.Body = body
+	return next, true
+}
@@ -0,0 +1,139 @@
+package mcp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutRateLimit returns the transport wrapped by a rate limit layer.
+func withoutRateLimit(rt http.RoundTripper) http.RoundTripper {
+	if r, ok := rt.(*rateLimitRoundTripper); ok {
+		return r.base
+	}
+	return rt
+}
+
+func TestNewRateLimitRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	base := http.DefaultTransport
+	rt, ok := newRateLimitRoundTripper("test", config.MCPConfig{}, base).(*rateLimitRoundTripper)
+	require.True(t, ok)
+	require.Equal(t, defaultRateLimitRetries, rt.retries)
+	require.Equal(t, defaultRateLimitMaxWait, rt.maxWait)
+
+	rt, ok = newRateLimitRoundTripper("test", config.MCPConfig{RateLimitRetries: new(5), RateLimitMaxWait: 60}, base).(*rateLimitRoundTripper)
+	require.True(t, ok)
+	require.Equal(t, 5, rt.retries)
+	require.Equal(t, time.Minute, rt.maxWait)
+
+	require.Equal(t, base, newRateLimitRoundTripper("test", config.MCPConfig{RateLimitRetries: new(0)}, base))
+}
+
+func TestRateLimitRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	// newServer answers the first limited requests with 429 and the given
+	// Retry-After, echoing the request body once it succeeds.
+	newServer := func(t *testing.T, limited int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if int(calls.Add(1)) <= limited {
+				if retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write(body)
+		}))
+		t.Cleanup(srv.Close)
+		return srv, &calls
+	}
+	newRT := func(retries int, maxWait time.Duration) *rateLimitRoundTripper {
+		return &rateLimitRoundTripper{
+			name:    "test",
+			retries: retries,
+			maxWait: maxWait,
+			base:    http.DefaultTransport,
+			now:     time.Now,
+			backoff: time.Millisecond,
+		}
+	}
+	post := func(t *testing.T, ctx context.Context, rt http.RoundTripper, url string) (*http.Response, error) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(`{"jsonrpc":"2.0"}`))
+		require.NoError(t, err)
+		return rt.RoundTrip(req)
+	}
+
+	t.Run("retries and replays the body", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := newServer(t, 2, "0")
+
+		resp, err := post(t, t.Context(), newRT(3, time.Second), srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, `{"jsonrpc":"2.0"}`, string(body))
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("backs off without Retry-After", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := newServer(t, 1, "")
+
+		resp, err := post(t, t.Context(), newRT(3, time.Second), srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("returns the 429 after the last retry", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := newServer(t, 10, "0")
+
+		resp, err := post(t, t.Context(), newRT(2, time.Second), srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("does not wait beyond the cap", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := newServer(t, 1, "120")
+
+		resp, err := post(t, t.Context(), newRT(3, time.Second), srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("stops waiting when the context is canceled", func(t *testing.T) {
+		t.Parallel()
+		srv, calls := newServer(t, 1, "5")
+
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := post(t, ctx, newRT(3, time.Minute), srv.URL)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.Equal(t, int32(1), calls.Load())
+	})
+}
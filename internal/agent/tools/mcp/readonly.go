@@ -0,0 +1,67 @@
+package mcp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+
+	"github.com/charmbracelet/crush/internal/config"
+)
+
+// ErrReadOnly is returned when calling a tool that MCP read-only mode
+// blocks.
+var ErrReadOnly = errors.New("tool is blocked in MCP read-only mode")
+
+// filterReadOnlyTools removes the tools read-only mode blocks, if enabled.
+func filterReadOnlyTools(cfg *config.ConfigStore, mcpName string, tools []*Tool) []*Tool {
+	opts := cfg.Config().Options
+	if opts == nil || !opts.MCPReadOnly {
+		return tools
+	}
+
+	filtered := make([]*Tool, 0, len(tools))
+	var blocked []string
+	for _, tool := range tools {
+		if readOnlyAllowed(opts, tool) {
+			filtered = append(filtered, tool)
+		} else {
+			blocked = append(blocked, tool.Name)
+		}
+	}
+	if len(blocked) > 0 {
+		slog.Info("MCP read-only mode, blocking tools", "name", mcpName, "tools", blocked)
+	}
+	return filtered
+}
+
+// checkReadOnly returns ErrReadOnly if read-only mode is enabled and the
+// tool of server name is not an exposed read-only tool.
+func checkReadOnly(cfg *config.ConfigStore, name, toolName string) error {
+	opts := cfg.Config().Options
+	if opts == nil || !opts.MCPReadOnly {
+		return nil
+	}
+	tools, _ := serverTools.Get(name)
+	i := slices.IndexFunc(tools, func(t *Tool) bool { return t.Name == toolName })
+	if i < 0 || !readOnlyAllowed(opts, tools[i]) {
+		return fmt.Errorf("mcp %q tool %q: %w", name, toolName, ErrReadOnly)
+	}
+	return nil
+}
+
+// readOnlyAllowed reports whether read-only mode allows a tool: it must be
+// annotated as read-only and not match any of the destructive name
+// patterns. A malformed pattern blocks every tool.
+func readOnlyAllowed(opts *config.Options, tool *Tool) bool {
+	if tool.Annotations == nil || !tool.Annotations.ReadOnlyHint {
+		return false
+	}
+	for _, pattern := range opts.MCPDestructivePatterns {
+		if ok, err := path.Match(pattern, tool.Name); ok || err != nil {
+			return false
+		}
+	}
+	return true
+}
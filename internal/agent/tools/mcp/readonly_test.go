@@ -0,0 +1,58 @@
+package mcp
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyAllowed(t *testing.T) {
+	t.Parallel()
+
+	readOnly := func(name string) *Tool {
+		return &Tool{Name: name, Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true}}
+	}
+	opts := &config.Options{MCPDestructivePatterns: []string{"delete_*"}}
+
+	require.True(t, readOnlyAllowed(opts, readOnly("get_issue")))
+	require.False(t, readOnlyAllowed(opts, readOnly("delete_issue")))
+	require.False(t, readOnlyAllowed(opts, &Tool{Name: "create_issue", Annotations: &mcp.ToolAnnotations{}}))
+	require.False(t, readOnlyAllowed(opts, &Tool{Name: "unannotated"}))
+	require.False(t, readOnlyAllowed(&config.Options{MCPDestructivePatterns: []string{"["}}, readOnly("get_issue")))
+}
+
+func TestUpdateTools_ReadOnly(t *testing.T) {
+	// Uses the package-wide tool maps, so not parallel.
+	const name = "readonly-server"
+	t.Cleanup(func() {
+		serverTools.Del(name)
+		allTools.Del(name)
+		caches.Track(name, cacheTools, nil, nil)
+	})
+
+	tools := []*Tool{
+		{Name: "get_issue", Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true}},
+		{Name: "create_issue"},
+	}
+
+	cfg := config.NewTestStore(&config.Config{
+		Options: &config.Options{},
+		MCP:     map[string]config.MCPConfig{name: {}},
+	})
+	require.Equal(t, 2, updateTools(cfg, name, tools))
+	require.NoError(t, checkReadOnly(cfg, name, "create_issue"))
+
+	cfg = config.NewTestStore(&config.Config{
+		Options: &config.Options{MCPReadOnly: true},
+		MCP:     map[string]config.MCPConfig{name: {}},
+	})
+	require.Equal(t, 1, updateTools(cfg, name, tools))
+	exposed, _ := allTools.Get(name)
+	require.Equal(t, []*Tool{tools[0]}, exposed)
+
+	require.NoError(t, checkReadOnly(cfg, name, "get_issue"))
+	require.ErrorIs(t, checkReadOnly(cfg, name, "create_issue"), ErrReadOnly)
+	require.ErrorIs(t, checkReadOnly(cfg, name, "unknown"), ErrReadOnly)
+}
@@ -16,13 +16,23 @@ type Resource = mcp.Resource
 
 type ResourceContents = mcp.ResourceContents
 
+type ResourceTemplate = mcp.ResourceTemplate
+
 var allResources = csync.NewMap[string, []*Resource]()
 
-// Resources returns all available MCP resources.
+// Resources returns all available MCP resources. Lists evicted over the
+// cache limit are missing until RefetchEvictedResources fetches them again.
 func Resources() iter.Seq2[string, []*Resource] {
 	return allResources.Seq2()
 }
 
+// RefetchEvictedResources starts fetching again in the background the
+// resource lists evicted over the cache limit, for callers about to list
+// resources.
+func RefetchEvictedResources() {
+	refetchEvicted(cacheResources, RefreshResources)
+}
+
 // ListResources returns the current resources for an MCP server.
 func ListResources(ctx context.Context, cfg *config.ConfigStore, name string) ([]*Resource, error) {
 	session, err := getOrRenewClient(ctx, cfg, name)
@@ -48,6 +58,7 @@ func ReadResource(ctx context.Context, cfg *config.ConfigStore, name, uri string
 	if err != nil {
 		return nil, err
 	}
+	caches.Touch(name, cacheResources)
 	result, err := session.ReadResource(ctx, &mcp.ReadResourceParams{URI: uri})
 	if err != nil {
 		return nil, err
@@ -55,6 +66,16 @@ func ReadResource(ctx context.Context, cfg *config.ConfigStore, name, uri string
 	return result.Contents, nil
 }
 
+// ListResourceTemplates returns the resource templates of an MCP server.
+// Resources matching a template's URI template can be read with ReadResource.
+func ListResourceTemplates(ctx context.Context, cfg *config.ConfigStore, name string) ([]*ResourceTemplate, error) {
+	session, err := getOrRenewClient(ctx, cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	return getResourceTemplates(ctx, session)
+}
+
 // RefreshResources gets the updated list of resources from the MCP and updates the
 // global state.
 func RefreshResources(ctx context.Context, name string) {
@@ -78,7 +99,7 @@ func RefreshResources(ctx context.Context, name string) {
 }
 
 func getResources(ctx context.Context, c *ClientSession) ([]*Resource, error) {
-	if c.InitializeResult().Capabilities.Resources == nil {
+	if !c.Features().Resources {
 		return nil, nil
 	}
 	result, err := c.ListResources(ctx, &mcp.ListResourcesParams{})
@@ -93,6 +114,21 @@ func getResources(ctx context.Context, c *ClientSession) ([]*Resource, error) {
 	return result.Resources, nil
 }
 
+func getResourceTemplates(ctx context.Context, c *ClientSession) ([]*ResourceTemplate, error) {
+	if !c.Features().Resources {
+		return nil, nil
+	}
+	result, err := c.ListResourceTemplates(ctx, &mcp.ListResourceTemplatesParams{})
+	if err != nil {
+		if isMethodNotFoundError(err) {
+			slog.Warn("MCP server does not support resources/templates/list", "error", err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result.ResourceTemplates, nil
+}
+
 // isMethodNotFoundError checks if the error is a JSON-RPC "Method not found" error.
 func isMethodNotFoundError(err error) bool {
 	var rpcErr *jsonrpc.Error
@@ -101,9 +137,9 @@ func isMethodNotFoundError(err error) bool {
 
 func updateResources(name string, resources []*Resource) int {
 	if len(resources) == 0 {
-		allResources.Del(name)
+		caches.Track(name, cacheResources, nil, func() { allResources.Del(name) })
 		return 0
 	}
-	allResources.Set(name, resources)
+	caches.Track(name, cacheResources, resources, func() { allResources.Set(name, resources) })
 	return len(resources)
 }
@@ -0,0 +1,250 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"charm.land/fantasy"
+	"github.com/charmbracelet/crush/internal/csync"
+	"github.com/charmbracelet/crush/internal/permission"
+	"github.com/google/uuid"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// SamplingToolName is the tool name of permission requests for sampling.
+// It lacks the "mcp_" prefix of MCP tools so the dialog shows the request's
+// description, which names the model.
+const SamplingToolName = "sampling"
+
+// ErrSamplingUnavailable is returned to servers requesting a completion
+// while no model is configured.
+var ErrSamplingUnavailable = errors.New("no model available for sampling")
+
+// SamplingModel returns the model answering sampling requests and its ID,
+// or a nil model if none is available.
+type SamplingModel func() (fantasy.LanguageModel, string)
+
+var (
+	samplingModel       = csync.NewValue[SamplingModel](nil)
+	samplingPermissions = csync.NewValue[permission.Service](nil)
+	samplingSessions    = &activeSessions{calls: make(map[string]map[string]int)}
+)
+
+// activeSessions counts, per server, the tool calls in progress for each
+// agent session, which the server's sampling requests are attributed to.
+type activeSessions struct {
+	mu    sync.Mutex
+	calls map[string]map[string]int
+}
+
+// add records a call of a session to a server until the returned function
+// is called.
+func (a *activeSessions) add(name, sessionID string) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.calls[name] == nil {
+		a.calls[name] = make(map[string]int)
+	}
+	a.calls[name][sessionID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if a.calls[name][sessionID]--; a.calls[name][sessionID] <= 0 {
+				delete(a.calls[name], sessionID)
+			}
+			if len(a.calls[name]) == 0 {
+				delete(a.calls, name)
+			}
+		})
+	}
+}
+
+// session returns the session a sampling request of a server belongs to.
+// A request cannot be told apart when calls of several sessions are in
+// progress, so it is only attributed when they all belong to one session.
+func (a *activeSessions) session(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.calls[name]) != 1 {
+		return ""
+	}
+	for sessionID := range a.calls[name] {
+		return sessionID
+	}
+	return ""
+}
+
+type sessionIDKey struct{}
+
+// WithSessionID returns a context carrying the agent session that MCP tool
+// calls made with it belong to.
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// trackSamplingSession attributes sampling requests of a server to the
+// session in ctx until the returned function is called.
+func trackSamplingSession(ctx context.Context, name string) func() {
+	sessionID, _ := ctx.Value(sessionIDKey{}).(string)
+	if sessionID == "" {
+		return func() {}
+	}
+	return samplingSessions.add(name, sessionID)
+}
+
+// SetSamplingModel sets the model answering sampling requests of servers
+// with sampling enabled.
+func SetSamplingModel(fn SamplingModel) {
+	samplingModel.Set(fn)
+}
+
+// SamplingPermissionsParams are the parameters shown when a server asks to
+// sample the model.
+type SamplingPermissionsParams struct {
+	Server       string   `json:"server"`
+	Model        string   `json:"model"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	Messages     []string `json:"messages"`
+	MaxTokens    int64    `json:"max_tokens,omitempty"`
+}
+
+// createMessageHandler returns the sampling handler of a server. Each
+// request asks for permission before it is sent to the model.
+func createMessageHandler(name string) func(context.Context, *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+	return func(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+		fn := samplingModel.Get()
+		if fn == nil {
+			return nil, ErrSamplingUnavailable
+		}
+		model, modelID := fn()
+		if model == nil {
+			return nil, ErrSamplingUnavailable
+		}
+
+		call, err := samplingCall(req.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Debug("MCP server requested sampling", "name", name, "model", modelID, "messages", len(req.Params.Messages))
+		if err := requestSamplingPermission(ctx, name, modelID, req.Params); err != nil {
+			return nil, err
+		}
+
+		resp, err := model.Generate(ctx, call)
+		if err != nil {
+			return nil, fmt.Errorf("sampling failed: %w", err)
+		}
+		return &mcp.CreateMessageResult{
+			Content:    &mcp.TextContent{Text: resp.Content.Text()},
+			Model:      modelID,
+			Role:       "assistant",
+			StopReason: stopReason(resp.FinishReason),
+		}, nil
+	}
+}
+
+// requestSamplingPermission asks the user to approve a sampling request,
+// denying it when no permission service is set. Approvals are kept per
+// server and session, so allowing one server does not allow others.
+func requestSamplingPermission(ctx context.Context, name, modelID string, params *mcp.CreateMessageParams) error {
+	permissions := samplingPermissions.Get()
+	if permissions == nil {
+		return permission.ErrorPermissionDenied
+	}
+
+	shown := SamplingPermissionsParams{
+		Server:       name,
+		Model:        modelID,
+		SystemPrompt: params.SystemPrompt,
+		MaxTokens:    params.MaxTokens,
+	}
+	for _, msg := range params.Messages {
+		text := fmt.Sprintf("[%T]", msg.Content)
+		if c, ok := msg.Content.(*mcp.TextContent); ok {
+			text = c.Text
+		}
+		shown.Messages = append(shown.Messages, string(msg.Role)+": "+text)
+	}
+	// Permission dialogs pretty-print JSON strings.
+	data, err := json.Marshal(shown)
+	if err != nil {
+		return err
+	}
+
+	sessionID := samplingSessions.session(name)
+	if sessionID == "" {
+		slog.Debug("Sampling request not attributed to a session", "name", name)
+	}
+	granted, err := permissions.Request(ctx, permission.CreatePermissionRequest{
+		SessionID:   sessionID,
+		ToolCallID:  uuid.NewString(),
+		ToolName:    SamplingToolName,
+		Action:      "sample:" + name,
+		Description: fmt.Sprintf("MCP server %q requests a completion from %s", name, modelID),
+		Params:      string(data),
+		Path:        ".",
+	})
+	if err != nil {
+		return err
+	}
+	if !granted {
+		return permission.ErrorPermissionDenied
+	}
+	return nil
+}
+
+// samplingCall converts the parameters of a sampling request into a model
+// call.
+func samplingCall(params *mcp.CreateMessageParams) (fantasy.Call, error) {
+	var call fantasy.Call
+	if params.SystemPrompt != "" {
+		call.Prompt = append(call.Prompt, fantasy.NewSystemMessage(params.SystemPrompt))
+	}
+	for _, msg := range params.Messages {
+		var part fantasy.MessagePart
+		switch c := msg.Content.(type) {
+		case *mcp.TextContent:
+			part = fantasy.TextPart{Text: c.Text}
+		case *mcp.ImageContent:
+			part = fantasy.FilePart{Data: c.Data, MediaType: c.MIMEType}
+		case *mcp.AudioContent:
+			part = fantasy.FilePart{Data: c.Data, MediaType: c.MIMEType}
+		default:
+			return fantasy.Call{}, fmt.Errorf("unsupported sampling content %T", msg.Content)
+		}
+		role := fantasy.MessageRoleUser
+		if msg.Role == "assistant" {
+			role = fantasy.MessageRoleAssistant
+		}
+		call.Prompt = append(call.Prompt, fantasy.Message{Role: role, Content: []fantasy.MessagePart{part}})
+	}
+	if params.MaxTokens > 0 {
+		call.MaxOutputTokens = &params.MaxTokens
+	}
+	if params.Temperature > 0 {
+		call.Temperature = &params.Temperature
+	}
+	return call, nil
+}
+
+// stopReason maps a model finish reason to an MCP stop reason.
+func stopReason(reason fantasy.FinishReason) string {
+	switch reason {
+	case fantasy.FinishReasonStop:
+		return "endTurn"
+	case fantasy.FinishReasonLength:
+		return "maxTokens"
+	case fantasy.FinishReasonToolCalls:
+		return "toolUse"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,217 @@
+package mcp
+
+import (
+	"context"
+	"testing"
+
+	"charm.land/fantasy"
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/permission"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSamplingModel answers every call with a fixed text and records the
+// last call.
+type fakeSamplingModel struct {
+	fantasy.LanguageModel
+	text string
+	call fantasy.Call
+}
+
+func (m *fakeSamplingModel) Generate(_ context.Context, call fantasy.Call) (*fantasy.Response, error) {
+	m.call = call
+	return &fantasy.Response{
+		Content:      fantasy.ResponseContent{fantasy.TextContent{Text: m.text}},
+		FinishReason: fantasy.FinishReasonStop,
+	}, nil
+}
+
+// fakePermissions grants or denies every request and records the last one.
+type fakePermissions struct {
+	permission.Service
+	grant bool
+	req   permission.CreatePermissionRequest
+}
+
+func (p *fakePermissions) Request(_ context.Context, req permission.CreatePermissionRequest) (bool, error) {
+	p.req = req
+	return p.grant, nil
+}
+
+func TestSamplingCall(t *testing.T) {
+	t.Parallel()
+
+	call, err := samplingCall(&mcp.CreateMessageParams{
+		SystemPrompt: "be brief",
+		MaxTokens:    100,
+		Temperature:  0.5,
+		Messages: []*mcp.SamplingMessage{
+			{Role: "user", Content: &mcp.TextContent{Text: "hi"}},
+			{Role: "assistant", Content: &mcp.TextContent{Text: "hello"}},
+			{Role: "user", Content: &mcp.ImageContent{Data: []byte("png"), MIMEType: "image/png"}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, call.Prompt, 4)
+	require.Equal(t, fantasy.MessageRoleSystem, call.Prompt[0].Role)
+	require.Equal(t, fantasy.MessageRoleUser, call.Prompt[1].Role)
+	require.Equal(t, fantasy.TextPart{Text: "hi"}, call.Prompt[1].Content[0])
+	require.Equal(t, fantasy.MessageRoleAssistant, call.Prompt[2].Role)
+	require.Equal(t, fantasy.FilePart{Data: []byte("png"), MediaType: "image/png"}, call.Prompt[3].Content[0])
+	require.Equal(t, int64(100), *call.MaxOutputTokens)
+	require.Equal(t, 0.5, *call.Temperature)
+
+	call, err = samplingCall(&mcp.CreateMessageParams{
+		Messages: []*mcp.SamplingMessage{{Role: "user", Content: &mcp.TextContent{Text: "hi"}}},
+	})
+	require.NoError(t, err)
+	require.Len(t, call.Prompt, 1)
+	require.Nil(t, call.MaxOutputTokens)
+	require.Nil(t, call.Temperature)
+
+	_, err = samplingCall(&mcp.CreateMessageParams{
+		Messages: []*mcp.SamplingMessage{{Role: "user", Content: &mcp.ResourceLink{URI: "file:///x"}}},
+	})
+	require.Error(t, err)
+}
+
+func TestCapabilitiesFor(t *testing.T) {
+	t.Parallel()
+
+	require.Same(t, clientCapabilities, capabilitiesFor(config.MCPConfig{}))
+	caps := capabilitiesFor(config.MCPConfig{Sampling: true})
+	require.NotNil(t, caps.Sampling)
+	require.Equal(t, clientCapabilities.RootsV2, caps.RootsV2)
+	require.Nil(t, clientCapabilities.Sampling, "defaults must be left untouched")
+}
+
+func TestSamplingHandler(t *testing.T) {
+	// Uses the package-wide sampling model and permissions, so not parallel.
+	t.Cleanup(func() {
+		samplingModel.Set(nil)
+		samplingPermissions.Set(nil)
+	})
+
+	// connect returns a server session whose client has sampling configured
+	// as in m.
+	connect := func(t *testing.T, m config.MCPConfig) (*mcp.ServerSession, *ClientSession) {
+		t.Helper()
+		serverTransport, clientTransport := mcp.NewInMemoryTransports()
+		server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+		serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { serverSession.Close() })
+
+		client := mcp.NewClient(&mcp.Implementation{Name: "crush"}, clientOptions("sampler", m))
+		session, err := client.Connect(t.Context(), clientTransport, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { session.Close() })
+		return serverSession, &ClientSession{ClientSession: session, capabilities: capabilitiesFor(m)}
+	}
+	params := &mcp.CreateMessageParams{
+		MaxTokens: 50,
+		Messages:  []*mcp.SamplingMessage{{Role: "user", Content: &mcp.TextContent{Text: "summarize"}}},
+	}
+
+	t.Run("not offered unless enabled", func(t *testing.T) {
+		server, client := connect(t, config.MCPConfig{})
+		require.False(t, client.Features().Sampling)
+		_, err := server.CreateMessage(t.Context(), params)
+		require.Error(t, err)
+	})
+
+	t.Run("fails without a model", func(t *testing.T) {
+		samplingModel.Set(nil)
+		server, client := connect(t, config.MCPConfig{Sampling: true})
+		require.True(t, client.Features().Sampling)
+		_, err := server.CreateMessage(t.Context(), params)
+		require.ErrorContains(t, err, ErrSamplingUnavailable.Error())
+	})
+
+	t.Run("asks for permission and answers with the model", func(t *testing.T) {
+		model := &fakeSamplingModel{text: "a summary"}
+		perms := &fakePermissions{grant: true}
+		SetSamplingModel(func() (fantasy.LanguageModel, string) { return model, "big-model" })
+		samplingPermissions.Set(perms)
+
+		server, _ := connect(t, config.MCPConfig{Sampling: true})
+		res, err := server.CreateMessage(t.Context(), params)
+		require.NoError(t, err)
+		require.Equal(t, "big-model", res.Model)
+		require.Equal(t, mcp.Role("assistant"), res.Role)
+		require.Equal(t, "endTurn", res.StopReason)
+		require.Equal(t, &mcp.TextContent{Text: "a summary"}, res.Content)
+
+		require.Equal(t, SamplingToolName, perms.req.ToolName)
+		require.Equal(t, "sample:sampler", perms.req.Action, "approvals are kept per server")
+		require.Empty(t, perms.req.SessionID)
+		require.Contains(t, perms.req.Params, `"server":"sampler"`)
+		require.Contains(t, perms.req.Params, "user: summarize")
+		require.Equal(t, int64(50), *model.call.MaxOutputTokens)
+	})
+
+	t.Run("attributes requests to the session of the tool call", func(t *testing.T) {
+		SetSamplingModel(func() (fantasy.LanguageModel, string) { return &fakeSamplingModel{text: "ok"}, "big-model" })
+		perms := &fakePermissions{grant: true}
+		samplingPermissions.Set(perms)
+
+		server, _ := connect(t, config.MCPConfig{Sampling: true})
+		done := trackSamplingSession(WithSessionID(t.Context(), "session-1"), "sampler")
+		_, err := server.CreateMessage(t.Context(), params)
+		done()
+		require.NoError(t, err)
+		require.Equal(t, "session-1", perms.req.SessionID)
+
+		require.Empty(t, samplingSessions.session("sampler"), "the session is released after the call")
+	})
+
+	t.Run("keeps the session of overlapping calls", func(t *testing.T) {
+		SetSamplingModel(func() (fantasy.LanguageModel, string) { return &fakeSamplingModel{text: "ok"}, "big-model" })
+		perms := &fakePermissions{grant: true}
+		samplingPermissions.Set(perms)
+
+		server, _ := connect(t, config.MCPConfig{Sampling: true})
+		ctx := WithSessionID(t.Context(), "session-1")
+		first := trackSamplingSession(ctx, "sampler")
+		second := trackSamplingSession(ctx, "sampler")
+
+		// The first call finishing leaves the second one attributed.
+		first()
+		first()
+		_, err := server.CreateMessage(t.Context(), params)
+		require.NoError(t, err)
+		require.Equal(t, "session-1", perms.req.SessionID)
+
+		// Calls of another session make requests ambiguous.
+		other := trackSamplingSession(WithSessionID(t.Context(), "session-2"), "sampler")
+		_, err = server.CreateMessage(t.Context(), params)
+		require.NoError(t, err)
+		require.Empty(t, perms.req.SessionID)
+
+		other()
+		second()
+		require.Empty(t, samplingSessions.session("sampler"))
+		require.Empty(t, samplingSessions.calls)
+	})
+
+	t.Run("denied requests do not reach the model", func(t *testing.T) {
+		model := &fakeSamplingModel{text: "a summary"}
+		SetSamplingModel(func() (fantasy.LanguageModel, string) { return model, "big-model" })
+		samplingPermissions.Set(&fakePermissions{grant: false})
+
+		server, _ := connect(t, config.MCPConfig{Sampling: true})
+		_, err := server.CreateMessage(t.Context(), params)
+		require.Error(t, err)
+		require.Nil(t, model.call.Prompt)
+	})
+}
+
+func TestStopReason(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "endTurn", stopReason(fantasy.FinishReasonStop))
+	require.Equal(t, "maxTokens", stopReason(fantasy.FinishReasonLength))
+	require.Equal(t, "toolUse", stopReason(fantasy.FinishReasonToolCalls))
+	require.Empty(t, stopReason(fantasy.FinishReasonError))
+}
@@ -0,0 +1,185 @@
+package mcp
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
+	"github.com/charmbracelet/crush/internal/version"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// setupProbeTimeout bounds each connection attempt made by ProbeServer.
+const setupProbeTimeout = 15 * time.Second
+
+// SetupReport is what ProbeServer learned about a remote MCP server, for an
+// interactive setup to present before anything is saved.
+type SetupReport struct {
+	// URL is the probed server URL.
+	URL string
+	// Transport is the transport the server answered on, or empty if it
+	// could not be determined.
+	Transport config.MCPType
+	// Connected reports whether an MCP session could be established without
+	// credentials.
+	Connected bool
+	// ServerInfo is the implementation reported by the server, when
+	// connected.
+	ServerInfo *mcp.Implementation
+	// ConnectError is the last connection error, when not connected.
+	ConnectError error
+
+	// OAuthRequired reports whether the server rejected unauthenticated
+	// requests.
+	OAuthRequired bool
+	// OAuth is the discovered OAuth configuration, if the server publishes
+	// authorization server metadata.
+	OAuth *mcpoauth.Config
+	// DynamicRegistration reports whether the authorization server supports
+	// dynamic client registration, so no client ID needs to be configured.
+	DynamicRegistration bool
+	// NeedsClientID reports whether the user has to supply an OAuth client
+	// ID for the suggested configuration to work.
+	NeedsClientID bool
+
+	// Config is the suggested configuration for the server.
+	Config config.MCPConfig
+}
+
+// ProbeServer inspects a remote MCP server for an interactive setup: it runs
+// OAuth discovery, tries the streamable HTTP and SSE transports, and suggests
+// a configuration. It has no side effects: nothing is persisted, no tokens are
+// requested and no client is registered. An error is only returned for an
+// invalid URL or a done context; connection failures are reported in the
+// result.
+func ProbeServer(ctx context.Context, serverURL string) (*SetupReport, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid mcp server url %q: must be an absolute http or https URL", serverURL)
+	}
+
+	report := &SetupReport{URL: serverURL}
+
+	// Discovery is not cached here, so probing never affects running
+	// clients.
+	oauthCfg, err := mcpoauth.DiscoverOAuth(ctx, serverURL, mcpoauth.EndpointHostStrict)
+	if err != nil {
+		slog.Debug("OAuth discovery failed during MCP setup", "url", serverURL, "error", err)
+	}
+	report.OAuth = oauthCfg
+	report.DynamicRegistration = oauthCfg != nil && oauthCfg.RegistrationEndpoint != ""
+
+	for _, transport := range []config.MCPType{config.MCPHttp, config.MCPSSE} {
+		result := probeTransport(ctx, serverURL, transport)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if result.err == nil {
+			report.Transport = transport
+			report.Connected = true
+			report.ServerInfo = result.serverInfo
+			report.ConnectError = nil
+			break
+		}
+		report.ConnectError = result.err
+		if result.unauthorized && !report.OAuthRequired {
+			// The endpoint exists but wants credentials; keep the first
+			// transport that got that far.
+			report.OAuthRequired = true
+			report.Transport = transport
+		}
+	}
+
+	report.NeedsClientID = report.OAuthRequired && !report.DynamicRegistration
+	report.Config = suggestConfig(report)
+	return report, nil
+}
+
+// probeResult is the outcome of a single connection attempt.
+type probeResult struct {
+	serverInfo   *mcp.Implementation
+	unauthorized bool
+	err          error
+}
+
+// probeTransport tries to open and immediately close an MCP session using
+// the given transport.
+func probeTransport(ctx context.Context, serverURL string, transport config.MCPType) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, setupProbeTimeout)
+	defer cancel()
+
+	recorder := &unauthorizedRecorder{base: http.DefaultTransport}
+	client := &http.Client{Transport: recorder}
+
+	var t mcp.Transport
+	switch transport {
+	case config.MCPHttp:
+		t = &mcp.StreamableClientTransport{Endpoint: serverURL, HTTPClient: client}
+	case config.MCPSSE:
+		t = &mcp.SSEClientTransport{Endpoint: serverURL, HTTPClient: client}
+	default:
+		return probeResult{err: fmt.Errorf("unsupported transport %q", transport)}
+	}
+
+	c := mcp.NewClient(&mcp.Implementation{
+		Name:    "crush",
+		Version: version.Version,
+		Title:   "Crush",
+	}, &mcp.ClientOptions{Capabilities: clientCapabilities})
+	session, err := c.Connect(ctx, t, nil)
+	if err != nil {
+		return probeResult{unauthorized: recorder.unauthorized.Load(), err: err}
+	}
+	defer session.Close()
+
+	var info *mcp.Implementation
+	if res := session.InitializeResult(); res != nil {
+		info = res.ServerInfo
+	}
+	if info == nil {
+		return probeResult{err: errors.New("server did not complete initialization")}
+	}
+	return probeResult{serverInfo: info}
+}
+
+// unauthorizedRecorder notes whether any response was 401 Unauthorized.
+type unauthorizedRecorder struct {
+	base         http.RoundTripper
+	unauthorized atomic.Bool
+}
+
+func (r *unauthorizedRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		r.unauthorized.Store(true)
+	}
+	return resp, err
+}
+
+// suggestConfig builds the configuration to offer the user. Settings that
+// auto-discovery already provides at runtime are left out.
+func suggestConfig(r *SetupReport) config.MCPConfig {
+	m := config.MCPConfig{
+		Type: cmp.Or(r.Transport, config.MCPHttp),
+		URL:  r.URL,
+	}
+	if r.NeedsClientID && r.OAuth != nil {
+		// Without dynamic registration the user must add a client ID, which
+		// turns off discovery, so spell out the discovered endpoints.
+		m.OAuth = &config.MCPOAuthConfig{
+			AuthURL:          r.OAuth.AuthURL,
+			TokenURL:         r.OAuth.TokenURL,
+			Scopes:           r.OAuth.Scopes,
+			IntrospectionURL: r.OAuth.IntrospectionEndpoint,
+		}
+	}
+	return m
+}
@@ -0,0 +1,137 @@
+package mcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func newSetupTestServer() *mcp.Server {
+	return mcp.NewServer(&mcp.Implementation{Name: "setup-test", Version: "1.2.3"}, nil)
+}
+
+// newOAuthProtectedServer returns a server that rejects every MCP request
+// with 401 and publishes authorization server metadata.
+func newOAuthProtectedServer(t *testing.T, withRegistration bool) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		metadata := map[string]any{
+			"issuer":                   server.URL,
+			"authorization_endpoint":   server.URL + "/authorize",
+			"token_endpoint":           server.URL + "/token",
+			"scopes_supported":         []string{"read"},
+			"response_types_supported": []string{"code"},
+		}
+		if withRegistration {
+			metadata["registration_endpoint"] = server.URL + "/register"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(metadata)
+	})
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProbeServer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("streamable http", func(t *testing.T) {
+		t.Parallel()
+
+		server := newSetupTestServer()
+		ts := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+		t.Cleanup(ts.Close)
+
+		report, err := ProbeServer(t.Context(), ts.URL)
+		require.NoError(t, err)
+		require.True(t, report.Connected)
+		require.Equal(t, config.MCPHttp, report.Transport)
+		require.Equal(t, "setup-test", report.ServerInfo.Name)
+		require.False(t, report.OAuthRequired)
+		require.Nil(t, report.OAuth)
+		require.Equal(t, config.MCPConfig{Type: config.MCPHttp, URL: ts.URL}, report.Config)
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		t.Parallel()
+
+		server := newSetupTestServer()
+		mux := http.NewServeMux()
+		mux.Handle("/sse", mcp.NewSSEHandler(func(*http.Request) *mcp.Server { return server }, nil))
+		ts := httptest.NewServer(mux)
+		t.Cleanup(ts.Close)
+
+		report, err := ProbeServer(t.Context(), ts.URL+"/sse")
+		require.NoError(t, err)
+		require.True(t, report.Connected)
+		require.Equal(t, config.MCPSSE, report.Transport)
+		require.Equal(t, "1.2.3", report.ServerInfo.Version)
+		require.Equal(t, config.MCPConfig{Type: config.MCPSSE, URL: ts.URL + "/sse"}, report.Config)
+	})
+
+	t.Run("oauth with dynamic registration", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newOAuthProtectedServer(t, true)
+
+		report, err := ProbeServer(t.Context(), ts.URL+"/mcp")
+		require.NoError(t, err)
+		require.False(t, report.Connected)
+		require.Error(t, report.ConnectError)
+		require.True(t, report.OAuthRequired)
+		require.True(t, report.DynamicRegistration)
+		require.False(t, report.NeedsClientID)
+		require.Equal(t, ts.URL+"/token", report.OAuth.TokenURL)
+		require.Equal(t, config.MCPConfig{Type: config.MCPHttp, URL: ts.URL + "/mcp"}, report.Config)
+	})
+
+	t.Run("oauth without dynamic registration", func(t *testing.T) {
+		t.Parallel()
+
+		ts := newOAuthProtectedServer(t, false)
+
+		report, err := ProbeServer(t.Context(), ts.URL+"/mcp")
+		require.NoError(t, err)
+		require.True(t, report.OAuthRequired)
+		require.False(t, report.DynamicRegistration)
+		require.True(t, report.NeedsClientID)
+		require.NotNil(t, report.Config.OAuth)
+		require.Equal(t, ts.URL+"/authorize", report.Config.OAuth.AuthURL)
+		require.Equal(t, ts.URL+"/token", report.Config.OAuth.TokenURL)
+		require.Equal(t, []string{"read"}, report.Config.OAuth.Scopes)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		t.Parallel()
+
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+
+		report, err := ProbeServer(t.Context(), ts.URL)
+		require.NoError(t, err)
+		require.False(t, report.Connected)
+		require.False(t, report.OAuthRequired)
+		require.Error(t, report.ConnectError)
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		t.Parallel()
+
+		for _, u := range []string{"", "ftp://example.com", "/relative", "http://"} {
+			_, err := ProbeServer(t.Context(), u)
+			require.Error(t, err, u)
+		}
+	})
+}
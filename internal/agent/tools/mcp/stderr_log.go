@@ -0,0 +1,66 @@
+package mcp
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/csync"
+	"github.com/charmbracelet/crush/internal/home"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// stderrLogs holds the open stderr log file of each stdio server, kept
+// across restarts so output is appended to the same file.
+var stderrLogs = csync.NewMap[string, *lumberjack.Logger]()
+
+// stderrLogMaxSize is the size, in megabytes, at which a stderr log file is
+// rotated.
+const stderrLogMaxSize = 10
+
+// stderrLog returns the writer for a stdio server's stderr, or nil when no
+// log file is configured. It marks the start of the process in the file,
+// naming only the command, as resolved arguments may hold secrets.
+func stderrLog(name string, m config.MCPConfig, resolver config.VariableResolver, command string) (io.Writer, error) {
+	if m.LogFile == "" {
+		return nil, nil
+	}
+	path, err := resolver.ResolveValue(m.LogFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mcp log file: %w", err)
+	}
+	path = home.Long(path)
+
+	logger := stderrLogs.GetOrSet(name, func() *lumberjack.Logger { return newStderrLog(path) })
+	if logger.Filename != path {
+		_ = logger.Close()
+		logger = newStderrLog(path)
+		stderrLogs.Set(name, logger)
+	}
+
+	header := fmt.Sprintf("--- %s: starting %s ---\n", time.Now().Format(time.RFC3339), command)
+	if _, err := io.WriteString(logger, header); err != nil {
+		return nil, fmt.Errorf("failed to write mcp log file: %w", err)
+	}
+	return logger, nil
+}
+
+func newStderrLog(path string) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    stderrLogMaxSize,
+		MaxBackups: 1,
+	}
+}
+
+// closeStderrLogs closes all open stderr log files.
+func closeStderrLogs() {
+	for name, logger := range stderrLogs.Seq2() {
+		if err := logger.Close(); err != nil {
+			slog.Warn("Failed to close MCP log file", "name", name, "error", err)
+		}
+		stderrLogs.Del(name)
+	}
+}
@@ -0,0 +1,65 @@
+package mcp
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTransport_StderrLogFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	name := "stderr-" + t.Name()
+	t.Cleanup(func() {
+		if logger, ok := stderrLogs.Get(name); ok {
+			_ = logger.Close()
+			stderrLogs.Del(name)
+		}
+	})
+	m := config.MCPConfig{
+		Type:    config.MCPStdio,
+		Command: "sh",
+		Args:    []string{"-c", "echo oops >&2 # ${MCP_TEST_TOKEN:-secret-token}"},
+		LogFile: "${MCP_LOG_DIR:-" + dir + "}/server.log",
+	}
+	resolver := config.NewShellVariableResolver(env.NewFromMap(nil))
+
+	// Run the server twice; output of both runs is appended.
+	for range 2 {
+		transport, err := createTransport(t.Context(), name, m, resolver, nil)
+		require.NoError(t, err)
+		require.NoError(t, transport.(*mcp.CommandTransport).Command.Run())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "server.log"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	for i := 0; i < len(lines); i += 2 {
+		require.True(t, strings.HasSuffix(lines[i], "starting sh ---"), lines[i])
+		require.NotContains(t, lines[i], "secret-token", "arguments may hold secrets")
+		require.Equal(t, "oops", lines[i+1])
+	}
+}
+
+func TestCreateTransport_NoStderrLogFile(t *testing.T) {
+	t.Parallel()
+
+	transport, err := createTransport(t.Context(), "no-stderr-log", config.MCPConfig{
+		Type:    config.MCPStdio,
+		Command: "echo",
+	}, config.NewShellVariableResolver(env.NewFromMap(nil)), nil)
+	require.NoError(t, err)
+	require.Nil(t, transport.(*mcp.CommandTransport).Command.Stderr)
+}
@@ -0,0 +1,132 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// stdioTerminateDuration is how long to wait for a stdio server to exit after
+// closing its stdin before signalling it.
+const stdioTerminateDuration = 5 * time.Second
+
+// tolerantCommandTransport is like mcp.CommandTransport, but skips lines a
+// server writes to stdout that are not valid JSON instead of failing the
+// whole session. Strict framing (mcp.CommandTransport) remains the default.
+type tolerantCommandTransport struct {
+	name    string
+	Command *exec.Cmd
+}
+
+// Connect starts the command and connects to it over stdin/stdout.
+func (t *tolerantCommandTransport) Connect(ctx context.Context) (mcp.Connection, error) {
+	stdout, err := t.Command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := t.Command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = t.Command.Start(); err != nil {
+		return nil, err
+	}
+	transport := &mcp.IOTransport{
+		// Close the connection by closing stdin, not stdout.
+		Reader: io.NopCloser(newJSONLineReader(t.name, stdout)),
+		Writer: &processStdin{cmd: t.Command, stdin: stdin},
+	}
+	return transport.Connect(ctx)
+}
+
+// jsonLineReader reads newline-delimited JSON, dropping any line that isn't
+// valid JSON.
+type jsonLineReader struct {
+	name    string
+	src     *bufio.Reader
+	pending []byte
+}
+
+func newJSONLineReader(name string, r io.Reader) *jsonLineReader {
+	return &jsonLineReader{
+		name: name,
+		src:  bufio.NewReader(r),
+	}
+}
+
+func (r *jsonLineReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		line, err := r.src.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if json.Valid(trimmed) {
+				r.pending = line
+			} else {
+				slog.Warn("Skipping non-JSON output from MCP server", "name", r.name, "line", string(trimmed))
+			}
+		}
+		if err != nil {
+			if len(r.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// processStdin writes to a subprocess's stdin. Closing it closes stdin and
+// waits for the process to exit, escalating to SIGTERM and then SIGKILL if
+// it does not.
+type processStdin struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func (s *processStdin) Write(p []byte) (int, error) {
+	return s.stdin.Write(p)
+}
+
+func (s *processStdin) Close() error {
+	if err := s.stdin.Close(); err != nil {
+		return fmt.Errorf("closing stdin: %w", err)
+	}
+	resChan := make(chan error, 1)
+	go func() {
+		resChan <- s.cmd.Wait()
+	}()
+	wait := func() (error, bool) {
+		select {
+		case err := <-resChan:
+			return err, true
+		case <-time.After(stdioTerminateDuration):
+		}
+		return nil, false
+	}
+	if err, ok := wait(); ok {
+		return err
+	}
+	if err := s.cmd.Process.Signal(syscall.SIGTERM); err == nil {
+		if err, ok := wait(); ok {
+			return err
+		}
+	}
+	if err := s.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	if err, ok := wait(); ok {
+		return err
+	}
+	return errors.New("unresponsive subprocess")
+}
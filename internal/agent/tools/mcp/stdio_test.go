@@ -0,0 +1,71 @@
+package mcp
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONLineReader(t *testing.T) {
+	t.Run("skips non-JSON lines", func(t *testing.T) {
+		input := "{\"jsonrpc\":\"2.0\",\"id\":1}\n" +
+			"debug: server starting\n" +
+			"\n" +
+			"{\"jsonrpc\":\"2.0\",\"id\":2}\n"
+
+		out, err := io.ReadAll(newJSONLineReader("test", strings.NewReader(input)))
+		require.NoError(t, err)
+		require.Equal(t, "{\"jsonrpc\":\"2.0\",\"id\":1}\n{\"jsonrpc\":\"2.0\",\"id\":2}\n", string(out))
+	})
+
+	t.Run("keeps final line without newline", func(t *testing.T) {
+		out, err := io.ReadAll(newJSONLineReader("test", strings.NewReader("oops\n{\"id\":1}")))
+		require.NoError(t, err)
+		require.Equal(t, "{\"id\":1}", string(out))
+	})
+}
+
+// strayWriter writes a non-JSON line before every frame.
+type strayWriter struct {
+	io.WriteCloser
+}
+
+func (w strayWriter) Write(p []byte) (int, error) {
+	if _, err := w.WriteCloser.Write([]byte("debug: stray print\n")); err != nil {
+		return 0, err
+	}
+	return w.WriteCloser.Write(p)
+}
+
+func TestJSONLineReader_StraySessionOutput(t *testing.T) {
+	serverRead, clientWrite := io.Pipe()
+	clientRead, serverWrite := io.Pipe()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "echo"}, func(context.Context, *mcp.CallToolRequest, struct{}) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{}, nil, nil
+	})
+	serverSession, err := server.Connect(context.Background(), &mcp.IOTransport{
+		Reader: serverRead,
+		Writer: strayWriter{serverWrite},
+	}, nil)
+	require.NoError(t, err)
+	defer serverSession.Close()
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, nil)
+	clientSession, err := client.Connect(context.Background(), &mcp.IOTransport{
+		Reader: io.NopCloser(newJSONLineReader("test", clientRead)),
+		Writer: clientWrite,
+	}, nil)
+	require.NoError(t, err)
+	defer clientSession.Close()
+
+	result, err := clientSession.ListTools(context.Background(), &mcp.ListToolsParams{})
+	require.NoError(t, err)
+	require.Len(t, result.Tools, 1)
+	require.Equal(t, "echo", result.Tools[0].Name)
+}
@@ -1,10 +1,16 @@
 package mcp
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/charmbracelet/crush/internal/config"
@@ -18,78 +24,619 @@ type MCPOAuthData struct {
 	ExpiresAt    int64  `json:"expires_at,omitempty"`
 	ClientID     string `json:"client_id,omitempty"`
 	ClientSecret string `json:"client_secret,omitempty"`
+	// TokenEndpointAuthMethod is the client authentication method the
+	// server registered the client with.
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+
+	TokenType     string   `json:"token_type,omitempty"`
+	GrantedScopes []string `json:"granted_scopes,omitempty"`
+
+	// RegistrationAccessToken and RegistrationClientURI are returned by
+	// dynamic client registration and allow managing the registration later
+	// (RFC 7592).
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+
+	// ServerURLHash is the SHA-256 of the server URL the data was obtained
+	// for, so the data can be discarded when the URL changes.
+	ServerURLHash string `json:"server_url_hash,omitempty"`
+}
+
+// clone returns a copy of d that shares no memory with it.
+func (d *MCPOAuthData) clone() *MCPOAuthData {
+	if d == nil {
+		return nil
+	}
+	c := *d
+	c.GrantedScopes = slices.Clone(d.GrantedScopes)
+	return &c
+}
+
+// tokenStoreVersion is the format version of the token store file. Files
+// written before the format was versioned hold a plain map of entries and
+// are read as version 0. Raising it requires a matching entry in
+// tokenStoreMigrations.
+const tokenStoreVersion = 1
+
+// tokenStoreFile is the document in the token store file. Its fields are
+// written in declaration order and the entries sorted by key, so the output
+// is deterministic.
+type tokenStoreFile struct {
+	Version int                      `json:"version"`
+	Servers map[string]*MCPOAuthData `json:"servers"`
+}
+
+// RegisteredClient describes a dynamically registered OAuth client held in
+// the token store.
+type RegisteredClient struct {
+	MCPName  string
+	Profile  string
+	ClientID string
+	// RegistrationClientURI is empty if the server did not return one.
+	RegistrationClientURI string
+}
+
+// TokenStoreOp identifies the kind of operation performed on the token store.
+type TokenStoreOp string
+
+const (
+	TokenStoreOpLoad   TokenStoreOp = "load"
+	TokenStoreOpSave   TokenStoreOp = "save"
+	TokenStoreOpDelete TokenStoreOp = "delete"
+)
+
+// TokenStoreEvent describes an operation on the token store. It never
+// carries secret values, only which entry was touched and how.
+type TokenStoreEvent struct {
+	Op      TokenStoreOp
+	MCPName string
+	Profile string
+	// Found reports whether an entry existed for the MCP server.
+	Found bool
+	// HasAccessToken reports whether the entry holds an access token.
+	HasAccessToken bool
+	// HasRefreshToken reports whether the entry holds a refresh token.
+	HasRefreshToken bool
+	Error           error
+}
+
+// TokenStore persists the OAuth data of MCP servers, keyed by MCP name and
+// an optional profile. FileTokenStore is the default implementation; others,
+// such as a keyring or an in-memory store, can be set with SetTokenStore.
+type TokenStore interface {
+	// Load returns the data for an MCP server and profile, or nil if there
+	// is none.
+	Load(mcpName, profile string) (*MCPOAuthData, error)
+	// Save stores the data for an MCP server and profile.
+	Save(mcpName, profile string, data *MCPOAuthData) error
+	// Delete removes the data for an MCP server and profile, if any.
+	Delete(mcpName, profile string) error
+	// List returns the entries in the store, sorted by MCP name and
+	// profile.
+	List() ([]TokenStoreEntry, error)
 }
 
-// TokenStore handles persistence of MCP OAuth data globally.
+// TokenStoreEntry identifies an entry in a TokenStore.
+type TokenStoreEntry struct {
+	MCPName string
+	Profile string
+}
+
+// FileTokenStore handles persistence of MCP OAuth data globally.
 // Data is stored in ~/.local/share/crush/mcp.json (or platform equivalent).
-type TokenStore struct {
+//
+// Entries are keyed by MCP name and an optional profile, so the same server
+// can hold credentials for several identities. The empty profile maps to the
+// plain MCP name.
+type FileTokenStore struct {
 	path string
 	mu   sync.RWMutex
+	// migrateFailed is set when rewriting a file in an older format
+	// failed, e.g. in a read-only directory, so it isn't tried on every
+	// load. A successful write clears it.
+	migrateFailed bool
+
+	onEvent func(TokenStoreEvent)
 }
 
-// NewTokenStore creates a new TokenStore using the global data directory.
-func NewTokenStore() *TokenStore {
-	return &TokenStore{
+// NewTokenStore creates a new FileTokenStore using the global data directory.
+func NewTokenStore() *FileTokenStore {
+	return &FileTokenStore{
 		path: filepath.Join(config.GlobalDataDir(), "mcp.json"),
 	}
 }
 
-// Load returns the OAuth data for an MCP server, or nil if not found.
-// Returns an error if the file exists but cannot be read or parsed.
-func (s *TokenStore) Load(mcpName string) (*MCPOAuthData, error) {
+// CheckWritable reports whether the store's directory can be written, so a
+// read-only data directory is detected before an OAuth flow needs to save.
+func (s *FileTokenStore) CheckWritable() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("MCP OAuth directory %s is not writable: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".mcp.json.*.check")
+	if err != nil {
+		return fmt.Errorf("MCP OAuth directory %s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// SetEventHandler registers a callback invoked after every load, save and
+// delete. The callback runs synchronously while the store is locked, so it
+// must not call back into the store. Loads only hold the read lock, so it
+// may run concurrently for them. Pass nil to stop receiving events.
+func (s *FileTokenStore) SetEventHandler(fn func(TokenStoreEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvent = fn
+}
+
+// emit reports an operation to the registered handler, if any. Callers must
+// hold s.mu.
+func (s *FileTokenStore) emit(op TokenStoreOp, mcpName, profile string, data *MCPOAuthData, err error) {
+	if s.onEvent == nil {
+		return
+	}
+	event := TokenStoreEvent{
+		Op:      op,
+		MCPName: mcpName,
+		Profile: profile,
+		Found:   data != nil,
+		Error:   err,
+	}
+	if data != nil {
+		event.HasAccessToken = data.AccessToken != ""
+		event.HasRefreshToken = data.RefreshToken != ""
+	}
+	s.onEvent(event)
+}
+
+// storeKeyEscaper escapes the "@" separating MCP names from profiles in
+// store keys, and the "%" used for escaping, so any name can be stored.
+var (
+	storeKeyEscaper   = strings.NewReplacer("%", "%25", "@", "%40")
+	storeKeyUnescaper = strings.NewReplacer("%40", "@", "%25", "%")
+)
+
+// storeKey returns the key under which an MCP server's data is stored for
+// the given profile.
+func storeKey(mcpName, profile string) string {
+	if profile == "" {
+		return storeKeyEscaper.Replace(mcpName)
+	}
+	return storeKeyEscaper.Replace(mcpName) + "@" + storeKeyEscaper.Replace(profile)
+}
+
+// Load returns the OAuth data for an MCP server and profile, or nil if not
+// found. Returns an error if the file exists but cannot be read or parsed.
+// A file in an older format is rewritten in the current one.
+func (s *FileTokenStore) Load(mcpName, profile string) (*MCPOAuthData, error) {
+	data, migrate, err := s.load(mcpName, profile)
+	if migrate {
+		s.migrate()
+	}
+	return data, err
+}
+
+// load reads the data for Load under the read lock and reports whether the
+// file should be migrated.
+func (s *FileTokenStore) load(mcpName, profile string) (*MCPOAuthData, bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	data, err := os.ReadFile(s.path)
+	store, migrated, err := s.read()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to read MCP OAuth file: %w", err)
+		s.emit(TokenStoreOpLoad, mcpName, profile, nil, err)
+		return nil, false, err
+	}
+
+	data := store[storeKey(mcpName, profile)]
+	s.emit(TokenStoreOpLoad, mcpName, profile, data, nil)
+	return data, migrated && !s.migrateFailed, nil
+}
+
+// migrate rewrites a file in an older format in the current one. A failed
+// rewrite is warned about once and not tried again until a write succeeds.
+func (s *FileTokenStore) migrate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.migrateFailed {
+		return
+	}
+	store, migrated, err := s.read()
+	if err != nil || !migrated {
+		return
 	}
+	if err := s.writeAll(store); err != nil {
+		slog.Warn("Failed to write migrated MCP OAuth file", "error", err)
+		s.migrateFailed = true
+		return
+	}
+	slog.Info("Migrated MCP OAuth file", "path", s.path, "version", tokenStoreVersion)
+}
+
+// Save persists the OAuth data for an MCP server and profile.
+func (s *FileTokenStore) Save(mcpName, profile string, oauthData *MCPOAuthData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.save(storeKey(mcpName, profile), oauthData)
+	s.emit(TokenStoreOpSave, mcpName, profile, oauthData, err)
+	return err
+}
 
-	var store map[string]*MCPOAuthData
-	if err = json.Unmarshal(data, &store); err != nil {
-		return nil, fmt.Errorf("failed to parse MCP OAuth file: %w", err)
+func (s *FileTokenStore) save(key string, oauthData *MCPOAuthData) error {
+	store, err := s.readAll()
+	if err != nil {
+		return err
 	}
 
-	return store[mcpName], nil
+	// Update the entry
+	store[key] = oauthData
+
+	return s.writeAll(store)
 }
 
-// Save persists the OAuth data for an MCP server.
-func (s *TokenStore) Save(mcpName string, oauthData *MCPOAuthData) error {
+// Delete removes the OAuth data for an MCP server and profile. Deleting an
+// entry that does not exist is not an error.
+func (s *FileTokenStore) Delete(mcpName, profile string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Load existing data
-	store := make(map[string]*MCPOAuthData)
+	removed, err := s.delete(storeKey(mcpName, profile))
+	s.emit(TokenStoreOpDelete, mcpName, profile, removed, err)
+	return err
+}
+
+func (s *FileTokenStore) delete(key string) (*MCPOAuthData, error) {
+	store, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	removed, ok := store[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(store, key)
+
+	return removed, s.writeAll(store)
+}
+
+// Prune removes the entries, for any profile, of MCP servers not in keep. It
+// returns the store keys of the removed entries ("name" or "name@profile"),
+// sorted. The file is only rewritten if something was removed.
+func (s *FileTokenStore) Prune(keep map[string]bool) (removed []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range store {
+		if mcpName, _ := splitStoreKey(key); !keep[mcpName] {
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	slices.Sort(removed)
+
+	pruned := make(map[string]*MCPOAuthData, len(store)-len(removed))
+	for key, data := range store {
+		if !slices.Contains(removed, key) {
+			pruned[key] = data
+		}
+	}
+	err = s.writeAll(pruned)
+	for _, key := range removed {
+		mcpName, profile := splitStoreKey(key)
+		s.emit(TokenStoreOpDelete, mcpName, profile, store[key], err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// List returns the entries in the store, sorted by MCP name and profile.
+func (s *FileTokenStore) List() ([]TokenStoreEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	store, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TokenStoreEntry, 0, len(store))
+	for key := range store {
+		mcpName, profile := splitStoreKey(key)
+		entries = append(entries, TokenStoreEntry{MCPName: mcpName, Profile: profile})
+	}
+	slices.SortFunc(entries, func(a, b TokenStoreEntry) int {
+		return cmp.Or(cmp.Compare(a.MCPName, b.MCPName), cmp.Compare(a.Profile, b.Profile))
+	})
+	return entries, nil
+}
+
+// pruneStore removes the entries of MCP servers not in keep from store,
+// using its own Prune method when it has one, like FileTokenStore. It
+// returns the store keys of the removed entries, sorted.
+func pruneStore(store TokenStore, keep map[string]bool) ([]string, error) {
+	if p, ok := store.(interface {
+		Prune(keep map[string]bool) ([]string, error)
+	}); ok {
+		return p.Prune(keep)
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	var errs []error
+	for _, e := range entries {
+		if keep[e.MCPName] {
+			continue
+		}
+		if err := store.Delete(e.MCPName, e.Profile); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, storeKey(e.MCPName, e.Profile))
+	}
+	return removed, errors.Join(errs...)
+}
+
+// ListClients returns the dynamically registered OAuth clients in the store,
+// sorted by MCP name and profile.
+func (s *FileTokenStore) ListClients() ([]RegisteredClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	store, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []RegisteredClient
+	for key, data := range store {
+		if data == nil || data.ClientID == "" {
+			continue
+		}
+		mcpName, profile := splitStoreKey(key)
+		clients = append(clients, RegisteredClient{
+			MCPName:               mcpName,
+			Profile:               profile,
+			ClientID:              data.ClientID,
+			RegistrationClientURI: data.RegistrationClientURI,
+		})
+	}
+	slices.SortFunc(clients, func(a, b RegisteredClient) int {
+		return cmp.Or(cmp.Compare(a.MCPName, b.MCPName), cmp.Compare(a.Profile, b.Profile))
+	})
+	return clients, nil
+}
+
+// splitStoreKey is the inverse of storeKey.
+func splitStoreKey(key string) (mcpName, profile string) {
+	mcpName, profile, _ = strings.Cut(key, "@")
+	return storeKeyUnescaper.Replace(mcpName), storeKeyUnescaper.Replace(profile)
+}
+
+// readAll reads and parses the whole store file, migrating an older format
+// in memory. A missing file yields an empty map.
+func (s *FileTokenStore) readAll() (map[string]*MCPOAuthData, error) {
+	store, _, err := s.read()
+	return store, err
+}
+
+// read is readAll that also reports whether the file is in an older format.
+func (s *FileTokenStore) read() (store map[string]*MCPOAuthData, migrated bool, err error) {
 	data, err := os.ReadFile(s.path)
-	if err == nil {
-		// File exists, parse it
-		if err = json.Unmarshal(data, &store); err != nil {
-			return fmt.Errorf("failed to parse existing MCP OAuth file: %w", err)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]*MCPOAuthData), false, nil
 		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to read MCP OAuth file: %w", err)
+		return nil, false, fmt.Errorf("failed to read MCP OAuth file: %w", err)
 	}
 
-	// Update the entry
-	store[mcpName] = oauthData
+	if store, migrated, err = decodeTokenStore(data); err != nil {
+		return nil, false, fmt.Errorf("failed to parse MCP OAuth file: %w", err)
+	}
+	return store, migrated, nil
+}
+
+// tokenStoreMigrations upgrade the token store document from version i to
+// version i+1, so older files are read without losing saved tokens.
+var tokenStoreMigrations = []func(doc map[string]json.RawMessage) (map[string]json.RawMessage, error){
+	migrateTokenStoreV0,
+}
+
+// migrateTokenStoreV0 wraps the legacy plain map of entries into the
+// versioned document, dropping null entries. Legacy keys are plain MCP
+// names, which are escaped like store keys.
+func migrateTokenStoreV0(doc map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	servers := make(map[string]json.RawMessage, len(doc))
+	for name, entry := range doc {
+		if string(entry) != "null" {
+			servers[storeKey(name, "")] = entry
+		}
+	}
+	serversJSON, err := json.Marshal(servers)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]json.RawMessage{
+		"version": json.RawMessage("1"),
+		"servers": serversJSON,
+	}, nil
+}
+
+// decodeTokenStore parses the contents of the token store file, migrating
+// older formats to the current one. It reports whether the file was
+// migrated. Entries are always objects, so a numeric "version" marks a
+// versioned document and anything else a legacy plain map of entries.
+func decodeTokenStore(data []byte) (map[string]*MCPOAuthData, bool, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+	var version int
+	if json.Unmarshal(doc["version"], &version) != nil {
+		version = 0
+	}
+	if version < 0 || version > tokenStoreVersion {
+		return nil, false, fmt.Errorf("unsupported version %d, expected at most %d", version, tokenStoreVersion)
+	}
+
+	for v := version; v < tokenStoreVersion; v++ {
+		var err error
+		if doc, err = tokenStoreMigrations[v](doc); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate from version %d: %w", v, err)
+		}
+	}
 
+	store := make(map[string]*MCPOAuthData)
+	if servers, ok := doc["servers"]; ok {
+		if err := json.Unmarshal(servers, &store); err != nil {
+			return nil, false, err
+		}
+	}
+	return store, version < tokenStoreVersion, nil
+}
+
+// writeAll writes the whole store file in the current format, creating its
+// directory if needed.
+func (s *FileTokenStore) writeAll(store map[string]*MCPOAuthData) error {
 	// Ensure directory exists
-	if err = os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
 		return fmt.Errorf("failed to create MCP OAuth directory: %w", err)
 	}
 
-	// Write back
-	newData, err := json.MarshalIndent(store, "", "  ")
+	if store == nil {
+		store = make(map[string]*MCPOAuthData)
+	}
+	newData, err := json.MarshalIndent(tokenStoreFile{Version: tokenStoreVersion, Servers: store}, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal MCP OAuth data: %w", err)
 	}
+	newData = append(newData, '\n')
 
-	if err = os.WriteFile(s.path, newData, 0o600); err != nil {
+	if err = writeFileAtomic(s.path, newData, 0o600); err != nil {
 		return fmt.Errorf("failed to write MCP OAuth file: %w", err)
 	}
 
+	s.migrateFailed = false
+	return nil
+}
+
+// writeTemp writes data to the temporary file of writeFileAtomic. It is a
+// variable so tests can simulate a failed write.
+var writeTemp = func(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	return err
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a crash or full disk never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // no-op once renamed
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeTemp(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// MemoryTokenStore is a TokenStore that keeps OAuth data in memory only, for
+// the current session. Like FileTokenStore, it stores and returns copies, so
+// callers may modify the data they pass or get back. The zero value is not
+// usable; use NewMemoryTokenStore.
+type MemoryTokenStore struct {
+	mu   sync.Mutex
+	data map[TokenStoreEntry]*MCPOAuthData
+}
+
+// NewMemoryTokenStore creates an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{data: make(map[TokenStoreEntry]*MCPOAuthData)}
+}
+
+// Load returns the data for an MCP server and profile, or nil if there is
+// none.
+func (s *MemoryTokenStore) Load(mcpName, profile string) (*MCPOAuthData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[TokenStoreEntry{mcpName, profile}].clone(), nil
+}
+
+// Save stores the data for an MCP server and profile.
+func (s *MemoryTokenStore) Save(mcpName, profile string, data *MCPOAuthData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[TokenStoreEntry{mcpName, profile}] = data.clone()
+	return nil
+}
+
+// Delete removes the data for an MCP server and profile, if any.
+func (s *MemoryTokenStore) Delete(mcpName, profile string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, TokenStoreEntry{mcpName, profile})
+	return nil
+}
+
+// List returns the entries in the store, sorted by MCP name and profile.
+func (s *MemoryTokenStore) List() ([]TokenStoreEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := slices.Collect(maps.Keys(s.data))
+	slices.SortFunc(entries, func(a, b TokenStoreEntry) int {
+		return cmp.Or(cmp.Compare(a.MCPName, b.MCPName), cmp.Compare(a.Profile, b.Profile))
+	})
+	return entries, nil
+}
+
+// copyTokenStore copies the entries of src into dst.
+func copyTokenStore(dst, src TokenStore) error {
+	entries, err := src.List()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		data, err := src.Load(e.MCPName, e.Profile)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		if err := dst.Save(e.MCPName, e.Profile, data); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -1,10 +1,20 @@
 package mcp
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/charmbracelet/crush/internal/config"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,7 +34,7 @@ func TestTokenStore_Load(t *testing.T) {
 		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
 		store := NewTokenStore()
 
-		loaded, err := store.Load("nonexistent")
+		loaded, err := store.Load("nonexistent", "")
 		require.NoError(t, err)
 		require.Nil(t, loaded)
 	})
@@ -35,11 +45,11 @@ func TestTokenStore_Load(t *testing.T) {
 		store := NewTokenStore()
 
 		// Save one entry
-		err := store.Save("other-mcp", &MCPOAuthData{AccessToken: "token"})
+		err := store.Save("other-mcp", "", &MCPOAuthData{AccessToken: "token"})
 		require.NoError(t, err)
 
 		// Load a different entry
-		loaded, err := store.Load("nonexistent")
+		loaded, err := store.Load("nonexistent", "")
 		require.NoError(t, err)
 		require.Nil(t, loaded)
 	})
@@ -54,7 +64,7 @@ func TestTokenStore_Load(t *testing.T) {
 		require.NoError(t, err)
 
 		store := NewTokenStore()
-		loaded, err := store.Load("test")
+		loaded, err := store.Load("test", "")
 		require.Error(t, err)
 		require.Nil(t, loaded)
 	})
@@ -71,10 +81,10 @@ func TestTokenStore_Load(t *testing.T) {
 			ClientID:     "client-id",
 			ClientSecret: "client-secret",
 		}
-		err := store.Save("test-mcp", data)
+		err := store.Save("test-mcp", "", data)
 		require.NoError(t, err)
 
-		loaded, err := store.Load("test-mcp")
+		loaded, err := store.Load("test-mcp", "")
 		require.NoError(t, err)
 		require.NotNil(t, loaded)
 		require.Equal(t, data.AccessToken, loaded.AccessToken)
@@ -92,7 +102,7 @@ func TestTokenStore_Save(t *testing.T) {
 		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
 		store := NewTokenStore()
 
-		err := store.Save("test-mcp", &MCPOAuthData{AccessToken: "token"})
+		err := store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "token"})
 		require.NoError(t, err)
 
 		mcpFile := filepath.Join(tempDir, "mcp.json")
@@ -106,7 +116,7 @@ func TestTokenStore_Save(t *testing.T) {
 		t.Setenv("CRUSH_GLOBAL_DATA", nestedDir)
 		store := NewTokenStore()
 
-		err := store.Save("test-mcp", &MCPOAuthData{AccessToken: "token"})
+		err := store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "token"})
 		require.NoError(t, err)
 
 		mcpFile := filepath.Join(nestedDir, "mcp.json")
@@ -119,7 +129,7 @@ func TestTokenStore_Save(t *testing.T) {
 		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
 		store := NewTokenStore()
 
-		err := store.Save("test-mcp", &MCPOAuthData{AccessToken: "token"})
+		err := store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "token"})
 		require.NoError(t, err)
 
 		mcpFile := filepath.Join(tempDir, "mcp.json")
@@ -133,20 +143,20 @@ func TestTokenStore_Save(t *testing.T) {
 		store := NewTokenStore()
 
 		// Save first entry
-		err := store.Save("mcp-1", &MCPOAuthData{AccessToken: "token-1"})
+		err := store.Save("mcp-1", "", &MCPOAuthData{AccessToken: "token-1"})
 		require.NoError(t, err)
 
 		// Save second entry
-		err = store.Save("mcp-2", &MCPOAuthData{AccessToken: "token-2"})
+		err = store.Save("mcp-2", "", &MCPOAuthData{AccessToken: "token-2"})
 		require.NoError(t, err)
 
 		// Verify first entry still exists
-		loaded, err := store.Load("mcp-1")
+		loaded, err := store.Load("mcp-1", "")
 		require.NoError(t, err)
 		require.Equal(t, "token-1", loaded.AccessToken)
 
 		// Verify second entry exists
-		loaded, err = store.Load("mcp-2")
+		loaded, err = store.Load("mcp-2", "")
 		require.NoError(t, err)
 		require.Equal(t, "token-2", loaded.AccessToken)
 	})
@@ -156,7 +166,7 @@ func TestTokenStore_Save(t *testing.T) {
 		store := NewTokenStore()
 
 		// Save initial data
-		err := store.Save("test-mcp", &MCPOAuthData{
+		err := store.Save("test-mcp", "", &MCPOAuthData{
 			AccessToken:  "old-token",
 			RefreshToken: "old-refresh",
 			ClientID:     "client-id",
@@ -164,7 +174,7 @@ func TestTokenStore_Save(t *testing.T) {
 		require.NoError(t, err)
 
 		// Update with new token data
-		err = store.Save("test-mcp", &MCPOAuthData{
+		err = store.Save("test-mcp", "", &MCPOAuthData{
 			AccessToken:  "new-token",
 			RefreshToken: "new-refresh",
 			ClientID:     "client-id",
@@ -172,7 +182,7 @@ func TestTokenStore_Save(t *testing.T) {
 		require.NoError(t, err)
 
 		// Verify the update
-		loaded, err := store.Load("test-mcp")
+		loaded, err := store.Load("test-mcp", "")
 		require.NoError(t, err)
 		require.Equal(t, "new-token", loaded.AccessToken)
 		require.Equal(t, "new-refresh", loaded.RefreshToken)
@@ -189,7 +199,645 @@ func TestTokenStore_Save(t *testing.T) {
 		require.NoError(t, err)
 
 		store := NewTokenStore()
-		err = store.Save("test-mcp", &MCPOAuthData{AccessToken: "token"})
+		err = store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "token"})
 		require.Error(t, err)
 	})
+
+	t.Run("keeps the old file when a write fails midway", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+		store := NewTokenStore()
+		require.NoError(t, store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "old"}))
+
+		prev := writeTemp
+		t.Cleanup(func() { writeTemp = prev })
+		writeTemp = func(f *os.File, data []byte) error {
+			_, _ = f.Write(data[:len(data)/2])
+			return errors.New("no space left on device")
+		}
+
+		err := store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "new"})
+		require.ErrorContains(t, err, "no space left on device")
+
+		loaded, err := store.Load("test-mcp", "")
+		require.NoError(t, err)
+		require.Equal(t, "old", loaded.AccessToken)
+
+		entries, err := os.ReadDir(tempDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "temporary file must be removed")
+		info, err := os.Stat(filepath.Join(tempDir, "mcp.json"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+}
+
+func TestTokenStore_Format(t *testing.T) {
+	t.Run("writes a versioned deterministic document", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+		store := NewTokenStore()
+
+		require.NoError(t, store.Save("beta", "", &MCPOAuthData{AccessToken: "b"}))
+		require.NoError(t, store.Save("alpha", "work", &MCPOAuthData{ClientID: "id", AccessToken: "a"}))
+		first, err := os.ReadFile(filepath.Join(tempDir, "mcp.json"))
+		require.NoError(t, err)
+		require.Equal(t, `{
+  "version": 1,
+  "servers": {
+    "alpha@work": {
+      "access_token": "a",
+      "client_id": "id"
+    },
+    "beta": {
+      "access_token": "b"
+    }
+  }
+}
+`, string(first))
+
+		// Rewriting the same data gives the same bytes.
+		require.NoError(t, store.Save("beta", "", &MCPOAuthData{AccessToken: "b"}))
+		second, err := os.ReadFile(filepath.Join(tempDir, "mcp.json"))
+		require.NoError(t, err)
+		require.Equal(t, first, second)
+	})
+
+	t.Run("reads and upgrades a legacy file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+		mcpFile := filepath.Join(tempDir, "mcp.json")
+		// An MCP server may itself be named "version".
+		legacy := `{"version":{"access_token":"v"},"test-mcp":{"access_token":"legacy"}}`
+		require.NoError(t, os.WriteFile(mcpFile, []byte(legacy), 0o600))
+		store := NewTokenStore()
+
+		loaded, err := store.Load("version", "")
+		require.NoError(t, err)
+		require.Equal(t, "v", loaded.AccessToken)
+
+		require.NoError(t, store.Save("other", "", &MCPOAuthData{AccessToken: "o"}))
+		data, err := os.ReadFile(mcpFile)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"version": 1`)
+		loaded, err = store.Load("test-mcp", "")
+		require.NoError(t, err)
+		require.Equal(t, "legacy", loaded.AccessToken)
+	})
+
+	t.Run("migrates a version 0 fixture", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+		fixture, err := os.ReadFile(filepath.Join("testdata", "mcp_v0.json"))
+		require.NoError(t, err)
+		mcpFile := filepath.Join(tempDir, "mcp.json")
+		require.NoError(t, os.WriteFile(mcpFile, fixture, 0o600))
+		store := NewTokenStore()
+
+		loaded, err := store.Load("github", "")
+		require.NoError(t, err)
+		require.Equal(t, &MCPOAuthData{
+			AccessToken:  "gh-access",
+			RefreshToken: "gh-refresh",
+			ExpiresIn:    3600,
+			ExpiresAt:    1767225600,
+			ClientID:     "gh-client",
+			ClientSecret: "gh-secret",
+		}, loaded)
+
+		// Loading wrote the file back in the current format.
+		migrated, err := os.ReadFile(mcpFile)
+		require.NoError(t, err)
+		var doc tokenStoreFile
+		require.NoError(t, json.Unmarshal(migrated, &doc))
+		require.Equal(t, tokenStoreVersion, doc.Version)
+		require.Equal(t, []string{"github", "linear%40work"}, slices.Sorted(maps.Keys(doc.Servers)))
+
+		// Version 0 predates profiles, so its keys are plain MCP names.
+		entries, err := store.List()
+		require.NoError(t, err)
+		require.Equal(t, []TokenStoreEntry{{MCPName: "github"}, {MCPName: "linear@work"}}, entries)
+	})
+
+	t.Run("tries a failed migration once", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+		fixture, err := os.ReadFile(filepath.Join("testdata", "mcp_v0.json"))
+		require.NoError(t, err)
+		mcpFile := filepath.Join(tempDir, "mcp.json")
+		require.NoError(t, os.WriteFile(mcpFile, fixture, 0o600))
+		store := NewTokenStore()
+
+		var writes int
+		prev := writeTemp
+		writeTemp = func(*os.File, []byte) error {
+			writes++
+			return errors.New("read-only")
+		}
+		t.Cleanup(func() { writeTemp = prev })
+
+		for range 3 {
+			loaded, err := store.Load("github", "")
+			require.NoError(t, err)
+			require.Equal(t, "gh-access", loaded.AccessToken)
+		}
+		require.Equal(t, 1, writes)
+		data, err := os.ReadFile(mcpFile)
+		require.NoError(t, err)
+		require.Equal(t, fixture, data)
+	})
+
+	t.Run("rejects a newer version", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+		mcpFile := filepath.Join(tempDir, "mcp.json")
+		require.NoError(t, os.WriteFile(mcpFile, []byte(`{"version":2,"servers":{}}`), 0o600))
+		store := NewTokenStore()
+
+		_, err := store.Load("test-mcp", "")
+		require.ErrorContains(t, err, "unsupported version 2")
+		require.Error(t, store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "token"}))
+	})
+}
+
+func TestTokenStore_Delete(t *testing.T) {
+	t.Run("removes entry and preserves others", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		require.NoError(t, store.Save("mcp-1", "", &MCPOAuthData{AccessToken: "token-1"}))
+		require.NoError(t, store.Save("mcp-2", "", &MCPOAuthData{AccessToken: "token-2"}))
+
+		require.NoError(t, store.Delete("mcp-1", ""))
+
+		loaded, err := store.Load("mcp-1", "")
+		require.NoError(t, err)
+		require.Nil(t, loaded)
+
+		loaded, err = store.Load("mcp-2", "")
+		require.NoError(t, err)
+		require.Equal(t, "token-2", loaded.AccessToken)
+	})
+
+	t.Run("missing entry is not an error", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		require.NoError(t, store.Delete("nonexistent", ""))
+	})
+}
+
+func TestTokenStore_Prune(t *testing.T) {
+	t.Run("removes entries of unlisted servers", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		require.NoError(t, store.Save("github", "", &MCPOAuthData{AccessToken: "gh"}))
+		require.NoError(t, store.Save("github", "work", &MCPOAuthData{AccessToken: "gh-work"}))
+		require.NoError(t, store.Save("linear", "", &MCPOAuthData{AccessToken: "linear"}))
+		require.NoError(t, store.Save("sentry", "personal", &MCPOAuthData{AccessToken: "sentry"}))
+
+		var deleted []string
+		store.SetEventHandler(func(e TokenStoreEvent) {
+			if e.Op == TokenStoreOpDelete {
+				deleted = append(deleted, storeKey(e.MCPName, e.Profile))
+			}
+		})
+
+		removed, err := store.Prune(map[string]bool{"github": true})
+		require.NoError(t, err)
+		require.Equal(t, []string{"linear", "sentry@personal"}, removed)
+		require.Equal(t, removed, deleted)
+
+		for _, profile := range []string{"", "work"} {
+			loaded, err := store.Load("github", profile)
+			require.NoError(t, err)
+			require.NotNil(t, loaded)
+		}
+		loaded, err := store.Load("linear", "")
+		require.NoError(t, err)
+		require.Nil(t, loaded)
+	})
+
+	t.Run("nothing to remove", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		removed, err := store.Prune(map[string]bool{"github": true})
+		require.NoError(t, err)
+		require.Empty(t, removed)
+		_, err = os.Stat(store.path)
+		require.True(t, os.IsNotExist(err), "store file should not be created")
+	})
+}
+
+func TestTokenStore_Events(t *testing.T) {
+	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+	store := NewTokenStore()
+
+	var events []TokenStoreEvent
+	store.SetEventHandler(func(e TokenStoreEvent) {
+		events = append(events, e)
+	})
+
+	require.NoError(t, store.Save("test-mcp", "", &MCPOAuthData{
+		AccessToken:  "secret-access",
+		RefreshToken: "secret-refresh",
+	}))
+	_, err := store.Load("test-mcp", "")
+	require.NoError(t, err)
+	require.NoError(t, store.Delete("test-mcp", ""))
+
+	require.Equal(t, []TokenStoreEvent{
+		{Op: TokenStoreOpSave, MCPName: "test-mcp", Found: true, HasAccessToken: true, HasRefreshToken: true},
+		{Op: TokenStoreOpLoad, MCPName: "test-mcp", Found: true, HasAccessToken: true, HasRefreshToken: true},
+		{Op: TokenStoreOpDelete, MCPName: "test-mcp", Found: true, HasAccessToken: true, HasRefreshToken: true},
+	}, events)
+
+	store.SetEventHandler(nil)
+	require.NoError(t, store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "token"}))
+	require.Len(t, events, 3)
+}
+
+func TestTokenStore_Profiles(t *testing.T) {
+	t.Run("profiles are stored independently", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		require.NoError(t, store.Save("test-mcp", "", &MCPOAuthData{AccessToken: "default-token"}))
+		require.NoError(t, store.Save("test-mcp", "work", &MCPOAuthData{AccessToken: "work-token"}))
+		require.NoError(t, store.Save("test-mcp", "personal", &MCPOAuthData{AccessToken: "personal-token"}))
+
+		for profile, want := range map[string]string{
+			"":         "default-token",
+			"work":     "work-token",
+			"personal": "personal-token",
+		} {
+			loaded, err := store.Load("test-mcp", profile)
+			require.NoError(t, err)
+			require.Equal(t, want, loaded.AccessToken, "profile %q", profile)
+		}
+
+		require.NoError(t, store.Delete("test-mcp", "work"))
+		loaded, err := store.Load("test-mcp", "work")
+		require.NoError(t, err)
+		require.Nil(t, loaded)
+
+		loaded, err = store.Load("test-mcp", "personal")
+		require.NoError(t, err)
+		require.Equal(t, "personal-token", loaded.AccessToken)
+	})
+
+	t.Run("empty profile uses plain MCP name key", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("CRUSH_GLOBAL_DATA", tempDir)
+
+		err := os.WriteFile(filepath.Join(tempDir, "mcp.json"), []byte(`{"test-mcp":{"access_token":"legacy"}}`), 0o600)
+		require.NoError(t, err)
+
+		store := NewTokenStore()
+		loaded, err := store.Load("test-mcp", "")
+		require.NoError(t, err)
+		require.Equal(t, "legacy", loaded.AccessToken)
+	})
+
+	t.Run("escapes '@' in names and profiles", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		entries := []TokenStoreEntry{
+			{MCPName: "a"},
+			{MCPName: "a", Profile: "b@c"},
+			{MCPName: "a%40b"},
+			{MCPName: "a@b"},
+			{MCPName: "a@b", Profile: "c%d"},
+		}
+		for _, entry := range entries {
+			data := &MCPOAuthData{AccessToken: entry.MCPName + "|" + entry.Profile}
+			require.NoError(t, store.Save(entry.MCPName, entry.Profile, data))
+		}
+		for _, entry := range entries {
+			loaded, err := store.Load(entry.MCPName, entry.Profile)
+			require.NoError(t, err)
+			require.Equal(t, entry.MCPName+"|"+entry.Profile, loaded.AccessToken)
+		}
+
+		listed, err := store.List()
+		require.NoError(t, err)
+		require.ElementsMatch(t, entries, listed)
+
+		require.NoError(t, store.Delete("a@b", ""))
+		loaded, err := store.Load("a@b", "")
+		require.NoError(t, err)
+		require.Nil(t, loaded)
+		loaded, err = store.Load("a@b", "c%d")
+		require.NoError(t, err)
+		require.NotNil(t, loaded)
+	})
+}
+
+func TestTokenStore_ListClients(t *testing.T) {
+	t.Run("empty store", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		clients, err := store.ListClients()
+		require.NoError(t, err)
+		require.Empty(t, clients)
+	})
+
+	t.Run("lists registered clients with their URIs", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		store := NewTokenStore()
+
+		require.NoError(t, store.Save("github", "", &MCPOAuthData{
+			ClientID:              "gh-client",
+			RegistrationClientURI: "https://github.example/register/gh-client",
+		}))
+		require.NoError(t, store.Save("github", "work", &MCPOAuthData{
+			ClientID: "gh-work-client",
+		}))
+		require.NoError(t, store.Save("atlassian", "", &MCPOAuthData{
+			ClientID:              "atl-client",
+			RegistrationClientURI: "https://atlassian.example/register/atl-client",
+		}))
+		// Entries without a client_id were not dynamically registered.
+		require.NoError(t, store.Save("static", "", &MCPOAuthData{AccessToken: "token"}))
+
+		clients, err := store.ListClients()
+		require.NoError(t, err)
+		require.Equal(t, []RegisteredClient{
+			{MCPName: "atlassian", ClientID: "atl-client", RegistrationClientURI: "https://atlassian.example/register/atl-client"},
+			{MCPName: "github", ClientID: "gh-client", RegistrationClientURI: "https://github.example/register/gh-client"},
+			{MCPName: "github", Profile: "work", ClientID: "gh-work-client"},
+		}, clients)
+	})
+}
+
+func TestUnregisterClient(t *testing.T) {
+	t.Run("deletes remote registration and stored data", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+		var deleted atomic.Bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodDelete, r.Method)
+			require.Equal(t, "Bearer reg-token", r.Header.Get("Authorization"))
+			deleted.Store(true)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		store := NewTokenStore()
+		require.NoError(t, store.Save("github", "work", &MCPOAuthData{
+			ClientID:                "client-123",
+			RegistrationAccessToken: "reg-token",
+			RegistrationClientURI:   server.URL + "/register/client-123",
+		}))
+
+		require.NoError(t, UnregisterClient(context.Background(), "github", config.MCPConfig{Profile: "work"}))
+		require.True(t, deleted.Load())
+
+		loaded, err := store.Load("github", "work")
+		require.NoError(t, err)
+		require.Nil(t, loaded)
+	})
+
+	t.Run("removes stored data when the server fails", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		store := NewTokenStore()
+		require.NoError(t, store.Save("github", "", &MCPOAuthData{
+			ClientID:                "client-123",
+			RegistrationAccessToken: "reg-token",
+			RegistrationClientURI:   server.URL,
+		}))
+
+		require.Error(t, UnregisterClient(context.Background(), "github", config.MCPConfig{}))
+
+		loaded, err := store.Load("github", "")
+		require.NoError(t, err)
+		require.Nil(t, loaded)
+	})
+
+	t.Run("uses the server's OAuth timeout", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer server.Close()
+
+		store := NewTokenStore()
+		require.NoError(t, store.Save("github", "", &MCPOAuthData{
+			ClientID:                "client-123",
+			RegistrationAccessToken: "reg-token",
+			RegistrationClientURI:   server.URL,
+		}))
+
+		start := time.Now()
+		m := config.MCPConfig{OAuth: &config.MCPOAuthConfig{Timeout: 1}}
+		require.ErrorContains(t, UnregisterClient(context.Background(), "github", m), "Client.Timeout")
+		require.Less(t, time.Since(start), 4*time.Second)
+	})
+
+	t.Run("no stored data", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+		require.NoError(t, UnregisterClient(context.Background(), "missing", config.MCPConfig{}))
+	})
+
+	t.Run("drops the in-memory token provider", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		t.Cleanup(func() { tokenProviders.Del("github") })
+
+		provider, err := NewOAuthTokenProvider("github", "", validConfig(), NewTokenStore())
+		require.NoError(t, err)
+		registerTokenProvider("github", provider)
+
+		require.NoError(t, UnregisterClient(context.Background(), "github", config.MCPConfig{}))
+		_, ok := tokenProviders.Get("github")
+		require.False(t, ok)
+	})
+}
+
+func TestRemoveConfigField(t *testing.T) {
+	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+
+	var deleted atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		deleted.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cfg, err := config.Init(t.TempDir(), "", false)
+	require.NoError(t, err)
+	require.NoError(t, cfg.SetConfigField(config.ScopeGlobal, "mcp.github", map[string]any{
+		"type":     "http",
+		"url":      server.URL,
+		"disabled": true,
+	}))
+	require.Contains(t, cfg.Config().MCP, "github")
+	t.Cleanup(func() { states.Del("github") })
+
+	store := NewTokenStore()
+	require.NoError(t, store.Save("github", "", &MCPOAuthData{
+		ClientID:                "client-123",
+		RegistrationAccessToken: "reg-token",
+		RegistrationClientURI:   server.URL + "/register/client-123",
+	}))
+
+	// Removing a field of the server keeps its registration.
+	require.NoError(t, RemoveConfigField(context.Background(), cfg, config.ScopeGlobal, "mcp.github.disabled"))
+	require.False(t, deleted.Load())
+
+	require.NoError(t, RemoveConfigField(context.Background(), cfg, config.ScopeGlobal, "mcp.github"))
+	require.NotContains(t, cfg.Config().MCP, "github")
+	require.True(t, deleted.Load(), "removing a server deletes its client registration")
+	loaded, err := store.Load("github", "")
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+}
+
+func TestTokenStore_List(t *testing.T) {
+	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+	store := NewTokenStore()
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, store.Save("github", "work", &MCPOAuthData{AccessToken: "a"}))
+	require.NoError(t, store.Save("github", "", &MCPOAuthData{AccessToken: "b"}))
+	require.NoError(t, store.Save("atlassian", "", &MCPOAuthData{ClientID: "c"}))
+
+	entries, err = store.List()
+	require.NoError(t, err)
+	require.Equal(t, []TokenStoreEntry{
+		{MCPName: "atlassian"},
+		{MCPName: "github"},
+		{MCPName: "github", Profile: "work"},
+	}, entries)
+}
+
+func TestPruneStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryTokenStore()
+	require.NoError(t, store.Save("kept", "", &MCPOAuthData{AccessToken: "a"}))
+	require.NoError(t, store.Save("gone", "", &MCPOAuthData{AccessToken: "b"}))
+	require.NoError(t, store.Save("gone", "work", &MCPOAuthData{AccessToken: "c"}))
+
+	removed, err := pruneStore(store, map[string]bool{"kept": true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"gone", "gone@work"}, removed)
+
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Equal(t, []TokenStoreEntry{{MCPName: "kept"}}, entries)
+}
+
+func TestFileTokenStore_CheckWritable(t *testing.T) {
+	t.Run("writable", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "data")
+		t.Setenv("CRUSH_GLOBAL_DATA", dir)
+		require.NoError(t, NewTokenStore().CheckWritable())
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("not writable", func(t *testing.T) {
+		// A file in place of a parent directory can't be written even by
+		// root, unlike a read-only directory.
+		blocker := filepath.Join(t.TempDir(), "blocker")
+		require.NoError(t, os.WriteFile(blocker, nil, 0o600))
+		t.Setenv("CRUSH_GLOBAL_DATA", filepath.Join(blocker, "data"))
+		require.ErrorContains(t, NewTokenStore().CheckWritable(), "not writable")
+	})
+}
+
+func TestDefaultTokenStore(t *testing.T) {
+	t.Run("file store when writable", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		require.IsType(t, &FileTokenStore{}, defaultTokenStore())
+	})
+
+	t.Run("memory store when not writable", func(t *testing.T) {
+		blocker := filepath.Join(t.TempDir(), "blocker")
+		require.NoError(t, os.WriteFile(blocker, nil, 0o600))
+		t.Setenv("CRUSH_GLOBAL_DATA", filepath.Join(blocker, "data"))
+
+		store := defaultTokenStore()
+		require.IsType(t, &MemoryTokenStore{}, store)
+		require.NoError(t, store.Save("test", "", &MCPOAuthData{AccessToken: "a"}))
+		data, err := store.Load("test", "")
+		require.NoError(t, err)
+		require.Equal(t, "a", data.AccessToken)
+	})
+
+	t.Run("memory store is seeded with the file's data", func(t *testing.T) {
+		t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+		file := NewTokenStore()
+		require.NoError(t, file.Save("seeded", "work", &MCPOAuthData{AccessToken: "stored", GrantedScopes: []string{"read"}}))
+
+		store := fallbackTokenStore(file, errors.New("read-only"))
+		require.IsType(t, &MemoryTokenStore{}, store)
+		data, err := store.Load("seeded", "work")
+		require.NoError(t, err)
+		require.Equal(t, &MCPOAuthData{AccessToken: "stored", GrantedScopes: []string{"read"}}, data)
+
+		// Saves stay in memory.
+		require.NoError(t, store.Save("seeded", "work", &MCPOAuthData{AccessToken: "new"}))
+		data, err = file.Load("seeded", "work")
+		require.NoError(t, err)
+		require.Equal(t, "stored", data.AccessToken)
+	})
+}
+
+func TestMemoryTokenStore_Copies(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryTokenStore()
+	saved := &MCPOAuthData{AccessToken: "a", GrantedScopes: []string{"read"}}
+	require.NoError(t, store.Save("test", "", saved))
+	saved.AccessToken = "changed"
+	saved.GrantedScopes[0] = "changed"
+
+	loaded, err := store.Load("test", "")
+	require.NoError(t, err)
+	require.Equal(t, &MCPOAuthData{AccessToken: "a", GrantedScopes: []string{"read"}}, loaded)
+	loaded.AccessToken = "changed"
+	loaded.GrantedScopes[0] = "changed"
+
+	again, err := store.Load("test", "")
+	require.NoError(t, err)
+	require.Equal(t, &MCPOAuthData{AccessToken: "a", GrantedScopes: []string{"read"}}, again)
+}
+
+func TestCopyTokenStore(t *testing.T) {
+	t.Setenv("CRUSH_GLOBAL_DATA", t.TempDir())
+	src := NewTokenStore()
+	require.NoError(t, src.Save("a", "", &MCPOAuthData{AccessToken: "1"}))
+	require.NoError(t, src.Save("a", "work", &MCPOAuthData{ClientID: "c"}))
+
+	dst := NewMemoryTokenStore()
+	require.NoError(t, copyTokenStore(dst, src))
+
+	entries, err := dst.List()
+	require.NoError(t, err)
+	require.Equal(t, []TokenStoreEntry{{MCPName: "a"}, {MCPName: "a", Profile: "work"}}, entries)
+	data, err := dst.Load("a", "work")
+	require.NoError(t, err)
+	require.Equal(t, "c", data.ClientID)
 }
@@ -0,0 +1,113 @@
+package mcp
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/csync"
+)
+
+var (
+	// serverTools holds the tools of each server before the global tool
+	// limit is applied; allTools holds the tools exposed to the model.
+	serverTools = csync.NewMap[string, []*Tool]()
+	// toolCapMu serializes recomputing allTools from serverTools.
+	toolCapMu sync.Mutex
+	// droppedTools holds the tools dropped per server by the last
+	// recomputation, so a warning is only logged when they change. Guarded
+	// by toolCapMu.
+	droppedTools = map[string][]string{}
+)
+
+// setServerTools records the tools of a server and applies the global tool
+// limit across all servers. It returns the number of tools of the server
+// that are exposed.
+func setServerTools(cfg *config.ConfigStore, name string, tools []*Tool) int {
+	toolCapMu.Lock()
+	defer toolCapMu.Unlock()
+
+	if len(tools) == 0 {
+		serverTools.Del(name)
+	} else {
+		serverTools.Set(name, tools)
+	}
+
+	limit := 0
+	if opts := cfg.Config().Options; opts != nil {
+		limit = opts.MCPMaxTools
+	}
+	priority := func(server string) int {
+		return cfg.Config().MCP[server].Priority
+	}
+	exposed, dropped := capTools(serverTools.Copy(), priority, limit)
+
+	for server, names := range dropped {
+		if !slices.Equal(droppedTools[server], names) {
+			slog.Warn("MCP tool limit reached, dropping tools", "name", server, "limit", limit, "tools", names)
+		}
+	}
+	droppedTools = dropped
+	for server := range allTools.Seq2() {
+		if _, ok := exposed[server]; !ok {
+			allTools.Del(server)
+			updateToolCount(server, name, 0)
+		}
+	}
+	for server, tools := range exposed {
+		prev, _ := allTools.Get(server)
+		allTools.Set(server, tools)
+		if len(prev) != len(tools) {
+			updateToolCount(server, name, len(tools))
+		}
+	}
+	return len(exposed[name])
+}
+
+// updateToolCount updates the tool count of a connected server other than
+// the one being updated, whose caller reports its own state.
+func updateToolCount(server, updating string, count int) {
+	if server == updating {
+		return
+	}
+	info, ok := states.Get(server)
+	if !ok || info.State != StateConnected {
+		return
+	}
+	info.Counts.Tools = count
+	updateState(server, info.State, info.Error, info.Client, info.Counts)
+}
+
+// capTools keeps at most limit tools across servers, 0 meaning no limit.
+// Servers with a higher priority keep their tools first, ties are broken by
+// server name, and each server's tools keep their order. It returns the
+// kept tools and the names of the dropped ones per server.
+func capTools(servers map[string][]*Tool, priority func(string) int, limit int) (map[string][]*Tool, map[string][]string) {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(cmp.Compare(priority(b), priority(a)), cmp.Compare(a, b))
+	})
+
+	exposed := make(map[string][]*Tool, len(servers))
+	dropped := make(map[string][]string)
+	remaining := limit
+	for _, name := range names {
+		tools := servers[name]
+		if limit > 0 && len(tools) > remaining {
+			for _, tool := range tools[remaining:] {
+				dropped[name] = append(dropped[name], tool.Name)
+			}
+			tools = tools[:remaining]
+		}
+		remaining -= len(tools)
+		if len(tools) > 0 {
+			exposed[name] = tools
+		}
+	}
+	return exposed, dropped
+}
@@ -0,0 +1,143 @@
+package mcp
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapTools(t *testing.T) {
+	t.Parallel()
+
+	toolsNamed := func(names ...string) []*Tool {
+		tools := make([]*Tool, len(names))
+		for i, name := range names {
+			tools[i] = &Tool{Name: name}
+		}
+		return tools
+	}
+	servers := map[string][]*Tool{
+		"alpha": toolsNamed("a1", "a2"),
+		"beta":  toolsNamed("b1", "b2", "b3"),
+		"gamma": toolsNamed("g1"),
+	}
+	priorities := map[string]int{"gamma": 10}
+	priority := func(name string) int { return priorities[name] }
+
+	t.Run("no limit", func(t *testing.T) {
+		t.Parallel()
+		exposed, dropped := capTools(servers, priority, 0)
+		require.Equal(t, servers, exposed)
+		require.Empty(t, dropped)
+	})
+
+	t.Run("priority then name", func(t *testing.T) {
+		t.Parallel()
+		exposed, dropped := capTools(servers, priority, 4)
+		require.Equal(t, map[string][]*Tool{
+			"gamma": servers["gamma"],
+			"alpha": servers["alpha"],
+			"beta":  servers["beta"][:1],
+		}, exposed)
+		require.Equal(t, map[string][]string{"beta": {"b2", "b3"}}, dropped)
+	})
+
+	t.Run("servers past the limit are dropped entirely", func(t *testing.T) {
+		t.Parallel()
+		exposed, dropped := capTools(servers, priority, 1)
+		require.Equal(t, map[string][]*Tool{"gamma": servers["gamma"]}, exposed)
+		require.Equal(t, map[string][]string{
+			"alpha": {"a1", "a2"},
+			"beta":  {"b1", "b2", "b3"},
+		}, dropped)
+	})
+}
+
+func TestSetServerTools(t *testing.T) {
+	// Uses the package-wide tool maps, so not parallel.
+	const low, high = "cap-low", "cap-high"
+	t.Cleanup(func() {
+		for _, name := range []string{low, high} {
+			serverTools.Del(name)
+			allTools.Del(name)
+			states.Del(name)
+		}
+	})
+
+	cfg := config.NewTestStore(&config.Config{
+		Options: &config.Options{MCPMaxTools: 3},
+		MCP: map[string]config.MCPConfig{
+			low:  {},
+			high: {Priority: 1},
+		},
+	})
+	updateState(low, StateConnected, nil, nil, Counts{})
+
+	require.Equal(t, 3, setServerTools(cfg, low, []*Tool{{Name: "l1"}, {Name: "l2"}, {Name: "l3"}}))
+	updateState(low, StateConnected, nil, nil, Counts{Tools: 3})
+
+	// The higher priority server takes over two of the three slots.
+	require.Equal(t, 2, setServerTools(cfg, high, []*Tool{{Name: "h1"}, {Name: "h2"}}))
+	tools, _ := allTools.Get(low)
+	require.Len(t, tools, 1)
+	require.Equal(t, 1, mustState(t, low).Counts.Tools)
+
+	// Removing it gives them back.
+	require.Equal(t, 0, setServerTools(cfg, high, nil))
+	_, ok := allTools.Get(high)
+	require.False(t, ok)
+	tools, _ = allTools.Get(low)
+	require.Len(t, tools, 3)
+	require.Equal(t, 3, mustState(t, low).Counts.Tools)
+}
+
+func TestSetServerTools_WarnsWhenDroppedToolsChange(t *testing.T) {
+	// Uses the package-wide tool maps and logger, so not parallel.
+	const low, high = "warn-low", "warn-high"
+	t.Cleanup(func() {
+		for _, name := range []string{low, high} {
+			serverTools.Del(name)
+			allTools.Del(name)
+		}
+		toolCapMu.Lock()
+		droppedTools = map[string][]string{}
+		toolCapMu.Unlock()
+	})
+
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	warnings := func() int {
+		return strings.Count(logs.String(), "MCP tool limit reached")
+	}
+
+	cfg := config.NewTestStore(&config.Config{
+		Options: &config.Options{MCPMaxTools: 2},
+		MCP: map[string]config.MCPConfig{
+			low:  {},
+			high: {Priority: 1},
+		},
+	})
+
+	setServerTools(cfg, high, []*Tool{{Name: "h1"}, {Name: "h2"}})
+	setServerTools(cfg, low, []*Tool{{Name: "l1"}})
+	require.Equal(t, 1, warnings())
+
+	// Refreshing either server without changing what is dropped stays quiet.
+	setServerTools(cfg, low, []*Tool{{Name: "l1"}})
+	setServerTools(cfg, high, []*Tool{{Name: "h1"}, {Name: "h2"}})
+	require.Equal(t, 1, warnings())
+
+	setServerTools(cfg, low, []*Tool{{Name: "l1"}, {Name: "l2"}})
+	require.Equal(t, 2, warnings())
+
+	// Once nothing is dropped, dropping the same tools again warns again.
+	setServerTools(cfg, high, nil)
+	setServerTools(cfg, high, []*Tool{{Name: "h1"}, {Name: "h2"}})
+	require.Equal(t, 3, warnings())
+}
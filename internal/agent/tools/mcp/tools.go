@@ -32,6 +32,28 @@ func Tools() iter.Seq2[string, []*Tool] {
 	return allTools.Seq2()
 }
 
+// RequiresPermission reports whether calling tool of a server configured as
+// m must be approved by the user. With MCPPermissionSensitive, only the
+// configured sensitive tools and tools not annotated as read-only or
+// non-destructive do.
+func RequiresPermission(m config.MCPConfig, tool *Tool) bool {
+	if m.PermissionMode != config.MCPPermissionSensitive {
+		return true
+	}
+	if slices.Contains(m.SensitiveTools, tool.Name) {
+		return true
+	}
+	a := tool.Annotations
+	if a == nil {
+		return true
+	}
+	if a.ReadOnlyHint {
+		return false
+	}
+	// Tools are destructive unless annotated otherwise.
+	return a.DestructiveHint == nil || *a.DestructiveHint
+}
+
 // RunTool runs an MCP tool with the given input parameters.
 func RunTool(ctx context.Context, cfg *config.ConfigStore, name, toolName string, input string) (ToolResult, error) {
 	var args map[string]any
@@ -39,14 +61,25 @@ func RunTool(ctx context.Context, cfg *config.ConfigStore, name, toolName string
 		return ToolResult{}, fmt.Errorf("error parsing parameters: %s", err)
 	}
 
+	if err := checkReadOnly(cfg, name, toolName); err != nil {
+		return ToolResult{}, err
+	}
+
+	ctx, sc := withCallTrace(ctx)
+	slog.Debug("Calling MCP tool", "name", name, "tool", toolName, "trace_id", sc.TraceID().String())
+
+	release, err := limiterFor(name, cfg.Config().MCP[name].MaxConcurrentCalls).acquire(ctx)
+	if err != nil {
+		return ToolResult{}, err
+	}
+	defer release()
+	defer trackSamplingSession(ctx, name)()
+
 	c, err := getOrRenewClient(ctx, cfg, name)
 	if err != nil {
 		return ToolResult{}, err
 	}
-	result, err := c.CallTool(ctx, &mcp.CallToolParams{
-		Name:      toolName,
-		Arguments: args,
-	})
+	result, err := c.CallTool(ctx, callToolParams(ctx, cfg.Config().MCP[name], toolName, args))
 	if err != nil {
 		return ToolResult{}, err
 	}
@@ -143,12 +176,13 @@ func getTools(ctx context.Context, session *ClientSession) ([]*Tool, error) {
 
 func updateTools(cfg *config.ConfigStore, name string, tools []*Tool) int {
 	tools = filterDisabledTools(cfg, name, tools)
+	tools = filterReadOnlyTools(cfg, name, tools)
 	if len(tools) == 0 {
-		allTools.Del(name)
-		return 0
+		caches.Track(name, cacheTools, nil, nil)
+	} else {
+		caches.Track(name, cacheTools, tools, nil)
 	}
-	allTools.Set(name, tools)
-	return len(tools)
+	return setServerTools(cfg, name, tools)
 }
 
 // filterDisabledTools removes tools that are disabled via config.
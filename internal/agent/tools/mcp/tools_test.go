@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"testing"
 
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -67,3 +69,37 @@ func TestEnsureRawBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestRequiresPermission(t *testing.T) {
+	t.Parallel()
+
+	readOnly := &Tool{Name: "read", Annotations: &mcp.ToolAnnotations{ReadOnlyHint: true}}
+	safe := &Tool{Name: "safe", Annotations: &mcp.ToolAnnotations{DestructiveHint: new(false)}}
+	destructive := &Tool{Name: "delete", Annotations: &mcp.ToolAnnotations{DestructiveHint: new(true)}}
+	unannotated := &Tool{Name: "plain"}
+	defaultHints := &Tool{Name: "hinted", Annotations: &mcp.ToolAnnotations{Title: "Hinted"}}
+
+	sensitive := config.MCPConfig{PermissionMode: config.MCPPermissionSensitive, SensitiveTools: []string{"read"}}
+
+	tests := []struct {
+		name string
+		cfg  config.MCPConfig
+		tool *Tool
+		want bool
+	}{
+		{"default mode gates read-only tools", config.MCPConfig{}, readOnly, true},
+		{"all mode gates read-only tools", config.MCPConfig{PermissionMode: config.MCPPermissionAll}, readOnly, true},
+		{"sensitive mode skips non-destructive tools", sensitive, safe, false},
+		{"sensitive mode gates destructive tools", sensitive, destructive, true},
+		{"sensitive mode gates unannotated tools", sensitive, unannotated, true},
+		{"sensitive mode gates tools destructive by default", sensitive, defaultHints, true},
+		{"sensitive tools are gated despite annotations", sensitive, readOnly, true},
+		{"read-only tools are skipped", config.MCPConfig{PermissionMode: config.MCPPermissionSensitive}, readOnly, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, RequiresPermission(tt.cfg, tt.tool))
+		})
+	}
+}
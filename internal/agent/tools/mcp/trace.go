@@ -0,0 +1,96 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceParentHeader is the W3C Trace Context header name.
+const traceParentHeader = "traceparent"
+
+// traceRoundTripper injects a correlation header into outbound MCP requests so
+// server-side logs can be tied back to the Crush request that caused them.
+//
+// When header is "traceparent" the active OpenTelemetry span context from the
+// request context is propagated in W3C format. Any other header name carries
+// the active trace ID as a plain correlation ID. Requests without an active
+// span get a freshly generated trace ID.
+type traceRoundTripper struct {
+	header string
+	base   http.RoundTripper
+}
+
+func (rt traceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		sc = newSpanContext()
+	}
+
+	req = req.Clone(ctx)
+	if strings.EqualFold(rt.header, traceParentHeader) {
+		propagation.TraceContext{}.Inject(
+			trace.ContextWithSpanContext(ctx, sc),
+			propagation.HeaderCarrier(req.Header),
+		)
+	} else {
+		req.Header.Set(rt.header, sc.TraceID().String())
+	}
+
+	base := rt.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// newSpanContext returns a sampled span context with random trace and span
+// IDs, used when the request carries no active span.
+func newSpanContext() trace.SpanContext {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	_, _ = rand.Read(traceID[:])
+	_, _ = rand.Read(spanID[:])
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+}
+
+// withCallTrace returns ctx carrying the span context of a tool call: the
+// active one, or a new one so all requests of the call share a trace ID.
+func withCallTrace(ctx context.Context) (context.Context, trace.SpanContext) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		sc = newSpanContext()
+		ctx = trace.ContextWithSpanContext(ctx, sc)
+	}
+	return ctx, sc
+}
+
+// callToolParams builds the parameters of a tool call. When trace
+// propagation is configured, the span context of ctx is also sent in
+// _meta.traceparent, which reaches stdio servers that have no headers.
+func callToolParams(ctx context.Context, m config.MCPConfig, toolName string, args map[string]any) *mcp.CallToolParams {
+	params := &mcp.CallToolParams{
+		Name:      toolName,
+		Arguments: args,
+	}
+	if m.TraceHeader == "" {
+		return params
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if v := carrier.Get(traceParentHeader); v != "" {
+		params.Meta = mcp.Meta{traceParentHeader: v}
+	}
+	return params
+}
@@ -0,0 +1,125 @@
+package mcp
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceRoundTripper(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	t.Cleanup(srv.Close)
+
+	do := func(t *testing.T, rt http.RoundTripper, req *http.Request) {
+		t.Helper()
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	t.Run("injects a new traceparent without an active span", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		do(t, traceRoundTripper{header: "traceparent"}, req)
+
+		require.True(t, isTraceParent(got.Get("traceparent")), "got %q", got.Get("traceparent"))
+		require.Empty(t, req.Header.Get("traceparent"), "original request must not be mutated")
+	})
+
+	t.Run("propagates the active span context", func(t *testing.T) {
+		sc := newSpanContext()
+		ctx := trace.ContextWithSpanContext(t.Context(), sc)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		do(t, traceRoundTripper{header: "Traceparent"}, req)
+
+		v := got.Get("traceparent")
+		require.True(t, isTraceParent(v), "got %q", v)
+		require.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", v)
+	})
+
+	t.Run("sets a custom correlation header", func(t *testing.T) {
+		sc := newSpanContext()
+		ctx := trace.ContextWithSpanContext(t.Context(), sc)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, nil)
+		require.NoError(t, err)
+		do(t, traceRoundTripper{header: "X-Correlation-ID"}, req)
+
+		require.Equal(t, sc.TraceID().String(), got.Get("X-Correlation-ID"))
+		require.Empty(t, got.Get("traceparent"))
+	})
+}
+
+func TestWithCallTrace(t *testing.T) {
+	t.Parallel()
+
+	ctx, sc := withCallTrace(t.Context())
+	require.True(t, sc.IsValid())
+	require.Equal(t, sc, trace.SpanContextFromContext(ctx))
+
+	active := newSpanContext()
+	_, sc = withCallTrace(trace.ContextWithSpanContext(t.Context(), active))
+	require.Equal(t, active, sc, "an active span is kept")
+}
+
+func TestCallToolParams_TraceMeta(t *testing.T) {
+	t.Parallel()
+
+	ctx, sc := withCallTrace(t.Context())
+	args := map[string]any{"q": "x"}
+
+	params := callToolParams(ctx, config.MCPConfig{Type: config.MCPStdio}, "search", args)
+	require.Equal(t, "search", params.Name)
+	require.Nil(t, params.Meta, "no meta without trace propagation")
+
+	params = callToolParams(ctx, config.MCPConfig{Type: config.MCPStdio, TraceHeader: "traceparent"}, "search", args)
+	require.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", params.Meta["traceparent"])
+
+	// The server receives the trace context.
+	got := make(chan map[string]any, 1)
+	server := mcp.NewServer(&mcp.Implementation{Name: "test-server"}, nil)
+	server.AddTool(&mcp.Tool{Name: "search", InputSchema: map[string]any{"type": "object"}}, func(_ context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		got <- req.Params.Meta
+		return &mcp.CallToolResult{}, nil
+	})
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(t.Context(), serverTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = serverSession.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "crush-test"}, nil)
+	session, err := client.Connect(t.Context(), clientTransport, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+
+	_, err = session.CallTool(ctx, params)
+	require.NoError(t, err)
+	require.Equal(t, params.Meta["traceparent"], (<-got)["traceparent"])
+}
+
+// isTraceParent reports whether v is a well-formed W3C traceparent value.
+func isTraceParent(v string) bool {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 {
+		return false
+	}
+	for i, n := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != n {
+			return false
+		}
+		if _, err := hex.DecodeString(parts[i]); err != nil {
+			return false
+		}
+	}
+	return parts[0] == "00"
+}
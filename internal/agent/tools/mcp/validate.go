@@ -0,0 +1,183 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/charmbracelet/crush/internal/home"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
+)
+
+// ValidateMCPConfig checks an MCP server configuration the way
+// createTransport would use it, without connecting, spawning processes or
+// running OAuth discovery. It returns all problems found, joined.
+func ValidateMCPConfig(ctx context.Context, name string, m config.MCPConfig, resolver config.VariableResolver) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	switch m.Type {
+	case config.MCPStdio:
+		errs = append(errs, validateStdioConfig(m, resolver)...)
+	case config.MCPHttp, config.MCPSSE:
+		errs = append(errs, validateHTTPConfig(name, m, resolver)...)
+	default:
+		errs = append(errs, fmt.Errorf("unsupported mcp type: %q", m.Type))
+	}
+
+	if m.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("'timeout' must not be negative"))
+	}
+	if m.CallTimeout < 0 {
+		errs = append(errs, fmt.Errorf("'call_timeout' must not be negative"))
+	}
+	switch m.PermissionMode {
+	case "", config.MCPPermissionAll, config.MCPPermissionSensitive:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported 'permission_mode' %q: must be all or sensitive", m.PermissionMode))
+	}
+	if m.RateLimitRetries != nil && *m.RateLimitRetries < 0 {
+		errs = append(errs, fmt.Errorf("'rate_limit_retries' must not be negative"))
+	}
+	if m.RateLimitMaxWait < 0 {
+		errs = append(errs, fmt.Errorf("'rate_limit_max_wait' must not be negative"))
+	}
+	if m.MaxConcurrentCalls < 0 {
+		errs = append(errs, fmt.Errorf("'max_concurrent_calls' must not be negative"))
+	}
+	if b := m.CircuitBreaker; b != nil && (b.MaxFailures < 0 || b.Window < 0 || b.Cooldown < 0) {
+		errs = append(errs, fmt.Errorf("'circuit_breaker' values must not be negative"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("mcp '%s': %w", name, err)
+	}
+	return nil
+}
+
+func validateStdioConfig(m config.MCPConfig, resolver config.VariableResolver) []error {
+	var errs []error
+	command, err := resolveCommand(m, resolver)
+	if err != nil {
+		errs = append(errs, err)
+	} else if _, err := exec.LookPath(home.Long(command)); err != nil {
+		errs = append(errs, fmt.Errorf("mcp command %q not found: %w", command, err))
+	}
+	if _, err := resolveArgs(m.Args, resolver); err != nil {
+		errs = append(errs, fmt.Errorf("invalid args: %w", err))
+	}
+	for k, v := range m.Env {
+		if _, err := resolver.ResolveValue(v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid env %q: %w", k, err))
+		}
+	}
+	if _, err := resolveCwd(m, resolver); err != nil {
+		errs = append(errs, err)
+	}
+	if m.LogFile != "" {
+		if _, err := resolver.ResolveValue(m.LogFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid 'log_file': %w", err))
+		}
+	}
+	if m.URL != "" {
+		errs = append(errs, fmt.Errorf("'url' is not used by stdio servers; set 'type' to http or sse"))
+	}
+	return errs
+}
+
+func validateHTTPConfig(name string, m config.MCPConfig, resolver config.VariableResolver) []error {
+	var errs []error
+	if u, err := resolveURL(m, resolver); err != nil {
+		errs = append(errs, err)
+	} else if err := validateHTTPURL("url", u); err != nil {
+		errs = append(errs, err)
+	}
+	if m.Command != "" {
+		errs = append(errs, fmt.Errorf("'command' is not used by %s servers; set 'type' to stdio", m.Type))
+	}
+	if m.Cwd != "" {
+		errs = append(errs, fmt.Errorf("'cwd' is not used by %s servers; set 'type' to stdio", m.Type))
+	}
+	for k, v := range m.Headers {
+		if _, err := resolver.ResolveValue(v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid header %q: %w", k, err))
+		}
+	}
+	if len(m.TokenCommand) > 0 {
+		if p, err := newCommandTokenProvider(name, m, resolver); err != nil {
+			errs = append(errs, err)
+		} else if _, err := exec.LookPath(home.Long(p.command[0])); err != nil {
+			errs = append(errs, fmt.Errorf("token command %q not found: %w", p.command[0], err))
+		}
+	} else if m.OAuth.IsEnabled() {
+		errs = append(errs, validateOAuthConfig(m.WithEnvSecrets(name, env.New()))...)
+	}
+	if m.TokenCommandTTL < 0 {
+		errs = append(errs, fmt.Errorf("'token_command_ttl' must not be negative"))
+	}
+	return errs
+}
+
+// validateOAuthConfig checks the explicit OAuth settings resolveOAuthConfig
+// relies on.
+func validateOAuthConfig(m config.MCPConfig) []error {
+	o := m.OAuth
+	if o == nil {
+		return nil
+	}
+
+	var errs []error
+	urls := []struct{ field, value string }{
+		{"oauth.authorization_url", o.AuthURL},
+		{"oauth.token_url", o.TokenURL},
+		{"oauth.redirect_uri", o.RedirectURI},
+		{"oauth.introspection_url", o.IntrospectionURL},
+	}
+	for _, u := range urls {
+		if u.value == "" {
+			continue
+		}
+		if err := validateHTTPURL(u.field, u.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if o.SkipDiscovery && (o.AuthURL == "" || o.TokenURL == "") {
+		errs = append(errs, fmt.Errorf("'oauth.skip_discovery' requires 'oauth.authorization_url' and 'oauth.token_url'"))
+	} else if o.ClientID != "" && !o.ForceDiscovery && (o.AuthURL == "" || o.TokenURL == "") {
+		errs = append(errs, fmt.Errorf("'oauth.client_id' disables discovery, so 'oauth.authorization_url' and 'oauth.token_url' are required; set 'oauth.force_discovery' to discover them"))
+	}
+	if o.ClientSecret != "" && o.ClientID == "" {
+		errs = append(errs, fmt.Errorf("'oauth.client_secret' requires 'oauth.client_id'"))
+	}
+	switch mcpoauth.EndpointHostPolicy(o.EndpointHostCheck) {
+	case "", mcpoauth.EndpointHostStrict, mcpoauth.EndpointHostWarn, mcpoauth.EndpointHostOff:
+	default:
+		errs = append(errs, fmt.Errorf("invalid 'oauth.endpoint_host_check' %q: must be strict, warn or off", o.EndpointHostCheck))
+	}
+	if o.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("'oauth.timeout' must not be negative"))
+	}
+	if o.DefaultExpiresIn < 0 {
+		errs = append(errs, fmt.Errorf("'oauth.default_expires_in' must not be negative"))
+	}
+	return errs
+}
+
+// validateHTTPURL checks that value is an absolute http or https URL.
+func validateHTTPURL(field, value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid '%s': %w", field, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid '%s' %q: must be an absolute http or https URL", field, value)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package mcp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/config"
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMCPConfig(t *testing.T) {
+	t.Parallel()
+
+	resolver := config.NewShellVariableResolver(env.NewFromMap(map[string]string{"PATH": "/usr/bin:/bin"}))
+
+	tests := []struct {
+		name    string
+		cfg     config.MCPConfig
+		wantErr []string
+	}{
+		{
+			name: "valid stdio",
+			cfg:  config.MCPConfig{Type: config.MCPStdio, Command: "sh"},
+		},
+		{
+			name:    "stdio without command",
+			cfg:     config.MCPConfig{Type: config.MCPStdio},
+			wantErr: []string{"non-empty 'command'"},
+		},
+		{
+			name:    "stdio command not found",
+			cfg:     config.MCPConfig{Type: config.MCPStdio, Command: "crush-no-such-mcp-server"},
+			wantErr: []string{"not found"},
+		},
+		{
+			name:    "stdio with url",
+			cfg:     config.MCPConfig{Type: config.MCPStdio, Command: "sh", URL: "https://example.com/mcp"},
+			wantErr: []string{"'url' is not used"},
+		},
+		{
+			name: "stdio with cwd",
+			cfg:  config.MCPConfig{Type: config.MCPStdio, Command: "sh", Cwd: "/"},
+		},
+		{
+			name:    "stdio with missing cwd",
+			cfg:     config.MCPConfig{Type: config.MCPStdio, Command: "sh", Cwd: "/crush-no-such-dir"},
+			wantErr: []string{"mcp cwd \"/crush-no-such-dir\" is not accessible"},
+		},
+		{
+			name:    "http with cwd",
+			cfg:     config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", Cwd: "/"},
+			wantErr: []string{"'cwd' is not used"},
+		},
+		{
+			name: "valid http",
+			cfg:  config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp"},
+		},
+		{
+			name:    "http without url",
+			cfg:     config.MCPConfig{Type: config.MCPHttp},
+			wantErr: []string{"mcp http config requires a non-empty 'url' field"},
+		},
+		{
+			name:    "sse with relative url",
+			cfg:     config.MCPConfig{Type: config.MCPSSE, URL: "/sse"},
+			wantErr: []string{"absolute http or https URL"},
+		},
+		{
+			name:    "unsupported type",
+			cfg:     config.MCPConfig{Type: "websocket"},
+			wantErr: []string{"unsupported mcp type"},
+		},
+		{
+			name:    "unsupported permission mode",
+			cfg:     config.MCPConfig{Type: config.MCPStdio, Command: "sh", PermissionMode: "none"},
+			wantErr: []string{"unsupported 'permission_mode' \"none\""},
+		},
+		{
+			name: "valid oauth",
+			cfg: config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", OAuth: &config.MCPOAuthConfig{
+				ClientID: "id",
+				AuthURL:  "https://auth.example.com/authorize",
+				TokenURL: "https://auth.example.com/token",
+			}},
+		},
+		{
+			name: "client id without endpoints",
+			cfg: config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", OAuth: &config.MCPOAuthConfig{
+				ClientID: "id",
+			}},
+			wantErr: []string{"'oauth.client_id' disables discovery"},
+		},
+		{
+			name: "skip discovery without endpoints",
+			cfg: config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", OAuth: &config.MCPOAuthConfig{
+				SkipDiscovery: true,
+			}},
+			wantErr: []string{"'oauth.skip_discovery' requires"},
+		},
+		{
+			name: "multiple oauth problems",
+			cfg: config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", OAuth: &config.MCPOAuthConfig{
+				ClientSecret:      "secret",
+				TokenURL:          "token",
+				EndpointHostCheck: "loose",
+			}},
+			wantErr: []string{"'oauth.token_url'", "'oauth.client_secret' requires", "'oauth.endpoint_host_check'"},
+		},
+		{
+			name: "oauth disabled is not checked",
+			cfg: config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", OAuth: &config.MCPOAuthConfig{
+				Enabled:  new(false),
+				TokenURL: "token",
+			}},
+		},
+		{
+			name:    "negative timeout",
+			cfg:     config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", Timeout: -1},
+			wantErr: []string{"'timeout' must not be negative"},
+		},
+		{
+			name:    "negative call timeout",
+			cfg:     config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", CallTimeout: -1},
+			wantErr: []string{"'call_timeout' must not be negative"},
+		},
+		{
+			name:    "negative rate limit settings",
+			cfg:     config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", RateLimitRetries: new(-1), RateLimitMaxWait: -1},
+			wantErr: []string{"'rate_limit_retries' must not be negative", "'rate_limit_max_wait' must not be negative"},
+		},
+		{
+			name: "profile with '@'",
+			cfg:  config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp", Profile: "me@work"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateMCPConfig(context.Background(), "test", tt.cfg, resolver)
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "mcp 'test'")
+			for _, want := range tt.wantErr {
+				require.Contains(t, err.Error(), want)
+			}
+		})
+	}
+
+	t.Run("name with '@'", func(t *testing.T) {
+		t.Parallel()
+
+		err := ValidateMCPConfig(context.Background(), "a@b", config.MCPConfig{Type: config.MCPHttp, URL: "https://example.com/mcp"}, resolver)
+		require.NoError(t, err)
+	})
+}
@@ -203,6 +203,23 @@ func (app *App) resolveSession(ctx context.Context, continueSessionID string, us
 	}
 }
 
+// mcpServersSettled returns a condition for mcp.WaitForReady that holds once
+// every enabled server in servers is done starting, whether it connected or
+// failed.
+func mcpServersSettled(servers config.MCPs) func(map[string]mcp.ClientInfo) bool {
+	return func(states map[string]mcp.ClientInfo) bool {
+		for name, m := range servers {
+			if m.Disabled {
+				continue
+			}
+			if state, ok := states[name]; !ok || state.State == mcp.StateStarting {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 // RunNonInteractive runs the application in non-interactive mode with the
 // given prompt, printing to stdout.
 func (app *App) RunNonInteractive(ctx context.Context, output io.Writer, prompt, largeModel, smallModel string, hideSpinner bool, continueSessionID string, useLast bool) error {
@@ -263,8 +280,8 @@ func (app *App) RunNonInteractive(ctx context.Context, output io.Writer, prompt,
 		}
 	}
 
-	// Wait for MCP initialization to complete before reading MCP tools.
-	if err := mcp.WaitForInit(ctx); err != nil {
+	// Wait for the MCP servers to connect or fail before reading MCP tools.
+	if err := mcp.WaitForReady(ctx, mcpServersSettled(app.config.Config().MCP)); err != nil {
 		return fmt.Errorf("failed to wait for MCP initialization: %w", err)
 	}
 
@@ -483,6 +500,7 @@ func (app *App) setupEvents() {
 	setupSubscriber(ctx, app.serviceEventsWG, "mcp", mcp.SubscribeEvents, app.events)
 	setupSubscriber(ctx, app.serviceEventsWG, "lsp", SubscribeLSPEvents, app.events)
 	setupSubscriber(ctx, app.serviceEventsWG, "skills", skills.SubscribeEvents, app.events)
+	app.serviceEventsWG.Go(func() { app.forgetDeletedSessions(ctx) })
 	cleanupFunc := func(context.Context) error {
 		cancel()
 		app.serviceEventsWG.Wait()
@@ -491,6 +509,16 @@ func (app *App) setupEvents() {
 	app.cleanupFuncs = append(app.cleanupFuncs, cleanupFunc)
 }
 
+// forgetDeletedSessions drops the agent's state for sessions as they are
+// deleted.
+func (app *App) forgetDeletedSessions(ctx context.Context) {
+	for event := range app.Sessions.Subscribe(ctx) {
+		if event.Type == pubsub.DeletedEvent && app.AgentCoordinator != nil {
+			app.AgentCoordinator.ForgetSession(event.Payload.ID)
+		}
+	}
+}
+
 const subscriberSendTimeout = 2 * time.Second
 
 func setupSubscriber[T any](
@@ -603,6 +631,7 @@ func (app *App) Shutdown() {
 	// before closing the DB so agents can finish writing their state.
 	if app.AgentCoordinator != nil {
 		app.AgentCoordinator.CancelAll()
+		app.AgentCoordinator.Close()
 	}
 
 	// Now run remaining cleanup tasks in parallel.
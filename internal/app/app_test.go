@@ -8,6 +8,8 @@ import (
 	"time"
 
 	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/crush/internal/agent/tools/mcp"
+	"github.com/charmbracelet/crush/internal/config"
 	"github.com/charmbracelet/crush/internal/pubsub"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/goleak"
@@ -155,3 +157,22 @@ func newSubscriberFixture(t *testing.T, bufSize int) *subscriberFixture {
 
 	return f
 }
+
+func TestMCPServersSettled(t *testing.T) {
+	t.Parallel()
+
+	settled := mcpServersSettled(config.MCPs{
+		"fast": {},
+		"slow": {},
+		"off":  {Disabled: true},
+	})
+	require.False(t, settled(map[string]mcp.ClientInfo{}), "servers without a state have not started yet")
+	require.False(t, settled(map[string]mcp.ClientInfo{
+		"fast": {State: mcp.StateConnected},
+		"slow": {State: mcp.StateStarting},
+	}))
+	require.True(t, settled(map[string]mcp.ClientInfo{
+		"fast": {State: mcp.StateConnected},
+		"slow": {State: mcp.StateError},
+	}))
+}
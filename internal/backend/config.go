@@ -38,7 +38,7 @@ func (b *Backend) RemoveConfigField(workspaceID string, scope config.Scope, key
 	if err != nil {
 		return err
 	}
-	return ws.Cfg.RemoveConfigField(scope, key)
+	return mcptools.RemoveConfigField(context.Background(), ws.Cfg, scope, key)
 }
 
 // UpdatePreferredModel updates the preferred model for the given type
@@ -304,6 +304,20 @@ func (c *Client) ClearAgentSessionQueuedPrompts(ctx context.Context, id string,
 	return nil
 }
 
+// OverrideAgentSessionLoopDetection lets a session halted by loop detection
+// continue.
+func (c *Client) OverrideAgentSessionLoopDetection(ctx context.Context, id string, sessionID string) error {
+	rsp, err := c.post(ctx, fmt.Sprintf("/workspaces/%s/agent/sessions/%s/loop/override", id, sessionID), nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to override loop detection: %w", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to override loop detection: status code %d", rsp.StatusCode)
+	}
+	return nil
+}
+
 // GetAgentInfo retrieves the agent status for a workspace.
 func (c *Client) GetAgentInfo(ctx context.Context, id string) (*proto.AgentInfo, error) {
 	rsp, err := c.get(ctx, fmt.Sprintf("/workspaces/%s/agent", id), nil, nil)
@@ -3,9 +3,12 @@ package commands
 import (
 	"context"
 	"io/fs"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -31,7 +34,10 @@ type Argument struct {
 
 // MCPPrompt represents a custom command loaded from an MCP server.
 type MCPPrompt struct {
-	ID          string
+	ID string
+	// Name is the command name shown to users. It is the prompt name, or
+	// "server:prompt" when several servers expose a prompt of that name.
+	Name        string
 	Title       string
 	Description string
 	PromptID    string
@@ -58,12 +64,56 @@ func LoadCustomCommands(cfg *config.Config) ([]CustomCommand, error) {
 	return loadAll(buildCommandSources(cfg))
 }
 
-// LoadMCPPrompts loads custom commands from available MCP servers.
-func LoadMCPPrompts() ([]MCPPrompt, error) {
+// LoadMCPPrompts loads custom commands from available MCP servers. Prompts
+// with the same name on several servers are resolved according to the
+// configured conflict policy.
+func LoadMCPPrompts(cfg *config.Config) ([]MCPPrompt, error) {
+	var policy string
+	if cfg != nil && cfg.Options != nil {
+		policy = cfg.Options.MCPPromptConflicts
+	}
+	return buildMCPPrompts(maps.Collect(mcp.Prompts()), policy), nil
+}
+
+// buildMCPPrompts converts the prompts of each MCP server into commands,
+// ordered by server and prompt name so conflicts resolve deterministically.
+func buildMCPPrompts(byServer map[string][]*mcp.Prompt, policy string) []MCPPrompt {
+	servers := slices.Sorted(maps.Keys(byServer))
+
+	owners := make(map[string][]string)
+	for _, mcpName := range servers {
+		for _, prompt := range byServer[mcpName] {
+			owners[prompt.Name] = append(owners[prompt.Name], mcpName)
+		}
+	}
+	for name, names := range owners {
+		if len(names) < 2 {
+			continue
+		}
+		if policy == config.MCPPromptConflictsFirst {
+			slog.Warn("MCP prompt exposed by several servers, using the first", "prompt", name, "servers", names, "winner", names[0])
+		} else {
+			slog.Warn("MCP prompt exposed by several servers, namespacing by server", "prompt", name, "servers", names)
+		}
+	}
+
 	var commands []MCPPrompt
-	for mcpName, prompts := range mcp.Prompts() {
+	for _, mcpName := range servers {
+		prompts := slices.SortedFunc(slices.Values(byServer[mcpName]), func(a, b *mcp.Prompt) int {
+			return strings.Compare(a.Name, b.Name)
+		})
 		for _, prompt := range prompts {
 			key := mcpName + ":" + prompt.Name
+			name := prompt.Name
+			if shared := owners[prompt.Name]; len(shared) > 1 {
+				if policy == config.MCPPromptConflictsFirst {
+					if shared[0] != mcpName {
+						continue
+					}
+				} else {
+					name = key
+				}
+			}
 			var args []Argument
 			for _, arg := range prompt.Arguments {
 				title := arg.Title
@@ -79,6 +129,7 @@ func LoadMCPPrompts() ([]MCPPrompt, error) {
 			}
 			commands = append(commands, MCPPrompt{
 				ID:          key,
+				Name:        name,
 				Title:       prompt.Title,
 				Description: prompt.Description,
 				PromptID:    prompt.Name,
@@ -87,7 +138,7 @@ func LoadMCPPrompts() ([]MCPPrompt, error) {
 			})
 		}
 	}
-	return commands, nil
+	return commands
 }
 
 func buildCommandSources(cfg *config.Config) []commandSource {
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/charmbracelet/crush/internal/agent/tools/mcp"
+	"github.com/charmbracelet/crush/internal/config"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,3 +53,52 @@ func TestLoadAll_MixedSources(t *testing.T) {
 	require.Len(t, cmds, 1)
 	require.Equal(t, "user:cmd", cmds[0].ID)
 }
+
+func TestBuildMCPPrompts_Conflicts(t *testing.T) {
+	t.Parallel()
+
+	byServer := map[string][]*mcp.Prompt{
+		"github": {{Name: "review"}, {Name: "triage"}},
+		"gitlab": {{Name: "review"}},
+	}
+
+	names := func(prompts []MCPPrompt) map[string]string {
+		m := make(map[string]string)
+		for _, p := range prompts {
+			m[p.Name] = p.ID
+		}
+		return m
+	}
+
+	t.Run("namespaces conflicting prompts by default", func(t *testing.T) {
+		t.Parallel()
+
+		prompts := buildMCPPrompts(byServer, "")
+		require.Equal(t, map[string]string{
+			"github:review": "github:review",
+			"gitlab:review": "gitlab:review",
+			"triage":        "github:triage",
+		}, names(prompts))
+	})
+
+	t.Run("first server wins", func(t *testing.T) {
+		t.Parallel()
+
+		prompts := buildMCPPrompts(byServer, config.MCPPromptConflictsFirst)
+		require.Equal(t, map[string]string{
+			"review": "github:review",
+			"triage": "github:triage",
+		}, names(prompts))
+	})
+
+	t.Run("deterministic order", func(t *testing.T) {
+		t.Parallel()
+
+		prompts := buildMCPPrompts(byServer, config.MCPPromptConflictsNamespace)
+		var ids []string
+		for _, p := range prompts {
+			ids = append(ids, p.ID)
+		}
+		require.Equal(t, []string{"github:review", "github:triage", "gitlab:review"}, ids)
+	})
+}
@@ -16,6 +16,7 @@ import (
 	"charm.land/catwalk/pkg/catwalk"
 	"github.com/charmbracelet/crush/internal/csync"
 	"github.com/charmbracelet/crush/internal/env"
+	"github.com/charmbracelet/crush/internal/log"
 	"github.com/charmbracelet/crush/internal/oauth"
 	"github.com/charmbracelet/crush/internal/oauth/copilot"
 	"github.com/invopop/jsonschema"
@@ -166,6 +167,44 @@ const (
 	MCPHttp  MCPType = "http"
 )
 
+// MCPPermissionMode selects which tools of an MCP server ask for permission
+// before they run.
+type MCPPermissionMode string
+
+const (
+	// MCPPermissionAll asks before every tool call.
+	MCPPermissionAll MCPPermissionMode = "all"
+	// MCPPermissionSensitive asks only before calls to the server's
+	// sensitive tools and to tools it doesn't mark as read-only or
+	// non-destructive.
+	MCPPermissionSensitive MCPPermissionMode = "sensitive"
+)
+
+// MCPHealthCheckConfig configures deeper health checks for an MCP server,
+// run periodically on top of pings to catch servers that answer pings while
+// failing real requests.
+type MCPHealthCheckConfig struct {
+	// Interval is the minimum time, in seconds, between health checks.
+	Interval int `json:"interval,omitempty" jsonschema:"description=Minimum seconds between health checks,default=300,example=60"`
+	// Tool is a canary tool to call. If empty, the server's tools are listed instead.
+	Tool string `json:"tool,omitempty" jsonschema:"description=Canary tool to call for the health check (defaults to listing tools),example=health"`
+	// Arguments are passed to the canary tool.
+	Arguments map[string]any `json:"arguments,omitempty" jsonschema:"description=Arguments passed to the canary tool"`
+}
+
+// MCPCircuitBreakerConfig limits how often Crush tries to connect to a
+// server that keeps failing, e.g. a stdio command that crashes on start.
+type MCPCircuitBreakerConfig struct {
+	// MaxFailures is how many failed connects within Window open the
+	// breaker.
+	MaxFailures int `json:"max_failures,omitempty" jsonschema:"description=Failed connects within the window that open the circuit breaker,default=5,example=3"`
+	// Window is the period, in seconds, in which failures are counted.
+	Window int `json:"window,omitempty" jsonschema:"description=Seconds in which failed connects are counted,default=60,example=120"`
+	// Cooldown is how long, in seconds, no connects are attempted once the
+	// breaker is open.
+	Cooldown int `json:"cooldown,omitempty" jsonschema:"description=Seconds to stop connecting once the circuit breaker opens,default=30,example=300"`
+}
+
 // MCPOAuthConfig holds OAuth 2.0 configuration for MCP servers.
 type MCPOAuthConfig struct {
 	// Enabled controls whether OAuth 2.0 authentication is enabled for this MCP server.
@@ -183,6 +222,49 @@ type MCPOAuthConfig struct {
 	Scopes []string `json:"scopes,omitempty" jsonschema:"description=OAuth 2.0 scopes to request"`
 	// RedirectURI is the redirect URI for the OAuth callback (defaults to localhost).
 	RedirectURI string `json:"redirect_uri,omitempty" jsonschema:"description=OAuth 2.0 redirect URI for callback,format=uri,default=http://localhost:19876/callback"`
+	// IntrospectionURL is the token introspection endpoint URL (RFC 7662).
+	IntrospectionURL string `json:"introspection_url,omitempty" jsonschema:"description=OAuth 2.0 token introspection endpoint URL,format=uri"`
+	// StrictIntrospection introspects tokens before use and discards inactive ones.
+	StrictIntrospection bool `json:"strict_introspection,omitempty" jsonschema:"description=Introspect OAuth tokens before use and re-authorize when revoked,default=false"`
+	// DefaultExpiresIn is the token lifetime, in seconds, assumed when the
+	// server omits expires_in or sends zero. If unset, such tokens are kept
+	// until the server rejects them.
+	DefaultExpiresIn int `json:"default_expires_in,omitempty" jsonschema:"description=Token lifetime in seconds assumed when the server reports no expiry (0 keeps the token until rejected),default=0,example=3600"`
+	// ExpirySkew is how long, in seconds, before their expiry tokens are
+	// refreshed. It is capped at half of a token's lifetime.
+	ExpirySkew int `json:"expiry_skew,omitempty" jsonschema:"description=Seconds before their expiry OAuth tokens are refreshed (capped at half of the token lifetime),default=30,example=120"`
+	// Timeout is the timeout, in seconds, for each request to the
+	// authorization server.
+	Timeout int `json:"timeout,omitempty" jsonschema:"description=Timeout in seconds for requests to the OAuth authorization server,default=30,example=60"`
+	// Registration overrides the client metadata sent during dynamic client
+	// registration.
+	Registration *MCPOAuthRegistrationConfig `json:"registration,omitempty" jsonschema:"description=Client metadata for OAuth 2.0 dynamic client registration"`
+	// SkipDiscovery never probes the server's OAuth metadata. It requires
+	// AuthURL and TokenURL; without them OAuth is disabled.
+	SkipDiscovery bool `json:"skip_discovery,omitempty" jsonschema:"description=Never run OAuth discovery; requires authorization_url and token_url,default=false"`
+	// ForceDiscovery runs OAuth discovery even when a client ID is set, to
+	// pick up endpoints the config leaves unset. Explicit values still take
+	// precedence over discovered ones.
+	ForceDiscovery bool `json:"force_discovery,omitempty" jsonschema:"description=Run OAuth discovery even when a client ID is set; explicit values take precedence,default=false"`
+	// EndpointHostCheck controls discovered endpoints on a host other than
+	// the issuer's: "strict" rejects the metadata, "warn" logs a warning and
+	// "off" skips the check.
+	EndpointHostCheck string `json:"endpoint_host_check,omitempty" jsonschema:"description=How to treat discovered OAuth endpoints on a host other than the issuer's,enum=strict,enum=warn,enum=off,default=strict"`
+	// AllowedEndpointHosts are hosts besides the issuer's that discovered
+	// endpoints may use, e.g. an identity provider the server delegates to.
+	AllowedEndpointHosts []string `json:"allowed_endpoint_hosts,omitempty" jsonschema:"description=Hosts besides the issuer's that discovered OAuth endpoints may use,example=login.example.com"`
+}
+
+// MCPOAuthRegistrationConfig is the client metadata sent during OAuth 2.0
+// dynamic client registration (RFC 7591). Unset fields keep the defaults.
+type MCPOAuthRegistrationConfig struct {
+	ClientName              string   `json:"client_name,omitempty" jsonschema:"description=Client name to register,default=crush-oauth-client"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty" jsonschema:"description=Token endpoint authentication method to register,default=none,example=client_secret_post"`
+	SoftwareID              string   `json:"software_id,omitempty" jsonschema:"description=Identifier of the client software"`
+	SoftwareVersion         string   `json:"software_version,omitempty" jsonschema:"description=Version of the client software"`
+	Contacts                []string `json:"contacts,omitempty" jsonschema:"description=Email addresses of people responsible for the client"`
+	LogoURI                 string   `json:"logo_uri,omitempty" jsonschema:"description=URL of the client logo,format=uri"`
+	SoftwareStatement       string   `json:"software_statement,omitempty" jsonschema:"description=Signed JWT asserting the client metadata"`
 }
 
 // IsEnabled returns whether OAuth is enabled for this config.
@@ -198,11 +280,75 @@ type MCPConfig struct {
 	Command       string            `json:"command,omitempty" jsonschema:"description=Command to execute for stdio MCP servers,example=npx"`
 	Env           map[string]string `json:"env,omitempty" jsonschema:"description=Environment variables to set for the MCP server"`
 	Args          []string          `json:"args,omitempty" jsonschema:"description=Arguments to pass to the MCP server command"`
+	Cwd           string            `json:"cwd,omitempty" jsonschema:"description=Working directory for stdio MCP servers (defaults to the current directory),example=~/projects/my-server"`
+	InheritEnv    *bool             `json:"inherit_env,omitempty" jsonschema:"description=Pass Crush's environment to stdio MCP servers; when false they only get env plus PATH and HOME,default=true"`
 	Type          MCPType           `json:"type" jsonschema:"required,description=Type of MCP connection,enum=stdio,enum=sse,enum=http,default=stdio"`
 	URL           string            `json:"url,omitempty" jsonschema:"description=URL for HTTP or SSE MCP servers,format=uri,example=http://localhost:3000/mcp"`
 	Disabled      bool              `json:"disabled,omitempty" jsonschema:"description=Whether this MCP server is disabled,default=false"`
 	DisabledTools []string          `json:"disabled_tools,omitempty" jsonschema:"description=List of tools from this MCP server to disable,example=get-library-doc"`
 	Timeout       int               `json:"timeout,omitempty" jsonschema:"description=Timeout in seconds for MCP server connections,default=15,example=30,example=60,example=120"`
+	Priority      int               `json:"priority,omitempty" jsonschema:"description=Servers with a higher priority keep their tools first when mcp_max_tools is reached,default=0,example=10"`
+
+	// LogFile, when set, receives the stderr output of a stdio server. It is
+	// appended to across restarts and rotated by size.
+	LogFile string `json:"log_file,omitempty" jsonschema:"description=File to append a stdio MCP server's stderr output to; rotated by size,example=~/.local/state/crush/mcp-github.log"`
+
+	// TolerantStdout skips non-JSON lines a stdio server writes to stdout
+	// instead of failing the session. Strict framing is the default.
+	TolerantStdout bool `json:"tolerant_stdout,omitempty" jsonschema:"description=Skip non-JSON lines written to stdout by stdio MCP servers instead of failing,default=false"`
+	// ToolsChangedDebounce is a grace window, in milliseconds, used to batch
+	// rapid tools/list_changed notifications into a single refresh. Zero
+	// uses the default; a negative value refreshes on every notification.
+	ToolsChangedDebounce int `json:"tools_changed_debounce,omitempty" jsonschema:"description=Grace window in milliseconds to batch rapid tool list change notifications; negative disables batching,default=250,example=250,example=1000"`
+	// CallTimeout bounds each request to an HTTP server, including a tool
+	// result streamed in its response. SSE servers keep their streams open
+	// and only get a response header timeout.
+	CallTimeout int `json:"call_timeout,omitempty" jsonschema:"description=Timeout in seconds for each request to an HTTP MCP server including streamed results (0 for none),default=0,example=300"`
+	// DisableHTTP2 forces HTTP/1.1 for HTTP and SSE servers, working around
+	// gateways with broken HTTP/2 support.
+	DisableHTTP2 bool `json:"disable_http2,omitempty" jsonschema:"description=Force HTTP/1.1 for HTTP/SSE MCP servers instead of negotiating HTTP/2,default=false"`
+	// RateLimitRetries is how often a request rate limited with 429 is
+	// retried after its Retry-After delay. Defaults to 3 when nil.
+	RateLimitRetries *int `json:"rate_limit_retries,omitempty" jsonschema:"description=How often a request to an HTTP/SSE MCP server rejected with 429 is retried (0 to disable),default=3,example=0,example=5"`
+	// RateLimitMaxWait caps, in seconds, how long a single retry waits. A
+	// longer Retry-After returns the 429 instead. Defaults to 30.
+	RateLimitMaxWait int `json:"rate_limit_max_wait,omitempty" jsonschema:"description=Longest wait in seconds before retrying a rate limited request; longer Retry-After delays fail the request,default=30,example=60"`
+	// TraceHeader, when set, injects a correlation header into requests to
+	// HTTP/SSE servers. "traceparent" propagates the active span in W3C
+	// Trace Context format; any other name carries the trace ID. Tool calls
+	// to any server then also carry the trace context in _meta.traceparent.
+	TraceHeader string `json:"trace_header,omitempty" jsonschema:"description=Header used to propagate a trace or correlation ID to HTTP/SSE MCP servers,example=traceparent,example=X-Correlation-ID"`
+	// HealthCheck enables periodic checks beyond ping. Off when nil.
+	HealthCheck *MCPHealthCheckConfig `json:"health_check,omitempty" jsonschema:"description=Periodic health check that lists tools or calls a canary tool to detect broken servers"`
+	// MaxConcurrentCalls limits how many tool calls run on the server at
+	// once; excess calls wait for a free slot. Zero means unlimited.
+	MaxConcurrentCalls int `json:"max_concurrent_calls,omitempty" jsonschema:"description=Maximum number of concurrent tool calls to this MCP server (0 for unlimited),default=0,example=1,example=4"`
+	// CircuitBreaker stops connect attempts for a while after repeated
+	// failures. Defaults apply when nil.
+	CircuitBreaker *MCPCircuitBreakerConfig `json:"circuit_breaker,omitempty" jsonschema:"description=Stop connecting to a server for a cool-down period after repeated failed connects"`
+	// PermissionMode selects which tool calls ask for permission. Defaults
+	// to MCPPermissionAll.
+	PermissionMode MCPPermissionMode `json:"permission_mode,omitempty" jsonschema:"description=Which tool calls ask for permission: all or only sensitive and possibly destructive tools,enum=all,enum=sensitive,default=all"`
+	// SensitiveTools always ask for permission with MCPPermissionSensitive,
+	// whatever the server's annotations say.
+	SensitiveTools []string `json:"sensitive_tools,omitempty" jsonschema:"description=Tools that always ask for permission with the sensitive permission mode,example=delete_repository"`
+	// Sampling lets the server request completions from Crush's model. Each
+	// request asks for permission, as it spends tokens.
+	Sampling bool `json:"sampling,omitempty" jsonschema:"description=Allow this MCP server to request completions from the configured model after asking for permission,default=false"`
+	// LoopDetectionExempt excludes the server's tool calls from loop
+	// detection, for servers that are legitimately polled.
+	LoopDetectionExempt bool `json:"loop_detection_exempt,omitempty" jsonschema:"description=Exclude this MCP server's tool calls from loop detection,default=false"`
+	// LoopDetectionMaxRepeats raises how often a step calling only this
+	// server's tools may repeat before it counts as a loop. Zero uses the
+	// default.
+	LoopDetectionMaxRepeats int `json:"loop_detection_max_repeats,omitempty" jsonschema:"description=How often a tool call to this MCP server may repeat within the loop detection window (0 uses the default),default=0,example=8"`
+
+	// TokenCommand is a program and its arguments that prints a bearer token
+	// on stdout, used instead of OAuth for HTTP/SSE servers.
+	TokenCommand []string `json:"token_command,omitempty" jsonschema:"description=Command that prints a bearer token for HTTP/SSE MCP servers; replaces OAuth"`
+	// TokenCommandTTL is how long, in seconds, a token from TokenCommand is
+	// reused. Zero reuses it until the server rejects it.
+	TokenCommandTTL int `json:"token_command_ttl,omitempty" jsonschema:"description=Seconds a token from token_command is reused before running the command again (0 until the server rejects it),default=0,example=3000"`
 
 	// TODO: maybe make it possible to get the value from the env
 	Headers map[string]string `json:"headers,omitempty" jsonschema:"description=HTTP headers for HTTP/SSE MCP servers"`
@@ -211,6 +357,10 @@ type MCPConfig struct {
 	// If not specified, OAuth will be auto-discovered from the server's well-known endpoint.
 	// Set oauth.enabled to false to disable OAuth authentication.
 	OAuth *MCPOAuthConfig `json:"oauth,omitempty" jsonschema:"description=OAuth 2.0 configuration for SSE/HTTP MCP servers,default=true."`
+
+	// Profile selects which stored OAuth identity to use for this server,
+	// allowing several identities (e.g. work and personal) side by side.
+	Profile string `json:"profile,omitempty" jsonschema:"description=Name of the stored OAuth identity to use for this MCP server,example=work,example=personal"`
 }
 
 type LSPConfig struct {
@@ -243,8 +393,29 @@ type WakaTimeConfig struct {
 	APIKey string `json:"api_key,omitempty" jsonschema:"description=WakaTime API key (optional - falls back to ~/.wakatime.cfg)"`
 	// Category is the activity category sent to WakaTime.
 	Category string `json:"category,omitempty" jsonschema:"description=Activity category for WakaTime,default=ai coding"`
+	// CategoryByTool maps tool names to activity categories that override
+	// Category for their heartbeats.
+	CategoryByTool map[string]string `json:"category_by_tool,omitempty" jsonschema:"description=Activity category per tool name overriding category (optional),example={\"view\":\"code reviewing\"}"`
 	// CLIPath is an optional path to the wakatime-cli binary.
 	CLIPath string `json:"cli_path,omitempty" jsonschema:"description=Path to wakatime-cli binary (optional - auto-detected if not set)"`
+	// Hostname is the machine name heartbeats are tagged with. If empty, the
+	// system hostname is used.
+	Hostname string `json:"hostname,omitempty" jsonschema:"description=Machine name WakaTime heartbeats are tagged with (optional - defaults to the system hostname),example=work-laptop"`
+	// APIURL is the base URL of a WakaTime-compatible API, e.g. a
+	// self-hosted Wakapi instance.
+	APIURL string `json:"api_url,omitempty" jsonschema:"description=WakaTime-compatible API base URL such as a self-hosted Wakapi instance (optional - defaults to the public WakaTime API),example=https://wakapi.example.com/api"`
+	// Direct posts heartbeats to the API without running wakatime-cli.
+	Direct bool `json:"direct,omitempty" jsonschema:"description=Post heartbeats directly to the API instead of running wakatime-cli (requires api_key),default=false"`
+	// Tools lists the tool names that send heartbeats. If empty, a default
+	// set of file and directory tools is used.
+	Tools []string `json:"tools,omitempty" jsonschema:"description=Tool names that send WakaTime heartbeats (defaults to file and directory tools),example=view,example=edit,example=ls"`
+	// ProjectMarkers are the files or directories that mark a project root.
+	ProjectMarkers []string `json:"project_markers,omitempty" jsonschema:"description=Files or directories that mark a project root for WakaTime project detection (defaults to .git and common manifests),example=.git,example=go.mod,example=WORKSPACE"`
+	// ProjectStrategy selects how the project is derived from a file path.
+	ProjectStrategy string `json:"project_strategy,omitempty" jsonschema:"description=How the WakaTime project is detected from a file path,enum=nearest,enum=topmost,enum=depth,default=nearest"`
+	// ProjectDepth is the directory depth below the top-most project root
+	// used as the project when ProjectStrategy is "depth".
+	ProjectDepth int `json:"project_depth,omitempty" jsonschema:"description=Directory depth below the top-most project root used as the project with the depth strategy,default=0,example=2"`
 }
 
 // Completions defines options for the completions UI.
@@ -302,7 +473,37 @@ type Options struct {
 	Progress                  *bool        `json:"progress,omitempty" jsonschema:"description=Show indeterminate progress updates during long operations,default=true"`
 	DisableNotifications      bool         `json:"disable_notifications,omitempty" jsonschema:"description=Disable desktop notifications,default=false"`
 	DisabledSkills            []string     `json:"disabled_skills,omitempty" jsonschema:"description=List of skill names to disable and hide from the agent,example=crush-config"`
-}
+	LoopDetectionMaxCycle     int          `json:"loop_detection_max_cycle,omitempty" jsonschema:"description=Longest sequence of repeated tool calls checked for oscillating loops (1 disables cycle detection),default=3,example=2,example=4"`
+	LoopDetectionNudge        bool         `json:"loop_detection_nudge,omitempty" jsonschema:"description=Nudge the model to change strategy on the first detected loop instead of stopping it,default=false"`
+	LoopDetectionNudgeMessage string       `json:"loop_detection_nudge_message,omitempty" jsonschema:"description=Message sent to the model when nudging it out of a loop (defaults to a description of the repeated calls)"`
+	LoopDetectionGraceSteps   int          `json:"loop_detection_grace_steps,omitempty" jsonschema:"description=Steps after a nudge during which another detected loop stops the agent,default=10,example=5,example=20"`
+	MCPPromptConflicts        string       `json:"mcp_prompt_conflicts,omitempty" jsonschema:"description=How to resolve MCP prompts with the same name on several servers,enum=namespace,enum=first,default=namespace"`
+	MCPCacheLimit             int          `json:"mcp_cache_limit,omitempty" jsonschema:"description=Approximate memory limit in MiB for cached MCP tool, prompt and resource lists across servers (0 for unlimited),default=0,example=64"`
+	// MCPPruneTokens removes stored OAuth data of MCP servers that are not
+	// in the config after startup. The token store is shared by all
+	// projects, so this is only safe when every project uses the same
+	// servers.
+	MCPPruneTokens bool `json:"mcp_prune_tokens,omitempty" jsonschema:"description=Remove stored MCP OAuth tokens for servers not in the current config on startup (tokens are shared across projects),default=false"`
+	// MCPMaxTools caps the number of MCP tools exposed to the model across
+	// all servers. Servers with a higher MCPConfig.Priority keep their
+	// tools first.
+	MCPMaxTools int `json:"mcp_max_tools,omitempty" jsonschema:"description=Maximum number of MCP tools exposed to the model across all servers (0 for unlimited),default=0,example=64"`
+	// MCPReadOnly exposes only MCP tools annotated as read-only and rejects
+	// calls to any other tool.
+	MCPReadOnly bool `json:"mcp_readonly,omitempty" jsonschema:"description=Only expose MCP tools annotated as read-only and block all others,default=false"`
+	// MCPDestructivePatterns are glob patterns of MCP tool names that
+	// MCPReadOnly blocks even if they are annotated as read-only.
+	MCPDestructivePatterns []string `json:"mcp_destructive_patterns,omitempty" jsonschema:"description=Glob patterns of MCP tool names blocked in read-only mode regardless of annotations,example=delete_*,example=*_write"`
+}
+
+// MCP prompt conflict policies for Options.MCPPromptConflicts.
+const (
+	// MCPPromptConflictsNamespace names conflicting prompts "server:prompt".
+	MCPPromptConflictsNamespace = "namespace"
+	// MCPPromptConflictsFirst keeps only the prompt from the server whose
+	// name sorts first.
+	MCPPromptConflictsFirst = "first"
+)
 
 type MCPs map[string]MCPConfig
 
@@ -350,21 +551,40 @@ func (l LSPConfig) ResolvedEnv() []string {
 	return resolveEnvs(l.Env)
 }
 
+// InheritsEnv reports whether a stdio server inherits Crush's environment,
+// which is the default.
+func (m MCPConfig) InheritsEnv() bool {
+	return m.InheritEnv == nil || *m.InheritEnv
+}
+
 func (m MCPConfig) ResolvedEnv() []string {
-	return resolveEnvs(m.Env)
+	return m.ResolveEnv(NewShellVariableResolver(env.New()))
+}
+
+// ResolveEnv resolves the environment variables with resolver. The config is
+// left unchanged, so each call picks up the current values.
+func (m MCPConfig) ResolveEnv(resolver VariableResolver) []string {
+	return resolveEnvsWith(resolver, m.Env)
 }
 
 func (m MCPConfig) ResolvedHeaders() map[string]string {
-	resolver := NewShellVariableResolver(env.New())
+	return m.ResolveHeaders(NewShellVariableResolver(env.New()))
+}
+
+// ResolveHeaders resolves the headers with resolver. The config is left
+// unchanged, so each call picks up the current values; headers that fail to
+// resolve are left out.
+func (m MCPConfig) ResolveHeaders(resolver VariableResolver) map[string]string {
+	resolved := make(map[string]string, len(m.Headers))
 	for e, v := range m.Headers {
-		var err error
-		m.Headers[e], err = resolver.ResolveValue(v)
+		value, err := resolver.ResolveValue(v)
 		if err != nil {
-			slog.Error("Error resolving header variable", "error", err, "variable", e, "value", v)
+			slog.Error("Error resolving header variable", "error", err, "variable", e, "value", log.RedactHeaderValue(e, v))
 			continue
 		}
+		resolved[e] = value
 	}
-	return m.Headers
+	return resolved
 }
 
 type Agent struct {
@@ -683,19 +903,20 @@ func (c *ProviderConfig) TestConnection(resolver VariableResolver) error {
 }
 
 func resolveEnvs(envs map[string]string) []string {
-	resolver := NewShellVariableResolver(env.New())
+	return resolveEnvsWith(NewShellVariableResolver(env.New()), envs)
+}
+
+// resolveEnvsWith resolves envs with resolver without modifying the map.
+// Variables that fail to resolve are left out.
+func resolveEnvsWith(resolver VariableResolver, envs map[string]string) []string {
+	res := make([]string, 0, len(envs))
 	for e, v := range envs {
-		var err error
-		envs[e], err = resolver.ResolveValue(v)
+		value, err := resolver.ResolveValue(v)
 		if err != nil {
 			slog.Error("Error resolving environment variable", "error", err, "variable", e, "value", v)
 			continue
 		}
-	}
-
-	res := make([]string, 0, len(envs))
-	for k, v := range envs {
-		res = append(res, fmt.Sprintf("%s=%s", k, v))
+		res = append(res, fmt.Sprintf("%s=%s", e, value))
 	}
 	return res
 }
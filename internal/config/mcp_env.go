@@ -0,0 +1,98 @@
+package config
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/crush/internal/env"
+)
+
+// mcpEnvPrefix is the prefix of environment variables consulted for MCP
+// server secrets.
+const mcpEnvPrefix = "CRUSH_MCP_"
+
+// MCPEnvName returns the conventional environment variable name for a field
+// of an MCP server. The server name and parts are upper-cased, and any
+// character other than a letter or digit becomes an underscore. For example,
+// MCPEnvName("github", "HEADER", "Authorization") returns
+// "CRUSH_MCP_GITHUB_HEADER_AUTHORIZATION".
+func MCPEnvName(server string, parts ...string) string {
+	name := mcpEnvPrefix + envSegment(server)
+	for _, part := range parts {
+		name += "_" + envSegment(part)
+	}
+	return name
+}
+
+func envSegment(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// WithEnvSecrets returns a copy of m with secrets filled in from environment
+// variables named by convention, so they need not be templated in the
+// config. For a server named "github":
+//
+//   - CRUSH_MCP_GITHUB_HEADER_<NAME> sets the header <NAME>, with
+//     underscores read as dashes (X_API_KEY sets X-Api-Key).
+//   - CRUSH_MCP_GITHUB_OAUTH_CLIENT_ID sets the OAuth client ID.
+//   - CRUSH_MCP_GITHUB_OAUTH_CLIENT_SECRET sets the OAuth client secret.
+//
+// Values set explicitly in the config take precedence over the environment.
+func (m MCPConfig) WithEnvSecrets(name string, e env.Env) MCPConfig {
+	headerPrefix := MCPEnvName(name, "HEADER") + "_"
+	for _, kv := range e.Env() {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || value == "" || !strings.HasPrefix(key, headerPrefix) {
+			continue
+		}
+		header := http.CanonicalHeaderKey(strings.ReplaceAll(strings.TrimPrefix(key, headerPrefix), "_", "-"))
+		if hasHeader(m.Headers, header) {
+			continue
+		}
+		if m.Headers == nil {
+			m.Headers = make(map[string]string)
+		} else {
+			m.Headers = maps.Clone(m.Headers)
+		}
+		m.Headers[header] = value
+	}
+
+	clientID := e.Get(MCPEnvName(name, "OAUTH", "CLIENT_ID"))
+	clientSecret := e.Get(MCPEnvName(name, "OAUTH", "CLIENT_SECRET"))
+	if clientID == "" && clientSecret == "" {
+		return m
+	}
+	var oauth MCPOAuthConfig
+	if m.OAuth != nil {
+		oauth = *m.OAuth
+	}
+	if oauth.ClientID == "" {
+		oauth.ClientID = clientID
+	}
+	if oauth.ClientSecret == "" {
+		oauth.ClientSecret = clientSecret
+	}
+	m.OAuth = &oauth
+	return m
+}
+
+// hasHeader reports whether headers has a non-empty value for name, ignoring
+// case.
+func hasHeader(headers map[string]string, name string) bool {
+	for k, v := range headers {
+		if v != "" && strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
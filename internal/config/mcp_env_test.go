@@ -0,0 +1,89 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/crush/internal/env"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMCPEnvName(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "CRUSH_MCP_GITHUB_HEADER_AUTHORIZATION", MCPEnvName("github", "HEADER", "Authorization"))
+	require.Equal(t, "CRUSH_MCP_MY_SERVER_OAUTH_CLIENT_SECRET", MCPEnvName("my-server", "OAUTH", "CLIENT_SECRET"))
+	require.Equal(t, "CRUSH_MCP_GITHUB_HEADER_X_API_KEY", MCPEnvName("github", "HEADER", "X-Api-Key"))
+}
+
+func TestMCPConfig_WithEnvSecrets(t *testing.T) {
+	t.Parallel()
+
+	t.Run("headers from environment", func(t *testing.T) {
+		t.Parallel()
+
+		e := env.NewFromMap(map[string]string{
+			"CRUSH_MCP_GITHUB_HEADER_AUTHORIZATION": "Bearer from-env",
+			"CRUSH_MCP_GITHUB_HEADER_X_API_KEY":     "key-from-env",
+			"CRUSH_MCP_GITLAB_HEADER_AUTHORIZATION": "Bearer other-server",
+		})
+		m := MCPConfig{Type: MCPHttp}.WithEnvSecrets("github", e)
+		require.Equal(t, map[string]string{
+			"Authorization": "Bearer from-env",
+			"X-Api-Key":     "key-from-env",
+		}, m.Headers)
+	})
+
+	t.Run("explicit headers take precedence", func(t *testing.T) {
+		t.Parallel()
+
+		e := env.NewFromMap(map[string]string{
+			"CRUSH_MCP_GITHUB_HEADER_AUTHORIZATION": "Bearer from-env",
+			"CRUSH_MCP_GITHUB_HEADER_X_TRACE":       "trace-from-env",
+		})
+		headers := map[string]string{"authorization": "Bearer explicit"}
+		m := MCPConfig{Headers: headers}.WithEnvSecrets("github", e)
+		require.Equal(t, map[string]string{
+			"authorization": "Bearer explicit",
+			"X-Trace":       "trace-from-env",
+		}, m.Headers)
+		// The original config is left untouched.
+		require.Equal(t, map[string]string{"authorization": "Bearer explicit"}, headers)
+	})
+
+	t.Run("oauth secrets from environment", func(t *testing.T) {
+		t.Parallel()
+
+		e := env.NewFromMap(map[string]string{
+			"CRUSH_MCP_GITHUB_OAUTH_CLIENT_ID":     "id-from-env",
+			"CRUSH_MCP_GITHUB_OAUTH_CLIENT_SECRET": "secret-from-env",
+		})
+		m := MCPConfig{}.WithEnvSecrets("github", e)
+		require.NotNil(t, m.OAuth)
+		require.Equal(t, "id-from-env", m.OAuth.ClientID)
+		require.Equal(t, "secret-from-env", m.OAuth.ClientSecret)
+	})
+
+	t.Run("explicit oauth values take precedence", func(t *testing.T) {
+		t.Parallel()
+
+		e := env.NewFromMap(map[string]string{
+			"CRUSH_MCP_GITHUB_OAUTH_CLIENT_ID":     "id-from-env",
+			"CRUSH_MCP_GITHUB_OAUTH_CLIENT_SECRET": "secret-from-env",
+		})
+		oauth := &MCPOAuthConfig{ClientID: "explicit-id", Scopes: []string{"repo"}}
+		m := MCPConfig{OAuth: oauth}.WithEnvSecrets("github", e)
+		require.Equal(t, "explicit-id", m.OAuth.ClientID)
+		require.Equal(t, "secret-from-env", m.OAuth.ClientSecret)
+		require.Equal(t, []string{"repo"}, m.OAuth.Scopes)
+		// The original config is left untouched.
+		require.Empty(t, oauth.ClientSecret)
+	})
+
+	t.Run("no matching environment", func(t *testing.T) {
+		t.Parallel()
+
+		m := MCPConfig{}.WithEnvSecrets("github", env.NewFromMap(nil))
+		require.Nil(t, m.Headers)
+		require.Nil(t, m.OAuth)
+	})
+}
@@ -52,6 +52,8 @@ func NewShellVariableResolver(env env.Env) VariableResolver {
 // it will resolve shell-like variable substitution anywhere in the string, including:
 // - $(command) for command substitution
 // - $VAR or ${VAR} for environment variables
+// - ${VAR:-default} for an environment variable with a fallback when it is
+// unset or empty
 func (r *shellVariableResolver) ResolveValue(value string) (string, error) {
 	// Special case: lone $ is an error (backward compatibility)
 	if value == "$" {
@@ -153,8 +155,11 @@ func (r *shellVariableResolver) ResolveValue(value string) (string, error) {
 			varName = result[start+1 : end]
 		}
 
+		varName, fallback, hasFallback := strings.Cut(varName, ":-")
 		envValue := r.env.Get(varName)
-		if envValue == "" {
+		if envValue == "" && hasFallback {
+			envValue = fallback
+		} else if envValue == "" {
 			return "", fmt.Errorf("environment variable %q not set", varName)
 		}
 
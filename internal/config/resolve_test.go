@@ -237,6 +237,30 @@ func TestShellVariableResolver_EnhancedResolveValue(t *testing.T) {
 			value:       "$1$2$3",
 			expectError: true,
 		},
+		{
+			name:     "default used when variable is unset",
+			value:    "http://${MCP_HOST:-localhost}:${MCP_PORT:-3000}/mcp",
+			envVars:  map[string]string{},
+			expected: "http://localhost:3000/mcp",
+		},
+		{
+			name:     "variable overrides default",
+			value:    "http://${MCP_HOST:-localhost}:${MCP_PORT:-3000}/mcp",
+			envVars:  map[string]string{"MCP_HOST": "example.com", "MCP_PORT": "8080"},
+			expected: "http://example.com:8080/mcp",
+		},
+		{
+			name:     "empty default",
+			value:    "--flag=${OPTIONAL:-}",
+			envVars:  map[string]string{},
+			expected: "--flag=",
+		},
+		{
+			name:        "required variable without default",
+			value:       "${MCP_HOST:-localhost}/${REQUIRED}",
+			envVars:     map[string]string{},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
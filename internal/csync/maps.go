@@ -87,6 +87,18 @@ func (m *Map[K, V]) GetOrSet(key K, fn func() V) V {
 	return value
 }
 
+// Update sets the key to the value returned by fn, which receives the
+// current value and whether it exists, and returns it. The map is locked
+// while fn runs, so fn must not access the map itself.
+func (m *Map[K, V]) Update(key K, fn func(v V, ok bool) V) V {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.inner[key]
+	v = fn(v, ok)
+	m.inner[key] = v
+	return v
+}
+
 // Take gets an item and then deletes it.
 func (m *Map[K, V]) Take(key K) (V, bool) {
 	m.mu.Lock()
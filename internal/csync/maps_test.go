@@ -117,6 +117,32 @@ func TestMap_GetOrSet(t *testing.T) {
 	require.Equal(t, 1, m.Len())
 }
 
+func TestMap_Update(t *testing.T) {
+	t.Parallel()
+
+	m := NewMap[string, int]()
+
+	require.Equal(t, 1, m.Update("key1", func(v int, ok bool) int {
+		require.False(t, ok)
+		return v + 1
+	}))
+	require.Equal(t, 2, m.Update("key1", func(v int, ok bool) int {
+		require.True(t, ok)
+		return v + 1
+	}))
+
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Go(func() {
+			m.Update("key2", func(v int, _ bool) int { return v + 1 })
+		})
+	}
+	wg.Wait()
+
+	value, _ := m.Get("key2")
+	require.Equal(t, 100, value)
+}
+
 func TestMap_Get(t *testing.T) {
 	t.Parallel()
 
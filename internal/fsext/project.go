@@ -0,0 +1,41 @@
+package fsext
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultProjectMarkers are files or directories that mark a project root.
+var DefaultProjectMarkers = []string{".git", "go.mod", "package.json", "Cargo.toml", "pyproject.toml"}
+
+// FindProjectRoot walks up from startDir until filesystem root is reached
+// and returns the nearest directory containing one of markers. Like Lookup,
+// it skips markers owned by someone other than the owner of startDir.
+// The search includes startDir itself.
+func FindProjectRoot(startDir string, markers []string) (root string, found bool) {
+	if len(markers) == 0 {
+		return "", false
+	}
+
+	err := traverseUp(startDir, func(cwd string, owner int) error {
+		for _, marker := range markers {
+			fpath := filepath.Join(cwd, marker)
+			err := probeEnt(fpath, owner)
+			if errors.Is(err, os.ErrNotExist) ||
+				errors.Is(err, os.ErrPermission) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error probing file %s: %w", fpath, err)
+			}
+
+			root = cwd
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return root, err == nil && root != ""
+}
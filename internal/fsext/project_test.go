@@ -0,0 +1,45 @@
+package fsext
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProjectRoot(t *testing.T) {
+	t.Parallel()
+
+	// repo/.git
+	// repo/pkg/go.mod
+	// repo/pkg/sub/
+	repo := t.TempDir()
+	pkg := filepath.Join(repo, "pkg")
+	sub := filepath.Join(pkg, "sub")
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, ".git"), 0o755))
+	require.NoError(t, os.MkdirAll(sub, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(pkg, "go.mod"), nil, 0o644))
+
+	tests := []struct {
+		name    string
+		start   string
+		markers []string
+		want    string
+		found   bool
+	}{
+		{"nearest marker", sub, DefaultProjectMarkers, pkg, true},
+		{"start directory itself", pkg, DefaultProjectMarkers, pkg, true},
+		{"custom markers", sub, []string{".git"}, repo, true},
+		{"no matching marker", sub, []string{"does-not-exist"}, "", false},
+		{"no markers", sub, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			root, found := FindProjectRoot(tt.start, tt.markers)
+			require.Equal(t, tt.found, found)
+			require.Equal(t, tt.want, root)
+		})
+	}
+}
@@ -4,37 +4,88 @@ package wakatime
 import (
 	"context"
 	"encoding/json"
-	"os"
 	"path/filepath"
+	"strings"
 
 	"charm.land/fantasy"
+	"github.com/charmbracelet/crush/internal/csync"
+	"github.com/charmbracelet/crush/internal/fsext"
 )
 
-// fileTools are tool names that interact with files.
-var fileTools = map[string]bool{
-	"view":      true,
+// DefaultTools are the tool names wrapped when no tools are configured.
+var DefaultTools = []string{
+	"view",
+	"edit",
+	"multiedit",
+	"write",
+	"download",
+	"grep",
+	"glob",
+	"ls",
+}
+
+// writeTools are tool names that modify files.
+var writeTools = map[string]bool{
 	"edit":      true,
 	"multiedit": true,
 	"write":     true,
-	"grep":      true,
-	"glob":      true,
+	"download":  true,
+}
+
+// dirTools are tool names that operate on a directory and default to the
+// working directory when no path is given. Their heartbeat entity is the
+// directory itself.
+var dirTools = map[string]bool{
+	"grep": true,
+	"glob": true,
+	"ls":   true,
 }
 
 // Hook wraps fantasy tools to send WakaTime heartbeats.
 type Hook struct {
 	service    *Service
 	workingDir string
+	tools      map[string]bool
+	markers    []string
+	// roots caches the project roots found above each directory.
+	roots *csync.Map[string, []string]
 }
 
-// NewHook creates a new WakaTime hook.
+// NewHook creates a new WakaTime hook. The tools in the service config are
+// wrapped, falling back to DefaultTools when none are set, and projects are
+// detected with its ProjectMarkers, falling back to
+// fsext.DefaultProjectMarkers.
 func NewHook(service *Service, workingDir string) *Hook {
 	if service == nil {
 		return nil
 	}
+	names := service.cfg.Tools
+	if len(names) == 0 {
+		names = DefaultTools
+	}
+	tools := make(map[string]bool, len(names))
+	for _, name := range names {
+		tools[name] = true
+	}
+	markers := service.cfg.ProjectMarkers
+	if len(markers) == 0 {
+		markers = fsext.DefaultProjectMarkers
+	}
 	return &Hook{
 		service:    service,
 		workingDir: workingDir,
+		tools:      tools,
+		markers:    markers,
+		roots:      csync.NewMap[string, []string](),
+	}
+}
+
+// Close stops the underlying service, abandoning pending heartbeats.
+func (h *Hook) Close() {
+	if h == nil {
+		return
 	}
+	h.service.Close()
 }
 
 // WrapTools wraps the given tools to send WakaTime heartbeats on file operations.
@@ -45,7 +96,7 @@ func (h *Hook) WrapTools(tools []fantasy.AgentTool) []fantasy.AgentTool {
 
 	wrapped := make([]fantasy.AgentTool, len(tools))
 	for i, tool := range tools {
-		if fileTools[tool.Info().Name] {
+		if h.tools[tool.Info().Name] {
 			wrapped[i] = &wrappedTool{
 				AgentTool:  tool,
 				hook:       h,
@@ -70,21 +121,52 @@ func (w *wrappedTool) Run(ctx context.Context, call fantasy.ToolCall) (fantasy.T
 	result, err := w.AgentTool.Run(ctx, call)
 
 	// Extract file path from params and send heartbeat.
+	toolName := w.AgentTool.Info().Name
 	filePath := extractFilePath(call.Input, w.workingDir)
+	if filePath == "" && dirTools[toolName] {
+		filePath = w.workingDir
+	}
 	if filePath != "" {
-		toolName := w.AgentTool.Info().Name
-		isWrite := toolName == "edit" || toolName == "multiedit" || toolName == "write"
-
 		w.hook.service.SendHeartbeat(ctx, Heartbeat{
 			FilePath: filePath,
-			IsWrite:  isWrite,
-			Project:  detectProject(filePath),
+			IsWrite:  writeTools[toolName] && modified(result, err),
+			Category: w.hook.service.cfg.CategoryByTool[toolName],
+			Project:  w.hook.detectProject(filePath),
 		})
 	}
 
 	return result, err
 }
 
+// modified reports whether a write tool's result indicates that it changed a
+// file. Failed calls and no-op edits, which the tools report as errors, don't
+// count, nor do results whose metadata shows no applied edits or no changed
+// lines.
+func modified(result fantasy.ToolResponse, err error) bool {
+	if err != nil || result.IsError {
+		return false
+	}
+	if result.Metadata == "" {
+		return true
+	}
+
+	var meta struct {
+		Additions    *int `json:"additions"`
+		Removals     *int `json:"removals"`
+		EditsApplied *int `json:"edits_applied"`
+	}
+	if json.Unmarshal([]byte(result.Metadata), &meta) != nil {
+		return true
+	}
+	if meta.EditsApplied != nil && *meta.EditsApplied == 0 {
+		return false
+	}
+	if meta.Additions != nil && meta.Removals != nil && *meta.Additions == 0 && *meta.Removals == 0 {
+		return false
+	}
+	return true
+}
+
 // extractFilePath extracts the file path from tool parameters.
 func extractFilePath(params string, workingDir string) string {
 	// Parse JSON to extract file path.
@@ -93,45 +175,89 @@ func extractFilePath(params string, workingDir string) string {
 		return ""
 	}
 
-	// Try file_path first (view, edit, multiedit, write).
+	// Try file_path first (view, edit, multiedit, write, download).
 	if path, ok := data["file_path"].(string); ok && path != "" {
 		if !filepath.IsAbs(path) && workingDir != "" {
 			path = filepath.Join(workingDir, path)
 		}
-		return path
+		return filepath.Clean(path)
 	}
 
-	// Try path (grep, glob).
+	// Try path (grep, glob, ls). Cleaning the path lets equivalent spellings
+	// of the same directory share a throttle window.
 	if path, ok := data["path"].(string); ok && path != "" {
 		if !filepath.IsAbs(path) && workingDir != "" {
 			path = filepath.Join(workingDir, path)
 		}
-		return path
+		return filepath.Clean(path)
 	}
 
 	return ""
 }
 
-// detectProject attempts to detect the project name from a file path.
-func detectProject(filePath string) string {
-	// Walk up directories looking for common project markers.
+// detectProject attempts to detect the project name from a file path using
+// the given strategy and project markers. depth is only used by
+// ProjectStrategyDepth.
+func detectProject(filePath string, markers []string, strategy ProjectStrategy, depth int) string {
+	roots := projectRoots(filepath.Dir(filePath), markers, needsAllRoots(strategy))
+	return projectFromRoots(filePath, roots, strategy, depth)
+}
+
+// detectProject is the package-level detectProject with the hook's markers
+// and strategy, looking up the project roots of each directory only once.
+func (h *Hook) detectProject(filePath string) string {
+	strategy := h.service.cfg.ProjectStrategy
 	dir := filepath.Dir(filePath)
-	markers := []string{".git", "go.mod", "package.json", "Cargo.toml", "pyproject.toml"}
+	roots := h.roots.GetOrSet(dir, func() []string {
+		return projectRoots(dir, h.markers, needsAllRoots(strategy))
+	})
+	return projectFromRoots(filePath, roots, strategy, h.service.cfg.ProjectDepth)
+}
+
+// needsAllRoots reports whether strategy looks beyond the nearest project
+// root.
+func needsAllRoots(strategy ProjectStrategy) bool {
+	return strategy == ProjectStrategyTopmost || strategy == ProjectStrategyDepth
+}
 
+// projectFromRoots picks the project name of filePath from the project roots
+// above it, nearest first, using the given strategy.
+func projectFromRoots(filePath string, roots []string, strategy ProjectStrategy, depth int) string {
+	if len(roots) == 0 {
+		// Fall back to parent directory name.
+		return filepath.Base(filepath.Dir(filePath))
+	}
+
+	switch strategy {
+	case ProjectStrategyTopmost:
+		return filepath.Base(roots[len(roots)-1])
+	case ProjectStrategyDepth:
+		root := roots[len(roots)-1]
+		rel, err := filepath.Rel(root, filepath.Dir(filePath))
+		if err != nil || rel == "." || depth <= 0 {
+			return filepath.Base(root)
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		return parts[min(depth, len(parts))-1]
+	default:
+		return filepath.Base(roots[0])
+	}
+}
+
+// projectRoots returns the directories at or above dir that contain one of
+// markers, nearest first. Unless all is set, it stops at the nearest one.
+func projectRoots(dir string, markers []string, all bool) []string {
+	var roots []string
 	for {
-		parent := filepath.Dir(dir)
-		// Stop at filesystem root (Unix: /, Windows: C:\, etc.).
-		if parent == dir || dir == "." {
-			break
+		root, ok := fsext.FindProjectRoot(dir, markers)
+		if !ok {
+			return roots
 		}
-		for _, marker := range markers {
-			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
-				return filepath.Base(dir)
-			}
+		roots = append(roots, root)
+		parent := filepath.Dir(root)
+		if !all || parent == root {
+			return roots
 		}
 		dir = parent
 	}
-
-	// Fall back to parent directory name.
-	return filepath.Base(filepath.Dir(filePath))
 }
@@ -0,0 +1,195 @@
+package wakatime
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/crush/internal/version"
+)
+
+// DefaultAPIURL is the base URL of the public WakaTime API.
+const DefaultAPIURL = "https://api.wakatime.com/api/v1"
+
+// userAgent identifies Crush as the plugin sending heartbeats.
+var userAgent = "crush/" + version.Version + " crush-wakatime/1.0.0"
+
+// Sender delivers heartbeats to WakaTime.
+type Sender interface {
+	Send(ctx context.Context, h Heartbeat) error
+}
+
+// BatchSender is implemented by senders that can deliver several heartbeats
+// at once.
+type BatchSender interface {
+	Sender
+	SendBatch(ctx context.Context, batch []Heartbeat) error
+}
+
+// newSender returns the sender for a validated config: cfg.Sender if set,
+// an APISender in direct mode, and a CLISender otherwise.
+func newSender(cfg Config) (Sender, error) {
+	if cfg.Sender != nil {
+		return cfg.Sender, nil
+	}
+	if cfg.Direct {
+		apiURL := cfg.APIURL
+		if apiURL == "" {
+			apiURL = DefaultAPIURL
+		}
+		return &APISender{URL: apiURL, APIKey: cfg.APIKey}, nil
+	}
+
+	cliPath := cfg.CLIPath
+	if cliPath == "" {
+		var err error
+		cliPath, err = findCLI()
+		if err != nil {
+			return nil, fmt.Errorf("wakatime-cli not found, set wakatime cli_path or enable direct: %w", err)
+		}
+	}
+	return &CLISender{Path: cliPath, APIKey: cfg.APIKey, APIURL: cfg.APIURL}, nil
+}
+
+// CLISender sends heartbeats by running wakatime-cli. Without an APIKey or
+// APIURL the CLI reads them from ~/.wakatime.cfg.
+type CLISender struct {
+	Path   string
+	APIKey string
+	APIURL string
+}
+
+// Send runs wakatime-cli for a heartbeat.
+func (c *CLISender) Send(ctx context.Context, h Heartbeat) error {
+	return exec.CommandContext(ctx, c.Path, c.args(h)...).Run()
+}
+
+// SendBatch runs wakatime-cli once for several heartbeats, passing all but
+// the first as --extra-heartbeats on stdin.
+func (c *CLISender) SendBatch(ctx context.Context, batch []Heartbeat) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	extra := make([]map[string]any, 0, len(batch)-1)
+	for _, h := range batch[1:] {
+		extra = append(extra, payload(h))
+	}
+	stdin, err := json.Marshal(extra)
+	if err != nil {
+		return fmt.Errorf("failed to encode heartbeats: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path, append(c.args(batch[0]), "--extra-heartbeats")...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	return cmd.Run()
+}
+
+// String describes the sender without its API key.
+func (c *CLISender) String() string {
+	return "cli " + c.Path
+}
+
+// args returns the wakatime-cli arguments for a heartbeat.
+func (c *CLISender) args(h Heartbeat) []string {
+	args := []string{
+		"--entity", h.FilePath,
+		"--category", h.Category,
+		"--plugin", userAgent,
+	}
+
+	if h.IsWrite {
+		args = append(args, "--write")
+	}
+
+	if h.Project != "" {
+		args = append(args, "--project", h.Project)
+	}
+
+	if h.Hostname != "" {
+		args = append(args, "--hostname", h.Hostname)
+	}
+
+	if !h.Time.IsZero() {
+		args = append(args, "--time", strconv.FormatFloat(float64(h.Time.UnixMilli())/1000, 'f', 3, 64))
+	}
+
+	if c.APIKey != "" {
+		args = append(args, "--key", c.APIKey)
+	}
+
+	if c.APIURL != "" {
+		args = append(args, "--api-url", c.APIURL)
+	}
+	return args
+}
+
+// APISender posts heartbeats directly to a WakaTime-compatible API, for
+// setups without wakatime-cli.
+type APISender struct {
+	// URL is the API base URL, e.g. DefaultAPIURL.
+	URL    string
+	APIKey string
+	// Client is used for requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Send posts a heartbeat to the API's /users/current/heartbeats endpoint.
+func (a *APISender) Send(ctx context.Context, h Heartbeat) error {
+	body, err := json.Marshal(payload(h))
+	if err != nil {
+		return fmt.Errorf("failed to encode heartbeat: %w", err)
+	}
+
+	endpoint := strings.TrimSuffix(a.URL, "/") + "/users/current/heartbeats"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create heartbeat request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(a.APIKey)))
+	if h.Hostname != "" {
+		req.Header.Set("X-Machine-Name", h.Hostname)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("heartbeat rejected: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// String describes the sender without its API key.
+func (a *APISender) String() string {
+	return "api " + a.URL
+}
+
+// payload returns the JSON form of a heartbeat used by the WakaTime API and
+// wakatime-cli's --extra-heartbeats.
+func payload(h Heartbeat) map[string]any {
+	return map[string]any{
+		"entity":   h.FilePath,
+		"type":     "file",
+		"category": h.Category,
+		"time":     float64(h.Time.UnixMilli()) / 1000,
+		"is_write": h.IsWrite,
+		"project":  h.Project,
+	}
+}
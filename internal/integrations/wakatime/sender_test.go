@@ -0,0 +1,154 @@
+package wakatime
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLISender_Args(t *testing.T) {
+	t.Parallel()
+
+	sender := &CLISender{Path: "wakatime-cli", APIKey: "key", APIURL: "https://wakapi.example.com/api"}
+	h := Heartbeat{
+		FilePath: "/test/file.go",
+		IsWrite:  true,
+		Project:  "test",
+		Category: DefaultCategory,
+		Hostname: "work-laptop",
+		Time:     time.UnixMilli(1_700_000_000_500),
+	}
+
+	args := sender.args(h)
+	flag := func(name string) string {
+		i := slices.Index(args, name)
+		require.GreaterOrEqual(t, i, 0, name)
+		return args[i+1]
+	}
+	require.Equal(t, "/test/file.go", flag("--entity"))
+	require.Equal(t, DefaultCategory, flag("--category"))
+	require.Equal(t, "test", flag("--project"))
+	require.Equal(t, "work-laptop", flag("--hostname"))
+	require.Equal(t, "1700000000.500", flag("--time"))
+	require.Equal(t, "key", flag("--key"))
+	require.Equal(t, "https://wakapi.example.com/api", flag("--api-url"))
+	require.Contains(t, args, "--write")
+
+	h.Hostname = ""
+	require.NotContains(t, sender.args(h), "--hostname")
+	sender.APIURL = ""
+	require.NotContains(t, sender.args(h), "--api-url")
+}
+
+func TestNewSender(t *testing.T) {
+	t.Parallel()
+
+	sender, err := newSender(Config{Direct: true, APIKey: "key"})
+	require.NoError(t, err)
+	require.Equal(t, &APISender{URL: DefaultAPIURL, APIKey: "key"}, sender)
+
+	sender, err = newSender(Config{Direct: true, APIKey: "key", APIURL: "https://wakapi.example.com/api"})
+	require.NoError(t, err)
+	require.Equal(t, "https://wakapi.example.com/api", sender.(*APISender).URL)
+
+	sender, err = newSender(Config{CLIPath: "/usr/bin/wakatime-cli", APIURL: "https://wakapi.example.com/api"})
+	require.NoError(t, err)
+	require.Equal(t, &CLISender{Path: "/usr/bin/wakatime-cli", APIURL: "https://wakapi.example.com/api"}, sender)
+}
+
+func TestCLISender_SendBatch(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the wakatime CLI")
+	}
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	stdinFile := filepath.Join(dir, "stdin")
+	cli := filepath.Join(dir, "wakatime-cli")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\ncat > " + stdinFile + "\n"
+	require.NoError(t, os.WriteFile(cli, []byte(script), 0o755))
+
+	sender := &CLISender{Path: cli}
+	err := sender.SendBatch(t.Context(), []Heartbeat{
+		{FilePath: "/test/a.go", Category: DefaultCategory},
+		{FilePath: "/test/b.go", Category: DefaultCategory, IsWrite: true},
+		{FilePath: "/test/c.go", Category: DefaultCategory},
+	})
+	require.NoError(t, err)
+
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	require.Contains(t, string(args), "--entity /test/a.go")
+	require.Contains(t, string(args), "--extra-heartbeats")
+
+	stdin, err := os.ReadFile(stdinFile)
+	require.NoError(t, err)
+	var extra []map[string]any
+	require.NoError(t, json.Unmarshal(stdin, &extra))
+	require.Len(t, extra, 2)
+	require.Equal(t, "/test/b.go", extra[0]["entity"])
+	require.Equal(t, true, extra[0]["is_write"])
+	require.Equal(t, "/test/c.go", extra[1]["entity"])
+}
+
+func TestAPISender_Send(t *testing.T) {
+	t.Parallel()
+
+	var (
+		got  map[string]any
+		auth string
+		host string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/api/v1/users/current/heartbeats", r.URL.Path)
+		auth = r.Header.Get("Authorization")
+		host = r.Header.Get("X-Machine-Name")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+
+	sender := &APISender{URL: server.URL + "/api/v1/", APIKey: "key"}
+	err := sender.Send(t.Context(), Heartbeat{
+		FilePath: "/test/file.go",
+		IsWrite:  true,
+		Project:  "test",
+		Category: DefaultCategory,
+		Hostname: "work-laptop",
+		Time:     time.Unix(1_700_000_000, 0),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("key")), auth)
+	require.Equal(t, "work-laptop", host)
+	require.Equal(t, "/test/file.go", got["entity"])
+	require.Equal(t, "file", got["type"])
+	require.Equal(t, DefaultCategory, got["category"])
+	require.Equal(t, "test", got["project"])
+	require.Equal(t, true, got["is_write"])
+	require.InDelta(t, 1_700_000_000, got["time"], 0.001)
+}
+
+func TestAPISender_Rejected(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+
+	sender := &APISender{URL: server.URL, APIKey: "bad"}
+	err := sender.Send(t.Context(), Heartbeat{FilePath: "/test/file.go"})
+	require.ErrorContains(t, err, "status 401")
+}
@@ -3,15 +3,18 @@ package wakatime
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/charmbracelet/crush/internal/version"
 )
 
 const (
@@ -20,6 +23,19 @@ const (
 
 	// heartbeatThreshold is the minimum time between heartbeats for the same file.
 	heartbeatThreshold = 2 * time.Minute
+
+	// sendTimeout bounds sending a single heartbeat.
+	sendTimeout = 10 * time.Second
+
+	// closeTimeout is how long Close waits for in-flight heartbeats.
+	closeTimeout = 2 * time.Second
+
+	// flushTimeout bounds sending the heartbeats still buffered on Close.
+	flushTimeout = time.Second
+
+	// batchWindow is how long heartbeats are collected before they are sent
+	// together, so bursts of tool calls don't spawn a process each.
+	batchWindow = 250 * time.Millisecond
 )
 
 // Config holds WakaTime configuration.
@@ -28,33 +44,130 @@ type Config struct {
 	APIKey   string
 	Category string
 	CLIPath  string
+	// Hostname is the machine name heartbeats are tagged with. Defaults to
+	// os.Hostname() when empty.
+	Hostname string
+	// Tools lists the tool names that send heartbeats. Defaults to
+	// DefaultTools when empty.
+	Tools []string
+	// CategoryByTool maps tool names to the category of their heartbeats,
+	// e.g. "view" to "code reviewing". Tools without an entry use Category.
+	CategoryByTool map[string]string
+	// ProjectMarkers are the files or directories that mark a project root.
+	// Defaults to fsext.DefaultProjectMarkers when empty.
+	ProjectMarkers []string
+	// ProjectStrategy selects how the project is derived from a file path.
+	// Defaults to ProjectStrategyNearest.
+	ProjectStrategy ProjectStrategy
+	// ProjectDepth is the number of directories below the top-most project
+	// root used as the project with ProjectStrategyDepth.
+	ProjectDepth int
+	// APIURL is the base URL of a WakaTime-compatible API such as a
+	// self-hosted Wakapi instance. Defaults to DefaultAPIURL.
+	APIURL string
+	// Direct posts heartbeats to the API without wakatime-cli. APIKey is
+	// required.
+	Direct bool
+	// Sender sends heartbeats. When set, it overrides both wakatime-cli and
+	// Direct.
+	Sender Sender
 }
 
+// Validate reports configuration errors of an enabled config: a CLIPath that
+// doesn't exist or isn't an executable file, an unknown ProjectStrategy, or
+// a negative ProjectDepth. A disabled config is always valid.
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.CLIPath != "" {
+		info, err := os.Stat(c.CLIPath)
+		if err != nil {
+			return fmt.Errorf("invalid wakatime cli_path: %w", err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("invalid wakatime cli_path %q: is a directory", c.CLIPath)
+		}
+		if !isExecutable(c.CLIPath) {
+			return fmt.Errorf("invalid wakatime cli_path %q: not executable", c.CLIPath)
+		}
+	}
+
+	switch c.ProjectStrategy {
+	case "", ProjectStrategyNearest, ProjectStrategyTopmost, ProjectStrategyDepth:
+	default:
+		return fmt.Errorf("invalid wakatime project_strategy %q: must be nearest, topmost or depth", c.ProjectStrategy)
+	}
+
+	if c.ProjectDepth < 0 {
+		return errors.New("invalid wakatime project_depth: must not be negative")
+	}
+
+	if c.APIURL != "" {
+		u, err := url.Parse(c.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid wakatime api_url %q: must be an http or https URL", c.APIURL)
+		}
+	}
+	if c.Direct && c.APIKey == "" {
+		return errors.New("wakatime api_key is required with direct")
+	}
+	return nil
+}
+
+// ProjectStrategy selects how a heartbeat's project is detected.
+type ProjectStrategy string
+
+const (
+	// ProjectStrategyNearest uses the nearest directory containing a project
+	// marker.
+	ProjectStrategyNearest ProjectStrategy = "nearest"
+	// ProjectStrategyTopmost uses the top-most directory containing a project
+	// marker, e.g. the root of a monorepo.
+	ProjectStrategyTopmost ProjectStrategy = "topmost"
+	// ProjectStrategyDepth uses the directory ProjectDepth levels below the
+	// top-most project root, e.g. depth 2 maps root/services/api/main.go to
+	// "api".
+	ProjectStrategyDepth ProjectStrategy = "depth"
+)
+
 // Service manages WakaTime heartbeat tracking.
 type Service struct {
 	cfg      Config
-	cliPath  string
+	sender   Sender
 	category string
+	hostname string
 
 	mu             sync.RWMutex
 	lastHeartbeats map[string]time.Time
+
+	// batchMu guards the heartbeats waiting for the next flush.
+	batchMu    sync.Mutex
+	pending    []Heartbeat
+	flushTimer *time.Timer
+	closed     bool
+
+	// ctx is cancelled by Close to abandon pending heartbeats.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
-// New creates a new WakaTime service. Returns (nil, nil) if disabled or CLI not found,
-// which allows callers to safely skip initialization without error handling.
+// New creates a new WakaTime service. Returns (nil, nil) if disabled, which
+// allows callers to safely skip initialization. An enabled config that fails
+// Validate, or whose CLI can't be found, returns an error.
 func New(cfg Config) (*Service, error) {
 	if !cfg.Enabled {
 		return nil, nil
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
-	cliPath := cfg.CLIPath
-	if cliPath == "" {
-		var err error
-		cliPath, err = findCLI()
-		if err != nil {
-			slog.Warn("WakaTime CLI not found, integration disabled", "error", err)
-			return nil, nil
-		}
+	sender, err := newSender(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	category := cfg.Category
@@ -62,26 +175,87 @@ func New(cfg Config) (*Service, error) {
 		category = DefaultCategory
 	}
 
-	slog.Info("WakaTime integration enabled", "cli", cliPath, "category", category)
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	slog.Info("WakaTime integration enabled", "sender", sender, "category", category)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Service{
 		cfg:            cfg,
-		cliPath:        cliPath,
+		sender:         sender,
 		category:       category,
+		hostname:       hostname,
 		lastHeartbeats: make(map[string]time.Time),
+		ctx:            ctx,
+		cancel:         cancel,
 	}, nil
 }
 
+// Close sends the buffered heartbeats, abandons in-flight sends and waits
+// briefly for them to finish. Heartbeats sent after Close are dropped, and
+// closing again does nothing.
+func (s *Service) Close() {
+	if s == nil {
+		return
+	}
+
+	// Sends are only started under batchMu while the service is open, so
+	// none can be added once the wait below begins.
+	s.batchMu.Lock()
+	if s.closed {
+		s.batchMu.Unlock()
+		return
+	}
+	s.closed = true
+	s.cancel()
+	if s.flushTimer != nil && s.flushTimer.Stop() {
+		s.wg.Done()
+	}
+	s.flushTimer = nil
+	if batch := s.pending; len(batch) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		s.wg.Go(func() {
+			defer cancel()
+			s.send(ctx, batch)
+		})
+	}
+	s.pending = nil
+	s.batchMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		slog.Debug("Timed out waiting for WakaTime heartbeats to finish")
+	}
+}
+
 // Heartbeat represents a file activity event.
 type Heartbeat struct {
 	FilePath string
 	IsWrite  bool
 	Project  string
+
+	// Category is the activity category. The Service uses its configured
+	// category when empty.
+	Category string
+
+	// Hostname and Time are filled in by the Service before the heartbeat is
+	// handed to its Sender.
+	Hostname string
+	Time     time.Time
 }
 
 // SendHeartbeat sends a heartbeat to WakaTime if appropriate.
 func (s *Service) SendHeartbeat(ctx context.Context, h Heartbeat) {
-	if s == nil {
+	if s == nil || s.ctx.Err() != nil {
 		return
 	}
 
@@ -90,9 +264,44 @@ func (s *Service) SendHeartbeat(ctx context.Context, h Heartbeat) {
 	}
 
 	s.recordHeartbeat(h.FilePath)
+	if h.Category == "" {
+		h.Category = s.category
+	}
+	h.Hostname = s.hostname
+	h.Time = time.Now()
+	s.enqueue(h)
+}
 
-	// Run in background to avoid blocking.
-	go s.send(h)
+// enqueue buffers a heartbeat until the next flush, scheduling one if none
+// is pending.
+func (s *Service) enqueue(h Heartbeat) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.pending = append(s.pending, h)
+	if s.flushTimer == nil {
+		s.wg.Add(1)
+		s.flushTimer = time.AfterFunc(batchWindow, s.flush)
+	}
+}
+
+// flush sends the buffered heartbeats. It runs in the background to avoid
+// blocking, tied to the service context rather than the tool call's.
+func (s *Service) flush() {
+	defer s.wg.Done()
+
+	s.batchMu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.flushTimer = nil
+	s.batchMu.Unlock()
+
+	if len(batch) > 0 {
+		s.send(s.ctx, batch)
+	}
 }
 
 // shouldSend determines if a heartbeat should be sent based on throttling rules.
@@ -120,33 +329,22 @@ func (s *Service) recordHeartbeat(filePath string) {
 	s.mu.Unlock()
 }
 
-// send executes wakatime-cli to send a heartbeat.
-func (s *Service) send(h Heartbeat) {
-	args := []string{
-		"--entity", h.FilePath,
-		"--category", s.category,
-		"--plugin", "crush/" + version.Version + " crush-wakatime/1.0.0",
-	}
-
-	if h.IsWrite {
-		args = append(args, "--write")
-	}
-
-	if h.Project != "" {
-		args = append(args, "--project", h.Project)
-	}
-
-	if s.cfg.APIKey != "" {
-		args = append(args, "--key", s.cfg.APIKey)
-	}
-
-	// Use a short timeout context for the CLI call.
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// send hands a batch of heartbeats to the sender, in one call if it is a
+// BatchSender.
+func (s *Service) send(ctx context.Context, batch []Heartbeat) {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, s.cliPath, args...)
-	if err := cmd.Run(); err != nil {
-		slog.Debug("WakaTime heartbeat failed", "error", err, "file", h.FilePath)
+	if bs, ok := s.sender.(BatchSender); ok && len(batch) > 1 {
+		if err := bs.SendBatch(ctx, batch); err != nil {
+			slog.Debug("WakaTime heartbeats failed", "error", err, "count", len(batch))
+		}
+		return
+	}
+	for _, h := range batch {
+		if err := s.sender.Send(ctx, h); err != nil {
+			slog.Debug("WakaTime heartbeat failed", "error", err, "file", h.FilePath)
+		}
 	}
 }
 
@@ -155,22 +353,13 @@ func findCLI() (string, error) {
 	// Check ~/.wakatime/ directory first.
 	home, err := os.UserHomeDir()
 	if err == nil {
-		wakatimeDir := filepath.Join(home, ".wakatime")
-		entries, err := os.ReadDir(wakatimeDir)
-		if err == nil {
-			for _, entry := range entries {
-				name := entry.Name()
-				if strings.HasPrefix(name, "wakatime-cli") && !entry.IsDir() {
-					path := filepath.Join(wakatimeDir, name)
-					if isExecutable(path) {
-						return path, nil
-					}
-				}
-			}
+		if path, ok := cliInDir(filepath.Join(home, ".wakatime"), executableExts(runtime.GOOS)); ok {
+			return path, nil
 		}
 	}
 
-	// Fall back to PATH.
+	// Fall back to PATH. On Windows, LookPath also tries the PATHEXT
+	// extensions, e.g. wakatime-cli.exe.
 	path, err := exec.LookPath("wakatime-cli")
 	if err == nil {
 		return path, nil
@@ -185,11 +374,67 @@ func findCLI() (string, error) {
 	return "", err
 }
 
-// isExecutable checks if a file is executable.
+// cliInDir returns the first executable file in dir named like wakatime-cli,
+// e.g. wakatime-cli-linux-amd64 or wakatime-cli-windows-amd64.exe. exts is
+// as for isExecutableFile.
+func cliInDir(dir string, exts []string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "wakatime-cli") || entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if isExecutableFile(path, exts) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// isExecutable checks if a file is executable on the current platform.
 func isExecutable(path string) bool {
+	return isExecutableFile(path, executableExts(runtime.GOOS))
+}
+
+// isExecutableFile checks if path is a file that can be executed. With exts
+// set, as on Windows, executability is decided by the file extension alone;
+// otherwise by the mode's execute bits.
+func isExecutableFile(path string, exts []string) bool {
 	info, err := os.Stat(path)
-	if err != nil {
+	if err != nil || info.IsDir() {
 		return false
 	}
-	return info.Mode()&0o111 != 0
+	if exts == nil {
+		return info.Mode()&0o111 != 0
+	}
+	ext := filepath.Ext(path)
+	return slices.ContainsFunc(exts, func(e string) bool {
+		return strings.EqualFold(e, ext)
+	})
+}
+
+// executableExts returns the extensions of executable files on goos: the
+// PATHEXT entries plus .exe, .bat and .cmd on Windows, and nil elsewhere,
+// where mode bits apply.
+func executableExts(goos string) []string {
+	if goos != "windows" {
+		return nil
+	}
+	exts := []string{".exe", ".bat", ".cmd"}
+	for ext := range strings.SplitSeq(os.Getenv("PATHEXT"), ";") {
+		if ext = strings.TrimSpace(ext); ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext = strings.ToLower(ext); !slices.Contains(exts, ext) {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
 }
@@ -2,9 +2,16 @@ package wakatime
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
+	"charm.land/fantasy"
+	"github.com/charmbracelet/crush/internal/fsext"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,6 +23,206 @@ func TestNew_DisabledReturnsNil(t *testing.T) {
 	require.Nil(t, svc)
 }
 
+func TestNew_InvalidCLIPath(t *testing.T) {
+	t.Parallel()
+
+	svc, err := New(Config{Enabled: true, CLIPath: filepath.Join(t.TempDir(), "missing")})
+	require.ErrorContains(t, err, "invalid wakatime cli_path")
+	require.Nil(t, svc)
+}
+
+func TestExecutableExts(t *testing.T) {
+	t.Setenv("PATHEXT", ".COM;.EXE; .PS1;;VBS")
+
+	require.Nil(t, executableExts("linux"))
+	require.Equal(t, []string{".exe", ".bat", ".cmd", ".com", ".ps1", ".vbs"}, executableExts("windows"))
+}
+
+func TestIsExecutableFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name string, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, nil, mode))
+		require.NoError(t, os.Chmod(path, mode))
+		return path
+	}
+	winExts := []string{".exe", ".bat", ".cmd"}
+
+	t.Run("windows extensions ignore mode bits", func(t *testing.T) {
+		t.Parallel()
+		require.True(t, isExecutableFile(write("cli.exe", 0o644), winExts))
+		require.True(t, isExecutableFile(write("cli.CMD", 0o644), winExts))
+		require.False(t, isExecutableFile(write("cli.zip", 0o755), winExts))
+		require.False(t, isExecutableFile(dir, winExts))
+	})
+
+	t.Run("unix mode bits", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("relies on Unix permission bits")
+		}
+		require.True(t, isExecutableFile(write("cli", 0o755), nil))
+		require.False(t, isExecutableFile(write("cli.bat", 0o644), nil))
+		require.False(t, isExecutableFile(dir, nil))
+	})
+}
+
+func TestCLIInDir(t *testing.T) {
+	t.Parallel()
+
+	// A ~/.wakatime directory as laid out by the Windows plugins.
+	dir := t.TempDir()
+	for _, name := range []string{"wakatime-cli-windows-amd64.zip", "wakatime-cli-windows-amd64.exe", "other.exe"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "wakatime-cli.exe.d"), 0o755))
+
+	path, ok := cliInDir(dir, []string{".exe"})
+	require.True(t, ok)
+	require.Equal(t, filepath.Join(dir, "wakatime-cli-windows-amd64.exe"), path)
+
+	_, ok = cliInDir(dir, []string{".bat"})
+	require.False(t, ok)
+
+	_, ok = cliInDir(filepath.Join(dir, "missing"), []string{".exe"})
+	require.False(t, ok)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on Unix permission bits")
+	}
+
+	dir := t.TempDir()
+	cli := filepath.Join(dir, "wakatime-cli")
+	require.NoError(t, os.WriteFile(cli, []byte("#!/bin/sh\n"), 0o755))
+	notExec := filepath.Join(dir, "not-executable")
+	require.NoError(t, os.WriteFile(notExec, nil, 0o644))
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"disabled with bogus path", Config{CLIPath: "/does/not/exist"}, ""},
+		{"enabled without path", Config{Enabled: true}, ""},
+		{"executable path", Config{Enabled: true, CLIPath: cli}, ""},
+		{"missing path", Config{Enabled: true, CLIPath: filepath.Join(dir, "missing")}, "invalid wakatime cli_path"},
+		{"directory", Config{Enabled: true, CLIPath: dir}, "is a directory"},
+		{"not executable", Config{Enabled: true, CLIPath: notExec}, "not executable"},
+		{"known strategy", Config{Enabled: true, ProjectStrategy: ProjectStrategyDepth, ProjectDepth: 2}, ""},
+		{"unknown strategy", Config{Enabled: true, ProjectStrategy: "deepest"}, "invalid wakatime project_strategy"},
+		{"negative depth", Config{Enabled: true, ProjectDepth: -1}, "invalid wakatime project_depth"},
+		{"api url", Config{Enabled: true, APIURL: "https://wakapi.example.com/api"}, ""},
+		{"invalid api url", Config{Enabled: true, APIURL: "wakapi.example.com"}, "invalid wakatime api_url"},
+		{"direct with key", Config{Enabled: true, Direct: true, APIKey: "key"}, ""},
+		{"direct without key", Config{Enabled: true, Direct: true}, "api_key is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+// fakeSender records the heartbeats it is given.
+type fakeSender struct {
+	sent chan Heartbeat
+}
+
+func (f *fakeSender) Send(_ context.Context, h Heartbeat) error {
+	f.sent <- h
+	return nil
+}
+
+func TestService_SendHeartbeat_UsesSender(t *testing.T) {
+	t.Parallel()
+
+	sender := &fakeSender{sent: make(chan Heartbeat, 1)}
+	svc, err := New(Config{Enabled: true, Hostname: "work-laptop", Sender: sender})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	svc.SendHeartbeat(t.Context(), Heartbeat{FilePath: "/test/file.go", IsWrite: true, Project: "test"})
+
+	select {
+	case h := <-sender.sent:
+		require.Equal(t, "/test/file.go", h.FilePath)
+		require.True(t, h.IsWrite)
+		require.Equal(t, "test", h.Project)
+		require.Equal(t, DefaultCategory, h.Category)
+		require.Equal(t, "work-laptop", h.Hostname)
+		require.False(t, h.Time.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat not sent")
+	}
+}
+
+// fakeBatchSender records the batches it is given.
+type fakeBatchSender struct {
+	fakeSender
+	batches chan []Heartbeat
+}
+
+func (f *fakeBatchSender) SendBatch(_ context.Context, batch []Heartbeat) error {
+	f.batches <- batch
+	return nil
+}
+
+func TestService_BatchesHeartbeats(t *testing.T) {
+	t.Parallel()
+
+	sender := &fakeBatchSender{
+		fakeSender: fakeSender{sent: make(chan Heartbeat, 3)},
+		batches:    make(chan []Heartbeat, 1),
+	}
+	svc, err := New(Config{Enabled: true, Sender: sender})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	for _, file := range []string{"/test/a.go", "/test/b.go", "/test/c.go"} {
+		svc.SendHeartbeat(t.Context(), Heartbeat{FilePath: file, IsWrite: true})
+	}
+
+	select {
+	case batch := <-sender.batches:
+		require.Len(t, batch, 3)
+		require.Equal(t, "/test/a.go", batch[0].FilePath)
+		require.Equal(t, "/test/c.go", batch[2].FilePath)
+	case <-time.After(time.Second):
+		t.Fatal("batch not sent")
+	}
+	require.Empty(t, sender.sent)
+}
+
+func TestService_Close_FlushesPending(t *testing.T) {
+	t.Parallel()
+
+	sender := &fakeSender{sent: make(chan Heartbeat, 1)}
+	svc, err := New(Config{Enabled: true, Sender: sender})
+	require.NoError(t, err)
+
+	svc.SendHeartbeat(t.Context(), Heartbeat{FilePath: "/test/file.go", IsWrite: true})
+	svc.Close()
+
+	select {
+	case h := <-sender.sent:
+		require.Equal(t, "/test/file.go", h.FilePath)
+	default:
+		t.Fatal("pending heartbeat not flushed on Close")
+	}
+}
+
 func TestService_SendHeartbeat_NilSafe(t *testing.T) {
 	t.Parallel()
 
@@ -27,6 +234,62 @@ func TestService_SendHeartbeat_NilSafe(t *testing.T) {
 	})
 }
 
+func TestService_Close_AbandonsPendingSends(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the wakatime CLI")
+	}
+
+	cli := filepath.Join(t.TempDir(), "wakatime-cli")
+	require.NoError(t, os.WriteFile(cli, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755))
+
+	svc, err := New(Config{Enabled: true, CLIPath: cli})
+	require.NoError(t, err)
+
+	svc.SendHeartbeat(context.Background(), Heartbeat{FilePath: "/test/file.go", IsWrite: true})
+
+	start := time.Now()
+	svc.Close()
+	require.Less(t, time.Since(start), closeTimeout)
+
+	// Heartbeats after Close are dropped.
+	svc.SendHeartbeat(context.Background(), Heartbeat{FilePath: "/test/other.go", IsWrite: true})
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	require.NotContains(t, svc.lastHeartbeats, "/test/other.go")
+}
+
+func TestService_Close_ConcurrentSends(t *testing.T) {
+	t.Parallel()
+
+	sender := &fakeSender{sent: make(chan Heartbeat, 100)}
+	svc, err := New(Config{Enabled: true, Sender: sender})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			svc.SendHeartbeat(context.Background(), Heartbeat{FilePath: fmt.Sprintf("/test/%d.go", i), IsWrite: true})
+		})
+	}
+	wg.Go(svc.Close)
+	wg.Go(svc.Close)
+	wg.Wait()
+
+	// Nothing is accepted after Close.
+	sent := len(sender.sent)
+	svc.SendHeartbeat(context.Background(), Heartbeat{FilePath: "/test/late.go", IsWrite: true})
+	svc.Close()
+	require.Len(t, sender.sent, sent)
+}
+
+func TestService_Close_NilSafe(t *testing.T) {
+	t.Parallel()
+
+	var svc *Service
+	svc.Close()
+}
+
 func TestService_ShouldSend_AlwaysOnWrite(t *testing.T) {
 	t.Parallel()
 
@@ -69,6 +332,154 @@ func TestHook_WrapTools_NilSafe(t *testing.T) {
 	require.Nil(t, result)
 }
 
+func TestHook_WrapTools(t *testing.T) {
+	t.Parallel()
+
+	noop := func(context.Context, struct{}, fantasy.ToolCall) (fantasy.ToolResponse, error) {
+		return fantasy.NewTextResponse(""), nil
+	}
+	tools := []fantasy.AgentTool{
+		fantasy.NewAgentTool("view", "", noop),
+		fantasy.NewAgentTool("ls", "", noop),
+		fantasy.NewAgentTool("bash", "", noop),
+	}
+
+	t.Run("wraps default tools", func(t *testing.T) {
+		t.Parallel()
+
+		hook := NewHook(&Service{}, "/working")
+		wrapped := hook.WrapTools(tools)
+		require.IsType(t, &wrappedTool{}, wrapped[0])
+		require.IsType(t, &wrappedTool{}, wrapped[1])
+		require.Same(t, tools[2], wrapped[2])
+	})
+
+	t.Run("wraps configured tools only", func(t *testing.T) {
+		t.Parallel()
+
+		hook := NewHook(&Service{cfg: Config{Tools: []string{"bash"}}}, "/working")
+		wrapped := hook.WrapTools(tools)
+		require.Same(t, tools[0], wrapped[0])
+		require.Same(t, tools[1], wrapped[1])
+		require.IsType(t, &wrappedTool{}, wrapped[2])
+	})
+}
+
+func TestHook_DirectoryHeartbeats(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the wakatime CLI")
+	}
+
+	cli := filepath.Join(t.TempDir(), "wakatime-cli")
+	require.NoError(t, os.WriteFile(cli, []byte("#!/bin/sh\n"), 0o755))
+
+	svc, err := New(Config{Enabled: true, CLIPath: cli})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	noop := func(context.Context, struct{}, fantasy.ToolCall) (fantasy.ToolResponse, error) {
+		return fantasy.NewTextResponse(""), nil
+	}
+	hook := NewHook(svc, "/working")
+	wrapped := hook.WrapTools([]fantasy.AgentTool{
+		fantasy.NewAgentTool("grep", "", noop),
+		fantasy.NewAgentTool("glob", "", noop),
+		fantasy.NewAgentTool("ls", "", noop),
+	})
+
+	run := func(tool fantasy.AgentTool, input string) {
+		_, err := tool.Run(t.Context(), fantasy.ToolCall{Input: input})
+		require.NoError(t, err)
+	}
+
+	// grep and glob targeting the same directory share one throttle entry.
+	run(wrapped[0], `{"pattern": "foo", "path": "/src/"}`)
+	first := svc.lastHeartbeats["/src"]
+	require.False(t, first.IsZero())
+	run(wrapped[1], `{"pattern": "*.go", "path": "/src"}`)
+	require.Equal(t, first, svc.lastHeartbeats["/src"])
+
+	// ls without a path targets the working directory.
+	run(wrapped[2], `{}`)
+	require.Contains(t, svc.lastHeartbeats, "/working")
+	require.Len(t, svc.lastHeartbeats, 2)
+}
+
+func TestHook_CategoryByTool(t *testing.T) {
+	t.Parallel()
+
+	sender := &fakeSender{sent: make(chan Heartbeat, 2)}
+	svc, err := New(Config{
+		Enabled:        true,
+		Category:       "coding",
+		CategoryByTool: map[string]string{"view": "code reviewing"},
+		Sender:         sender,
+	})
+	require.NoError(t, err)
+	t.Cleanup(svc.Close)
+
+	noop := func(context.Context, struct{}, fantasy.ToolCall) (fantasy.ToolResponse, error) {
+		return fantasy.NewTextResponse(""), nil
+	}
+	hook := NewHook(svc, "/working")
+	wrapped := hook.WrapTools([]fantasy.AgentTool{
+		fantasy.NewAgentTool("view", "", noop),
+		fantasy.NewAgentTool("edit", "", noop),
+	})
+	_, err = wrapped[0].Run(t.Context(), fantasy.ToolCall{Input: `{"file_path": "/src/a.go"}`})
+	require.NoError(t, err)
+	_, err = wrapped[1].Run(t.Context(), fantasy.ToolCall{Input: `{"file_path": "/src/b.go"}`})
+	require.NoError(t, err)
+
+	categories := make(map[string]string)
+	for range 2 {
+		select {
+		case h := <-sender.sent:
+			categories[h.FilePath] = h.Category
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat not sent")
+		}
+	}
+	require.Equal(t, map[string]string{
+		"/src/a.go": "code reviewing",
+		"/src/b.go": "coding",
+	}, categories)
+}
+
+func TestModified(t *testing.T) {
+	t.Parallel()
+
+	withMeta := func(meta string) fantasy.ToolResponse {
+		resp := fantasy.NewTextResponse("")
+		resp.Metadata = meta
+		return resp
+	}
+
+	tests := []struct {
+		name   string
+		result fantasy.ToolResponse
+		err    error
+		want   bool
+	}{
+		{"success without metadata", fantasy.NewTextResponse("Downloaded"), nil, true},
+		{"changed lines", withMeta(`{"additions":2,"removals":1}`), nil, true},
+		{"no-op edit", fantasy.NewTextErrorResponse("new content is the same as old content. No changes made."), nil, false},
+		{"tool error", fantasy.NewTextResponse(""), context.Canceled, false},
+		{"no changed lines", withMeta(`{"additions":0,"removals":0}`), nil, false},
+		{"no applied edits", withMeta(`{"additions":0,"removals":0,"edits_applied":0}`), nil, false},
+		{"some applied edits", withMeta(`{"additions":1,"removals":0,"edits_applied":1}`), nil, true},
+		{"unrelated metadata", withMeta(`{"diff":""}`), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, modified(tt.result, tt.err))
+		})
+	}
+}
+
 func TestExtractFilePath_FilePath(t *testing.T) {
 	t.Parallel()
 
@@ -93,10 +504,91 @@ func TestExtractFilePath_PathParam(t *testing.T) {
 	require.Equal(t, "/src", path)
 }
 
+func TestExtractFilePath_CleansPath(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "/working/src", extractFilePath(`{"path": "./src/"}`, "/working"))
+	require.Equal(t, "/working", extractFilePath(`{"path": "."}`, "/working"))
+}
+
 func TestDetectProject_ReturnsBasename(t *testing.T) {
 	t.Parallel()
 
 	// Without project markers, returns parent directory name.
-	project := detectProject("/some/random/path/file.go")
+	project := detectProject("/some/random/path/file.go", fsext.DefaultProjectMarkers, ProjectStrategyNearest, 0)
 	require.Equal(t, "path", project)
 }
+
+func TestDetectProject_Strategies(t *testing.T) {
+	t.Parallel()
+
+	// monorepo/.git
+	// monorepo/services/api/go.mod
+	// monorepo/services/api/internal/handler.go
+	root := filepath.Join(t.TempDir(), "monorepo")
+	api := filepath.Join(root, "services", "api")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(api, "internal"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(api, "go.mod"), nil, 0o644))
+	file := filepath.Join(api, "internal", "handler.go")
+
+	tests := []struct {
+		name     string
+		strategy ProjectStrategy
+		depth    int
+		file     string
+		want     string
+	}{
+		{"nearest marker", ProjectStrategyNearest, 0, file, "api"},
+		{"default is nearest", "", 0, file, "api"},
+		{"top-most marker", ProjectStrategyTopmost, 0, file, "monorepo"},
+		{"depth zero is root", ProjectStrategyDepth, 0, file, "monorepo"},
+		{"depth one", ProjectStrategyDepth, 1, file, "services"},
+		{"depth two", ProjectStrategyDepth, 2, file, "api"},
+		{"depth beyond file", ProjectStrategyDepth, 10, file, "internal"},
+		{"depth for file at root", ProjectStrategyDepth, 2, filepath.Join(root, "README.md"), "monorepo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, detectProject(tt.file, fsext.DefaultProjectMarkers, tt.strategy, tt.depth))
+		})
+	}
+
+	t.Run("custom markers", func(t *testing.T) {
+		t.Parallel()
+		require.Equal(t, "monorepo", detectProject(file, []string{".git"}, ProjectStrategyNearest, 0))
+	})
+}
+
+func TestProjectRoots(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join(t.TempDir(), "monorepo")
+	api := filepath.Join(root, "services", "api")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0o755))
+	require.NoError(t, os.MkdirAll(api, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(api, "go.mod"), nil, 0o644))
+
+	require.Equal(t, []string{api}, projectRoots(api, fsext.DefaultProjectMarkers, false))
+	require.Equal(t, []string{api, root}, projectRoots(api, fsext.DefaultProjectMarkers, true))
+}
+
+func TestHook_DetectProjectCachesRoots(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.Join(t.TempDir(), "project")
+	src := filepath.Join(root, "src")
+	require.NoError(t, os.MkdirAll(src, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), nil, 0o644))
+
+	hook := NewHook(&Service{}, root)
+	require.Equal(t, "project", hook.detectProject(filepath.Join(src, "main.go")))
+
+	// A marker added later is not seen for a directory already looked up.
+	require.NoError(t, os.WriteFile(filepath.Join(src, "go.mod"), nil, 0o644))
+	require.Equal(t, "project", hook.detectProject(filepath.Join(src, "other.go")))
+	roots, ok := hook.roots.Get(src)
+	require.True(t, ok)
+	require.Equal(t, []string{root}, roots)
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -72,7 +71,7 @@ func (h *HTTPRoundTripLogger) RoundTrip(req *http.Request) (*http.Response, erro
 			"HTTP Response",
 			"status_code", resp.StatusCode,
 			"status", resp.Status,
-			"headers", formatHeaders(resp.Header),
+			"headers", RedactHeaders(resp.Header),
 			"body", bodyToString(save),
 			"content_length", resp.ContentLength,
 			"duration_ms", duration.Milliseconds(),
@@ -98,24 +97,6 @@ func bodyToString(body io.ReadCloser) string {
 	return b.String()
 }
 
-// formatHeaders formats HTTP headers for logging, filtering out sensitive information.
-func formatHeaders(headers http.Header) map[string][]string {
-	filtered := make(map[string][]string)
-	for key, values := range headers {
-		lowerKey := strings.ToLower(key)
-		// Filter out sensitive headers
-		if strings.Contains(lowerKey, "authorization") ||
-			strings.Contains(lowerKey, "api-key") ||
-			strings.Contains(lowerKey, "token") ||
-			strings.Contains(lowerKey, "secret") {
-			filtered[key] = []string{"[REDACTED]"}
-		} else {
-			filtered[key] = values
-		}
-	}
-	return filtered
-}
-
 func drainBody(b io.ReadCloser) (r1, r2 io.ReadCloser, err error) {
 	if b == nil || b == http.NoBody {
 		return http.NoBody, http.NoBody, nil
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Redacted replaces sensitive values in logs.
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaderParts are substrings of header names whose values must not
+// be logged.
+var sensitiveHeaderParts = []string{"authorization", "cookie", "token", "secret", "key"}
+
+// IsSensitiveHeader reports whether the value of the named header may hold a
+// credential and must not be logged.
+func IsSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeaderValue returns value, or Redacted if the named header is
+// sensitive.
+func RedactHeaderValue(name, value string) string {
+	if IsSensitiveHeader(name) {
+		return Redacted
+	}
+	return value
+}
+
+// RedactHeaders returns a copy of headers safe to log, with the values of
+// sensitive headers replaced.
+func RedactHeaders(headers http.Header) map[string][]string {
+	redacted := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		if IsSensitiveHeader(name) {
+			redacted[name] = []string{Redacted}
+		} else {
+			redacted[name] = values
+		}
+	}
+	return redacted
+}
+
+// RedactHeaderMap is like RedactHeaders for single-valued header maps, as
+// used in configuration.
+func RedactHeaderMap(headers map[string]string) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		redacted[name] = RedactHeaderValue(name, value)
+	}
+	return redacted
+}
@@ -0,0 +1,39 @@
+package log
+
+import "testing"
+
+func TestIsSensitiveHeader(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+		"X-Api-Key":           true,
+		"X-Auth-Token":        true,
+		"Client-Secret":       true,
+		"Content-Type":        false,
+		"User-Agent":          false,
+		"Traceparent":         false,
+	} {
+		if got := IsSensitiveHeader(name); got != want {
+			t.Errorf("IsSensitiveHeader(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRedactHeaderMap(t *testing.T) {
+	headers := map[string]string{
+		"Authorization": "Bearer secret-token",
+		"X-Custom":      "visible",
+	}
+	redacted := RedactHeaderMap(headers)
+	if redacted["Authorization"] != Redacted {
+		t.Error("Authorization header should be redacted")
+	}
+	if redacted["X-Custom"] != "visible" {
+		t.Error("X-Custom header should be preserved")
+	}
+	if headers["Authorization"] != "Bearer secret-token" {
+		t.Error("original headers should not be modified")
+	}
+}
@@ -3,25 +3,67 @@ package mcp
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 )
 
-// openBrowser opens the specified URL in the system's default browser.
-func openBrowser(url string) error {
+// BrowserEnv names the environment variable holding a command to open the
+// authorization URL with, e.g. "firefox --new-window". The URL is appended as
+// the last argument.
+const BrowserEnv = "CRUSH_BROWSER"
+
+// browserCommandFromEnv returns the browser command set in BrowserEnv, if
+// any.
+func browserCommandFromEnv() []string {
+	return strings.Fields(os.Getenv(BrowserEnv))
+}
+
+// openBrowser opens the specified URL with command, or in the system's
+// default browser when command is empty.
+func openBrowser(command []string, url string) error {
 	ctx := context.Background()
 	var cmd *exec.Cmd
 
-	switch runtime.GOOS {
-	case "darwin":
+	switch {
+	case len(command) > 0:
+		args := append(command[1:len(command):len(command)], url)
+		cmd = exec.CommandContext(ctx, command[0], args...)
+	case runtime.GOOS == "darwin":
 		cmd = exec.CommandContext(ctx, "open", url)
-	case "linux":
-		cmd = exec.CommandContext(ctx, "xdg-open", url)
-	case "windows":
+	case runtime.GOOS == "linux":
+		args := linuxBrowserCommand(url, isWSL(), exec.LookPath)
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	case runtime.GOOS == "windows":
 		cmd = exec.CommandContext(ctx, "cmd", "/c", "start", url)
 	default:
-		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+		return fmt.Errorf("unsupported platform: %s; set %s to open the browser", runtime.GOOS, BrowserEnv)
 	}
 
 	return cmd.Start()
 }
+
+// linuxBrowserCommand returns the command opening url on Linux. Under WSL
+// there is usually no X server for xdg-open, so the Windows browser is used
+// through wslview, or cmd.exe when wslu is not installed.
+func linuxBrowserCommand(url string, wsl bool, lookPath func(string) (string, error)) []string {
+	if !wsl {
+		return []string{"xdg-open", url}
+	}
+	if _, err := lookPath("wslview"); err == nil {
+		return []string{"wslview", url}
+	}
+	// cmd.exe treats & as a command separator.
+	return []string{"cmd.exe", "/c", "start", strings.ReplaceAll(url, "&", "^&")}
+}
+
+// isWSL reports whether the process runs under the Windows Subsystem for
+// Linux.
+func isWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/version")
+	return err == nil && strings.Contains(strings.ToLower(string(data)), "microsoft")
+}
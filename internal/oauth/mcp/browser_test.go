@@ -0,0 +1,70 @@
+package mcp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowserCommandFromEnv(t *testing.T) {
+	t.Setenv(BrowserEnv, "  firefox   --new-window ")
+	require.Equal(t, []string{"firefox", "--new-window"}, browserCommandFromEnv())
+	require.Equal(t, []string{"firefox", "--new-window"}, DefaultAuthFlowOptions().BrowserCommand)
+
+	t.Setenv(BrowserEnv, "")
+	require.Empty(t, browserCommandFromEnv())
+}
+
+func TestOpenBrowser_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "browser.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+out+".tmp && mv "+out+".tmp "+out+"\n"), 0o755))
+
+	command := []string{script, "--new-window"}
+	require.NoError(t, openBrowser(command, "https://auth.example.com/authorize?state=x"))
+	require.Equal(t, []string{script, "--new-window"}, command, "command must not be modified")
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(out)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "--new-window https://auth.example.com/authorize?state=x\n", string(data))
+}
+
+func TestLinuxBrowserCommand(t *testing.T) {
+	t.Parallel()
+
+	const authURL = "https://auth.example.com/authorize?a=1&b=2"
+	found := func(string) (string, error) { return "/usr/bin/wslview", nil }
+	missing := func(string) (string, error) { return "", exec.ErrNotFound }
+
+	tests := []struct {
+		name     string
+		wsl      bool
+		lookPath func(string) (string, error)
+		want     []string
+	}{
+		{"native linux", false, found, []string{"xdg-open", authURL}},
+		{"wsl with wslview", true, found, []string{"wslview", authURL}},
+		{"wsl without wslview", true, missing, []string{"cmd.exe", "/c", "start", "https://auth.example.com/authorize?a=1^&b=2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, linuxBrowserCommand(authURL, tt.wsl, tt.lookPath))
+		})
+	}
+}
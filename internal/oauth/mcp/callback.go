@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html/template"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
@@ -26,6 +29,8 @@ type callbackServer struct {
 	listener net.Listener
 	result   chan callbackResult
 	once     sync.Once
+	// returnURL is opened from the success page, if set.
+	returnURL string
 }
 
 // newCallbackServer creates a new callback server on the specified port and path.
@@ -120,7 +125,7 @@ func (cs *callbackServer) handleCallback(w http.ResponseWriter, r *http.Request)
 
 	cs.sendResult(callbackResult{Code: code, State: state})
 	w.Header().Set("Content-Type", "text/html")
-	_, _ = w.Write([]byte(successHTML()))
+	_, _ = w.Write([]byte(successHTML(cs.returnURL)))
 }
 
 func (cs *callbackServer) sendResult(result callbackResult) {
@@ -129,8 +134,25 @@ func (cs *callbackServer) sendResult(result callbackResult) {
 	})
 }
 
-func successHTML() string {
-	return `<!DOCTYPE html>
+// ReturnURLEnv names the environment variable holding a URL to open once
+// authorization succeeded, e.g. a deep link back to the terminal.
+const ReturnURLEnv = "CRUSH_OAUTH_RETURN_URL"
+
+// successHTML renders the success page. It tries to close itself after a
+// moment, first opening returnURL if set; when the browser refuses, the page
+// still tells the user to close it.
+func successHTML(returnURL string) string {
+	if u, err := url.Parse(returnURL); err != nil || strings.EqualFold(u.Scheme, "javascript") {
+		returnURL = ""
+	}
+	var link, open string
+	if returnURL != "" {
+		link = fmt.Sprintf(`
+        <p><a class="button" href="%s">Return to terminal</a></p>`, template.HTMLEscapeString(returnURL))
+		open = fmt.Sprintf(`
+            window.location.href = "%s";`, template.JSEscapeString(returnURL))
+	}
+	return fmt.Sprintf(`<!DOCTYPE html>
 <html>
 <head>
     <title>Authorization Successful</title>
@@ -140,16 +162,22 @@ func successHTML() string {
         .check { font-size: 4rem; color: #4ade80; }
         h1 { margin: 1rem 0; }
         p { color: #aaa; }
+        .button { display: inline-block; margin-top: 1rem; padding: 0.5rem 1rem; border-radius: 0.375rem; background: #4ade80; color: #1a1a2e; text-decoration: none; }
     </style>
 </head>
 <body>
     <div class="container">
         <div class="check">✓</div>
         <h1>Authorization Successful</h1>
-        <p>You can close this window and return to Crush.</p>
+        <p>You can close this window and return to Crush.</p>%s
     </div>
+    <script>
+        setTimeout(function () {%s
+            window.close();
+        }, 1500);
+    </script>
 </body>
-</html>`
+</html>`, link, open)
 }
 
 func errorHTML(errMsg string) string {
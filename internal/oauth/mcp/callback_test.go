@@ -0,0 +1,54 @@
+package mcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuccessHTML(t *testing.T) {
+	t.Parallel()
+
+	t.Run("closes itself", func(t *testing.T) {
+		t.Parallel()
+		page := successHTML("")
+		require.Contains(t, page, "window.close()")
+		require.Contains(t, page, "You can close this window")
+		require.NotContains(t, page, "Return to terminal")
+		require.NotContains(t, page, "window.location")
+	})
+
+	t.Run("links back to the terminal", func(t *testing.T) {
+		t.Parallel()
+		page := successHTML(`myterm://focus?a=1&b="2"`)
+		require.Contains(t, page, `href="myterm://focus?a=1&amp;b=&#34;2&#34;"`)
+		require.Contains(t, page, `window.location.href = "myterm://focus?a\u003D1\u0026b\u003D\"2\"";`)
+		require.Contains(t, page, "window.close()")
+	})
+
+	t.Run("ignores javascript urls", func(t *testing.T) {
+		t.Parallel()
+		page := successHTML("JavaScript:alert(1)")
+		require.NotContains(t, page, "alert(1)")
+		require.NotContains(t, page, "Return to terminal")
+	})
+}
+
+func TestHandleCallback_ReturnURL(t *testing.T) {
+	t.Parallel()
+
+	cs, err := newCallbackServer(t.Context(), 0, "")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cs.listener.Close() })
+	cs.returnURL = "myterm://focus"
+
+	rec := httptest.NewRecorder()
+	cs.handleCallback(rec, httptest.NewRequest(http.MethodGet, "/callback?code=abc&state=xyz", nil))
+	require.Contains(t, rec.Body.String(), `href="myterm://focus"`)
+
+	result, err := cs.waitForCallback(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, callbackResult{Code: "abc", State: "xyz"}, result)
+}
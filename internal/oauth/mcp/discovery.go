@@ -1,17 +1,41 @@
 package mcp
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 )
 
+// ErrDiscoveryUnavailable is returned by discovery when a request kept
+// failing with a network error or a 429 or 5xx status for a server that
+// demanded authentication or is configured for OAuth, so whether the server
+// supports OAuth is unknown and discovery can be retried later.
+var ErrDiscoveryUnavailable = errors.New("oauth discovery unavailable")
+
+// ErrEndpointHostRejected is returned by discovery when the metadata
+// advertises an endpoint on a host other than the issuer's and the policy is
+// EndpointHostStrict.
+var ErrEndpointHostRejected = errors.New("oauth endpoint host rejected")
+
+const (
+	// discoveryAttempts is how often a discovery request is sent before it
+	// is considered unavailable.
+	discoveryAttempts = 3
+	// discoveryBackoff is the wait before the first retry, doubled for each
+	// further retry.
+	discoveryBackoff = 100 * time.Millisecond
+	// discoveryTimeout bounds a whole discovery, including all retries.
+	discoveryTimeout = 30 * time.Second
+)
+
 // discoveryResponse represents the OAuth 2.0 Authorization Server Metadata (RFC 8414).
 // This is used internally for JSON unmarshaling during discovery.
 type discoveryResponse struct {
@@ -19,14 +43,31 @@ type discoveryResponse struct {
 	AuthorizationEndpoint  string   `json:"authorization_endpoint"`
 	TokenEndpoint          string   `json:"token_endpoint"`
 	RegistrationEndpoint   string   `json:"registration_endpoint,omitempty"`
+	IntrospectionEndpoint  string   `json:"introspection_endpoint,omitempty"`
 	ScopesSupported        []string `json:"scopes_supported,omitempty"`
 	ResponseTypesSupported []string `json:"response_types_supported"`
 }
 
+// EndpointHostPolicy controls how discovery treats advertised endpoints whose
+// scheme and host differ from the issuer's.
+type EndpointHostPolicy string
+
+const (
+	// EndpointHostStrict rejects metadata with endpoints on another host.
+	// This is the default.
+	EndpointHostStrict EndpointHostPolicy = "strict"
+	// EndpointHostWarn logs a warning but accepts the metadata.
+	EndpointHostWarn EndpointHostPolicy = "warn"
+	// EndpointHostOff skips the check.
+	EndpointHostOff EndpointHostPolicy = "off"
+)
+
 // validateDiscoveryResponse validates the OAuth discovery response per RFC 8414.
 // It checks all required fields and verifies the issuer matches the expected host.
 // The scheme and host parameters are used to verify the issuer to prevent impersonation attacks.
-func validateDiscoveryResponse(resp *discoveryResponse, scheme, host string) error {
+// The policy decides what happens when the advertised endpoints are not on the
+// issuer's scheme and host or one of allowedHosts.
+func validateDiscoveryResponse(resp *discoveryResponse, scheme, host string, policy EndpointHostPolicy, allowedHosts ...string) error {
 	// Validate required fields per RFC 8414
 	if resp.Issuer == "" {
 		return fmt.Errorf("missing required issuer field")
@@ -46,63 +87,366 @@ func validateDiscoveryResponse(resp *discoveryResponse, scheme, host string) err
 	// URI string was inserted. Since we discover from the root, we validate that
 	// the issuer's scheme and host match to prevent impersonation attacks while
 	// allowing legitimate issuers with path components (e.g., multi-tenant setups).
-	expectedPrefix := fmt.Sprintf("%s://%s", scheme, host)
-	if !strings.HasPrefix(resp.Issuer, expectedPrefix) {
-		return fmt.Errorf("issuer %q does not match expected host %q", resp.Issuer, expectedPrefix)
+	// The issuer is parsed, as a prefix match would accept a host such as
+	// "example.com.evil.net" for "example.com".
+	expected := fmt.Sprintf("%s://%s", scheme, host)
+	issuer, err := url.Parse(resp.Issuer)
+	if err != nil || !strings.EqualFold(issuer.Scheme, scheme) || !strings.EqualFold(issuer.Host, host) {
+		return fmt.Errorf("issuer %q does not match expected host %q", resp.Issuer, expected)
+	}
+
+	return validateEndpointHosts(resp, policy, allowedHosts...)
+}
+
+// validateEndpointHosts checks that the advertised endpoints share the
+// issuer's scheme and host, so a bad metadata document cannot send
+// credentials elsewhere. Endpoints on one of allowedHosts, with or without
+// port, pass as long as they use the issuer's scheme.
+func validateEndpointHosts(resp *discoveryResponse, policy EndpointHostPolicy, allowedHosts ...string) error {
+	if policy == EndpointHostOff {
+		return nil
+	}
+	issuer, err := url.Parse(resp.Issuer)
+	if err != nil {
+		return fmt.Errorf("invalid issuer %q: %w", resp.Issuer, err)
 	}
 
+	endpoints := []struct{ name, value string }{
+		{"authorization_endpoint", resp.AuthorizationEndpoint},
+		{"token_endpoint", resp.TokenEndpoint},
+		{"registration_endpoint", resp.RegistrationEndpoint},
+		{"introspection_endpoint", resp.IntrospectionEndpoint},
+	}
+	for _, e := range endpoints {
+		if e.value == "" {
+			continue
+		}
+		u, err := url.Parse(e.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", e.name, e.value, err)
+		}
+		if strings.EqualFold(u.Scheme, issuer.Scheme) && (strings.EqualFold(u.Host, issuer.Host) || hostAllowed(u, allowedHosts)) {
+			continue
+		}
+		if policy == EndpointHostWarn {
+			slog.Warn("OAuth endpoint is not on the issuer's host", "endpoint", e.name, "url", e.value, "issuer", resp.Issuer)
+			continue
+		}
+		return fmt.Errorf("%w: %s %q does not match issuer host %q", ErrEndpointHostRejected, e.name, e.value, issuer.Scheme+"://"+issuer.Host)
+	}
 	return nil
 }
 
-// DiscoverOAuth attempts to discover OAuth configuration from the server's well-known endpoint.
-// It returns nil if OAuth is not supported or discovery fails.
-func DiscoverOAuth(ctx context.Context, serverURL string) (*Config, error) {
+// hostAllowed reports whether the host of u, with or without its port, is one
+// of allowedHosts.
+func hostAllowed(u *url.URL, allowedHosts []string) bool {
+	return slices.ContainsFunc(allowedHosts, func(h string) bool {
+		return strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname())
+	})
+}
+
+// protectedResourceMetadata is the OAuth 2.0 Protected Resource Metadata
+// (RFC 9728) an MCP server points to from its WWW-Authenticate challenge.
+type protectedResourceMetadata struct {
+	Resource             string   `json:"resource"`
+	AuthorizationServers []string `json:"authorization_servers"`
+	ScopesSupported      []string `json:"scopes_supported,omitempty"`
+}
+
+// DiscoveryOptions controls OAuth discovery.
+type DiscoveryOptions struct {
+	// Policy controls endpoints advertised on a host other than the
+	// issuer's and not in AllowedHosts; empty means EndpointHostStrict.
+	Policy       EndpointHostPolicy
+	AllowedHosts []string
+	// Transport sends the discovery requests and is set on the discovered
+	// Config. Nil uses http.DefaultTransport.
+	Transport http.RoundTripper
+	// RequireOAuth reports failed well-known requests as
+	// ErrDiscoveryUnavailable even if the server did not demand
+	// authentication, for servers configured to use OAuth.
+	RequireOAuth bool
+}
+
+// DiscoverOAuth attempts to discover OAuth configuration for an MCP server.
+// It first follows the resource_metadata hint of the server's 401 challenge
+// (RFC 9728) to its authorization server, and falls back to the well-known
+// endpoint on the server's host. It returns nil if OAuth is not supported,
+// and ErrDiscoveryUnavailable if the server demanded authentication but
+// network errors kept discovery from completing even after retrying. The
+// policy controls endpoints advertised on a host other than the issuer's and
+// not in allowedHosts; empty means EndpointHostStrict, under which they fail
+// discovery with ErrEndpointHostRejected.
+func DiscoverOAuth(ctx context.Context, serverURL string, policy EndpointHostPolicy, allowedHosts ...string) (*Config, error) {
+	return DiscoverOAuthWithOptions(ctx, serverURL, DiscoveryOptions{Policy: policy, AllowedHosts: allowedHosts})
+}
+
+// DiscoverOAuthWithOptions is DiscoverOAuth with options such as a custom
+// transport.
+func DiscoverOAuthWithOptions(ctx context.Context, serverURL string, opts DiscoveryOptions) (*Config, error) {
 	slog.Info("Discovering OAuth 2.0 configuration", "url", serverURL)
 	parsed, err := url.Parse(serverURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid oauth server URL: %w", err)
 	}
 
+	discoverCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	cfg, challenged, err := discover(discoverCtx, parsed, serverURL, opts)
+	if err != nil && ctx.Err() == nil && discoverCtx.Err() != nil {
+		err = fmt.Errorf("%w: timed out after %s: %w", ErrDiscoveryUnavailable, discoveryTimeout, err)
+	}
+	// A server that never asked for credentials works without OAuth, so
+	// failing well-known requests must not keep it from connecting.
+	if errors.Is(err, ErrDiscoveryUnavailable) && !challenged && !opts.RequireOAuth {
+		slog.Debug("OAuth discovery failed for a server that did not demand authentication, assuming no OAuth", "url", serverURL, "error", err)
+		return nil, nil
+	}
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	cfg.Transport = opts.Transport
+	return cfg, nil
+}
+
+// discover runs the discovery steps of DiscoverOAuthWithOptions and reports
+// whether the server answered the unauthenticated probe with a 401.
+func discover(ctx context.Context, parsed *url.URL, serverURL string, opts DiscoveryOptions) (*Config, bool, error) {
+	policy, allowedHosts := cmp.Or(opts.Policy, EndpointHostStrict), opts.AllowedHosts
+	client := &http.Client{Transport: opts.Transport}
+
+	metadataURL, challenged, err := resourceMetadataURL(ctx, client, serverURL)
+	if err != nil {
+		return nil, challenged, err
+	}
+	if metadataURL != "" {
+		cfg, err := discoverFromResourceMetadata(ctx, client, serverURL, metadataURL, policy, allowedHosts)
+		if err != nil || cfg != nil {
+			return cfg, challenged, err
+		}
+	}
+
 	// Build the well-known URL according to RFC 8414
 	wellKnownURL := fmt.Sprintf("%s://%s/.well-known/oauth-authorization-server", parsed.Scheme, parsed.Host)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnownURL, nil)
+	var discovery discoveryResponse
+	found, err := fetchMetadata(ctx, client, wellKnownURL, &discovery)
+	if err != nil || !found {
+		return nil, challenged, err
+	}
+
+	if err = validateDiscoveryResponse(&discovery, parsed.Scheme, parsed.Host, policy, allowedHosts...); err != nil {
+		return nil, challenged, rejectMetadata(err)
+	}
+	return discoveredConfig(&discovery, nil), challenged, nil
+}
+
+// discoverFromResourceMetadata follows the resource_metadata URL from the
+// server's WWW-Authenticate challenge to the protected resource metadata and
+// from there to the first authorization server's metadata. It returns nil
+// when any step finds no valid metadata, and an error when a request fails
+// or the endpoints are rejected.
+func discoverFromResourceMetadata(ctx context.Context, client *http.Client, serverURL, metadataURL string, policy EndpointHostPolicy, allowedHosts []string) (*Config, error) {
+	var resource protectedResourceMetadata
+	if found, err := fetchMetadata(ctx, client, metadataURL, &resource); err != nil || !found {
+		return nil, err
+	}
+	// RFC 9728 §3.3: metadata naming another resource must not be used, or
+	// a server could have tokens minted for another server's audience.
+	if resource.Resource != "" && !resourceMatches(resource.Resource, serverURL) {
+		slog.Warn("Ignoring protected resource metadata for another resource", "resource", resource.Resource, "url", serverURL)
+		return nil, nil
+	}
+	if len(resource.AuthorizationServers) == 0 {
+		slog.Debug("Protected resource metadata lists no authorization servers", "url", metadataURL)
+		return nil, nil
+	}
+
+	issuer, err := url.Parse(resource.AuthorizationServers[0])
+	if err != nil || issuer.Host == "" {
+		slog.Debug("Invalid authorization server in protected resource metadata", "url", resource.AuthorizationServers[0])
+		return nil, nil
+	}
+	// RFC 8414 §3.1 inserts the well-known segment between host and path.
+	wellKnownURL := fmt.Sprintf("%s://%s/.well-known/oauth-authorization-server%s", issuer.Scheme, issuer.Host, strings.TrimSuffix(issuer.Path, "/"))
+	var discovery discoveryResponse
+	if found, err := fetchMetadata(ctx, client, wellKnownURL, &discovery); err != nil || !found {
+		return nil, err
+	}
+	if err := validateDiscoveryResponse(&discovery, issuer.Scheme, issuer.Host, policy, allowedHosts...); err != nil {
+		return nil, rejectMetadata(err)
+	}
+	cfg := discoveredConfig(&discovery, resource.ScopesSupported)
+	cfg.Resource = resource.Resource
+	return cfg, nil
+}
+
+// resourceMatches reports whether the resource identifier of protected
+// resource metadata covers serverURL: the same scheme and host, and a path
+// that is serverURL's path or one of its parents.
+func resourceMatches(resource, serverURL string) bool {
+	r, err := url.Parse(resource)
+	if err != nil {
+		return false
+	}
+	s, err := url.Parse(serverURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create oauth discovery request: %w", err)
+		return false
+	}
+	if !strings.EqualFold(r.Scheme, s.Scheme) || !strings.EqualFold(r.Host, s.Host) {
+		return false
+	}
+	prefix := strings.TrimSuffix(r.Path, "/")
+	path := strings.TrimSuffix(s.Path, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// rejectMetadata handles metadata that failed validation. Invalid metadata
+// means the server doesn't support OAuth, but endpoints rejected by the host
+// policy are reported, so OAuth doesn't silently go missing.
+func rejectMetadata(err error) error {
+	if errors.Is(err, ErrEndpointHostRejected) {
+		slog.Warn("OAuth metadata rejected", "error", err)
+		return err
+	}
+	slog.Debug("OAuth metadata validation failed", "error", err)
+	return nil
+}
+
+// resourceMetadataURL requests the MCP server without credentials and
+// returns the resource_metadata URL of its 401 challenge, if any, and whether
+// it answered with a 401. A probe that keeps failing returns no URL rather
+// than an error, so discovery falls back to the well-known endpoint.
+func resourceMetadataURL(ctx context.Context, client *http.Client, serverURL string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURL, nil)
+	if err != nil {
+		return "", false, nil
+	}
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := doWithRetry(ctx, client, req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", false, ctxErr
+		}
+		// Some servers fail unauthenticated requests instead of answering
+		// with a challenge; the well-known endpoint may still work.
+		slog.Debug("OAuth resource probe failed, falling back to well-known metadata", "error", err)
+		return "", false, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", false, nil
+	}
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		if v := authParam(challenge, "resource_metadata"); v != "" {
+			return v, true, nil
+		}
+	}
+	return "", true, nil
+}
+
+// doWithRetry sends a discovery request, retrying network errors and 429
+// and 5xx responses with exponential backoff. When all attempts fail it
+// returns ErrDiscoveryUnavailable wrapping the last failure.
+func doWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	backoff := discoveryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if attempt == discoveryAttempts {
+			return nil, fmt.Errorf("%w: %s: %w", ErrDiscoveryUnavailable, req.URL.Redacted(), err)
+		}
+
+		slog.Debug("OAuth discovery request failed, retrying", "url", req.URL.Redacted(), "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// authParam returns the value of the named auth-param in a WWW-Authenticate
+// challenge, e.g. resource_metadata in
+// `Bearer realm="mcp", resource_metadata="https://..."`.
+func authParam(challenge, name string) string {
+	for rest := challenge; rest != ""; {
+		i := strings.Index(strings.ToLower(rest), name+"=")
+		if i == -1 {
+			return ""
+		}
+		// Only match whole parameter names.
+		if i > 0 && !strings.ContainsRune(" ,", rune(rest[i-1])) {
+			rest = rest[i+len(name)+1:]
+			continue
+		}
+		value := rest[i+len(name)+1:]
+		if strings.HasPrefix(value, `"`) {
+			value, _, _ = strings.Cut(value[1:], `"`)
+			return value
+		}
+		value, _, _ = strings.Cut(value, ",")
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// fetchMetadata fetches a JSON metadata document into v. It reports false
+// without an error when the document does not exist or cannot be parsed, so
+// callers treat the server as not supporting OAuth, and returns an error when
+// the request fails.
+func fetchMetadata(ctx context.Context, client *http.Client, metadataURL string, v any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create oauth discovery request: %w", err)
 	}
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doWithRetry(ctx, client, req)
 	if err != nil {
 		slog.Debug("OAuth discovery request failed", "error", err)
-		return nil, nil
+		return false, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		slog.Debug("OAuth well-known endpoint not found", "url", wellKnownURL)
-		return nil, nil // No OAuth metadata, server doesn't support OAuth discovery
+		slog.Debug("OAuth metadata not found", "url", metadataURL)
+		return false, nil // No OAuth metadata, server doesn't support OAuth discovery
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		slog.Debug("OAuth discovery returned non-OK status", "status", resp.StatusCode)
-		return nil, nil
+		slog.Debug("OAuth discovery returned non-OK status", "status", resp.StatusCode, "url", metadataURL)
+		return false, nil
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read discovery response: %w", err)
+		return false, fmt.Errorf("failed to read discovery response: %w", err)
 	}
 
-	var discovery discoveryResponse
-	if err = json.Unmarshal(body, &discovery); err != nil {
-		slog.Debug("Failed to parse OAuth metadata", "error", err)
-		return nil, nil // Invalid metadata, treat as no OAuth
+	if err = json.Unmarshal(body, v); err != nil {
+		slog.Debug("Failed to parse OAuth metadata", "error", err, "url", metadataURL)
+		return false, nil // Invalid metadata, treat as no OAuth
 	}
+	return true, nil
+}
 
-	if err = validateDiscoveryResponse(&discovery, parsed.Scheme, parsed.Host); err != nil {
-		slog.Debug("OAuth metadata validation failed", "error", err)
-		return nil, nil
+// discoveredConfig builds the OAuth configuration from validated
+// authorization server metadata. Scopes from the protected resource metadata
+// take precedence over the authorization server's.
+func discoveredConfig(discovery *discoveryResponse, resourceScopes []string) *Config {
+	scopes := discovery.ScopesSupported
+	if len(resourceScopes) > 0 {
+		scopes = resourceScopes
 	}
 
 	slog.Info("Discovered OAuth metadata successfully", "issuer", discovery.Issuer)
@@ -111,13 +455,15 @@ func DiscoverOAuth(ctx context.Context, serverURL string) (*Config, error) {
 		"auth_endpoint", discovery.AuthorizationEndpoint,
 		"registration_endpoint", discovery.RegistrationEndpoint,
 		"token_endpoint", discovery.TokenEndpoint,
-		"scopes_supported", strings.Join(discovery.ScopesSupported, ","),
+		"introspection_endpoint", discovery.IntrospectionEndpoint,
+		"scopes_supported", strings.Join(scopes, ","),
 	)
 
 	return &Config{
-		AuthURL:              discovery.AuthorizationEndpoint,
-		TokenURL:             discovery.TokenEndpoint,
-		Scopes:               discovery.ScopesSupported,
-		RegistrationEndpoint: discovery.RegistrationEndpoint,
-	}, nil
+		AuthURL:               discovery.AuthorizationEndpoint,
+		TokenURL:              discovery.TokenEndpoint,
+		Scopes:                scopes,
+		RegistrationEndpoint:  discovery.RegistrationEndpoint,
+		IntrospectionEndpoint: discovery.IntrospectionEndpoint,
+	}
 }
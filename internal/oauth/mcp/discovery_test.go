@@ -1,6 +1,11 @@
 package mcp
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -107,6 +112,30 @@ func TestValidateDiscoveryResponse(t *testing.T) {
 			host:    "example.com",
 			wantErr: true,
 		},
+		{
+			name: "issuer host extends expected host",
+			resp: &discoveryResponse{
+				Issuer:                 "https://example.com.evil.net",
+				AuthorizationEndpoint:  "https://example.com.evil.net/authorize",
+				TokenEndpoint:          "https://example.com.evil.net/token",
+				ResponseTypesSupported: []string{"code"},
+			},
+			scheme:  "https",
+			host:    "example.com",
+			wantErr: true,
+		},
+		{
+			name: "issuer with another port",
+			resp: &discoveryResponse{
+				Issuer:                 "https://example.com:8443",
+				AuthorizationEndpoint:  "https://example.com:8443/authorize",
+				TokenEndpoint:          "https://example.com:8443/token",
+				ResponseTypesSupported: []string{"code"},
+			},
+			scheme:  "https",
+			host:    "example.com",
+			wantErr: true,
+		},
 		{
 			name: "issuer scheme mismatch",
 			resp: &discoveryResponse{
@@ -123,7 +152,7 @@ func TestValidateDiscoveryResponse(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateDiscoveryResponse(tt.resp, tt.scheme, tt.host)
+			err := validateDiscoveryResponse(tt.resp, tt.scheme, tt.host, EndpointHostStrict)
 			if tt.wantErr {
 				require.Error(t, err)
 			} else {
@@ -132,3 +161,390 @@ func TestValidateDiscoveryResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateDiscoveryResponse_EndpointHosts(t *testing.T) {
+	sameHost := &discoveryResponse{
+		Issuer:                 "https://example.com",
+		AuthorizationEndpoint:  "https://example.com/authorize",
+		TokenEndpoint:          "https://example.com/token",
+		RegistrationEndpoint:   "https://example.com/register",
+		ResponseTypesSupported: []string{"code"},
+	}
+	crossHost := &discoveryResponse{
+		Issuer:                 "https://example.com",
+		AuthorizationEndpoint:  "https://example.com/authorize",
+		TokenEndpoint:          "https://attacker.example.net/token",
+		ResponseTypesSupported: []string{"code"},
+	}
+	crossScheme := &discoveryResponse{
+		Issuer:                 "https://example.com",
+		AuthorizationEndpoint:  "http://example.com/authorize",
+		TokenEndpoint:          "https://example.com/token",
+		ResponseTypesSupported: []string{"code"},
+	}
+	crossRegistration := &discoveryResponse{
+		Issuer:                 "https://example.com",
+		AuthorizationEndpoint:  "https://example.com/authorize",
+		TokenEndpoint:          "https://example.com/token",
+		RegistrationEndpoint:   "https://example.com.evil.com/register",
+		ResponseTypesSupported: []string{"code"},
+	}
+
+	allowedHTTP := &discoveryResponse{
+		Issuer:                 "https://example.com",
+		AuthorizationEndpoint:  "https://example.com/authorize",
+		TokenEndpoint:          "http://login.example.org/token",
+		ResponseTypesSupported: []string{"code"},
+	}
+
+	tests := []struct {
+		name    string
+		resp    *discoveryResponse
+		policy  EndpointHostPolicy
+		allowed []string
+		wantErr bool
+	}{
+		{"matching hosts strict", sameHost, EndpointHostStrict, nil, false},
+		{"cross host strict", crossHost, EndpointHostStrict, nil, true},
+		{"cross scheme strict", crossScheme, EndpointHostStrict, nil, true},
+		{"cross host registration strict", crossRegistration, EndpointHostStrict, nil, true},
+		{"cross host warn", crossHost, EndpointHostWarn, nil, false},
+		{"cross host off", crossHost, EndpointHostOff, nil, false},
+		{"cross host allowed", crossHost, EndpointHostStrict, []string{"attacker.example.net"}, false},
+		{"cross host allowed with port", crossHost, EndpointHostStrict, []string{"attacker.example.net:443"}, true},
+		{"cross host not in allowlist", crossHost, EndpointHostStrict, []string{"login.example.org"}, true},
+		{"allowed host needs issuer scheme", allowedHTTP, EndpointHostStrict, []string{"login.example.org"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDiscoveryResponse(tt.resp, "https", "example.com", tt.policy, tt.allowed...)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrEndpointHostRejected)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDiscoverOAuth_ResourceMetadata(t *testing.T) {
+	t.Parallel()
+
+	authServer := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(authServer.Close)
+	issuer := authServer.URL + "/tenant"
+	authServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/oauth-authorization-server/tenant" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                   issuer,
+			"authorization_endpoint":   issuer + "/authorize",
+			"token_endpoint":           issuer + "/token",
+			"registration_endpoint":    issuer + "/register",
+			"scopes_supported":         []string{"openid"},
+			"response_types_supported": []string{"code"},
+		})
+	})
+
+	var mcpServer *httptest.Server
+	mcpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mcp":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcp", resource_metadata="`+mcpServer.URL+`/.well-known/oauth-protected-resource/mcp"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/.well-known/oauth-protected-resource/mcp":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"resource":              mcpServer.URL + "/mcp",
+				"authorization_servers": []string{issuer},
+				"scopes_supported":      []string{"mcp:tools"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(mcpServer.Close)
+
+	cfg, err := DiscoverOAuth(t.Context(), mcpServer.URL+"/mcp", "")
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, issuer+"/authorize", cfg.AuthURL)
+	require.Equal(t, issuer+"/token", cfg.TokenURL)
+	require.Equal(t, issuer+"/register", cfg.RegistrationEndpoint)
+	require.Equal(t, []string{"mcp:tools"}, cfg.Scopes, "resource scopes take precedence")
+	require.Equal(t, mcpServer.URL+"/mcp", cfg.Resource)
+}
+
+func TestDiscoverOAuth_ResourceMismatch(t *testing.T) {
+	t.Parallel()
+
+	var authHits atomic.Int32
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHits.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(authServer.Close)
+
+	var mcpServer *httptest.Server
+	mcpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mcp":
+			w.Header().Set("WWW-Authenticate", `Bearer resource_metadata="`+mcpServer.URL+`/.well-known/oauth-protected-resource/mcp"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/.well-known/oauth-protected-resource/mcp":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"resource":              "https://other.example/mcp",
+				"authorization_servers": []string{authServer.URL},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(mcpServer.Close)
+
+	cfg, err := DiscoverOAuth(t.Context(), mcpServer.URL+"/mcp", "")
+	require.NoError(t, err)
+	require.Nil(t, cfg, "metadata for another resource must be ignored")
+	require.Zero(t, authHits.Load())
+}
+
+func TestResourceMatches(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		resource string
+		want     bool
+	}{
+		{"https://mcp.example/mcp", true},
+		{"https://MCP.example/mcp/", true},
+		{"https://mcp.example", true},
+		{"https://mcp.example/", true},
+		{"https://mcp.example/mc", false},
+		{"https://mcp.example/mcp/v2", false},
+		{"http://mcp.example/mcp", false},
+		{"https://mcp.example:8443/mcp", false},
+		{"https://other.example/mcp", false},
+		{"https://mcp.example.evil.net/mcp", false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, resourceMatches(tt.resource, "https://mcp.example/mcp"), tt.resource)
+	}
+}
+
+func TestDiscoverOAuth_FallsBackToWellKnown(t *testing.T) {
+	t.Parallel()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mcp":
+			// No resource_metadata hint.
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcp"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/.well-known/oauth-authorization-server":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"issuer":                   srv.URL,
+				"authorization_endpoint":   srv.URL + "/authorize",
+				"token_endpoint":           srv.URL + "/token",
+				"response_types_supported": []string{"code"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg, err := DiscoverOAuth(t.Context(), srv.URL+"/mcp", "")
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, srv.URL+"/authorize", cfg.AuthURL)
+	require.Empty(t, cfg.Resource)
+}
+
+// roundTripFunc is an http.RoundTripper backed by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// jsonResponse builds a response with a JSON body.
+func jsonResponse(t *testing.T, status int, v any) *http.Response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(status)
+	require.NoError(t, json.NewEncoder(rec).Encode(v))
+	return rec.Result()
+}
+
+func TestDiscoverOAuthWithOptions_Transport(t *testing.T) {
+	t.Parallel()
+
+	var requested []string
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.URL.String())
+		switch r.URL.Path {
+		case "/.well-known/oauth-authorization-server":
+			return jsonResponse(t, http.StatusOK, map[string]any{
+				"issuer":                   "https://mcp.example.com",
+				"authorization_endpoint":   "https://mcp.example.com/authorize",
+				"token_endpoint":           "https://mcp.example.com/token",
+				"response_types_supported": []string{"code"},
+			}), nil
+		case "/token":
+			return jsonResponse(t, http.StatusOK, map[string]any{"access_token": "mocked"}), nil
+		}
+		return jsonResponse(t, http.StatusNotFound, map[string]any{}), nil
+	})
+
+	cfg, err := DiscoverOAuthWithOptions(t.Context(), "https://mcp.example.com/mcp", DiscoveryOptions{Transport: transport})
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, "https://mcp.example.com/token", cfg.TokenURL)
+
+	// Token requests made with the discovered config use the same transport.
+	cfg.ClientID = "client"
+	token, err := RefreshToken(t.Context(), *cfg, "refresh")
+	require.NoError(t, err)
+	require.Equal(t, "mocked", token.AccessToken)
+	require.Contains(t, requested, "https://mcp.example.com/token")
+}
+
+func TestDiscoverOAuth_Retries(t *testing.T) {
+	t.Parallel()
+
+	metadata := map[string]any{
+		"issuer":                   "https://mcp.example.com",
+		"authorization_endpoint":   "https://mcp.example.com/authorize",
+		"token_endpoint":           "https://mcp.example.com/token",
+		"response_types_supported": []string{"code"},
+	}
+	discover := func(transport roundTripFunc) (*Config, error) {
+		return DiscoverOAuthWithOptions(t.Context(), "https://mcp.example.com/mcp", DiscoveryOptions{Transport: transport})
+	}
+
+	t.Run("recovers from transient failures", func(t *testing.T) {
+		t.Parallel()
+		var failures int
+		cfg, err := discover(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/mcp" {
+				return jsonResponse(t, http.StatusUnauthorized, map[string]any{}), nil
+			}
+			if failures < 2 {
+				failures++
+				return jsonResponse(t, http.StatusServiceUnavailable, map[string]any{}), nil
+			}
+			return jsonResponse(t, http.StatusOK, metadata), nil
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		require.Equal(t, 2, failures)
+	})
+
+	t.Run("network errors are unavailable", func(t *testing.T) {
+		t.Parallel()
+		var attempts int
+		cfg, err := DiscoverOAuthWithOptions(t.Context(), "https://mcp.example.com/mcp", DiscoveryOptions{
+			Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+				attempts++
+				return nil, errors.New("connection refused")
+			}),
+			RequireOAuth: true,
+		})
+		require.ErrorIs(t, err, ErrDiscoveryUnavailable)
+		require.ErrorContains(t, err, "connection refused")
+		require.Nil(t, cfg)
+		require.Equal(t, 2*discoveryAttempts, attempts, "probe and well-known are both retried")
+	})
+
+	t.Run("failures without a challenge mean no OAuth", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := discover(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/mcp" {
+				return jsonResponse(t, http.StatusMethodNotAllowed, map[string]any{}), nil
+			}
+			return jsonResponse(t, http.StatusBadGateway, map[string]any{}), nil
+		})
+		require.NoError(t, err)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("failures after a challenge are unavailable", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := discover(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/mcp" {
+				return jsonResponse(t, http.StatusUnauthorized, map[string]any{}), nil
+			}
+			return jsonResponse(t, http.StatusBadGateway, map[string]any{}), nil
+		})
+		require.ErrorIs(t, err, ErrDiscoveryUnavailable)
+		require.Nil(t, cfg)
+	})
+
+	t.Run("failing probe falls back to well-known", func(t *testing.T) {
+		t.Parallel()
+		var probes int
+		cfg, err := discover(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/mcp" {
+				probes++
+				return jsonResponse(t, http.StatusInternalServerError, map[string]any{}), nil
+			}
+			return jsonResponse(t, http.StatusOK, metadata), nil
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cfg)
+		require.Equal(t, "https://mcp.example.com/token", cfg.TokenURL)
+		require.Equal(t, discoveryAttempts, probes)
+	})
+
+	t.Run("cross-host endpoints are reported", func(t *testing.T) {
+		t.Parallel()
+		cfg, err := discover(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path == "/mcp" {
+				return jsonResponse(t, http.StatusUnauthorized, map[string]any{}), nil
+			}
+			return jsonResponse(t, http.StatusOK, map[string]any{
+				"issuer":                   "https://mcp.example.com",
+				"authorization_endpoint":   "https://mcp.example.com/authorize",
+				"token_endpoint":           "https://tokens.example.net/token",
+				"response_types_supported": []string{"code"},
+			}), nil
+		})
+		require.ErrorIs(t, err, ErrEndpointHostRejected)
+		require.ErrorContains(t, err, "tokens.example.net")
+		require.Nil(t, cfg)
+	})
+
+	t.Run("missing metadata means no oauth", func(t *testing.T) {
+		t.Parallel()
+		var attempts int
+		cfg, err := discover(func(*http.Request) (*http.Response, error) {
+			attempts++
+			return jsonResponse(t, http.StatusNotFound, map[string]any{}), nil
+		})
+		require.NoError(t, err)
+		require.Nil(t, cfg)
+		require.Equal(t, 2, attempts, "probe and well-known are not retried")
+	})
+}
+
+func TestAuthParam(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		challenge string
+		want      string
+	}{
+		{`Bearer resource_metadata="https://a.example/prm"`, "https://a.example/prm"},
+		{`Bearer realm="mcp", resource_metadata="https://a.example/prm", scope="x"`, "https://a.example/prm"},
+		{`Bearer Resource_Metadata=https://a.example/prm, scope=x`, "https://a.example/prm"},
+		{`Bearer x_resource_metadata="https://evil.example"`, ""},
+		{`Bearer realm="mcp"`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, authParam(tt.challenge, "resource_metadata"), tt.challenge)
+	}
+}
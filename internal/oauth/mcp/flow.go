@@ -3,10 +3,12 @@ package mcp
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net/url"
+	"os"
 	"strconv"
 	"time"
 
@@ -24,24 +26,97 @@ type AuthFlowOptions struct {
 	Timeout time.Duration
 	// OpenBrowser controls whether to automatically open the browser
 	OpenBrowser bool
+	// BrowserCommand, when set, is run with the authorization URL appended
+	// instead of the platform's default browser opener.
+	BrowserCommand []string
+	// ReturnURL, when set, is opened from the success page of the callback,
+	// e.g. a deep link back to the terminal. It may use any scheme but
+	// javascript.
+	ReturnURL string
 	// OnAuthURL is called with the authorization URL (for displaying to user)
 	OnAuthURL func(url string)
 	// OnBrowserFailed is called when the browser fails to open automatically.
 	OnBrowserFailed func(authURL string, err error)
+	// OnCallbackReceived is called when the OAuth callback arrives, whether
+	// authorization succeeded or not. Use it to notify users who switched
+	// away from the terminal, e.g. with a bell or desktop notification.
+	OnCallbackReceived func(result CallbackResult)
+	// OnStage is called on every stage transition of the flow, so a UI can
+	// show progress and tell which stage failed.
+	OnStage func(event StageEvent)
+}
+
+// AuthStage is a stage of the OAuth authorization process.
+type AuthStage string
+
+const (
+	// AuthStageDiscovering means the authorization server metadata is being
+	// discovered.
+	AuthStageDiscovering AuthStage = "discovering"
+	// AuthStageDiscovered means discovery found the authorization server
+	// metadata. Further stages follow only if authorization is needed.
+	AuthStageDiscovered AuthStage = "discovered"
+	// AuthStageNone means discovery found that the server does not use
+	// OAuth.
+	AuthStageNone AuthStage = "none"
+	// AuthStageRegistering means the client is being registered
+	// dynamically.
+	AuthStageRegistering AuthStage = "registering"
+	// AuthStageAwaitingBrowser means the authorization URL is being opened
+	// in the browser.
+	AuthStageAwaitingBrowser AuthStage = "awaiting_browser"
+	// AuthStageAwaitingCallback means the flow waits for the user to
+	// authorize and the callback to arrive.
+	AuthStageAwaitingCallback AuthStage = "awaiting_callback"
+	// AuthStageExchanging means the authorization code is being exchanged
+	// for tokens.
+	AuthStageExchanging AuthStage = "exchanging"
+	// AuthStageComplete means authorization succeeded.
+	AuthStageComplete AuthStage = "complete"
+	// AuthStageFailed means authorization failed; see StageEvent.FailedStage.
+	AuthStageFailed AuthStage = "failed"
+)
+
+// StageEvent describes a stage transition of the OAuth authorization
+// process.
+type StageEvent struct {
+	Stage AuthStage
+	// FailedStage is the stage that failed, when Stage is AuthStageFailed.
+	FailedStage AuthStage
+	// Error is the failure, when Stage is AuthStageFailed.
+	Error error
+}
+
+// CallbackResult describes a received OAuth callback.
+type CallbackResult struct {
+	// Success reports whether the callback carried a valid authorization
+	// code.
+	Success bool
+	// Error is the reason authorization failed, if it did.
+	Error string
 }
 
 // DefaultAuthFlowOptions returns the default options for the auth flow.
 func DefaultAuthFlowOptions() AuthFlowOptions {
 	return AuthFlowOptions{
-		Timeout:     DefaultAuthTimeout,
-		OpenBrowser: true,
+		Timeout:        DefaultAuthTimeout,
+		OpenBrowser:    true,
+		BrowserCommand: browserCommandFromEnv(),
+		ReturnURL:      os.Getenv(ReturnURLEnv),
 	}
 }
 
 // StartAuthFlow initiates the complete OAuth authorization flow.
 // It starts a local callback server, opens the browser for authorization,
 // waits for the callback, and exchanges the code for tokens.
-func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oauth.Token, error) {
+func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (token *oauth.Token, err error) {
+	stage := AuthStageAwaitingBrowser
+	defer func() {
+		if err != nil {
+			notifyStage(opts, StageEvent{Stage: AuthStageFailed, FailedStage: stage, Error: err})
+		}
+	}()
+
 	// Create a context with timeout for the entire flow
 	flowCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
 	defer cancel()
@@ -50,7 +125,10 @@ func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oaut
 	verifier, challenge := generatePKCE()
 
 	// Generate random state for CSRF protection
-	state := generateState()
+	state, err := generateState()
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse redirect URI to extract port and path (already validated by Config.Validate())
 	callbackPort, callbackPath := parseRedirectURI(cfg.RedirectURI)
@@ -61,6 +139,7 @@ func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oaut
 		return nil, fmt.Errorf("failed to start callback server: %w", err)
 	}
 	defer server.Close()
+	server.returnURL = opts.ReturnURL
 	server.Start()
 
 	// Use the server's redirect URI (includes actual port if we used random)
@@ -77,6 +156,8 @@ func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oaut
 		"redirect_uri", cfg.RedirectURI,
 	)
 
+	notifyStage(opts, StageEvent{Stage: stage})
+
 	// Notify caller of the auth URL
 	if opts.OnAuthURL != nil {
 		opts.OnAuthURL(authURL)
@@ -84,7 +165,7 @@ func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oaut
 
 	// Open browser if requested
 	if opts.OpenBrowser {
-		if err = openBrowser(authURL); err != nil {
+		if err = openBrowser(opts.BrowserCommand, authURL); err != nil {
 			slog.Warn("Failed to open browser automatically", "error", err)
 			if opts.OnBrowserFailed != nil {
 				opts.OnBrowserFailed(authURL, err)
@@ -93,6 +174,8 @@ func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oaut
 	}
 
 	// Wait for the callback
+	stage = AuthStageAwaitingCallback
+	notifyStage(opts, StageEvent{Stage: stage})
 	result, err := server.waitForCallback(flowCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed waiting for OAuth callback: %w", err)
@@ -100,25 +183,55 @@ func StartAuthFlow(ctx context.Context, cfg Config, opts AuthFlowOptions) (*oaut
 
 	// Check for errors in the callback
 	if result.Error != "" {
+		notifyCallback(opts, CallbackResult{Error: result.Error})
 		return nil, fmt.Errorf("failed OAuth authorization: %s", result.Error)
 	}
 
 	// Verify state to prevent CSRF
-	if result.State != state {
+	if !validState(result.State, state) {
+		notifyCallback(opts, CallbackResult{Error: "state mismatch"})
 		return nil, fmt.Errorf("mismatch in OAuth state")
 	}
+	notifyCallback(opts, CallbackResult{Success: true})
 
 	// Exchange the code for tokens
-	token, err := exchangeToken(flowCtx, cfg, result.Code, verifier)
+	stage = AuthStageExchanging
+	notifyStage(opts, StageEvent{Stage: stage})
+	token, err = exchangeToken(flowCtx, cfg, result.Code, verifier)
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
 	}
 
 	slog.Info("OAuth authorization successful")
+	notifyStage(opts, StageEvent{Stage: AuthStageComplete})
 
 	return token, nil
 }
 
+// validState reports whether the state returned in the callback matches the
+// expected one. The comparison is constant-time, and an empty state never
+// matches.
+func validState(got, want string) bool {
+	if got == "" || want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// notifyCallback calls the OnCallbackReceived hook, if set.
+func notifyCallback(opts AuthFlowOptions, result CallbackResult) {
+	if opts.OnCallbackReceived != nil {
+		opts.OnCallbackReceived(result)
+	}
+}
+
+// notifyStage calls the OnStage hook, if set.
+func notifyStage(opts AuthFlowOptions, event StageEvent) {
+	if opts.OnStage != nil {
+		opts.OnStage(event)
+	}
+}
+
 // parseRedirectURI parses a validated redirect URI into port and path components.
 // The URI must be validated via Config.Validate() before calling this function.
 func parseRedirectURI(redirectURI string) (port int, path string) {
@@ -138,11 +251,12 @@ func parseRedirectURI(redirectURI string) (port int, path string) {
 	return port, path
 }
 
-// generateState generates a random state string for CSRF protection.
-// It panics if the system's random number generator fails, which indicates
-// a fundamental system problem that cannot be recovered from.
-func generateState() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	return hex.EncodeToString(b)
+// generateState generates a random state string for CSRF protection from 32
+// random bytes, matching the PKCE verifier.
+func generateState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate OAuth state: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
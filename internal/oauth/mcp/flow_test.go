@@ -2,6 +2,7 @@ package mcp
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -127,15 +128,20 @@ func TestStartAuthFlow(t *testing.T) {
 
 func TestGenerateState(t *testing.T) {
 
-	// 32 hex chars
-	state1 := generateState()
-	require.Len(t, state1, 32)
+	seen := make(map[string]bool)
+	for range 1000 {
+		state, err := generateState()
+		require.NoError(t, err)
 
-	state2 := generateState()
-	require.Len(t, state2, 32)
+		// 64 hex chars
+		require.Len(t, state, 64)
+		_, err = hex.DecodeString(state)
+		require.NoError(t, err)
 
-	// States should be unique
-	require.NotEqual(t, state1, state2)
+		// States should be unique
+		require.False(t, seen[state], "duplicate state %q", state)
+		seen[state] = true
+	}
 }
 
 func TestParseRedirectURI(t *testing.T) {
@@ -187,3 +193,181 @@ func TestParseRedirectURI(t *testing.T) {
 		})
 	}
 }
+
+func TestStartAuthFlow_OnCallbackReceived(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token",
+			"token_type":   "Bearer",
+		})
+	}))
+	defer tokenServer.Close()
+
+	tests := []struct {
+		name  string
+		query func(state string) string
+		want  CallbackResult
+	}{
+		{
+			name:  "success",
+			query: func(state string) string { return "code=test-code&state=" + state },
+			want:  CallbackResult{Success: true},
+		},
+		{
+			name:  "authorization denied",
+			query: func(state string) string { return "error=access_denied&state=" + state },
+			want:  CallbackResult{Error: "access_denied"},
+		},
+		{
+			name:  "state mismatch",
+			query: func(string) string { return "code=test-code&state=wrong" },
+			want:  CallbackResult{Error: "state mismatch"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				ClientID:    "test-client",
+				AuthURL:     "http://localhost:19999/authorize",
+				TokenURL:    tokenServer.URL,
+				RedirectURI: "http://localhost:0/callback",
+			}
+
+			authURLs := make(chan string, 1)
+			received := make(chan CallbackResult, 1)
+			opts := AuthFlowOptions{
+				Timeout:            5 * time.Second,
+				OnAuthURL:          func(authURL string) { authURLs <- authURL },
+				OnCallbackReceived: func(result CallbackResult) { received <- result },
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := StartAuthFlow(context.Background(), cfg, opts)
+				done <- err
+			}()
+
+			var authURL *url.URL
+			select {
+			case raw := <-authURLs:
+				var err error
+				authURL, err = url.Parse(raw)
+				require.NoError(t, err)
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for auth URL")
+			}
+
+			redirectURI := authURL.Query().Get("redirect_uri")
+			resp, err := http.Get(redirectURI + "?" + tt.query(authURL.Query().Get("state")))
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+
+			select {
+			case err := <-done:
+				require.Equal(t, tt.want.Success, err == nil)
+			case <-time.After(3 * time.Second):
+				t.Fatal("timeout waiting for auth flow to complete")
+			}
+			require.Equal(t, tt.want, <-received)
+		})
+	}
+}
+
+func TestStartAuthFlow_OnStage(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokenOK    bool
+		wantStages []AuthStage
+		wantFailed AuthStage
+	}{
+		{
+			name:       "success",
+			tokenOK:    true,
+			wantStages: []AuthStage{AuthStageAwaitingBrowser, AuthStageAwaitingCallback, AuthStageExchanging, AuthStageComplete},
+		},
+		{
+			name:       "token exchange fails",
+			wantStages: []AuthStage{AuthStageAwaitingBrowser, AuthStageAwaitingCallback, AuthStageExchanging, AuthStageFailed},
+			wantFailed: AuthStageExchanging,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.tokenOK {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "token"})
+			}))
+			defer tokenServer.Close()
+
+			cfg := Config{
+				ClientID:    "test-client",
+				AuthURL:     "http://localhost:19999/authorize",
+				TokenURL:    tokenServer.URL,
+				RedirectURI: "http://localhost:0/callback",
+			}
+
+			authURLs := make(chan string, 1)
+			events := make(chan StageEvent, 10)
+			opts := AuthFlowOptions{
+				Timeout:   5 * time.Second,
+				OnAuthURL: func(authURL string) { authURLs <- authURL },
+				OnStage:   func(event StageEvent) { events <- event },
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := StartAuthFlow(context.Background(), cfg, opts)
+				done <- err
+			}()
+
+			var authURL *url.URL
+			select {
+			case raw := <-authURLs:
+				var err error
+				authURL, err = url.Parse(raw)
+				require.NoError(t, err)
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for auth URL")
+			}
+
+			query := authURL.Query()
+			resp, err := http.Get(query.Get("redirect_uri") + "?code=test-code&state=" + query.Get("state"))
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+
+			select {
+			case err := <-done:
+				require.Equal(t, tt.tokenOK, err == nil)
+			case <-time.After(3 * time.Second):
+				t.Fatal("timeout waiting for auth flow to complete")
+			}
+			close(events)
+
+			var stages []AuthStage
+			var last StageEvent
+			for event := range events {
+				stages = append(stages, event.Stage)
+				last = event
+			}
+			require.Equal(t, tt.wantStages, stages)
+			require.Equal(t, tt.wantFailed, last.FailedStage)
+			require.Equal(t, tt.wantFailed != "", last.Error != nil)
+		})
+	}
+}
+
+func TestValidState(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, validState("abc123", "abc123"))
+	require.False(t, validState("abc123", "abc124"))
+	require.False(t, validState("abc", "abc123"))
+	require.False(t, validState("", "abc123"))
+	require.False(t, validState("abc123", ""))
+	require.False(t, validState("", ""))
+}
@@ -0,0 +1,56 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// IntrospectToken asks the authorization server whether a token is still
+// active (RFC 7662). It returns an error if the configuration has no
+// introspection endpoint or the request fails.
+func IntrospectToken(ctx context.Context, cfg Config, token string) (bool, error) {
+	if cfg.IntrospectionEndpoint == "" {
+		return false, fmt.Errorf("introspection endpoint is required")
+	}
+
+	data := url.Values{}
+	data.Set("token", token)
+	data.Set("token_type_hint", "access_token")
+	cfg.setClientSecret(data)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.IntrospectionEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return false, fmt.Errorf("failed to create introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	cfg.setBasicAuth(req)
+
+	resp, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return false, fmt.Errorf("introspection request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read introspection response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("introspection request failed: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var introspectResp struct {
+		Active bool `json:"active"`
+	}
+	if err = json.Unmarshal(body, &introspectResp); err != nil {
+		return false, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+
+	return introspectResp.Active, nil
+}
@@ -0,0 +1,60 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	t.Run("reports active token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "POST", r.Method)
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "access-token", r.FormValue("token"))
+			require.Equal(t, "test-client", r.FormValue("client_id"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": true})
+		}))
+		defer server.Close()
+
+		cfg := Config{ClientID: "test-client", IntrospectionEndpoint: server.URL}
+		active, err := IntrospectToken(context.Background(), cfg, "access-token")
+		require.NoError(t, err)
+		require.True(t, active)
+	})
+
+	t.Run("reports inactive token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"active": false})
+		}))
+		defer server.Close()
+
+		cfg := Config{ClientID: "test-client", IntrospectionEndpoint: server.URL}
+		active, err := IntrospectToken(context.Background(), cfg, "revoked-token")
+		require.NoError(t, err)
+		require.False(t, active)
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		cfg := Config{ClientID: "test-client", IntrospectionEndpoint: server.URL}
+		_, err := IntrospectToken(context.Background(), cfg, "access-token")
+		require.Error(t, err)
+	})
+
+	t.Run("requires introspection endpoint", func(t *testing.T) {
+		_, err := IntrospectToken(context.Background(), Config{ClientID: "test-client"}, "access-token")
+		require.Error(t, err)
+	})
+}
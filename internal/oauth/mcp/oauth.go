@@ -2,13 +2,16 @@
 package mcp
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,8 +21,61 @@ import (
 const (
 	// DefaultRedirectURI is the default redirect URI using the default callback port.
 	DefaultRedirectURI = "http://localhost:19876/callback"
+	// DefaultHTTPTimeout is the default timeout for requests to the
+	// authorization server.
+	DefaultHTTPTimeout = 30 * time.Second
 )
 
+// maxResponseSize caps how much of a response from an OAuth endpoint is read.
+const maxResponseSize = 1 << 20
+
+// ErrResponseTooLarge is returned when an OAuth endpoint responds with more
+// than maxResponseSize bytes.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// readBody reads r up to maxResponseSize bytes, failing with
+// ErrResponseTooLarge instead of reading further.
+func readBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, maxResponseSize)
+	}
+	return body, nil
+}
+
+// ErrEndpointNotFound is returned when an OAuth endpoint responds as if it
+// does not exist, which usually means previously discovered metadata is stale.
+var ErrEndpointNotFound = errors.New("oauth endpoint not found")
+
+// ScopeError reports that the authorization server granted fewer scopes than
+// required, so using the token would only be rejected again.
+type ScopeError struct {
+	Granted  []string
+	Required []string
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("server granted scopes %q but %q was required", strings.Join(e.Granted, " "), strings.Join(e.Required, " "))
+}
+
+// CheckScopes returns a *ScopeError if token lacks any of the required
+// scopes. A token without granted scopes passes, since servers omit the scope
+// of a token response when it matches the request (RFC 6749, section 5.1).
+func CheckScopes(required []string, token *oauth.Token) error {
+	if len(required) == 0 || len(token.GrantedScopes) == 0 {
+		return nil
+	}
+	for _, scope := range required {
+		if !slices.Contains(token.GrantedScopes, scope) {
+			return &ScopeError{Granted: token.GrantedScopes, Required: required}
+		}
+	}
+	return nil
+}
+
 // Config holds the OAuth configuration for an MCP server.
 type Config struct {
 	ClientID             string
@@ -29,6 +85,92 @@ type Config struct {
 	Scopes               []string
 	RedirectURI          string
 	RegistrationEndpoint string // For dynamic client registration (RFC 7591)
+	// IntrospectionEndpoint is used to check whether a token is still
+	// active (RFC 7662).
+	IntrospectionEndpoint string
+	// TokenEndpointAuthMethod is how the client authenticates to the token
+	// and introspection endpoints. "client_secret_basic" sends the
+	// credentials with HTTP Basic authentication; anything else sends the
+	// client secret, if any, in the request body.
+	TokenEndpointAuthMethod string
+	// RequiredScopes must all be granted for a token to be usable. Scopes
+	// only lists what is requested, which may be everything the server
+	// supports.
+	RequiredScopes []string
+	// Resource is the canonical URI of the protected resource, sent as the
+	// resource parameter (RFC 8707) so tokens are minted for this server.
+	// It is taken from the protected resource metadata when discovered.
+	Resource string
+	// DefaultExpiresIn is the lifetime assumed for tokens issued without a
+	// positive expires_in. When zero, such tokens never expire locally and
+	// are only refreshed once the server rejects them.
+	DefaultExpiresIn time.Duration
+	// ExpirySkew is how long before their expiry tokens are refreshed. It is
+	// capped at half of a token's lifetime. Zero uses
+	// oauth.DefaultExpirySkew.
+	ExpirySkew time.Duration
+	// HTTPTimeout bounds each request made to the authorization server.
+	// Zero uses DefaultHTTPTimeout.
+	HTTPTimeout time.Duration
+	// Transport sends the requests made to the authorization server, e.g.
+	// through a proxy or with tracing. Nil uses http.DefaultTransport.
+	Transport http.RoundTripper
+	// Registration overrides the client metadata sent during dynamic client
+	// registration.
+	Registration RegistrationMetadata
+}
+
+// RegistrationMetadata is optional client metadata for dynamic client
+// registration (RFC 7591). Empty fields keep the defaults.
+type RegistrationMetadata struct {
+	// ClientName defaults to "crush-oauth-client".
+	ClientName string
+	// TokenEndpointAuthMethod defaults to "none" (public client).
+	TokenEndpointAuthMethod string
+	// SoftwareID identifies the client software across registrations.
+	SoftwareID string
+	// SoftwareVersion is the version of the client software.
+	SoftwareVersion string
+	// Contacts are email addresses of people responsible for the client.
+	Contacts []string
+	// LogoURI is a URL of the client's logo.
+	LogoURI string
+	// SoftwareStatement is a signed JWT asserting the client metadata, for
+	// servers that require one.
+	SoftwareStatement string
+}
+
+// usesBasicAuth reports whether the client authenticates with HTTP Basic
+// authentication.
+func (c *Config) usesBasicAuth() bool {
+	return c.TokenEndpointAuthMethod == "client_secret_basic" && c.ClientSecret != ""
+}
+
+// setClientSecret adds the client ID, and the client secret unless it is
+// sent with HTTP Basic authentication, to a request body.
+func (c *Config) setClientSecret(data url.Values) {
+	data.Set("client_id", c.ClientID)
+	if c.ClientSecret != "" && !c.usesBasicAuth() {
+		data.Set("client_secret", c.ClientSecret)
+	}
+}
+
+// setBasicAuth authenticates the client with HTTP Basic authentication if
+// it was registered for it. The credentials are form-encoded first, as
+// required by RFC 6749 section 2.3.1.
+func (c *Config) setBasicAuth(req *http.Request) {
+	if c.usesBasicAuth() {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+}
+
+// httpClient returns the client used for requests to the authorization
+// server.
+func (c *Config) httpClient() *http.Client {
+	return &http.Client{
+		Transport: c.Transport,
+		Timeout:   cmp.Or(c.HTTPTimeout, DefaultHTTPTimeout),
+	}
 }
 
 // SupportsDynamicRegistration returns true if dynamic client registration is available.
@@ -69,6 +211,12 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.IntrospectionEndpoint != "" {
+		if _, err := url.Parse(c.IntrospectionEndpoint); err != nil {
+			return fmt.Errorf("invalid introspection_endpoint: %w", err)
+		}
+	}
+
 	// Must have either ClientID or RegistrationEndpoint for dynamic registration
 	if c.ClientID == "" && c.RegistrationEndpoint == "" {
 		return fmt.Errorf("either client_id or registration_endpoint must be set")
@@ -113,6 +261,9 @@ func authorizeURL(cfg Config, state string, challenge string) (string, error) {
 	if len(cfg.Scopes) > 0 {
 		q.Set("scope", strings.Join(cfg.Scopes, " "))
 	}
+	if cfg.Resource != "" {
+		q.Set("resource", cfg.Resource)
+	}
 
 	// PKCE is mandatory per RFC 7636
 	q.Set("code_challenge", challenge)
@@ -130,16 +281,15 @@ func exchangeToken(ctx context.Context, cfg Config, code, verifier string) (*oau
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
 	data.Set("redirect_uri", cfg.RedirectURI)
-	data.Set("client_id", cfg.ClientID)
-
-	if cfg.ClientSecret != "" {
-		data.Set("client_secret", cfg.ClientSecret)
-	}
+	cfg.setClientSecret(data)
 
 	// PKCE is mandatory per RFC 7636
 	data.Set("code_verifier", verifier)
+	if cfg.Resource != "" {
+		data.Set("resource", cfg.Resource)
+	}
 
-	return doTokenRequest(ctx, cfg.TokenURL, data)
+	return doTokenRequest(ctx, cfg, data)
 }
 
 // RefreshToken refreshes an expired access token using the refresh token.
@@ -147,36 +297,40 @@ func RefreshToken(ctx context.Context, cfg Config, refreshToken string) (*oauth.
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", refreshToken)
-	data.Set("client_id", cfg.ClientID)
+	cfg.setClientSecret(data)
 
-	if cfg.ClientSecret != "" {
-		data.Set("client_secret", cfg.ClientSecret)
+	if cfg.Resource != "" {
+		data.Set("resource", cfg.Resource)
 	}
 
-	return doTokenRequest(ctx, cfg.TokenURL, data)
+	return doTokenRequest(ctx, cfg, data)
 }
 
-func doTokenRequest(ctx context.Context, tokenURL string, data url.Values) (*oauth.Token, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
+func doTokenRequest(ctx context.Context, cfg Config, data url.Values) (*oauth.Token, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.TokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create token request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
+	cfg.setBasicAuth(req)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := cfg.httpClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("token request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read token response: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("token request failed: %w: status %d", ErrEndpointNotFound, resp.StatusCode)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("token request failed: status %d, body: %s", resp.StatusCode, string(body))
 	}
@@ -197,8 +351,36 @@ func doTokenRequest(ctx context.Context, tokenURL string, data url.Values) (*oau
 		AccessToken:  tokenResp.AccessToken,
 		RefreshToken: tokenResp.RefreshToken,
 		ExpiresIn:    tokenResp.ExpiresIn,
+		TokenType:    tokenResp.TokenType,
+	}
+	if tokenResp.Scope != "" {
+		token.GrantedScopes = strings.Fields(tokenResp.Scope)
+	}
+	if token.ExpiresIn <= 0 && cfg.DefaultExpiresIn > 0 {
+		token.ExpiresIn = int(cfg.DefaultExpiresIn.Seconds())
 	}
-	token.SetExpiresAt()
+	SetExpiresAt(token, time.Now())
 
 	return token, nil
 }
+
+// SetExpiresAt sets the expiry of an MCP token issued at now. Unlike
+// oauth.Token.SetExpiresAt, a token without a positive ExpiresIn gets no
+// expiry, as MCP servers may issue tokens that are valid until revoked.
+func SetExpiresAt(token *oauth.Token, now time.Time) {
+	if token.ExpiresIn <= 0 {
+		token.ExpiresAt = 0
+		return
+	}
+	token.SetExpiresAtFrom(now)
+}
+
+// IsExpiredAt reports whether an MCP token needs a refresh at now, skew
+// before its expiry. A zero skew uses oauth.DefaultExpirySkew. Tokens without
+// an expiry never need one; they are used until the server rejects them.
+func IsExpiredAt(token *oauth.Token, now time.Time, skew time.Duration) bool {
+	if token.ExpiresAt == 0 {
+		return false
+	}
+	return token.IsExpiredAt(now, cmp.Or(skew, oauth.DefaultExpirySkew))
+}
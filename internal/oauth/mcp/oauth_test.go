@@ -1,9 +1,16 @@
 package mcp
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/charmbracelet/crush/internal/oauth"
 	"github.com/stretchr/testify/require"
 )
 
@@ -165,3 +172,155 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceParameter(t *testing.T) {
+	const resource = "https://mcp.example.com/mcp"
+
+	// The mock authorization server mints tokens for the requested audience.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "aud=" + r.PostForm.Get("resource"),
+		})
+	}))
+	defer server.Close()
+
+	cfg := Config{
+		ClientID:    "test-client",
+		AuthURL:     "https://auth.example.com/authorize",
+		TokenURL:    server.URL,
+		RedirectURI: "http://localhost:8080/callback",
+		Resource:    resource,
+	}
+
+	authURL, err := authorizeURL(cfg, "state", "challenge")
+	require.NoError(t, err)
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, resource, parsed.Query().Get("resource"))
+
+	token, err := exchangeToken(context.Background(), cfg, "code", "verifier")
+	require.NoError(t, err)
+	require.Equal(t, "aud="+resource, token.AccessToken)
+
+	token, err = RefreshToken(context.Background(), cfg, "refresh")
+	require.NoError(t, err)
+	require.Equal(t, "aud="+resource, token.AccessToken)
+
+	cfg.Resource = ""
+	token, err = exchangeToken(context.Background(), cfg, "code", "verifier")
+	require.NoError(t, err)
+	require.Equal(t, "aud=", token.AccessToken, "no resource without discovery")
+}
+
+func TestRefreshToken_ParsesTokenTypeAndScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "new-access",
+			"refresh_token": "new-refresh",
+			"expires_in":    3600,
+			"token_type":    "DPoP",
+			"scope":         "read  write",
+		})
+	}))
+	defer server.Close()
+
+	cfg := Config{ClientID: "test-client", TokenURL: server.URL}
+	token, err := RefreshToken(context.Background(), cfg, "old-refresh")
+	require.NoError(t, err)
+	require.Equal(t, "DPoP", token.TokenType)
+	require.Equal(t, []string{"read", "write"}, token.GrantedScopes)
+}
+
+func TestRefreshToken_ZeroExpiresIn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "new-access",
+			"expires_in":   0,
+		})
+	}))
+	defer server.Close()
+
+	t.Run("treated as non-expiring by default", func(t *testing.T) {
+		cfg := Config{ClientID: "test-client", TokenURL: server.URL}
+		token, err := RefreshToken(context.Background(), cfg, "old-refresh")
+		require.NoError(t, err)
+		require.Zero(t, token.ExpiresAt)
+		require.False(t, IsExpiredAt(token, time.Now().Add(100*365*24*time.Hour), 0))
+	})
+
+	t.Run("uses configured fallback lifetime", func(t *testing.T) {
+		cfg := Config{ClientID: "test-client", TokenURL: server.URL, DefaultExpiresIn: time.Hour}
+		token, err := RefreshToken(context.Background(), cfg, "old-refresh")
+		require.NoError(t, err)
+		require.Equal(t, 3600, token.ExpiresIn)
+		require.InDelta(t, time.Now().Add(time.Hour).Unix(), token.ExpiresAt, 5)
+		require.False(t, IsExpiredAt(token, time.Now(), 0))
+		require.True(t, IsExpiredAt(token, time.Now().Add(2*time.Hour), 0))
+	})
+}
+
+func TestCheckScopes(t *testing.T) {
+	t.Parallel()
+
+	granted := func(scopes ...string) *oauth.Token {
+		return &oauth.Token{AccessToken: "a", GrantedScopes: scopes}
+	}
+
+	require.NoError(t, CheckScopes(nil, granted("read")))
+	require.NoError(t, CheckScopes([]string{"read"}, granted()), "an omitted scope means the request was granted")
+	require.NoError(t, CheckScopes([]string{"read"}, granted("read", "write")))
+
+	err := CheckScopes([]string{"read", "admin"}, granted("read"))
+	var scopeErr *ScopeError
+	require.ErrorAs(t, err, &scopeErr)
+	require.Equal(t, []string{"read"}, scopeErr.Granted)
+	require.EqualError(t, err, `server granted scopes "read" but "read admin" was required`)
+}
+
+func TestOversizedResponses(t *testing.T) {
+	t.Parallel()
+
+	// oversized answers every request with a JSON object padded past
+	// maxResponseSize.
+	oversized := func(status int) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"access_token":"a","client_id":"c","issuer":"i"` + strings.Repeat(" ", maxResponseSize) + `}`))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	t.Run("token", func(t *testing.T) {
+		t.Parallel()
+		server := oversized(http.StatusOK)
+		_, err := RefreshToken(t.Context(), Config{ClientID: "c", TokenURL: server.URL}, "refresh")
+		require.ErrorIs(t, err, ErrResponseTooLarge)
+	})
+
+	t.Run("registration", func(t *testing.T) {
+		t.Parallel()
+		server := oversized(http.StatusCreated)
+		_, err := RegisterClient(t.Context(), Config{RegistrationEndpoint: server.URL, RedirectURI: DefaultRedirectURI})
+		require.ErrorIs(t, err, ErrResponseTooLarge)
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		t.Parallel()
+		server := oversized(http.StatusOK)
+		_, err := DiscoverOAuth(t.Context(), server.URL, EndpointHostStrict)
+		require.ErrorIs(t, err, ErrResponseTooLarge)
+	})
+
+	t.Run("within the limit", func(t *testing.T) {
+		t.Parallel()
+		body, err := readBody(strings.NewReader(strings.Repeat("x", maxResponseSize)))
+		require.NoError(t, err)
+		require.Len(t, body, maxResponseSize)
+	})
+}
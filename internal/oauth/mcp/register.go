@@ -2,16 +2,31 @@ package mcp
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// registrationMaxAttempts is how many times a registration request is
+	// sent before giving up on transient failures.
+	registrationMaxAttempts = 3
+	// registrationRetryBackoff is the delay before the first retry; later
+	// retries wait proportionally longer.
+	registrationRetryBackoff = 250 * time.Millisecond
+	// registrationMaxRetryAfter caps the Retry-After delay of a rejected
+	// registration; a longer one fails the registration instead.
+	registrationMaxRetryAfter = 30 * time.Second
+)
+
 // ClientRegistrationRequest represents a Dynamic Client Registration request (RFC 7591).
 type ClientRegistrationRequest struct {
 	// RedirectURIs is the list of allowed redirect URIs for this client.
@@ -27,6 +42,16 @@ type ClientRegistrationRequest struct {
 	ResponseTypes []string `json:"response_types,omitempty"`
 	// Scope is the space-separated list of scopes the client is requesting.
 	Scope string `json:"scope,omitempty"`
+	// Contacts are email addresses of people responsible for the client.
+	Contacts []string `json:"contacts,omitempty"`
+	// LogoURI is a URL of the client's logo.
+	LogoURI string `json:"logo_uri,omitempty"`
+	// SoftwareID identifies the client software across registrations.
+	SoftwareID string `json:"software_id,omitempty"`
+	// SoftwareVersion is the version of the client software.
+	SoftwareVersion string `json:"software_version,omitempty"`
+	// SoftwareStatement is a signed JWT asserting the client metadata.
+	SoftwareStatement string `json:"software_statement,omitempty"`
 }
 
 // ClientRegistrationResponse represents the response from client registration.
@@ -58,6 +83,7 @@ type ClientRegistrationResponse struct {
 type ClientCredentials struct {
 	ClientID                string `json:"client_id"`
 	ClientSecret            string `json:"client_secret,omitempty"`
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
 	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
 	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
 }
@@ -73,12 +99,18 @@ func RegisterClient(ctx context.Context, cfg Config) (*ClientCredentials, error)
 	}
 
 	// Build registration request
+	meta := cfg.Registration
 	regReq := ClientRegistrationRequest{
 		RedirectURIs:            []string{cfg.RedirectURI},
-		ClientName:              "crush-oauth-client",
-		TokenEndpointAuthMethod: "none", // Public client
+		ClientName:              cmp.Or(meta.ClientName, "crush-oauth-client"),
+		TokenEndpointAuthMethod: cmp.Or(meta.TokenEndpointAuthMethod, "none"), // Public client by default
 		GrantTypes:              []string{"authorization_code", "refresh_token"},
 		ResponseTypes:           []string{"code"},
+		Contacts:                meta.Contacts,
+		LogoURI:                 meta.LogoURI,
+		SoftwareID:              meta.SoftwareID,
+		SoftwareVersion:         meta.SoftwareVersion,
+		SoftwareStatement:       meta.SoftwareStatement,
 	}
 
 	if len(cfg.Scopes) > 0 {
@@ -93,32 +125,14 @@ func RegisterClient(ctx context.Context, cfg Config) (*ClientCredentials, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize registration request: %w", err)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.RegistrationEndpoint, bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create registration request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, respBody, err := postRegistration(ctx, cfg, body)
 	if err != nil {
-		return nil, fmt.Errorf("registration request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read registration response: %w", err)
+		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		var errResp struct {
-			Error            string `json:"error"`
-			ErrorDescription string `json:"error_description"`
-		}
-		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != "" {
-			return nil, fmt.Errorf("client registration failed: %s - %s", errResp.Error, errResp.ErrorDescription)
+		if err := registrationError(respBody); err != nil {
+			return nil, err
 		}
 		return nil, fmt.Errorf("client registration failed: status %d, body: %s", resp.StatusCode, string(respBody))
 	}
@@ -128,15 +142,177 @@ func RegisterClient(ctx context.Context, cfg Config) (*ClientCredentials, error)
 	if err = json.Unmarshal(respBody, &regResp); err != nil {
 		return nil, fmt.Errorf("failed to parse registration response: %w", err)
 	}
+	if regResp.ClientID == "" {
+		// Some servers report errors with a success status.
+		if err := registrationError(respBody); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("client registration failed: response has no client_id")
+	}
 
 	slog.Info("OAuth client registered successfully",
 		"client_id", regResp.ClientID,
 	)
 
 	return &ClientCredentials{
-		ClientID:                regResp.ClientID,
-		ClientSecret:            regResp.ClientSecret,
+		ClientID:     regResp.ClientID,
+		ClientSecret: regResp.ClientSecret,
+		// The server may register a different method than requested.
+		TokenEndpointAuthMethod: cmp.Or(regResp.TokenEndpointAuthMethod, regReq.TokenEndpointAuthMethod),
 		RegistrationAccessToken: regResp.RegistrationAccessToken,
 		RegistrationClientURI:   regResp.RegistrationClientURI,
 	}, nil
 }
+
+// postRegistration sends the registration request, retrying only failures
+// unlikely to have created a client: connection errors before the request
+// was written, 429 Too Many Requests, and the 502, 503 and 504 answered by
+// gateways and overloaded servers, honoring their Retry-After header. Other
+// server errors, such as 500, are not retried, since the server may have
+// registered the client before failing.
+func postRegistration(ctx context.Context, cfg Config, body []byte) (*http.Response, []byte, error) {
+	client := cfg.httpClient()
+
+	var lastErr error
+	var wait time.Duration
+	for attempt := range registrationMaxAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		wait = time.Duration(attempt+1) * registrationRetryBackoff
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.RegistrationEndpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create registration request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+
+		// A request the server may have received is not retried, as a
+		// second registration could leave an orphaned client behind.
+		var sent atomic.Bool
+		req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			WroteRequest: func(httptrace.WroteRequestInfo) { sent.Store(true) },
+		}))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || sent.Load() {
+				return nil, nil, fmt.Errorf("registration request failed: %w", err)
+			}
+			lastErr = fmt.Errorf("registration request failed: %w", err)
+			slog.Debug("Retrying OAuth client registration", "attempt", attempt+1, "error", err)
+			continue
+		}
+
+		respBody, err := readBody(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read registration response: %w", err)
+		}
+
+		if retryableRegistrationStatus(resp.StatusCode) {
+			lastErr = fmt.Errorf("client registration failed: status %d, body: %s", resp.StatusCode, string(respBody))
+			if d, ok := RetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if d > registrationMaxRetryAfter {
+					return nil, nil, lastErr
+				}
+				wait = d
+			}
+			slog.Debug("Retrying OAuth client registration", "attempt", attempt+1, "status", resp.StatusCode, "wait", wait)
+			continue
+		}
+		return resp, respBody, nil
+	}
+	return nil, nil, lastErr
+}
+
+// retryableRegistrationStatus reports whether a registration answered with
+// the status is retried.
+func retryableRegistrationStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryAfter parses a Retry-After header value, either delay seconds or an
+// HTTP date, into the time to wait from now.
+func RetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		// Clamp absurd values before they overflow a time.Duration.
+		secs = min(secs, int(24*time.Hour/time.Second))
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// registrationError returns the error reported in a registration response
+// body, or nil if it carries none.
+func registrationError(body []byte) error {
+	var errResp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &errResp) != nil || errResp.Error == "" {
+		return nil
+	}
+	return fmt.Errorf("client registration failed: %s - %s", errResp.Error, errResp.ErrorDescription)
+}
+
+// DeleteClientRegistration deletes a dynamically registered client from the
+// authorization server. This implements the delete operation of RFC 7592
+// (OAuth 2.0 Dynamic Client Registration Management). A client the server no
+// longer knows about is treated as already deleted.
+func DeleteClientRegistration(ctx context.Context, cfg Config, accessToken, clientURI string) error {
+	if clientURI == "" {
+		return fmt.Errorf("registration client URI is required")
+	}
+	if accessToken == "" {
+		return fmt.Errorf("registration access token is required")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, clientURI, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create registration delete request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cfg.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("registration delete request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK, http.StatusNotFound, http.StatusGone:
+		slog.Info("OAuth client registration deleted", "uri", clientURI)
+		return nil
+	}
+
+	body, _ := readBody(resp.Body)
+	if err := registrationError(body); err != nil {
+		return err
+	}
+	return fmt.Errorf("client registration delete failed: status %d, body: %s", resp.StatusCode, string(body))
+}
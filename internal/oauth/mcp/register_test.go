@@ -3,9 +3,15 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -66,6 +72,239 @@ func TestRegisterClient(t *testing.T) {
 		require.Nil(t, creds)
 	})
 
+	t.Run("custom registration metadata", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req ClientRegistrationRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+			require.Equal(t, "acme-crush", req.ClientName)
+			require.Equal(t, "client_secret_post", req.TokenEndpointAuthMethod)
+			require.Equal(t, "crush", req.SoftwareID)
+			require.Equal(t, "1.2.3", req.SoftwareVersion)
+			require.Equal(t, []string{"ops@example.com"}, req.Contacts)
+			require.Equal(t, "https://example.com/logo.png", req.LogoURI)
+			require.Equal(t, "signed.jwt.value", req.SoftwareStatement)
+			require.Equal(t, []string{"authorization_code", "refresh_token"}, req.GrantTypes)
+
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{
+				ClientID:     "confidential-client-id",
+				ClientSecret: "secret",
+			})
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+			Registration: RegistrationMetadata{
+				ClientName:              "acme-crush",
+				TokenEndpointAuthMethod: "client_secret_post",
+				SoftwareID:              "crush",
+				SoftwareVersion:         "1.2.3",
+				Contacts:                []string{"ops@example.com"},
+				LogoURI:                 "https://example.com/logo.png",
+				SoftwareStatement:       "signed.jwt.value",
+			},
+		}
+		creds, err := RegisterClient(context.Background(), cfg)
+		require.NoError(t, err)
+		require.Equal(t, "confidential-client-id", creds.ClientID)
+		require.Equal(t, "secret", creds.ClientSecret)
+	})
+
+	t.Run("retries rate limited requests", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{ClientID: "retried-client-id"})
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		creds, err := RegisterClient(context.Background(), cfg)
+		require.NoError(t, err)
+		require.Equal(t, "retried-client-id", creds.ClientID)
+		require.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("gives up when Retry-After is too long", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		_, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "status 429")
+		require.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("retries gateway errors", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch attempts.Add(1) {
+			case 1:
+				w.WriteHeader(http.StatusBadGateway)
+			case 2:
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				w.WriteHeader(http.StatusCreated)
+				_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{ClientID: "retried-client-id"})
+			}
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		creds, err := RegisterClient(context.Background(), cfg)
+		require.NoError(t, err)
+		require.Equal(t, "retried-client-id", creds.ClientID)
+		require.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("gives up after repeated gateway errors", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		_, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "status 504")
+		require.Equal(t, int32(registrationMaxAttempts), attempts.Load())
+	})
+
+	t.Run("does not retry internal server errors", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		_, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "status 500")
+		require.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("retries connection errors before sending", func(t *testing.T) {
+		var dials atomic.Int32
+		cfg := Config{
+			RegistrationEndpoint: "http://registration.invalid/register",
+			RedirectURI:          "http://localhost:19876/callback",
+			Transport: &http.Transport{
+				DialContext: func(context.Context, string, string) (net.Conn, error) {
+					dials.Add(1)
+					return nil, errors.New("connection refused")
+				},
+			},
+		}
+		_, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "connection refused")
+		require.Equal(t, int32(registrationMaxAttempts), dials.Load())
+	})
+
+	t.Run("does not retry requests that were sent", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			_, _ = io.ReadAll(r.Body)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		_, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "registration request failed")
+		require.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_redirect_uri","error_description":"bad uri"}`))
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		_, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "invalid_redirect_uri - bad uri")
+		require.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("error body with success status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"error":"access_denied","error_description":"registration disabled"}`))
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+		}
+		creds, err := RegisterClient(context.Background(), cfg)
+		require.ErrorContains(t, err, "access_denied - registration disabled")
+		require.Nil(t, creds)
+	})
+
+	t.Run("configurable timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{ClientID: "slow-client-id"})
+		}))
+		defer server.Close()
+
+		cfg := Config{
+			RegistrationEndpoint: server.URL,
+			RedirectURI:          "http://localhost:19876/callback",
+			HTTPTimeout:          20 * time.Millisecond,
+		}
+		creds, err := RegisterClient(context.Background(), cfg)
+		require.Error(t, err)
+		require.Nil(t, creds)
+	})
+
 	t.Run("empty registration endpoint", func(t *testing.T) {
 		cfg := Config{
 			RedirectURI: "http://localhost:19876/callback",
@@ -87,6 +326,59 @@ func TestRegisterClient(t *testing.T) {
 	})
 }
 
+func TestRegisterClient_ClientSecretBasic(t *testing.T) {
+	const secret = "s3cret/+="
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		var req ClientRegistrationRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "client_secret_basic", req.TokenEndpointAuthMethod)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(ClientRegistrationResponse{
+			ClientID:                "basic-client",
+			ClientSecret:            secret,
+			TokenEndpointAuthMethod: req.TokenEndpointAuthMethod,
+		})
+	})
+	// The token endpoint only accepts credentials sent with Basic auth.
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		id, pass, ok := r.BasicAuth()
+		if !ok || id != "basic-client" || pass != url.QueryEscape(secret) || r.PostForm.Has("client_secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "new-access", "expires_in": 3600})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := Config{
+		RegistrationEndpoint: server.URL + "/register",
+		TokenURL:             server.URL + "/token",
+		RedirectURI:          "http://localhost:19876/callback",
+		Registration:         RegistrationMetadata{TokenEndpointAuthMethod: "client_secret_basic"},
+	}
+	creds, err := RegisterClient(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Equal(t, "client_secret_basic", creds.TokenEndpointAuthMethod)
+
+	cfg.ClientID = creds.ClientID
+	cfg.ClientSecret = creds.ClientSecret
+	cfg.TokenEndpointAuthMethod = creds.TokenEndpointAuthMethod
+	token, err := RefreshToken(context.Background(), cfg, "refresh")
+	require.NoError(t, err)
+	require.Equal(t, "new-access", token.AccessToken)
+
+	// Sending the secret in the body is rejected.
+	cfg.TokenEndpointAuthMethod = ""
+	_, err = RefreshToken(context.Background(), cfg, "refresh")
+	require.ErrorContains(t, err, "status 401")
+}
+
 func TestConfig_SupportsDynamicRegistration(t *testing.T) {
 	t.Run("with registration endpoint", func(t *testing.T) {
 		cfg := &Config{
@@ -100,3 +392,72 @@ func TestConfig_SupportsDynamicRegistration(t *testing.T) {
 		require.False(t, cfg.SupportsDynamicRegistration())
 	})
 }
+
+func TestDeleteClientRegistration(t *testing.T) {
+	t.Run("deletes the client", func(t *testing.T) {
+		var called bool
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			require.Equal(t, http.MethodDelete, r.Method)
+			require.Equal(t, "/register/client-123", r.URL.Path)
+			require.Equal(t, "Bearer reg-token", r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		err := DeleteClientRegistration(context.Background(), Config{}, "reg-token", server.URL+"/register/client-123")
+		require.NoError(t, err)
+		require.True(t, called)
+	})
+
+	t.Run("unknown client is already deleted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		err := DeleteClientRegistration(context.Background(), Config{}, "reg-token", server.URL)
+		require.NoError(t, err)
+	})
+
+	t.Run("surfaces error response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_token","error_description":"token revoked"}`))
+		}))
+		defer server.Close()
+
+		err := DeleteClientRegistration(context.Background(), Config{}, "reg-token", server.URL)
+		require.ErrorContains(t, err, "invalid_token - token revoked")
+	})
+
+	t.Run("requires client URI and token", func(t *testing.T) {
+		require.ErrorContains(t, DeleteClientRegistration(context.Background(), Config{}, "reg-token", ""), "registration client URI is required")
+		require.ErrorContains(t, DeleteClientRegistration(context.Background(), Config{}, "", "https://example.com/register/1"), "registration access token is required")
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "3", want: 3 * time.Second, ok: true},
+		{value: " 0 ", want: 0, ok: true},
+		{value: "-1", ok: false},
+		{value: "99999999999", want: 24 * time.Hour, ok: true},
+		{value: now.Add(10 * time.Second).Format(http.TimeFormat), want: 10 * time.Second, ok: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, ok: true},
+		{value: "soon", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := RetryAfter(tt.value, now)
+		require.Equal(t, tt.ok, ok, "value %q", tt.value)
+		require.Equal(t, tt.want, got, "value %q", tt.value)
+	}
+}
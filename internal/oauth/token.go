@@ -4,22 +4,46 @@ import (
 	"time"
 )
 
+// DefaultExpirySkew is how long before its expiry a token is already treated
+// as expired by IsExpired, so a token that is valid when checked has not
+// expired by the time the server processes the request.
+const DefaultExpirySkew = 30 * time.Second
+
 // Token represents an OAuth2 token.
 type Token struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
 	ExpiresAt    int64  `json:"expires_at"`
+	// TokenType is the token type returned by the server (e.g. "Bearer").
+	TokenType string `json:"token_type,omitempty"`
+	// GrantedScopes are the scopes actually granted by the server, which may
+	// be narrower than the ones requested.
+	GrantedScopes []string `json:"granted_scopes,omitempty"`
 }
 
 // SetExpiresAt calculates and sets the ExpiresAt field based on the current time and ExpiresIn.
 func (t *Token) SetExpiresAt() {
-	t.ExpiresAt = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second).Unix()
+	t.SetExpiresAtFrom(time.Now())
+}
+
+// SetExpiresAtFrom is like SetExpiresAt but takes the current time as now.
+func (t *Token) SetExpiresAtFrom(now time.Time) {
+	t.ExpiresAt = now.Add(time.Duration(t.ExpiresIn) * time.Second).Unix()
 }
 
-// IsExpired checks if the token is expired or about to expire (within 10% of its lifetime).
+// IsExpired checks if the token is expired or about to expire, using
+// DefaultExpirySkew. See IsExpiredAt.
 func (t *Token) IsExpired() bool {
-	return time.Now().Unix() >= (t.ExpiresAt - int64(t.ExpiresIn)/10)
+	return t.IsExpiredAt(time.Now(), DefaultExpirySkew)
+}
+
+// IsExpiredAt reports whether the token is expired or about to expire at now:
+// within 10% of its lifetime or skew, whichever is longer. The skew is capped
+// at half of the lifetime, so short-lived tokens remain usable.
+func (t *Token) IsExpiredAt(now time.Time, skew time.Duration) bool {
+	margin := max(int64(t.ExpiresIn)/10, min(int64(skew.Seconds()), int64(t.ExpiresIn)/2))
+	return now.Unix() >= t.ExpiresAt-margin
 }
 
 // SetExpiresIn calculates and sets the ExpiresIn field based on the ExpiresAt field.
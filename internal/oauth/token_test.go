@@ -0,0 +1,74 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToken_SetExpiresAt(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+
+	token := &Token{ExpiresIn: 3600}
+	token.SetExpiresAtFrom(now)
+	require.Equal(t, now.Unix()+3600, token.ExpiresAt)
+
+	// Without a lifetime the token expires right away.
+	token = &Token{ExpiresIn: 0, ExpiresAt: 123}
+	token.SetExpiresAtFrom(now)
+	require.Equal(t, now.Unix(), token.ExpiresAt)
+}
+
+func TestToken_IsExpiredAt(t *testing.T) {
+	t.Parallel()
+
+	issued := time.Unix(1_700_000_000, 0)
+	newToken := func(lifetime int) *Token {
+		token := &Token{ExpiresIn: lifetime}
+		token.SetExpiresAtFrom(issued)
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		lifetime int
+		elapsed  time.Duration
+		want     bool
+	}{
+		// Long-lived tokens are refreshed in the last 10% of their lifetime.
+		{"hour before refresh window", 3600, 3239 * time.Second, false},
+		{"hour at refresh window", 3600, 3240 * time.Second, true},
+		// For shorter ones the skew buffer is larger than 10%.
+		{"minutes before skew", 120, 89 * time.Second, false},
+		{"minutes at skew", 120, 90 * time.Second, true},
+		// The skew is capped at half of the lifetime.
+		{"short-lived before half", 20, 9 * time.Second, false},
+		{"short-lived at half", 20, 10 * time.Second, true},
+		{"past expiry", 3600, 2 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tt.want, newToken(tt.lifetime).IsExpiredAt(issued.Add(tt.elapsed), DefaultExpirySkew))
+		})
+	}
+
+	t.Run("without expiry", func(t *testing.T) {
+		t.Parallel()
+		require.True(t, (&Token{}).IsExpiredAt(issued, DefaultExpirySkew))
+		require.True(t, newToken(0).IsExpiredAt(issued, DefaultExpirySkew))
+	})
+
+	t.Run("custom skew", func(t *testing.T) {
+		t.Parallel()
+		token := newToken(600)
+		require.False(t, token.IsExpiredAt(issued.Add(539*time.Second), DefaultExpirySkew))
+		require.True(t, token.IsExpiredAt(issued.Add(539*time.Second), 2*time.Minute))
+		// Without skew only the last 10% of the lifetime count.
+		require.False(t, token.IsExpiredAt(issued.Add(539*time.Second), 0))
+		require.True(t, token.IsExpiredAt(issued.Add(540*time.Second), 0))
+	})
+}
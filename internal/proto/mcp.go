@@ -63,6 +63,8 @@ const (
 	MCPEventToolsListChanged     MCPEventType = "tools_list_changed"
 	MCPEventPromptsListChanged   MCPEventType = "prompts_list_changed"
 	MCPEventResourcesListChanged MCPEventType = "resources_list_changed"
+	MCPEventClientsSettled       MCPEventType = "clients_settled"
+	MCPEventOAuthStage           MCPEventType = "oauth_stage"
 )
 
 // MarshalText implements the [encoding.TextMarshaler] interface.
@@ -85,6 +87,8 @@ type MCPEvent struct {
 	ToolCount     int          `json:"tool_count,omitempty"`
 	PromptCount   int          `json:"prompt_count,omitempty"`
 	ResourceCount int          `json:"resource_count,omitempty"`
+	AuthStage     string       `json:"auth_stage,omitempty"`
+	FailedStage   string       `json:"failed_stage,omitempty"`
 }
 
 // MarshalJSON implements the [json.Marshaler] interface.
@@ -133,6 +137,11 @@ type MCPClientInfo struct {
 	PromptCount   int       `json:"prompt_count,omitempty"`
 	ResourceCount int       `json:"resource_count,omitempty"`
 	ConnectedAt   time.Time `json:"connected_at"`
+	// ServerName, ServerVersion and ProtocolVersion are reported by the
+	// server during the handshake.
+	ServerName      string `json:"server_name,omitempty"`
+	ServerVersion   string `json:"server_version,omitempty"`
+	ProtocolVersion string `json:"protocol_version,omitempty"`
 }
 
 // MarshalJSON implements the [json.Marshaler] interface.
@@ -401,7 +401,7 @@ func (c *controllerV1) handleGetWorkspaceMCPStates(w http.ResponseWriter, r *htt
 	states := c.backend.MCPGetStates(id)
 	result := make(map[string]proto.MCPClientInfo, len(states))
 	for k, v := range states {
-		result[k] = proto.MCPClientInfo{
+		info := proto.MCPClientInfo{
 			Name:          v.Name,
 			State:         proto.MCPState(v.State),
 			Error:         v.Error,
@@ -410,6 +410,12 @@ func (c *controllerV1) handleGetWorkspaceMCPStates(w http.ResponseWriter, r *htt
 			ResourceCount: v.Counts.Resources,
 			ConnectedAt:   v.ConnectedAt,
 		}
+		info.ProtocolVersion = v.Features.ProtocolVersion
+		if v.ServerInfo != nil {
+			info.ServerName = v.ServerInfo.Name
+			info.ServerVersion = v.ServerInfo.Version
+		}
+		result[k] = info
 	}
 	jsonEncode(w, result)
 }
@@ -37,11 +37,13 @@ func wrapEvent(ev any) *pubsub.Payload {
 		return envelope(pubsub.PayloadTypeMCPEvent, pubsub.Event[proto.MCPEvent]{
 			Type: e.Type,
 			Payload: proto.MCPEvent{
-				Type:      mcpEventTypeToProto(e.Payload.Type),
-				Name:      e.Payload.Name,
-				State:     proto.MCPState(e.Payload.State),
-				Error:     e.Payload.Error,
-				ToolCount: e.Payload.Counts.Tools,
+				Type:        mcpEventTypeToProto(e.Payload.Type),
+				Name:        e.Payload.Name,
+				State:       proto.MCPState(e.Payload.State),
+				Error:       e.Payload.Error,
+				ToolCount:   e.Payload.Counts.Tools,
+				AuthStage:   string(e.Payload.AuthStage.Stage),
+				FailedStage: string(e.Payload.AuthStage.FailedStage),
 			},
 		})
 	case pubsub.Event[permission.PermissionRequest]:
@@ -120,6 +122,10 @@ func mcpEventTypeToProto(t mcp.EventType) proto.MCPEventType {
 		return proto.MCPEventPromptsListChanged
 	case mcp.EventResourcesListChanged:
 		return proto.MCPEventResourcesListChanged
+	case mcp.EventClientsSettled:
+		return proto.MCPEventClientsSettled
+	case mcp.EventOAuthStage:
+		return proto.MCPEventOAuthStage
 	default:
 		return proto.MCPEventStateChanged
 	}
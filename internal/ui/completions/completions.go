@@ -408,6 +408,7 @@ func loadFiles(depth, limit int) []FileCompletionValue {
 }
 
 func loadMCPResources() []ResourceCompletionValue {
+	mcp.RefetchEvictedResources()
 	var resources []ResourceCompletionValue
 	for mcpName, mcpResources := range mcp.Resources() {
 		for _, r := range mcpResources {
@@ -57,6 +57,11 @@ type (
 	ActionSummarize                   struct {
 		SessionID string
 	}
+	// ActionOverrideLoopDetection lets a session stopped by loop detection
+	// continue.
+	ActionOverrideLoopDetection struct {
+		SessionID string
+	}
 	// ActionSelectReasoningEffort is a message indicating a reasoning effort
 	// has been selected.
 	ActionSelectReasoningEffort struct {
@@ -57,6 +57,7 @@ type Commands struct {
 	hasSession bool
 	hasTodos   bool
 	hasQueue   bool
+	loopHalted bool
 	selected   CommandType
 
 	spinner spinner.Model
@@ -78,7 +79,7 @@ type Commands struct {
 var _ Dialog = (*Commands)(nil)
 
 // NewCommands creates a new commands dialog.
-func NewCommands(com *common.Common, sessionID string, hasSession, hasTodos, hasQueue bool, customCommands []commands.CustomCommand, mcpPrompts []commands.MCPPrompt) (*Commands, error) {
+func NewCommands(com *common.Common, sessionID string, hasSession, hasTodos, hasQueue, loopHalted bool, customCommands []commands.CustomCommand, mcpPrompts []commands.MCPPrompt) (*Commands, error) {
 	c := &Commands{
 		com:            com,
 		selected:       SystemCommands,
@@ -86,6 +87,7 @@ func NewCommands(com *common.Common, sessionID string, hasSession, hasTodos, has
 		hasSession:     hasSession,
 		hasTodos:       hasTodos,
 		hasQueue:       hasQueue,
+		loopHalted:     loopHalted,
 		customCommands: customCommands,
 		mcpPrompts:     mcpPrompts,
 	}
@@ -405,7 +407,7 @@ func (c *Commands) setCommandItems(commandType CommandType) {
 				ClientID:    cmd.ClientID,
 				Arguments:   cmd.Arguments,
 			}
-			commandItems = append(commandItems, NewCommandItem(c.com.Styles, "mcp_"+cmd.ID, cmd.PromptID, "", action))
+			commandItems = append(commandItems, NewCommandItem(c.com.Styles, "mcp_"+cmd.ID, cmd.Name, "", action))
 		}
 	}
 
@@ -429,6 +431,11 @@ func (c *Commands) defaultCommands() []*CommandItem {
 		commands = append(commands, NewCommandItem(c.com.Styles, "summarize", "Summarize Session", "", ActionSummarize{SessionID: c.sessionID}))
 	}
 
+	// Let the agent continue after it was stopped for looping.
+	if c.loopHalted {
+		commands = append(commands, NewCommandItem(c.com.Styles, "override_loop_detection", "Continue Past Loop", "", ActionOverrideLoopDetection{SessionID: c.sessionID}))
+	}
+
 	// Add reasoning toggle for models that support it
 	cfg := c.com.Config()
 	if agentCfg, ok := cfg.Agents[config.AgentCoder]; ok {
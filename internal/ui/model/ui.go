@@ -251,6 +251,10 @@ type UI struct {
 	promptQueue        int
 	pillsView          string
 
+	// loopHalted holds the sessions stopped by loop detection until the
+	// user lets them continue.
+	loopHalted map[string]bool
+
 	// Todo spinner
 	todoSpinner    spinner.Model
 	todoIsSpinning bool
@@ -455,7 +459,9 @@ func (m *UI) loadCustomCommands() tea.Cmd {
 
 // loadMCPrompts loads the MCP prompts asynchronously.
 func (m *UI) loadMCPrompts() tea.Msg {
-	prompts, err := commands.LoadMCPPrompts()
+	// Evicted prompts are loaded on the state change ending their refetch.
+	mcp.RefetchEvictedPrompts()
+	prompts, err := commands.LoadMCPPrompts(m.com.Config())
 	if err != nil {
 		slog.Error("Failed to load MCP prompts", "error", err)
 	}
@@ -1331,6 +1337,11 @@ func (m *UI) handleDialogMsg(msg tea.Msg) tea.Cmd {
 			cmds = append(cmds, cmd)
 		}
 		m.dialog.CloseDialog(dialog.CommandsID)
+	case dialog.ActionOverrideLoopDetection:
+		m.com.Workspace.AgentOverrideLoopDetection(msg.SessionID)
+		delete(m.loopHalted, msg.SessionID)
+		cmds = append(cmds, util.CmdHandler(util.NewInfoMsg("Loop detection overridden")))
+		m.dialog.CloseDialog(dialog.CommandsID)
 	case dialog.ActionSummarize:
 		if m.isAgentBusy() {
 			cmds = append(cmds, util.ReportWarn("Agent is busy, please wait before summarizing session..."))
@@ -3151,8 +3162,9 @@ func (m *UI) openCommandsDialog() tea.Cmd {
 	}
 	hasTodos := hasSession && hasIncompleteTodos(m.session.Todos)
 	hasQueue := m.promptQueue > 0
+	loopHalted := hasSession && m.loopHalted[sessionID]
 
-	commands, err := dialog.NewCommands(m.com, sessionID, hasSession, hasTodos, hasQueue, m.customCommands, m.mcpPrompts)
+	commands, err := dialog.NewCommands(m.com, sessionID, hasSession, hasTodos, hasQueue, loopHalted, m.customCommands, m.mcpPrompts)
 	if err != nil {
 		return util.ReportError(err)
 	}
@@ -3260,6 +3272,12 @@ func (m *UI) handleAgentNotification(n notify.Notification) tea.Cmd {
 		})
 	case notify.TypeReAuthenticate:
 		return m.handleReAuthenticate(n.ProviderID)
+	case notify.TypeLoopHalted:
+		if m.loopHalted == nil {
+			m.loopHalted = make(map[string]bool)
+		}
+		m.loopHalted[n.SessionID] = true
+		return util.ReportWarn(`Agent was stopped for repeating the same tool calls. Run "Continue Past Loop" to let it go on.`)
 	default:
 		return nil
 	}
@@ -146,6 +146,12 @@ func (w *AppWorkspace) AgentClearQueue(sessionID string) {
 	}
 }
 
+func (w *AppWorkspace) AgentOverrideLoopDetection(sessionID string) {
+	if w.app.AgentCoordinator != nil {
+		w.app.AgentCoordinator.OverrideLoopDetection(sessionID)
+	}
+}
+
 func (w *AppWorkspace) AgentSummarize(ctx context.Context, sessionID string) error {
 	if w.app.AgentCoordinator == nil {
 		return errors.New("agent coordinator not initialized")
@@ -273,7 +279,7 @@ func (w *AppWorkspace) SetConfigField(scope config.Scope, key string, value any)
 }
 
 func (w *AppWorkspace) RemoveConfigField(scope config.Scope, key string) error {
-	return w.store.RemoveConfigField(scope, key)
+	return mcptools.RemoveConfigField(context.Background(), w.store, scope, key)
 }
 
 func (w *AppWorkspace) ImportCopilot() (*oauth.Token, bool) {
@@ -18,11 +18,13 @@ import (
 	"github.com/charmbracelet/crush/internal/lsp"
 	"github.com/charmbracelet/crush/internal/message"
 	"github.com/charmbracelet/crush/internal/oauth"
+	mcpoauth "github.com/charmbracelet/crush/internal/oauth/mcp"
 	"github.com/charmbracelet/crush/internal/permission"
 	"github.com/charmbracelet/crush/internal/proto"
 	"github.com/charmbracelet/crush/internal/pubsub"
 	"github.com/charmbracelet/crush/internal/session"
 	"github.com/charmbracelet/x/powernap/pkg/lsp/protocol"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
 // ClientWorkspace implements the Workspace interface by delegating all
@@ -222,6 +224,10 @@ func (w *ClientWorkspace) AgentClearQueue(sessionID string) {
 	_ = w.client.ClearAgentSessionQueuedPrompts(context.Background(), w.workspaceID(), sessionID)
 }
 
+func (w *ClientWorkspace) AgentOverrideLoopDetection(sessionID string) {
+	_ = w.client.OverrideAgentSessionLoopDetection(context.Background(), w.workspaceID(), sessionID)
+}
+
 func (w *ClientWorkspace) AgentSummarize(ctx context.Context, sessionID string) error {
 	return w.client.AgentSummarizeSession(ctx, w.workspaceID(), sessionID)
 }
@@ -481,7 +487,7 @@ func (w *ClientWorkspace) MCPGetStates() map[string]mcp.ClientInfo {
 	}
 	result := make(map[string]mcp.ClientInfo, len(states))
 	for k, v := range states {
-		result[k] = mcp.ClientInfo{
+		info := mcp.ClientInfo{
 			Name:  v.Name,
 			State: mcp.State(v.State),
 			Error: v.Error,
@@ -491,7 +497,12 @@ func (w *ClientWorkspace) MCPGetStates() map[string]mcp.ClientInfo {
 				Resources: v.ResourceCount,
 			},
 			ConnectedAt: v.ConnectedAt,
+			Features:    mcp.Features{ProtocolVersion: v.ProtocolVersion},
 		}
+		if v.ServerName != "" {
+			info.ServerInfo = &mcpsdk.Implementation{Name: v.ServerName, Version: v.ServerVersion}
+		}
+		result[k] = info
 	}
 	return result
 }
@@ -591,6 +602,11 @@ func translateEvent(ev any) tea.Msg {
 					Prompts:   e.Payload.PromptCount,
 					Resources: e.Payload.ResourceCount,
 				},
+				AuthStage: mcpoauth.StageEvent{
+					Stage:       mcpoauth.AuthStage(e.Payload.AuthStage),
+					FailedStage: mcpoauth.AuthStage(e.Payload.FailedStage),
+					Error:       e.Payload.Error,
+				},
 			},
 		}
 	case pubsub.Event[proto.PermissionRequest]:
@@ -656,6 +672,10 @@ func protoToMCPEventType(t proto.MCPEventType) mcp.EventType {
 		return mcp.EventPromptsListChanged
 	case proto.MCPEventResourcesListChanged:
 		return mcp.EventResourcesListChanged
+	case proto.MCPEventClientsSettled:
+		return mcp.EventClientsSettled
+	case proto.MCPEventOAuthStage:
+		return mcp.EventOAuthStage
 	default:
 		return mcp.EventStateChanged
 	}